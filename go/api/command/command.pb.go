@@ -117,7 +117,7 @@ func (x SymlinkBehaviorType_Value) Number() protoreflect.EnumNumber {
 
 // Deprecated: Use SymlinkBehaviorType_Value.Descriptor instead.
 func (SymlinkBehaviorType_Value) EnumDescriptor() ([]byte, []int) {
-	return file_go_api_command_command_proto_rawDescGZIP(), []int{5, 0}
+	return file_go_api_command_command_proto_rawDescGZIP(), []int{6, 0}
 }
 
 type CommandResultStatus_Value int32
@@ -181,7 +181,7 @@ func (x CommandResultStatus_Value) Number() protoreflect.EnumNumber {
 
 // Deprecated: Use CommandResultStatus_Value.Descriptor instead.
 func (CommandResultStatus_Value) EnumDescriptor() ([]byte, []int) {
-	return file_go_api_command_command_proto_rawDescGZIP(), []int{10, 0}
+	return file_go_api_command_command_proto_rawDescGZIP(), []int{11, 0}
 }
 
 type Command struct {
@@ -546,6 +546,85 @@ func (x *VirtualInput) GetIsEmptyDirectory() bool {
 	return false
 }
 
+type RemoteInput struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Path         string `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	Hash         string `protobuf:"bytes,2,opt,name=hash,proto3" json:"hash,omitempty"`
+	SizeBytes    int64  `protobuf:"varint,3,opt,name=size_bytes,json=sizeBytes,proto3" json:"size_bytes,omitempty"`
+	IsDirectory  bool   `protobuf:"varint,4,opt,name=is_directory,json=isDirectory,proto3" json:"is_directory,omitempty"`
+	IsExecutable bool   `protobuf:"varint,5,opt,name=is_executable,json=isExecutable,proto3" json:"is_executable,omitempty"`
+}
+
+func (x *RemoteInput) Reset() {
+	*x = RemoteInput{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_go_api_command_command_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RemoteInput) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RemoteInput) ProtoMessage() {}
+
+func (x *RemoteInput) ProtoReflect() protoreflect.Message {
+	mi := &file_go_api_command_command_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RemoteInput.ProtoReflect.Descriptor instead.
+func (*RemoteInput) Descriptor() ([]byte, []int) {
+	return file_go_api_command_command_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *RemoteInput) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *RemoteInput) GetHash() string {
+	if x != nil {
+		return x.Hash
+	}
+	return ""
+}
+
+func (x *RemoteInput) GetSizeBytes() int64 {
+	if x != nil {
+		return x.SizeBytes
+	}
+	return 0
+}
+
+func (x *RemoteInput) GetIsDirectory() bool {
+	if x != nil {
+		return x.IsDirectory
+	}
+	return false
+}
+
+func (x *RemoteInput) GetIsExecutable() bool {
+	if x != nil {
+		return x.IsExecutable
+	}
+	return false
+}
+
 type SymlinkBehaviorType struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -555,7 +634,7 @@ type SymlinkBehaviorType struct {
 func (x *SymlinkBehaviorType) Reset() {
 	*x = SymlinkBehaviorType{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_go_api_command_command_proto_msgTypes[5]
+		mi := &file_go_api_command_command_proto_msgTypes[6]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -568,7 +647,7 @@ func (x *SymlinkBehaviorType) String() string {
 func (*SymlinkBehaviorType) ProtoMessage() {}
 
 func (x *SymlinkBehaviorType) ProtoReflect() protoreflect.Message {
-	mi := &file_go_api_command_command_proto_msgTypes[5]
+	mi := &file_go_api_command_command_proto_msgTypes[6]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -581,7 +660,7 @@ func (x *SymlinkBehaviorType) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use SymlinkBehaviorType.ProtoReflect.Descriptor instead.
 func (*SymlinkBehaviorType) Descriptor() ([]byte, []int) {
-	return file_go_api_command_command_proto_rawDescGZIP(), []int{5}
+	return file_go_api_command_command_proto_rawDescGZIP(), []int{6}
 }
 
 type InputSpec struct {
@@ -591,16 +670,19 @@ type InputSpec struct {
 
 	Inputs               []string                   `protobuf:"bytes,2,rep,name=inputs,proto3" json:"inputs,omitempty"`
 	VirtualInputs        []*VirtualInput            `protobuf:"bytes,5,rep,name=virtual_inputs,json=virtualInputs,proto3" json:"virtual_inputs,omitempty"`
+	RemoteInputs         []*RemoteInput             `protobuf:"bytes,10,rep,name=remote_inputs,json=remoteInputs,proto3" json:"remote_inputs,omitempty"`
 	ExcludeInputs        []*ExcludeInput            `protobuf:"bytes,3,rep,name=exclude_inputs,json=excludeInputs,proto3" json:"exclude_inputs,omitempty"`
 	EnvironmentVariables map[string]string          `protobuf:"bytes,4,rep,name=environment_variables,json=environmentVariables,proto3" json:"environment_variables,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
 	SymlinkBehavior      SymlinkBehaviorType_Value  `protobuf:"varint,6,opt,name=symlink_behavior,json=symlinkBehavior,proto3,enum=cmd.SymlinkBehaviorType_Value" json:"symlink_behavior,omitempty"`
 	InputNodeProperties  map[string]*NodeProperties `protobuf:"bytes,7,rep,name=input_node_properties,json=inputNodeProperties,proto3" json:"input_node_properties,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	InputGlobs           []string                   `protobuf:"bytes,8,rep,name=input_globs,json=inputGlobs,proto3" json:"input_globs,omitempty"`
+	ExcludeGlobs         []string                   `protobuf:"bytes,9,rep,name=exclude_globs,json=excludeGlobs,proto3" json:"exclude_globs,omitempty"`
 }
 
 func (x *InputSpec) Reset() {
 	*x = InputSpec{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_go_api_command_command_proto_msgTypes[6]
+		mi := &file_go_api_command_command_proto_msgTypes[7]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -613,7 +695,7 @@ func (x *InputSpec) String() string {
 func (*InputSpec) ProtoMessage() {}
 
 func (x *InputSpec) ProtoReflect() protoreflect.Message {
-	mi := &file_go_api_command_command_proto_msgTypes[6]
+	mi := &file_go_api_command_command_proto_msgTypes[7]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -626,7 +708,7 @@ func (x *InputSpec) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use InputSpec.ProtoReflect.Descriptor instead.
 func (*InputSpec) Descriptor() ([]byte, []int) {
-	return file_go_api_command_command_proto_rawDescGZIP(), []int{6}
+	return file_go_api_command_command_proto_rawDescGZIP(), []int{7}
 }
 
 func (x *InputSpec) GetInputs() []string {
@@ -643,6 +725,13 @@ func (x *InputSpec) GetVirtualInputs() []*VirtualInput {
 	return nil
 }
 
+func (x *InputSpec) GetRemoteInputs() []*RemoteInput {
+	if x != nil {
+		return x.RemoteInputs
+	}
+	return nil
+}
+
 func (x *InputSpec) GetExcludeInputs() []*ExcludeInput {
 	if x != nil {
 		return x.ExcludeInputs
@@ -671,6 +760,20 @@ func (x *InputSpec) GetInputNodeProperties() map[string]*NodeProperties {
 	return nil
 }
 
+func (x *InputSpec) GetInputGlobs() []string {
+	if x != nil {
+		return x.InputGlobs
+	}
+	return nil
+}
+
+func (x *InputSpec) GetExcludeGlobs() []string {
+	if x != nil {
+		return x.ExcludeGlobs
+	}
+	return nil
+}
+
 type NodeProperties struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -684,7 +787,7 @@ type NodeProperties struct {
 func (x *NodeProperties) Reset() {
 	*x = NodeProperties{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_go_api_command_command_proto_msgTypes[7]
+		mi := &file_go_api_command_command_proto_msgTypes[8]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -697,7 +800,7 @@ func (x *NodeProperties) String() string {
 func (*NodeProperties) ProtoMessage() {}
 
 func (x *NodeProperties) ProtoReflect() protoreflect.Message {
-	mi := &file_go_api_command_command_proto_msgTypes[7]
+	mi := &file_go_api_command_command_proto_msgTypes[8]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -710,7 +813,7 @@ func (x *NodeProperties) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use NodeProperties.ProtoReflect.Descriptor instead.
 func (*NodeProperties) Descriptor() ([]byte, []int) {
-	return file_go_api_command_command_proto_rawDescGZIP(), []int{7}
+	return file_go_api_command_command_proto_rawDescGZIP(), []int{8}
 }
 
 func (x *NodeProperties) GetProperties() []*NodeProperty {
@@ -746,7 +849,7 @@ type NodeProperty struct {
 func (x *NodeProperty) Reset() {
 	*x = NodeProperty{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_go_api_command_command_proto_msgTypes[8]
+		mi := &file_go_api_command_command_proto_msgTypes[9]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -759,7 +862,7 @@ func (x *NodeProperty) String() string {
 func (*NodeProperty) ProtoMessage() {}
 
 func (x *NodeProperty) ProtoReflect() protoreflect.Message {
-	mi := &file_go_api_command_command_proto_msgTypes[8]
+	mi := &file_go_api_command_command_proto_msgTypes[9]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -772,7 +875,7 @@ func (x *NodeProperty) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use NodeProperty.ProtoReflect.Descriptor instead.
 func (*NodeProperty) Descriptor() ([]byte, []int) {
-	return file_go_api_command_command_proto_rawDescGZIP(), []int{8}
+	return file_go_api_command_command_proto_rawDescGZIP(), []int{9}
 }
 
 func (x *NodeProperty) GetName() string {
@@ -796,12 +899,13 @@ type OutputSpec struct {
 
 	OutputFiles       []string `protobuf:"bytes,1,rep,name=output_files,json=outputFiles,proto3" json:"output_files,omitempty"`
 	OutputDirectories []string `protobuf:"bytes,2,rep,name=output_directories,json=outputDirectories,proto3" json:"output_directories,omitempty"`
+	OutputGlobs       []string `protobuf:"bytes,3,rep,name=output_globs,json=outputGlobs,proto3" json:"output_globs,omitempty"`
 }
 
 func (x *OutputSpec) Reset() {
 	*x = OutputSpec{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_go_api_command_command_proto_msgTypes[9]
+		mi := &file_go_api_command_command_proto_msgTypes[10]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -814,7 +918,7 @@ func (x *OutputSpec) String() string {
 func (*OutputSpec) ProtoMessage() {}
 
 func (x *OutputSpec) ProtoReflect() protoreflect.Message {
-	mi := &file_go_api_command_command_proto_msgTypes[9]
+	mi := &file_go_api_command_command_proto_msgTypes[10]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -827,7 +931,7 @@ func (x *OutputSpec) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use OutputSpec.ProtoReflect.Descriptor instead.
 func (*OutputSpec) Descriptor() ([]byte, []int) {
-	return file_go_api_command_command_proto_rawDescGZIP(), []int{9}
+	return file_go_api_command_command_proto_rawDescGZIP(), []int{10}
 }
 
 func (x *OutputSpec) GetOutputFiles() []string {
@@ -844,6 +948,13 @@ func (x *OutputSpec) GetOutputDirectories() []string {
 	return nil
 }
 
+func (x *OutputSpec) GetOutputGlobs() []string {
+	if x != nil {
+		return x.OutputGlobs
+	}
+	return nil
+}
+
 type CommandResultStatus struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -853,7 +964,7 @@ type CommandResultStatus struct {
 func (x *CommandResultStatus) Reset() {
 	*x = CommandResultStatus{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_go_api_command_command_proto_msgTypes[10]
+		mi := &file_go_api_command_command_proto_msgTypes[11]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -866,7 +977,7 @@ func (x *CommandResultStatus) String() string {
 func (*CommandResultStatus) ProtoMessage() {}
 
 func (x *CommandResultStatus) ProtoReflect() protoreflect.Message {
-	mi := &file_go_api_command_command_proto_msgTypes[10]
+	mi := &file_go_api_command_command_proto_msgTypes[11]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -879,7 +990,7 @@ func (x *CommandResultStatus) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use CommandResultStatus.ProtoReflect.Descriptor instead.
 func (*CommandResultStatus) Descriptor() ([]byte, []int) {
-	return file_go_api_command_command_proto_rawDescGZIP(), []int{10}
+	return file_go_api_command_command_proto_rawDescGZIP(), []int{11}
 }
 
 type CommandResult struct {
@@ -895,7 +1006,7 @@ type CommandResult struct {
 func (x *CommandResult) Reset() {
 	*x = CommandResult{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_go_api_command_command_proto_msgTypes[11]
+		mi := &file_go_api_command_command_proto_msgTypes[12]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -908,7 +1019,7 @@ func (x *CommandResult) String() string {
 func (*CommandResult) ProtoMessage() {}
 
 func (x *CommandResult) ProtoReflect() protoreflect.Message {
-	mi := &file_go_api_command_command_proto_msgTypes[11]
+	mi := &file_go_api_command_command_proto_msgTypes[12]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -921,7 +1032,7 @@ func (x *CommandResult) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use CommandResult.ProtoReflect.Descriptor instead.
 func (*CommandResult) Descriptor() ([]byte, []int) {
-	return file_go_api_command_command_proto_rawDescGZIP(), []int{11}
+	return file_go_api_command_command_proto_rawDescGZIP(), []int{12}
 }
 
 func (x *CommandResult) GetStatus() CommandResultStatus_Value {
@@ -957,7 +1068,7 @@ type TimeInterval struct {
 func (x *TimeInterval) Reset() {
 	*x = TimeInterval{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_go_api_command_command_proto_msgTypes[12]
+		mi := &file_go_api_command_command_proto_msgTypes[13]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -970,7 +1081,7 @@ func (x *TimeInterval) String() string {
 func (*TimeInterval) ProtoMessage() {}
 
 func (x *TimeInterval) ProtoReflect() protoreflect.Message {
-	mi := &file_go_api_command_command_proto_msgTypes[12]
+	mi := &file_go_api_command_command_proto_msgTypes[13]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -983,7 +1094,7 @@ func (x *TimeInterval) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use TimeInterval.ProtoReflect.Descriptor instead.
 func (*TimeInterval) Descriptor() ([]byte, []int) {
-	return file_go_api_command_command_proto_rawDescGZIP(), []int{12}
+	return file_go_api_command_command_proto_rawDescGZIP(), []int{13}
 }
 
 func (x *TimeInterval) GetFrom() *timestamppb.Timestamp {
@@ -1071,69 +1182,89 @@ var file_go_api_command_command_proto_rawDesc = []byte{
 	0x75, 0x74, 0x61, 0x62, 0x6c, 0x65, 0x12, 0x2c, 0x0a, 0x12, 0x69, 0x73, 0x5f, 0x65, 0x6d, 0x70,
 	0x74, 0x79, 0x5f, 0x64, 0x69, 0x72, 0x65, 0x63, 0x74, 0x6f, 0x72, 0x79, 0x18, 0x04, 0x20, 0x01,
 	0x28, 0x08, 0x52, 0x10, 0x69, 0x73, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x44, 0x69, 0x72, 0x65, 0x63,
-	0x74, 0x6f, 0x72, 0x79, 0x22, 0x4a, 0x0a, 0x13, 0x53, 0x79, 0x6d, 0x6c, 0x69, 0x6e, 0x6b, 0x42,
-	0x65, 0x68, 0x61, 0x76, 0x69, 0x6f, 0x72, 0x54, 0x79, 0x70, 0x65, 0x22, 0x33, 0x0a, 0x05, 0x56,
-	0x61, 0x6c, 0x75, 0x65, 0x12, 0x0f, 0x0a, 0x0b, 0x55, 0x4e, 0x53, 0x50, 0x45, 0x43, 0x49, 0x46,
-	0x49, 0x45, 0x44, 0x10, 0x00, 0x12, 0x0b, 0x0a, 0x07, 0x52, 0x45, 0x53, 0x4f, 0x4c, 0x56, 0x45,
-	0x10, 0x01, 0x12, 0x0c, 0x0a, 0x08, 0x50, 0x52, 0x45, 0x53, 0x45, 0x52, 0x56, 0x45, 0x10, 0x02,
-	0x22, 0xc4, 0x04, 0x0a, 0x09, 0x49, 0x6e, 0x70, 0x75, 0x74, 0x53, 0x70, 0x65, 0x63, 0x12, 0x16,
-	0x0a, 0x06, 0x69, 0x6e, 0x70, 0x75, 0x74, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x09, 0x52, 0x06,
-	0x69, 0x6e, 0x70, 0x75, 0x74, 0x73, 0x12, 0x38, 0x0a, 0x0e, 0x76, 0x69, 0x72, 0x74, 0x75, 0x61,
-	0x6c, 0x5f, 0x69, 0x6e, 0x70, 0x75, 0x74, 0x73, 0x18, 0x05, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x11,
-	0x2e, 0x63, 0x6d, 0x64, 0x2e, 0x56, 0x69, 0x72, 0x74, 0x75, 0x61, 0x6c, 0x49, 0x6e, 0x70, 0x75,
-	0x74, 0x52, 0x0d, 0x76, 0x69, 0x72, 0x74, 0x75, 0x61, 0x6c, 0x49, 0x6e, 0x70, 0x75, 0x74, 0x73,
-	0x12, 0x38, 0x0a, 0x0e, 0x65, 0x78, 0x63, 0x6c, 0x75, 0x64, 0x65, 0x5f, 0x69, 0x6e, 0x70, 0x75,
-	0x74, 0x73, 0x18, 0x03, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x11, 0x2e, 0x63, 0x6d, 0x64, 0x2e, 0x45,
-	0x78, 0x63, 0x6c, 0x75, 0x64, 0x65, 0x49, 0x6e, 0x70, 0x75, 0x74, 0x52, 0x0d, 0x65, 0x78, 0x63,
-	0x6c, 0x75, 0x64, 0x65, 0x49, 0x6e, 0x70, 0x75, 0x74, 0x73, 0x12, 0x5d, 0x0a, 0x15, 0x65, 0x6e,
-	0x76, 0x69, 0x72, 0x6f, 0x6e, 0x6d, 0x65, 0x6e, 0x74, 0x5f, 0x76, 0x61, 0x72, 0x69, 0x61, 0x62,
-	0x6c, 0x65, 0x73, 0x18, 0x04, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x28, 0x2e, 0x63, 0x6d, 0x64, 0x2e,
-	0x49, 0x6e, 0x70, 0x75, 0x74, 0x53, 0x70, 0x65, 0x63, 0x2e, 0x45, 0x6e, 0x76, 0x69, 0x72, 0x6f,
-	0x6e, 0x6d, 0x65, 0x6e, 0x74, 0x56, 0x61, 0x72, 0x69, 0x61, 0x62, 0x6c, 0x65, 0x73, 0x45, 0x6e,
-	0x74, 0x72, 0x79, 0x52, 0x14, 0x65, 0x6e, 0x76, 0x69, 0x72, 0x6f, 0x6e, 0x6d, 0x65, 0x6e, 0x74,
-	0x56, 0x61, 0x72, 0x69, 0x61, 0x62, 0x6c, 0x65, 0x73, 0x12, 0x49, 0x0a, 0x10, 0x73, 0x79, 0x6d,
-	0x6c, 0x69, 0x6e, 0x6b, 0x5f, 0x62, 0x65, 0x68, 0x61, 0x76, 0x69, 0x6f, 0x72, 0x18, 0x06, 0x20,
-	0x01, 0x28, 0x0e, 0x32, 0x1e, 0x2e, 0x63, 0x6d, 0x64, 0x2e, 0x53, 0x79, 0x6d, 0x6c, 0x69, 0x6e,
-	0x6b, 0x42, 0x65, 0x68, 0x61, 0x76, 0x69, 0x6f, 0x72, 0x54, 0x79, 0x70, 0x65, 0x2e, 0x56, 0x61,
-	0x6c, 0x75, 0x65, 0x52, 0x0f, 0x73, 0x79, 0x6d, 0x6c, 0x69, 0x6e, 0x6b, 0x42, 0x65, 0x68, 0x61,
-	0x76, 0x69, 0x6f, 0x72, 0x12, 0x5b, 0x0a, 0x15, 0x69, 0x6e, 0x70, 0x75, 0x74, 0x5f, 0x6e, 0x6f,
-	0x64, 0x65, 0x5f, 0x70, 0x72, 0x6f, 0x70, 0x65, 0x72, 0x74, 0x69, 0x65, 0x73, 0x18, 0x07, 0x20,
-	0x03, 0x28, 0x0b, 0x32, 0x27, 0x2e, 0x63, 0x6d, 0x64, 0x2e, 0x49, 0x6e, 0x70, 0x75, 0x74, 0x53,
-	0x70, 0x65, 0x63, 0x2e, 0x49, 0x6e, 0x70, 0x75, 0x74, 0x4e, 0x6f, 0x64, 0x65, 0x50, 0x72, 0x6f,
-	0x70, 0x65, 0x72, 0x74, 0x69, 0x65, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x52, 0x13, 0x69, 0x6e,
-	0x70, 0x75, 0x74, 0x4e, 0x6f, 0x64, 0x65, 0x50, 0x72, 0x6f, 0x70, 0x65, 0x72, 0x74, 0x69, 0x65,
-	0x73, 0x1a, 0x47, 0x0a, 0x19, 0x45, 0x6e, 0x76, 0x69, 0x72, 0x6f, 0x6e, 0x6d, 0x65, 0x6e, 0x74,
-	0x56, 0x61, 0x72, 0x69, 0x61, 0x62, 0x6c, 0x65, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x10,
-	0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79,
-	0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52,
-	0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x1a, 0x5b, 0x0a, 0x18, 0x49, 0x6e,
-	0x70, 0x75, 0x74, 0x4e, 0x6f, 0x64, 0x65, 0x50, 0x72, 0x6f, 0x70, 0x65, 0x72, 0x74, 0x69, 0x65,
-	0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20,
-	0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x29, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75,
-	0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x13, 0x2e, 0x63, 0x6d, 0x64, 0x2e, 0x4e, 0x6f,
-	0x64, 0x65, 0x50, 0x72, 0x6f, 0x70, 0x65, 0x72, 0x74, 0x69, 0x65, 0x73, 0x52, 0x05, 0x76, 0x61,
-	0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x22, 0xb0, 0x01, 0x0a, 0x0e, 0x4e, 0x6f, 0x64, 0x65,
-	0x50, 0x72, 0x6f, 0x70, 0x65, 0x72, 0x74, 0x69, 0x65, 0x73, 0x12, 0x31, 0x0a, 0x0a, 0x70, 0x72,
-	0x6f, 0x70, 0x65, 0x72, 0x74, 0x69, 0x65, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x11,
-	0x2e, 0x63, 0x6d, 0x64, 0x2e, 0x4e, 0x6f, 0x64, 0x65, 0x50, 0x72, 0x6f, 0x70, 0x65, 0x72, 0x74,
-	0x79, 0x52, 0x0a, 0x70, 0x72, 0x6f, 0x70, 0x65, 0x72, 0x74, 0x69, 0x65, 0x73, 0x12, 0x30, 0x0a,
-	0x05, 0x6d, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67,
-	0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54,
-	0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x05, 0x6d, 0x74, 0x69, 0x6d, 0x65, 0x12,
-	0x39, 0x0a, 0x09, 0x75, 0x6e, 0x69, 0x78, 0x5f, 0x6d, 0x6f, 0x64, 0x65, 0x18, 0x03, 0x20, 0x01,
-	0x28, 0x0b, 0x32, 0x1c, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74,
-	0x6f, 0x62, 0x75, 0x66, 0x2e, 0x55, 0x49, 0x6e, 0x74, 0x33, 0x32, 0x56, 0x61, 0x6c, 0x75, 0x65,
-	0x52, 0x08, 0x75, 0x6e, 0x69, 0x78, 0x4d, 0x6f, 0x64, 0x65, 0x22, 0x38, 0x0a, 0x0c, 0x4e, 0x6f,
-	0x64, 0x65, 0x50, 0x72, 0x6f, 0x70, 0x65, 0x72, 0x74, 0x79, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x61,
-	0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x14,
-	0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x76,
-	0x61, 0x6c, 0x75, 0x65, 0x22, 0x5e, 0x0a, 0x0a, 0x4f, 0x75, 0x74, 0x70, 0x75, 0x74, 0x53, 0x70,
-	0x65, 0x63, 0x12, 0x21, 0x0a, 0x0c, 0x6f, 0x75, 0x74, 0x70, 0x75, 0x74, 0x5f, 0x66, 0x69, 0x6c,
-	0x65, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x09, 0x52, 0x0b, 0x6f, 0x75, 0x74, 0x70, 0x75, 0x74,
-	0x46, 0x69, 0x6c, 0x65, 0x73, 0x12, 0x2d, 0x0a, 0x12, 0x6f, 0x75, 0x74, 0x70, 0x75, 0x74, 0x5f,
-	0x64, 0x69, 0x72, 0x65, 0x63, 0x74, 0x6f, 0x72, 0x69, 0x65, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28,
-	0x09, 0x52, 0x11, 0x6f, 0x75, 0x74, 0x70, 0x75, 0x74, 0x44, 0x69, 0x72, 0x65, 0x63, 0x74, 0x6f,
-	0x72, 0x69, 0x65, 0x73, 0x22, 0x9c, 0x01, 0x0a, 0x13, 0x43, 0x6f, 0x6d, 0x6d, 0x61, 0x6e, 0x64,
+	0x74, 0x6f, 0x72, 0x79, 0x22, 0x9c, 0x01, 0x0a, 0x0b, 0x52, 0x65, 0x6d, 0x6f, 0x74, 0x65, 0x49,
+	0x6e, 0x70, 0x75, 0x74, 0x12, 0x12, 0x0a, 0x04, 0x70, 0x61, 0x74, 0x68, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x04, 0x70, 0x61, 0x74, 0x68, 0x12, 0x12, 0x0a, 0x04, 0x68, 0x61, 0x73, 0x68,
+	0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x68, 0x61, 0x73, 0x68, 0x12, 0x1d, 0x0a, 0x0a,
+	0x73, 0x69, 0x7a, 0x65, 0x5f, 0x62, 0x79, 0x74, 0x65, 0x73, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03,
+	0x52, 0x09, 0x73, 0x69, 0x7a, 0x65, 0x42, 0x79, 0x74, 0x65, 0x73, 0x12, 0x21, 0x0a, 0x0c, 0x69,
+	0x73, 0x5f, 0x64, 0x69, 0x72, 0x65, 0x63, 0x74, 0x6f, 0x72, 0x79, 0x18, 0x04, 0x20, 0x01, 0x28,
+	0x08, 0x52, 0x0b, 0x69, 0x73, 0x44, 0x69, 0x72, 0x65, 0x63, 0x74, 0x6f, 0x72, 0x79, 0x12, 0x23,
+	0x0a, 0x0d, 0x69, 0x73, 0x5f, 0x65, 0x78, 0x65, 0x63, 0x75, 0x74, 0x61, 0x62, 0x6c, 0x65, 0x18,
+	0x05, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0c, 0x69, 0x73, 0x45, 0x78, 0x65, 0x63, 0x75, 0x74, 0x61,
+	0x62, 0x6c, 0x65, 0x22, 0x4a, 0x0a, 0x13, 0x53, 0x79, 0x6d, 0x6c, 0x69, 0x6e, 0x6b, 0x42, 0x65,
+	0x68, 0x61, 0x76, 0x69, 0x6f, 0x72, 0x54, 0x79, 0x70, 0x65, 0x22, 0x33, 0x0a, 0x05, 0x56, 0x61,
+	0x6c, 0x75, 0x65, 0x12, 0x0f, 0x0a, 0x0b, 0x55, 0x4e, 0x53, 0x50, 0x45, 0x43, 0x49, 0x46, 0x49,
+	0x45, 0x44, 0x10, 0x00, 0x12, 0x0b, 0x0a, 0x07, 0x52, 0x45, 0x53, 0x4f, 0x4c, 0x56, 0x45, 0x10,
+	0x01, 0x12, 0x0c, 0x0a, 0x08, 0x50, 0x52, 0x45, 0x53, 0x45, 0x52, 0x56, 0x45, 0x10, 0x02, 0x22,
+	0xc1, 0x05, 0x0a, 0x09, 0x49, 0x6e, 0x70, 0x75, 0x74, 0x53, 0x70, 0x65, 0x63, 0x12, 0x16, 0x0a,
+	0x06, 0x69, 0x6e, 0x70, 0x75, 0x74, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x09, 0x52, 0x06, 0x69,
+	0x6e, 0x70, 0x75, 0x74, 0x73, 0x12, 0x38, 0x0a, 0x0e, 0x76, 0x69, 0x72, 0x74, 0x75, 0x61, 0x6c,
+	0x5f, 0x69, 0x6e, 0x70, 0x75, 0x74, 0x73, 0x18, 0x05, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x11, 0x2e,
+	0x63, 0x6d, 0x64, 0x2e, 0x56, 0x69, 0x72, 0x74, 0x75, 0x61, 0x6c, 0x49, 0x6e, 0x70, 0x75, 0x74,
+	0x52, 0x0d, 0x76, 0x69, 0x72, 0x74, 0x75, 0x61, 0x6c, 0x49, 0x6e, 0x70, 0x75, 0x74, 0x73, 0x12,
+	0x35, 0x0a, 0x0d, 0x72, 0x65, 0x6d, 0x6f, 0x74, 0x65, 0x5f, 0x69, 0x6e, 0x70, 0x75, 0x74, 0x73,
+	0x18, 0x0a, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x10, 0x2e, 0x63, 0x6d, 0x64, 0x2e, 0x52, 0x65, 0x6d,
+	0x6f, 0x74, 0x65, 0x49, 0x6e, 0x70, 0x75, 0x74, 0x52, 0x0c, 0x72, 0x65, 0x6d, 0x6f, 0x74, 0x65,
+	0x49, 0x6e, 0x70, 0x75, 0x74, 0x73, 0x12, 0x38, 0x0a, 0x0e, 0x65, 0x78, 0x63, 0x6c, 0x75, 0x64,
+	0x65, 0x5f, 0x69, 0x6e, 0x70, 0x75, 0x74, 0x73, 0x18, 0x03, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x11,
+	0x2e, 0x63, 0x6d, 0x64, 0x2e, 0x45, 0x78, 0x63, 0x6c, 0x75, 0x64, 0x65, 0x49, 0x6e, 0x70, 0x75,
+	0x74, 0x52, 0x0d, 0x65, 0x78, 0x63, 0x6c, 0x75, 0x64, 0x65, 0x49, 0x6e, 0x70, 0x75, 0x74, 0x73,
+	0x12, 0x5d, 0x0a, 0x15, 0x65, 0x6e, 0x76, 0x69, 0x72, 0x6f, 0x6e, 0x6d, 0x65, 0x6e, 0x74, 0x5f,
+	0x76, 0x61, 0x72, 0x69, 0x61, 0x62, 0x6c, 0x65, 0x73, 0x18, 0x04, 0x20, 0x03, 0x28, 0x0b, 0x32,
+	0x28, 0x2e, 0x63, 0x6d, 0x64, 0x2e, 0x49, 0x6e, 0x70, 0x75, 0x74, 0x53, 0x70, 0x65, 0x63, 0x2e,
+	0x45, 0x6e, 0x76, 0x69, 0x72, 0x6f, 0x6e, 0x6d, 0x65, 0x6e, 0x74, 0x56, 0x61, 0x72, 0x69, 0x61,
+	0x62, 0x6c, 0x65, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x52, 0x14, 0x65, 0x6e, 0x76, 0x69, 0x72,
+	0x6f, 0x6e, 0x6d, 0x65, 0x6e, 0x74, 0x56, 0x61, 0x72, 0x69, 0x61, 0x62, 0x6c, 0x65, 0x73, 0x12,
+	0x49, 0x0a, 0x10, 0x73, 0x79, 0x6d, 0x6c, 0x69, 0x6e, 0x6b, 0x5f, 0x62, 0x65, 0x68, 0x61, 0x76,
+	0x69, 0x6f, 0x72, 0x18, 0x06, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x1e, 0x2e, 0x63, 0x6d, 0x64, 0x2e,
+	0x53, 0x79, 0x6d, 0x6c, 0x69, 0x6e, 0x6b, 0x42, 0x65, 0x68, 0x61, 0x76, 0x69, 0x6f, 0x72, 0x54,
+	0x79, 0x70, 0x65, 0x2e, 0x56, 0x61, 0x6c, 0x75, 0x65, 0x52, 0x0f, 0x73, 0x79, 0x6d, 0x6c, 0x69,
+	0x6e, 0x6b, 0x42, 0x65, 0x68, 0x61, 0x76, 0x69, 0x6f, 0x72, 0x12, 0x5b, 0x0a, 0x15, 0x69, 0x6e,
+	0x70, 0x75, 0x74, 0x5f, 0x6e, 0x6f, 0x64, 0x65, 0x5f, 0x70, 0x72, 0x6f, 0x70, 0x65, 0x72, 0x74,
+	0x69, 0x65, 0x73, 0x18, 0x07, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x27, 0x2e, 0x63, 0x6d, 0x64, 0x2e,
+	0x49, 0x6e, 0x70, 0x75, 0x74, 0x53, 0x70, 0x65, 0x63, 0x2e, 0x49, 0x6e, 0x70, 0x75, 0x74, 0x4e,
+	0x6f, 0x64, 0x65, 0x50, 0x72, 0x6f, 0x70, 0x65, 0x72, 0x74, 0x69, 0x65, 0x73, 0x45, 0x6e, 0x74,
+	0x72, 0x79, 0x52, 0x13, 0x69, 0x6e, 0x70, 0x75, 0x74, 0x4e, 0x6f, 0x64, 0x65, 0x50, 0x72, 0x6f,
+	0x70, 0x65, 0x72, 0x74, 0x69, 0x65, 0x73, 0x12, 0x1f, 0x0a, 0x0b, 0x69, 0x6e, 0x70, 0x75, 0x74,
+	0x5f, 0x67, 0x6c, 0x6f, 0x62, 0x73, 0x18, 0x08, 0x20, 0x03, 0x28, 0x09, 0x52, 0x0a, 0x69, 0x6e,
+	0x70, 0x75, 0x74, 0x47, 0x6c, 0x6f, 0x62, 0x73, 0x12, 0x23, 0x0a, 0x0d, 0x65, 0x78, 0x63, 0x6c,
+	0x75, 0x64, 0x65, 0x5f, 0x67, 0x6c, 0x6f, 0x62, 0x73, 0x18, 0x09, 0x20, 0x03, 0x28, 0x09, 0x52,
+	0x0c, 0x65, 0x78, 0x63, 0x6c, 0x75, 0x64, 0x65, 0x47, 0x6c, 0x6f, 0x62, 0x73, 0x1a, 0x47, 0x0a,
+	0x19, 0x45, 0x6e, 0x76, 0x69, 0x72, 0x6f, 0x6e, 0x6d, 0x65, 0x6e, 0x74, 0x56, 0x61, 0x72, 0x69,
+	0x61, 0x62, 0x6c, 0x65, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65,
+	0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x14, 0x0a, 0x05,
+	0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x76, 0x61, 0x6c,
+	0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x1a, 0x5b, 0x0a, 0x18, 0x49, 0x6e, 0x70, 0x75, 0x74, 0x4e,
+	0x6f, 0x64, 0x65, 0x50, 0x72, 0x6f, 0x70, 0x65, 0x72, 0x74, 0x69, 0x65, 0x73, 0x45, 0x6e, 0x74,
+	0x72, 0x79, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x03, 0x6b, 0x65, 0x79, 0x12, 0x29, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x0b, 0x32, 0x13, 0x2e, 0x63, 0x6d, 0x64, 0x2e, 0x4e, 0x6f, 0x64, 0x65, 0x50, 0x72,
+	0x6f, 0x70, 0x65, 0x72, 0x74, 0x69, 0x65, 0x73, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a,
+	0x02, 0x38, 0x01, 0x22, 0xb0, 0x01, 0x0a, 0x0e, 0x4e, 0x6f, 0x64, 0x65, 0x50, 0x72, 0x6f, 0x70,
+	0x65, 0x72, 0x74, 0x69, 0x65, 0x73, 0x12, 0x31, 0x0a, 0x0a, 0x70, 0x72, 0x6f, 0x70, 0x65, 0x72,
+	0x74, 0x69, 0x65, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x11, 0x2e, 0x63, 0x6d, 0x64,
+	0x2e, 0x4e, 0x6f, 0x64, 0x65, 0x50, 0x72, 0x6f, 0x70, 0x65, 0x72, 0x74, 0x79, 0x52, 0x0a, 0x70,
+	0x72, 0x6f, 0x70, 0x65, 0x72, 0x74, 0x69, 0x65, 0x73, 0x12, 0x30, 0x0a, 0x05, 0x6d, 0x74, 0x69,
+	0x6d, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c,
+	0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73,
+	0x74, 0x61, 0x6d, 0x70, 0x52, 0x05, 0x6d, 0x74, 0x69, 0x6d, 0x65, 0x12, 0x39, 0x0a, 0x09, 0x75,
+	0x6e, 0x69, 0x78, 0x5f, 0x6d, 0x6f, 0x64, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1c,
+	0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66,
+	0x2e, 0x55, 0x49, 0x6e, 0x74, 0x33, 0x32, 0x56, 0x61, 0x6c, 0x75, 0x65, 0x52, 0x08, 0x75, 0x6e,
+	0x69, 0x78, 0x4d, 0x6f, 0x64, 0x65, 0x22, 0x38, 0x0a, 0x0c, 0x4e, 0x6f, 0x64, 0x65, 0x50, 0x72,
+	0x6f, 0x70, 0x65, 0x72, 0x74, 0x79, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61,
+	0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65,
+	0x22, 0x81, 0x01, 0x0a, 0x0a, 0x4f, 0x75, 0x74, 0x70, 0x75, 0x74, 0x53, 0x70, 0x65, 0x63, 0x12,
+	0x21, 0x0a, 0x0c, 0x6f, 0x75, 0x74, 0x70, 0x75, 0x74, 0x5f, 0x66, 0x69, 0x6c, 0x65, 0x73, 0x18,
+	0x01, 0x20, 0x03, 0x28, 0x09, 0x52, 0x0b, 0x6f, 0x75, 0x74, 0x70, 0x75, 0x74, 0x46, 0x69, 0x6c,
+	0x65, 0x73, 0x12, 0x2d, 0x0a, 0x12, 0x6f, 0x75, 0x74, 0x70, 0x75, 0x74, 0x5f, 0x64, 0x69, 0x72,
+	0x65, 0x63, 0x74, 0x6f, 0x72, 0x69, 0x65, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x09, 0x52, 0x11,
+	0x6f, 0x75, 0x74, 0x70, 0x75, 0x74, 0x44, 0x69, 0x72, 0x65, 0x63, 0x74, 0x6f, 0x72, 0x69, 0x65,
+	0x73, 0x12, 0x21, 0x0a, 0x0c, 0x6f, 0x75, 0x74, 0x70, 0x75, 0x74, 0x5f, 0x67, 0x6c, 0x6f, 0x62,
+	0x73, 0x18, 0x03, 0x20, 0x03, 0x28, 0x09, 0x52, 0x0b, 0x6f, 0x75, 0x74, 0x70, 0x75, 0x74, 0x47,
+	0x6c, 0x6f, 0x62, 0x73, 0x22, 0x9c, 0x01, 0x0a, 0x13, 0x43, 0x6f, 0x6d, 0x6d, 0x61, 0x6e, 0x64,
 	0x52, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x22, 0x84, 0x01, 0x0a,
 	0x05, 0x56, 0x61, 0x6c, 0x75, 0x65, 0x12, 0x0b, 0x0a, 0x07, 0x55, 0x4e, 0x4b, 0x4e, 0x4f, 0x57,
 	0x4e, 0x10, 0x00, 0x12, 0x0b, 0x0a, 0x07, 0x53, 0x55, 0x43, 0x43, 0x45, 0x53, 0x53, 0x10, 0x01,
@@ -1173,7 +1304,7 @@ func file_go_api_command_command_proto_rawDescGZIP() []byte {
 }
 
 var file_go_api_command_command_proto_enumTypes = make([]protoimpl.EnumInfo, 3)
-var file_go_api_command_command_proto_msgTypes = make([]protoimpl.MessageInfo, 16)
+var file_go_api_command_command_proto_msgTypes = make([]protoimpl.MessageInfo, 17)
 var file_go_api_command_command_proto_goTypes = []interface{}{
 	(InputType_Value)(0),           // 0: cmd.InputType.Value
 	(SymlinkBehaviorType_Value)(0), // 1: cmd.SymlinkBehaviorType.Value
@@ -1183,43 +1314,45 @@ var file_go_api_command_command_proto_goTypes = []interface{}{
 	(*InputType)(nil),              // 5: cmd.InputType
 	(*ExcludeInput)(nil),           // 6: cmd.ExcludeInput
 	(*VirtualInput)(nil),           // 7: cmd.VirtualInput
-	(*SymlinkBehaviorType)(nil),    // 8: cmd.SymlinkBehaviorType
-	(*InputSpec)(nil),              // 9: cmd.InputSpec
-	(*NodeProperties)(nil),         // 10: cmd.NodeProperties
-	(*NodeProperty)(nil),           // 11: cmd.NodeProperty
-	(*OutputSpec)(nil),             // 12: cmd.OutputSpec
-	(*CommandResultStatus)(nil),    // 13: cmd.CommandResultStatus
-	(*CommandResult)(nil),          // 14: cmd.CommandResult
-	(*TimeInterval)(nil),           // 15: cmd.TimeInterval
-	nil,                            // 16: cmd.Command.PlatformEntry
-	nil,                            // 17: cmd.InputSpec.EnvironmentVariablesEntry
-	nil,                            // 18: cmd.InputSpec.InputNodePropertiesEntry
-	(*timestamppb.Timestamp)(nil),  // 19: google.protobuf.Timestamp
-	(*wrapperspb.UInt32Value)(nil), // 20: google.protobuf.UInt32Value
+	(*RemoteInput)(nil),            // 8: cmd.RemoteInput
+	(*SymlinkBehaviorType)(nil),    // 9: cmd.SymlinkBehaviorType
+	(*InputSpec)(nil),              // 10: cmd.InputSpec
+	(*NodeProperties)(nil),         // 11: cmd.NodeProperties
+	(*NodeProperty)(nil),           // 12: cmd.NodeProperty
+	(*OutputSpec)(nil),             // 13: cmd.OutputSpec
+	(*CommandResultStatus)(nil),    // 14: cmd.CommandResultStatus
+	(*CommandResult)(nil),          // 15: cmd.CommandResult
+	(*TimeInterval)(nil),           // 16: cmd.TimeInterval
+	nil,                            // 17: cmd.Command.PlatformEntry
+	nil,                            // 18: cmd.InputSpec.EnvironmentVariablesEntry
+	nil,                            // 19: cmd.InputSpec.InputNodePropertiesEntry
+	(*timestamppb.Timestamp)(nil),  // 20: google.protobuf.Timestamp
+	(*wrapperspb.UInt32Value)(nil), // 21: google.protobuf.UInt32Value
 }
 var file_go_api_command_command_proto_depIdxs = []int32{
 	4,  // 0: cmd.Command.identifiers:type_name -> cmd.Identifiers
-	9,  // 1: cmd.Command.input:type_name -> cmd.InputSpec
-	12, // 2: cmd.Command.output:type_name -> cmd.OutputSpec
-	16, // 3: cmd.Command.platform:type_name -> cmd.Command.PlatformEntry
+	10, // 1: cmd.Command.input:type_name -> cmd.InputSpec
+	13, // 2: cmd.Command.output:type_name -> cmd.OutputSpec
+	17, // 3: cmd.Command.platform:type_name -> cmd.Command.PlatformEntry
 	0,  // 4: cmd.ExcludeInput.type:type_name -> cmd.InputType.Value
 	7,  // 5: cmd.InputSpec.virtual_inputs:type_name -> cmd.VirtualInput
-	6,  // 6: cmd.InputSpec.exclude_inputs:type_name -> cmd.ExcludeInput
-	17, // 7: cmd.InputSpec.environment_variables:type_name -> cmd.InputSpec.EnvironmentVariablesEntry
-	1,  // 8: cmd.InputSpec.symlink_behavior:type_name -> cmd.SymlinkBehaviorType.Value
-	18, // 9: cmd.InputSpec.input_node_properties:type_name -> cmd.InputSpec.InputNodePropertiesEntry
-	11, // 10: cmd.NodeProperties.properties:type_name -> cmd.NodeProperty
-	19, // 11: cmd.NodeProperties.mtime:type_name -> google.protobuf.Timestamp
-	20, // 12: cmd.NodeProperties.unix_mode:type_name -> google.protobuf.UInt32Value
-	2,  // 13: cmd.CommandResult.status:type_name -> cmd.CommandResultStatus.Value
-	19, // 14: cmd.TimeInterval.from:type_name -> google.protobuf.Timestamp
-	19, // 15: cmd.TimeInterval.to:type_name -> google.protobuf.Timestamp
-	10, // 16: cmd.InputSpec.InputNodePropertiesEntry.value:type_name -> cmd.NodeProperties
-	17, // [17:17] is the sub-list for method output_type
-	17, // [17:17] is the sub-list for method input_type
-	17, // [17:17] is the sub-list for extension type_name
-	17, // [17:17] is the sub-list for extension extendee
-	0,  // [0:17] is the sub-list for field type_name
+	8,  // 6: cmd.InputSpec.remote_inputs:type_name -> cmd.RemoteInput
+	6,  // 7: cmd.InputSpec.exclude_inputs:type_name -> cmd.ExcludeInput
+	18, // 8: cmd.InputSpec.environment_variables:type_name -> cmd.InputSpec.EnvironmentVariablesEntry
+	1,  // 9: cmd.InputSpec.symlink_behavior:type_name -> cmd.SymlinkBehaviorType.Value
+	19, // 10: cmd.InputSpec.input_node_properties:type_name -> cmd.InputSpec.InputNodePropertiesEntry
+	12, // 11: cmd.NodeProperties.properties:type_name -> cmd.NodeProperty
+	20, // 12: cmd.NodeProperties.mtime:type_name -> google.protobuf.Timestamp
+	21, // 13: cmd.NodeProperties.unix_mode:type_name -> google.protobuf.UInt32Value
+	2,  // 14: cmd.CommandResult.status:type_name -> cmd.CommandResultStatus.Value
+	20, // 15: cmd.TimeInterval.from:type_name -> google.protobuf.Timestamp
+	20, // 16: cmd.TimeInterval.to:type_name -> google.protobuf.Timestamp
+	11, // 17: cmd.InputSpec.InputNodePropertiesEntry.value:type_name -> cmd.NodeProperties
+	18, // [18:18] is the sub-list for method output_type
+	18, // [18:18] is the sub-list for method input_type
+	18, // [18:18] is the sub-list for extension type_name
+	18, // [18:18] is the sub-list for extension extendee
+	0,  // [0:18] is the sub-list for field type_name
 }
 
 func init() { file_go_api_command_command_proto_init() }
@@ -1289,7 +1422,7 @@ func file_go_api_command_command_proto_init() {
 			}
 		}
 		file_go_api_command_command_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*SymlinkBehaviorType); i {
+			switch v := v.(*RemoteInput); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1301,7 +1434,7 @@ func file_go_api_command_command_proto_init() {
 			}
 		}
 		file_go_api_command_command_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*InputSpec); i {
+			switch v := v.(*SymlinkBehaviorType); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1313,7 +1446,7 @@ func file_go_api_command_command_proto_init() {
 			}
 		}
 		file_go_api_command_command_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*NodeProperties); i {
+			switch v := v.(*InputSpec); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1325,7 +1458,7 @@ func file_go_api_command_command_proto_init() {
 			}
 		}
 		file_go_api_command_command_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*NodeProperty); i {
+			switch v := v.(*NodeProperties); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1337,7 +1470,7 @@ func file_go_api_command_command_proto_init() {
 			}
 		}
 		file_go_api_command_command_proto_msgTypes[9].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*OutputSpec); i {
+			switch v := v.(*NodeProperty); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1349,7 +1482,7 @@ func file_go_api_command_command_proto_init() {
 			}
 		}
 		file_go_api_command_command_proto_msgTypes[10].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*CommandResultStatus); i {
+			switch v := v.(*OutputSpec); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1361,7 +1494,7 @@ func file_go_api_command_command_proto_init() {
 			}
 		}
 		file_go_api_command_command_proto_msgTypes[11].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*CommandResult); i {
+			switch v := v.(*CommandResultStatus); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1373,6 +1506,18 @@ func file_go_api_command_command_proto_init() {
 			}
 		}
 		file_go_api_command_command_proto_msgTypes[12].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*CommandResult); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_go_api_command_command_proto_msgTypes[13].Exporter = func(v interface{}, i int) interface{} {
 			switch v := v.(*TimeInterval); i {
 			case 0:
 				return &v.state
@@ -1391,7 +1536,7 @@ func file_go_api_command_command_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_go_api_command_command_proto_rawDesc,
 			NumEnums:      3,
-			NumMessages:   16,
+			NumMessages:   17,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
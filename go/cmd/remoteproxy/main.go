@@ -0,0 +1,77 @@
+// Main package for the remoteproxy binary.
+//
+// This tool serves the REAPI services on a local Unix socket, forwarding all requests to a remote
+// backend through a single connection. Concurrent identical uploads and FindMissingBlobs queries of
+// its clients are deduplicated, and blobs are cached in a local disk CAS shared by all clients.
+//
+// Example usage:
+//
+//	remoteproxy --alsologtostderr --v 1 \
+//	  --service remotebuildexecution.googleapis.com:443 \
+//	  --instance $INSTANCE \
+//	  --credential_file $CRED_FILE \
+//	  --proxy_socket /tmp/remoteproxy.sock \
+//	  --proxy_cache_dir /tmp/remoteproxy-cache
+//
+// Clients then connect with --service unix:///tmp/remoteproxy.sock --service_no_security.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"syscall"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/proxy"
+
+	rflags "github.com/bazelbuild/remote-apis-sdks/go/pkg/flags"
+	log "github.com/golang/glog"
+)
+
+var (
+	socket   = flag.String("proxy_socket", "", "Path of the Unix socket to serve on.")
+	cacheDir = flag.String("proxy_cache_dir", filepath.Join(os.TempDir(), "remoteproxy-cache"), "Directory of the local disk CAS. It may be shared by several proxies, and grows without bound: blobs are never removed from it.")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %v [-flags]\n", path.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *socket == "" {
+		log.Exitf("--proxy_socket must be specified.")
+	}
+
+	ctx := context.Background()
+	grpcClient, err := rflags.NewClientFromFlags(ctx)
+	if err != nil {
+		log.Exitf("error connecting to remote execution client: %v", err)
+	}
+	defer grpcClient.Close()
+	p, err := proxy.New(grpcClient, &proxy.Options{CacheDir: *cacheDir})
+	if err != nil {
+		log.Exitf("error creating proxy: %v", err)
+	}
+	lis, err := proxy.ListenUnix(*socket)
+	if err != nil {
+		log.Exitf("error listening on %v: %v", *socket, err)
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		log.Infof("Shutting down proxy")
+		p.Stop()
+	}()
+	log.Infof("Serving on %v", *socket)
+	if err := p.Serve(lis); err != nil {
+		log.Exitf("error serving: %v", err)
+	}
+	log.Infof("Proxy stats: %+v", p.Stats())
+}
@@ -6,6 +6,9 @@
 // 2. Display details of a remotely executed action.
 // 3. Download action results by the action digest.
 // 4. Re-execute remote action (with optional inputs override).
+// 5. Check that a server conforms to the parts of the API the SDK relies on.
+// 6. Verify that all the blobs of an action or directory tree are in the CAS.
+// 7. Copy an action, its inputs and its cached result to another instance or service.
 //
 // Example (download an action result from remote action cache):
 //
@@ -25,7 +28,9 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"strings"
 
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/conformance"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/outerr"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/tool"
 
@@ -42,10 +47,14 @@ const (
 	downloadAction       OpType = "download_action"
 	downloadBlob         OpType = "download_blob"
 	downloadDir          OpType = "download_dir"
+	downloadManifest     OpType = "download_manifest_outputs"
 	executeAction        OpType = "execute_action"
 	checkDeterminism     OpType = "check_determinism"
 	uploadBlob           OpType = "upload_blob"
 	uploadBlobV2         OpType = "upload_blob_v2"
+	checkConformance     OpType = "check_conformance"
+	verify               OpType = "verify"
+	copyAction           OpType = "copy"
 )
 
 var supportedOps = []OpType{
@@ -54,9 +63,13 @@ var supportedOps = []OpType{
 	downloadAction,
 	downloadBlob,
 	downloadDir,
+	downloadManifest,
 	executeAction,
 	checkDeterminism,
 	uploadBlob,
+	checkConformance,
+	verify,
+	copyAction,
 }
 
 var (
@@ -67,6 +80,18 @@ var (
 	actionRoot   = flag.String("action_root", "", "For execute_action: the root of the action spec, containing ac.textproto (Action proto), cmd.textproto (Command proto), and input/ (root of the input tree).")
 	execAttempts = flag.Int("exec_attempts", 10, "For check_determinism: the number of times to remotely execute the action and check for mismatches.")
 	_            = flag.String("input_root", "", "Deprecated. Use action root instead.")
+	manifest     = flag.String("manifest", "", "For download_manifest_outputs: the output manifest recording the outputs of an action that were not downloaded.")
+	outputs      = flag.String("outputs", "", "For download_manifest_outputs: comma-separated list of output paths, relative to the output directory of the manifest, to download. All outputs are downloaded if empty.")
+
+	verifyDirectory = flag.Bool("verify_directory", false, "For verify: --digest is the digest of a Directory instead of an Action.")
+	verifyDownload  = flag.Bool("verify_download", false, "For verify: also download every blob and check it against its digest, instead of only checking that it is in the CAS.")
+
+	// dstFlags connect to the destination of copy, e.g. --dst_service and --dst_instance.
+	dstFlags = rflags.NewConnectionFlags(flag.CommandLine, "dst_")
+
+	conformanceTests         = flag.String("conformance_tests", "", fmt.Sprintf("For check_conformance: comma-separated list of checks to run, out of %v. All checks are run if empty.", conformance.Names()))
+	conformanceSkipExecution = flag.Bool("conformance_skip_execution", false, "For check_conformance: skip the checks that execute actions.")
+	conformancePlatform      = flag.String("conformance_platform", "", "For check_conformance: comma-separated list of key=value platform properties of executed actions.")
 )
 
 func main() {
@@ -108,6 +133,18 @@ func main() {
 			log.Exitf("error downloading directory for digest %v: %v", getDigestFlag(), err)
 		}
 
+	case downloadManifest:
+		if *manifest == "" {
+			log.Exitf("--manifest must be specified.")
+		}
+		var paths []string
+		if *outputs != "" {
+			paths = strings.Split(*outputs, ",")
+		}
+		if err := c.DownloadManifestOutputs(ctx, *manifest, paths, *pathPrefix); err != nil {
+			log.Exitf("error downloading outputs from manifest %v: %v", *manifest, err)
+		}
+
 	case showAction:
 		res, err := c.ShowAction(ctx, getDigestFlag())
 		if err != nil {
@@ -142,6 +179,33 @@ func main() {
 			log.Exitf("error uploading blob for digest %v: %v", getDigestFlag(), err)
 		}
 
+	case checkConformance:
+		opts, err := getConformanceOptions()
+		if err != nil {
+			log.Exitf("%v", err)
+		}
+		if err := c.CheckConformance(ctx, opts, os.Stdout); err != nil {
+			log.Exitf("error checking conformance: %v", err)
+		}
+
+	case verify:
+		if err := c.Verify(ctx, getDigestFlag(), *verifyDirectory, *verifyDownload, os.Stdout); err != nil {
+			log.Exitf("error verifying %v: %v", getDigestFlag(), err)
+		}
+
+	case copyAction:
+		if *dstFlags.Service == "" {
+			log.Exitf("--dst_service must be specified.")
+		}
+		dst, err := dstFlags.NewClient(ctx)
+		if err != nil {
+			log.Exitf("error connecting to the destination remote execution client: %v", err)
+		}
+		defer dst.Close()
+		if err := c.Copy(ctx, dst, getDigestFlag(), os.Stdout); err != nil {
+			log.Exitf("error copying action %v: %v", getDigestFlag(), err)
+		}
+
 	default:
 		log.Exitf("unsupported operation %v. Supported operations:\n%v", *operation, supportedOps)
 	}
@@ -160,3 +224,21 @@ func getPathFlag() string {
 	}
 	return *pathPrefix
 }
+
+func getConformanceOptions() (*conformance.Options, error) {
+	opts := &conformance.Options{SkipExecution: *conformanceSkipExecution}
+	if *conformanceTests != "" {
+		opts.Tests = strings.Split(*conformanceTests, ",")
+	}
+	if *conformancePlatform != "" {
+		opts.Platform = make(map[string]string)
+		for _, p := range strings.Split(*conformancePlatform, ",") {
+			kv := strings.SplitN(p, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("--conformance_platform: expected key=value, got %q", p)
+			}
+			opts.Platform[kv[0]] = kv[1]
+		}
+	}
+	return opts, nil
+}
@@ -43,6 +43,7 @@ func initFlags(cmd *command.Command, opt *command.ExecutionOptions) {
 	flag.Var((*moreflag.StringListValue)(&cmd.InputSpec.Inputs), "inputs", "Comma-separated command input paths, relative to exec root.")
 	flag.Var((*moreflag.StringListValue)(&cmd.OutputFiles), "output_files", "Comma-separated command output file paths, relative to exec root.")
 	flag.Var((*moreflag.StringListValue)(&cmd.OutputDirs), "output_directories", "Comma-separated command output directory paths, relative to exec root.")
+	flag.Var((*moreflag.StringListValue)(&cmd.OutputGlobs), "output_globs", "Comma-separated glob patterns matching additional command output files, relative to the working directory.")
 	flag.DurationVar(&cmd.Timeout, "exec_timeout", 0, "Timeout for the command. Value of 0 means no timeout.")
 	flag.Var((*moreflag.StringMapValue)(&cmd.Platform), "platform", "Comma-separated key value pairs in the form key=value. This is used to identify remote platform settings like the docker image to use to run the command.")
 	flag.Var((*moreflag.StringMapValue)(&cmd.InputSpec.EnvironmentVariables), "environment_variables", "Environment variables to pass through to remote execution, as comma-separated key value pairs in the form key=value.")
@@ -50,6 +51,7 @@ func initFlags(cmd *command.Command, opt *command.ExecutionOptions) {
 	flag.BoolVar(&opt.DoNotCache, "do_not_cache", false, "Boolean indicating whether to skip caching the command result remotely.")
 	flag.BoolVar(&opt.DownloadOutputs, "download_outputs", true, "Boolean indicating whether to download outputs after the command is executed.")
 	flag.BoolVar(&opt.DownloadOutErr, "download_outerr", true, "Boolean indicating whether to download stdout and stderr after the command is executed.")
+	flag.Var((*moreflag.StringListValue)(&opt.OutputNodeProperties), "output_node_properties", "Comma-separated names of node properties to capture for outputs, e.g. mtime,unix_mode.")
 }
 
 func main() {
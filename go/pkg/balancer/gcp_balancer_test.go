@@ -1,6 +1,7 @@
 package balancer
 
 import (
+	"context"
 	"sync"
 	"testing"
 	"time"
@@ -42,6 +43,38 @@ func TestGCPBalancer_UpdatingConnectionStateIsMutuallyExclusive(t *testing.T) {
 	wg.Wait()
 }
 
+func TestGCPPicker_DistinctSubConns(t *testing.T) {
+	var refs []*subConnRef
+	for i := 0; i < 3; i++ {
+		refs = append(refs, &subConnRef{subConn: &fakeSubConn{id: uuid.New()}})
+	}
+	picker := &gcpPicker{scRefs: refs, poolCfg: &poolConfig{maxConn: 3, maxStream: 10}}
+	pick := func(ctx context.Context) grpcbalancer.SubConn {
+		t.Helper()
+		res, err := picker.Pick(grpcbalancer.PickInfo{Ctx: ctx})
+		if err != nil {
+			t.Fatalf("Pick() failed: %v", err)
+		}
+		// Finish the call right away, so that all subconns stay equally busy.
+		res.Done(grpcbalancer.DoneInfo{})
+		return res.SubConn
+	}
+
+	ctx := WithDistinctSubConns(context.Background())
+	picked := make(map[grpcbalancer.SubConn]bool)
+	for i := 0; i < len(refs); i++ {
+		sc := pick(ctx)
+		if picked[sc] {
+			t.Errorf("Pick() #%d returned an already picked subconn", i)
+		}
+		picked[sc] = true
+	}
+	// Once all subconns were used by the group, picking falls back to the least busy one.
+	if sc := pick(ctx); !picked[sc] {
+		t.Errorf("Pick() returned an unknown subconn")
+	}
+}
+
 type fakeClientConn struct {
 	grpcbalancer.ClientConn
 
@@ -1,6 +1,7 @@
 package balancer
 
 import (
+	"context"
 	"fmt"
 	"reflect"
 	"sort"
@@ -11,6 +12,33 @@ import (
 	"google.golang.org/grpc/balancer"
 )
 
+type distinctSubConnsKey struct{}
+
+// distinctSubConns records the sub-connections used by a group of calls.
+type distinctSubConns struct {
+	mu   sync.Mutex
+	used map[balancer.SubConn]bool
+}
+
+func (d *distinctSubConns) has(sc balancer.SubConn) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.used[sc]
+}
+
+func (d *distinctSubConns) add(sc balancer.SubConn) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.used[sc] = true
+}
+
+// WithDistinctSubConns returns a context for a group of calls that should be sent on distinct
+// sub-connections when possible, such as a call and its hedges. Calls made with the returned
+// context prefer sub-connections that were not picked for the previous calls of the group.
+func WithDistinctSubConns(ctx context.Context) context.Context {
+	return context.WithValue(ctx, distinctSubConnsKey{}, &distinctSubConns{used: make(map[balancer.SubConn]bool)})
+}
+
 func newGCPPicker(readySCRefs []*subConnRef, gb *gcpBalancer) balancer.Picker {
 	return &gcpPicker{
 		gcpBalancer: gb,
@@ -58,11 +86,15 @@ func (p *gcpPicker) Pick(info balancer.PickInfo) (result balancer.PickResult, er
 		}
 	}
 
+	distinct, _ := info.Ctx.Value(distinctSubConnsKey{}).(*distinctSubConns)
 	var scRef *subConnRef
-	scRef, err = p.getSubConnRef(boundKey)
+	scRef, err = p.getSubConnRef(boundKey, distinct)
 	if err != nil {
 		return balancer.PickResult{}, err
 	}
+	if distinct != nil {
+		distinct.add(scRef.subConn)
+	}
 	result.SubConn = scRef.subConn
 	scRef.streamsIncr()
 
@@ -89,8 +121,9 @@ func (p *gcpPicker) Pick(info balancer.PickInfo) (result balancer.PickResult, er
 }
 
 // getSubConnRef returns the subConnRef object that contains the subconn
-// ready to be used by picker.
-func (p *gcpPicker) getSubConnRef(boundKey string) (*subConnRef, error) {
+// ready to be used by picker. If distinct is not nil, subconns it already
+// used are avoided unless all others are busy.
+func (p *gcpPicker) getSubConnRef(boundKey string, distinct *distinctSubConns) (*subConnRef, error) {
 	if boundKey != "" {
 		if ref, ok := p.gcpBalancer.getReadySubConnRef(boundKey); ok {
 			return ref, nil
@@ -101,6 +134,14 @@ func (p *gcpPicker) getSubConnRef(boundKey string) (*subConnRef, error) {
 		return p.scRefs[i].getStreamsCnt() < p.scRefs[j].getStreamsCnt()
 	})
 
+	if distinct != nil {
+		for _, ref := range p.scRefs {
+			if !distinct.has(ref.subConn) && ref.getStreamsCnt() < int32(p.poolCfg.maxStream) {
+				return ref, nil
+			}
+		}
+	}
+
 	// If the least busy connection still has capacity, use it
 	if len(p.scRefs) > 0 && p.scRefs[0].getStreamsCnt() < int32(p.poolCfg.maxStream) {
 		return p.scRefs[0], nil
@@ -0,0 +1,366 @@
+package balancer
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+	"google.golang.org/grpc/status"
+)
+
+// PoolName is the name of the pool balancer.
+const PoolName = "rbe_pool"
+
+const (
+	// DefaultPoolMaxStreams is the default maximum number of concurrent calls on a sub-connection of
+	// a pool.
+	DefaultPoolMaxStreams = 100
+
+	// DefaultPoolMaxFailures is the default number of consecutive UNAVAILABLE calls after which a
+	// sub-connection of a pool is replaced.
+	DefaultPoolMaxFailures = 5
+)
+
+// PoolConfig configures a pool balancer. Unlike the GCP balancer, it does not depend on the request
+// messages, and its configuration is specific to each connection.
+type PoolConfig struct {
+	// Name identifies the pool in PoolMetrics. Connections that use the same name share their
+	// metrics.
+	Name string `json:"name,omitempty"`
+
+	// MinConnections is the number of sub-connections created upfront. Defaults to
+	// DefaultMinConnections.
+	MinConnections int `json:"minConnections,omitempty"`
+
+	// MaxConnections is the maximum number of sub-connections. Zero means no limit.
+	MaxConnections int `json:"maxConnections,omitempty"`
+
+	// MaxStreams is the number of concurrent calls on a sub-connection above which a new
+	// sub-connection is created, if MaxConnections allows it. Defaults to DefaultPoolMaxStreams.
+	MaxStreams int `json:"maxStreams,omitempty"`
+
+	// MaxFailures is the number of consecutive calls failing with UNAVAILABLE after which a
+	// sub-connection is replaced. Defaults to DefaultPoolMaxFailures.
+	MaxFailures int `json:"maxFailures,omitempty"`
+}
+
+// PoolStats are the metrics of a pool.
+type PoolStats struct {
+	// SubConns is the current number of sub-connections.
+	SubConns int64
+	// ReadySubConns is the current number of ready sub-connections.
+	ReadySubConns int64
+	// Outstanding is the current number of calls in flight.
+	Outstanding int64
+	// Picks is the total number of calls.
+	Picks int64
+	// Recycled is the number of sub-connections replaced after repeated failures.
+	Recycled int64
+	// Reconnects is the number of ready sub-connections that went idle and reconnected, e.g. after
+	// the server sent GOAWAY.
+	Reconnects int64
+}
+
+var (
+	registerPoolOnce sync.Once
+
+	poolMetricsMu sync.Mutex
+	poolMetrics   = make(map[string]*PoolStats)
+)
+
+// PoolServiceConfig returns the gRPC service config that selects the pool balancer with the given
+// configuration, to be used with grpc.WithDefaultServiceConfig.
+func PoolServiceConfig(cfg PoolConfig) string {
+	registerPoolOnce.Do(func() { balancer.Register(poolBuilder{}) })
+	js, _ := json.Marshal(cfg)
+	return fmt.Sprintf(`{"loadBalancingConfig": [{"%s":%s}]}`, PoolName, js)
+}
+
+// PoolMetrics returns the metrics of the pools with the given name.
+func PoolMetrics(name string) PoolStats {
+	m := metricsFor(name)
+	return PoolStats{
+		SubConns:      atomic.LoadInt64(&m.SubConns),
+		ReadySubConns: atomic.LoadInt64(&m.ReadySubConns),
+		Outstanding:   atomic.LoadInt64(&m.Outstanding),
+		Picks:         atomic.LoadInt64(&m.Picks),
+		Recycled:      atomic.LoadInt64(&m.Recycled),
+		Reconnects:    atomic.LoadInt64(&m.Reconnects),
+	}
+}
+
+func metricsFor(name string) *PoolStats {
+	poolMetricsMu.Lock()
+	defer poolMetricsMu.Unlock()
+	m, ok := poolMetrics[name]
+	if !ok {
+		m = &PoolStats{}
+		poolMetrics[name] = m
+	}
+	return m
+}
+
+type poolLBConfig struct {
+	serviceconfig.LoadBalancingConfig
+	PoolConfig
+}
+
+type poolBuilder struct{}
+
+func (poolBuilder) Name() string {
+	return PoolName
+}
+
+func (poolBuilder) Build(cc balancer.ClientConn, opt balancer.BuildOptions) balancer.Balancer {
+	return &poolBalancer{
+		cc:     cc,
+		refs:   make(map[balancer.SubConn]*poolSubConn),
+		picker: newErrPicker(balancer.ErrNoSubConnAvailable),
+	}
+}
+
+func (poolBuilder) ParseConfig(js json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+	cfg := &poolLBConfig{}
+	if err := json.Unmarshal(js, &cfg.PoolConfig); err != nil {
+		return nil, fmt.Errorf("invalid %s config %s: %v", PoolName, js, err)
+	}
+	if cfg.MinConnections <= 0 {
+		cfg.MinConnections = DefaultMinConnections
+	}
+	if cfg.MaxConnections > 0 && cfg.MinConnections > cfg.MaxConnections {
+		cfg.MinConnections = cfg.MaxConnections
+	}
+	if cfg.MaxStreams <= 0 {
+		cfg.MaxStreams = DefaultPoolMaxStreams
+	}
+	if cfg.MaxFailures <= 0 {
+		cfg.MaxFailures = DefaultPoolMaxFailures
+	}
+	return cfg, nil
+}
+
+// poolSubConn is a sub-connection of a pool with its load and health.
+type poolSubConn struct {
+	sc          balancer.SubConn
+	state       connectivity.State
+	outstanding int32 // Calls in flight.
+	failures    int32 // Consecutive calls that failed with UNAVAILABLE.
+}
+
+type poolBalancer struct {
+	cc      balancer.ClientConn
+	metrics *PoolStats
+
+	mu     sync.Mutex
+	cfg    PoolConfig
+	addrs  []resolver.Address
+	refs   map[balancer.SubConn]*poolSubConn
+	picker balancer.Picker
+	closed bool
+}
+
+func (pb *poolBalancer) UpdateClientConnState(ccs balancer.ClientConnState) error {
+	cfg, ok := ccs.BalancerConfig.(*poolLBConfig)
+	if !ok {
+		return fmt.Errorf("%s: unexpected balancer config %T", PoolName, ccs.BalancerConfig)
+	}
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+	pb.cfg = cfg.PoolConfig
+	if pb.metrics == nil {
+		pb.metrics = metricsFor(pb.cfg.Name)
+	}
+	pb.addrs = ccs.ResolverState.Addresses
+	for _, ref := range pb.refs {
+		ref.sc.UpdateAddresses(pb.addrs)
+	}
+	for len(pb.refs) < pb.cfg.MinConnections {
+		if !pb.newSubConnLocked() {
+			break
+		}
+	}
+	return nil
+}
+
+func (pb *poolBalancer) ResolverError(err error) {
+	grpclog.Warningf("%s: ResolverError: %v", PoolName, err)
+}
+
+// newSubConnLocked creates and connects a new sub-connection. It must be called with pb.mu held.
+func (pb *poolBalancer) newSubConnLocked() bool {
+	if pb.closed {
+		return false
+	}
+	sc, err := pb.cc.NewSubConn(pb.addrs, balancer.NewSubConnOptions{HealthCheckEnabled: healthCheckEnabled})
+	if err != nil {
+		grpclog.Errorf("%s: failed to create a sub-connection: %v", PoolName, err)
+		return false
+	}
+	pb.refs[sc] = &poolSubConn{sc: sc, state: connectivity.Idle}
+	atomic.AddInt64(&pb.metrics.SubConns, 1)
+	sc.Connect()
+	return true
+}
+
+// grow creates a new sub-connection if the pool has none that is connecting and is not full.
+func (pb *poolBalancer) grow() {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+	for _, ref := range pb.refs {
+		if ref.state == connectivity.Connecting || ref.state == connectivity.Idle {
+			return
+		}
+	}
+	if pb.cfg.MaxConnections == 0 || len(pb.refs) < pb.cfg.MaxConnections {
+		pb.newSubConnLocked()
+	}
+}
+
+// recycle replaces an unhealthy sub-connection with a new one.
+func (pb *poolBalancer) recycle(ref *poolSubConn) {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+	if _, ok := pb.refs[ref.sc]; !ok || pb.closed {
+		return
+	}
+	grpclog.Infof("%s: replacing sub-connection %p after %d consecutive failures", PoolName, ref.sc, atomic.LoadInt32(&ref.failures))
+	atomic.AddInt64(&pb.metrics.Recycled, 1)
+	pb.removeLocked(ref)
+	ref.sc.Shutdown()
+	pb.newSubConnLocked()
+	pb.updateStateLocked()
+}
+
+// removeLocked forgets a sub-connection. It must be called with pb.mu held.
+func (pb *poolBalancer) removeLocked(ref *poolSubConn) {
+	if ref.state == connectivity.Ready {
+		atomic.AddInt64(&pb.metrics.ReadySubConns, -1)
+	}
+	delete(pb.refs, ref.sc)
+	atomic.AddInt64(&pb.metrics.SubConns, -1)
+}
+
+func (pb *poolBalancer) UpdateSubConnState(sc balancer.SubConn, scs balancer.SubConnState) {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+	ref, ok := pb.refs[sc]
+	if !ok {
+		return
+	}
+	old, s := ref.state, scs.ConnectivityState
+	switch s {
+	case connectivity.Shutdown:
+		pb.removeLocked(ref)
+		pb.updateStateLocked()
+		return
+	case connectivity.Idle:
+		if old == connectivity.Ready {
+			atomic.AddInt64(&pb.metrics.Reconnects, 1)
+		}
+		sc.Connect()
+	}
+	ref.state = s
+	if old == connectivity.Ready && s != connectivity.Ready {
+		atomic.AddInt64(&pb.metrics.ReadySubConns, -1)
+	} else if old != connectivity.Ready && s == connectivity.Ready {
+		atomic.StoreInt32(&ref.failures, 0)
+		atomic.AddInt64(&pb.metrics.ReadySubConns, 1)
+	}
+	if (old == connectivity.Ready) != (s == connectivity.Ready) || s == connectivity.TransientFailure {
+		pb.updateStateLocked()
+	}
+}
+
+// updateStateLocked regenerates the picker and updates the state of the ClientConn. It must be
+// called with pb.mu held.
+func (pb *poolBalancer) updateStateLocked() {
+	var ready []*poolSubConn
+	connecting := false
+	for _, ref := range pb.refs {
+		switch ref.state {
+		case connectivity.Ready:
+			ready = append(ready, ref)
+		case connectivity.Connecting, connectivity.Idle:
+			connecting = true
+		}
+	}
+	state := connectivity.TransientFailure
+	switch {
+	case len(ready) > 0:
+		state = connectivity.Ready
+		pb.picker = &poolPicker{pb: pb, refs: ready, maxStreams: int32(pb.cfg.MaxStreams), maxFailures: int32(pb.cfg.MaxFailures)}
+	case connecting:
+		state = connectivity.Connecting
+		pb.picker = newErrPicker(balancer.ErrNoSubConnAvailable)
+	default:
+		pb.picker = newErrPicker(balancer.ErrTransientFailure)
+	}
+	pb.cc.UpdateState(balancer.State{ConnectivityState: state, Picker: pb.picker})
+}
+
+func (pb *poolBalancer) Close() {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+	pb.closed = true
+	for _, ref := range pb.refs {
+		pb.removeLocked(ref)
+	}
+}
+
+// poolPicker picks the ready sub-connection with the fewest calls in flight.
+type poolPicker struct {
+	pb          *poolBalancer
+	refs        []*poolSubConn
+	maxStreams  int32
+	maxFailures int32
+}
+
+func (p *poolPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	distinct, _ := info.Ctx.Value(distinctSubConnsKey{}).(*distinctSubConns)
+	var best, bestDistinct *poolSubConn
+	for _, ref := range p.refs {
+		n := atomic.LoadInt32(&ref.outstanding)
+		if best == nil || n < atomic.LoadInt32(&best.outstanding) {
+			best = ref
+		}
+		if distinct != nil && !distinct.has(ref.sc) && n < p.maxStreams && (bestDistinct == nil || n < atomic.LoadInt32(&bestDistinct.outstanding)) {
+			bestDistinct = ref
+		}
+	}
+	if bestDistinct != nil {
+		best = bestDistinct
+	}
+	if atomic.LoadInt32(&best.outstanding) >= p.maxStreams {
+		// All sub-connections are at their limit: add one to the pool if possible, and use the least
+		// loaded one meanwhile.
+		go p.pb.grow()
+	}
+	if distinct != nil {
+		distinct.add(best.sc)
+	}
+	atomic.AddInt32(&best.outstanding, 1)
+	atomic.AddInt64(&p.pb.metrics.Outstanding, 1)
+	atomic.AddInt64(&p.pb.metrics.Picks, 1)
+	ref := best
+	return balancer.PickResult{
+		SubConn: ref.sc,
+		Done: func(di balancer.DoneInfo) {
+			atomic.AddInt32(&ref.outstanding, -1)
+			atomic.AddInt64(&p.pb.metrics.Outstanding, -1)
+			if status.Code(di.Err) != codes.Unavailable {
+				atomic.StoreInt32(&ref.failures, 0)
+				return
+			}
+			if atomic.AddInt32(&ref.failures, 1) == p.maxFailures {
+				go p.pb.recycle(ref)
+			}
+		},
+	}, nil
+}
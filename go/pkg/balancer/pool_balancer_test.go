@@ -0,0 +1,171 @@
+package balancer
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	grpcbalancer "google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/status"
+)
+
+type poolTestSubConn struct {
+	grpcbalancer.SubConn
+	mu       sync.Mutex
+	shutdown bool
+}
+
+func (sc *poolTestSubConn) Connect()                           {}
+func (sc *poolTestSubConn) UpdateAddresses([]resolver.Address) {}
+func (sc *poolTestSubConn) Shutdown() {
+	sc.mu.Lock()
+	sc.shutdown = true
+	sc.mu.Unlock()
+}
+
+// poolTestClientConn records the sub-connections and the latest picker of a balancer.
+type poolTestClientConn struct {
+	grpcbalancer.ClientConn
+	mu       sync.Mutex
+	subConns []*poolTestSubConn
+	state    grpcbalancer.State
+}
+
+func (cc *poolTestClientConn) NewSubConn([]resolver.Address, grpcbalancer.NewSubConnOptions) (grpcbalancer.SubConn, error) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	sc := &poolTestSubConn{}
+	cc.subConns = append(cc.subConns, sc)
+	return sc, nil
+}
+
+func (cc *poolTestClientConn) UpdateState(s grpcbalancer.State) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.state = s
+}
+
+func (cc *poolTestClientConn) numSubConns() int {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return len(cc.subConns)
+}
+
+func (cc *poolTestClientConn) subConn(i int) *poolTestSubConn {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return cc.subConns[i]
+}
+
+func (cc *poolTestClientConn) picker() grpcbalancer.Picker {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return cc.state.Picker
+}
+
+func newTestPool(t *testing.T, cfg PoolConfig) (grpcbalancer.Balancer, *poolTestClientConn) {
+	t.Helper()
+	js, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatalf("json.Marshal(%+v) failed: %v", cfg, err)
+	}
+	lbCfg, err := poolBuilder{}.ParseConfig(js)
+	if err != nil {
+		t.Fatalf("ParseConfig(%s) failed: %v", js, err)
+	}
+	cc := &poolTestClientConn{}
+	b := poolBuilder{}.Build(cc, grpcbalancer.BuildOptions{})
+	if err := b.UpdateClientConnState(grpcbalancer.ClientConnState{BalancerConfig: lbCfg}); err != nil {
+		t.Fatalf("UpdateClientConnState() failed: %v", err)
+	}
+	for i := 0; i < cc.numSubConns(); i++ {
+		b.UpdateSubConnState(cc.subConn(i), grpcbalancer.SubConnState{ConnectivityState: connectivity.Ready})
+	}
+	return b, cc
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPoolPicksLeastOutstanding(t *testing.T) {
+	_, cc := newTestPool(t, PoolConfig{Name: t.Name(), MinConnections: 2, MaxConnections: 3, MaxStreams: 1})
+	if n := cc.numSubConns(); n != 2 {
+		t.Fatalf("pool created %d sub-connections, want 2", n)
+	}
+	ctx := context.Background()
+	counts := make(map[grpcbalancer.SubConn]int)
+	var dones []func(grpcbalancer.DoneInfo)
+	for i := 0; i < 2; i++ {
+		res, err := cc.picker().Pick(grpcbalancer.PickInfo{Ctx: ctx})
+		if err != nil {
+			t.Fatalf("Pick() failed: %v", err)
+		}
+		counts[res.SubConn]++
+		dones = append(dones, res.Done)
+	}
+	if len(counts) != 2 {
+		t.Errorf("two concurrent calls were sent on %d sub-connections, want 2", len(counts))
+	}
+	if got := PoolMetrics(t.Name()); got.Outstanding != 2 || got.Picks != 2 || got.ReadySubConns != 2 {
+		t.Errorf("PoolMetrics() = %+v, want 2 outstanding calls, 2 picks and 2 ready sub-connections", got)
+	}
+	// Both sub-connections are at their stream limit, so the pool grows.
+	res, err := cc.picker().Pick(grpcbalancer.PickInfo{Ctx: ctx})
+	if err != nil {
+		t.Fatalf("Pick() failed: %v", err)
+	}
+	dones = append(dones, res.Done)
+	waitFor(t, "a new sub-connection", func() bool { return cc.numSubConns() == 3 })
+	for _, done := range dones {
+		done(grpcbalancer.DoneInfo{})
+	}
+	if got := PoolMetrics(t.Name()).Outstanding; got != 0 {
+		t.Errorf("PoolMetrics().Outstanding = %d after all calls completed, want 0", got)
+	}
+}
+
+func TestPoolRecyclesFailingSubConns(t *testing.T) {
+	_, cc := newTestPool(t, PoolConfig{Name: t.Name(), MinConnections: 1, MaxFailures: 2})
+	failing := cc.subConn(0)
+	for i := 0; i < 2; i++ {
+		res, err := cc.picker().Pick(grpcbalancer.PickInfo{Ctx: context.Background()})
+		if err != nil {
+			t.Fatalf("Pick() failed: %v", err)
+		}
+		res.Done(grpcbalancer.DoneInfo{Err: status.Error(codes.Unavailable, "connection reset")})
+	}
+	waitFor(t, "the sub-connection to be replaced", func() bool { return cc.numSubConns() == 2 })
+	failing.mu.Lock()
+	shutdown := failing.shutdown
+	failing.mu.Unlock()
+	if !shutdown {
+		t.Errorf("the failing sub-connection was not shut down")
+	}
+	if got := PoolMetrics(t.Name()); got.Recycled != 1 || got.SubConns != 1 {
+		t.Errorf("PoolMetrics() = %+v, want 1 recycled and 1 current sub-connection", got)
+	}
+}
+
+func TestPoolServiceConfig(t *testing.T) {
+	got := PoolServiceConfig(PoolConfig{Name: "cas", MaxStreams: 10})
+	want := `{"loadBalancingConfig": [{"rbe_pool":{"name":"cas","maxStreams":10}}]}`
+	if got != want {
+		t.Errorf("PoolServiceConfig() = %s, want %s", got, want)
+	}
+	if grpcbalancer.Get(PoolName) == nil {
+		t.Errorf("PoolServiceConfig() did not register the %s balancer", PoolName)
+	}
+}
@@ -0,0 +1,170 @@
+// Package bandwidth limits the rate at which bytes are transferred, so that large transfers do not
+// saturate a network link shared with other applications.
+package bandwidth
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket that limits a transfer rate in bytes per second. Waiting transfers are
+// granted tokens in turn, in pieces of at most the burst size, so that a large transfer does not
+// hold back smaller ones sharing the same limiter for long.
+//
+// A nil Limiter does not limit anything. A Limiter is safe for concurrent use, and its limit may be
+// changed at any time with SetLimit, including while transfers are waiting on it.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	// queue holds the waiting transfers. The first one is granted the next tokens.
+	queue []*waiter
+	// changed is closed and replaced when the limit changes, to wake the waiting transfers.
+	changed chan struct{}
+}
+
+// NewLimiter returns a limiter of bytesPerSecond, which allows bursts of up to burst bytes. A
+// non-positive bytesPerSecond means no limit, and a non-positive burst defaults to bytesPerSecond.
+func NewLimiter(bytesPerSecond, burst int64) *Limiter {
+	l := &Limiter{last: time.Now(), changed: make(chan struct{})}
+	l.setLimitLocked(bytesPerSecond, burst)
+	l.tokens = l.burst
+	return l
+}
+
+// SetLimit changes the limit as NewLimiter would set it.
+func (l *Limiter) SetLimit(bytesPerSecond, burst int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(time.Now())
+	l.setLimitLocked(bytesPerSecond, burst)
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+func (l *Limiter) setLimitLocked(bytesPerSecond, burst int64) {
+	if burst <= 0 {
+		burst = bytesPerSecond
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+	l.rate, l.burst = float64(bytesPerSecond), float64(burst)
+}
+
+// Limit returns the current limit in bytes per second, zero if unlimited, and the burst size.
+func (l *Limiter) Limit() (bytesPerSecond, burst int64) {
+	if l == nil {
+		return 0, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate <= 0 {
+		return 0, int64(l.burst)
+	}
+	return int64(l.rate), int64(l.burst)
+}
+
+// refill adds the tokens earned since the last refill.
+func (l *Limiter) refill(now time.Time) {
+	if l.rate > 0 {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+}
+
+// WaitN blocks until n bytes may be transferred, or ctx is done, in which case it returns the
+// context's error.
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	if l == nil || n <= 0 {
+		return nil
+	}
+	w := &waiter{wake: make(chan struct{}, 1)}
+	l.mu.Lock()
+	l.queue = append(l.queue, w)
+	l.mu.Unlock()
+	for n > 0 {
+		l.mu.Lock()
+		if l.rate <= 0 {
+			l.removeLocked(w)
+			l.mu.Unlock()
+			return nil
+		}
+		l.refill(time.Now())
+		take := float64(n)
+		if take > l.burst {
+			take = l.burst
+		}
+		head := l.queue[0] == w
+		if head && l.tokens >= take {
+			l.tokens -= take
+			n -= int(take)
+			// Let the other waiters take their turn before the rest of the n bytes.
+			l.removeLocked(w)
+			if n > 0 {
+				l.queue = append(l.queue, w)
+			}
+			l.mu.Unlock()
+			continue
+		}
+		var timer *time.Timer
+		var timerC <-chan time.Time
+		if head {
+			timer = time.NewTimer(time.Duration((take - l.tokens) / l.rate * float64(time.Second)))
+			timerC = timer.C
+		}
+		changed := l.changed
+		l.mu.Unlock()
+
+		var err error
+		select {
+		case <-timerC:
+		case <-w.wake:
+		case <-changed:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if err != nil {
+			l.mu.Lock()
+			l.removeLocked(w)
+			l.mu.Unlock()
+			return err
+		}
+	}
+	return nil
+}
+
+// waiter is a transfer waiting for tokens.
+type waiter struct {
+	// wake is signaled when the waiter becomes the first in the queue.
+	wake chan struct{}
+}
+
+// removeLocked removes w from the queue, and wakes the next waiter if w was the first.
+func (l *Limiter) removeLocked(w *waiter) {
+	for i, q := range l.queue {
+		if q != w {
+			continue
+		}
+		l.queue = append(l.queue[:i], l.queue[i+1:]...)
+		if i == 0 && len(l.queue) > 0 {
+			select {
+			case l.queue[0].wake <- struct{}{}:
+			default:
+			}
+		}
+		return
+	}
+}
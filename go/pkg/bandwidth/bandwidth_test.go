@@ -0,0 +1,89 @@
+package bandwidth
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWaitN(t *testing.T) {
+	ctx := context.Background()
+	l := NewLimiter(10000, 1000)
+	start := time.Now()
+	// The first 1000 bytes are the initial burst, the remaining 2000 take 200ms.
+	if err := l.WaitN(ctx, 3000); err != nil {
+		t.Fatalf("WaitN() failed: %v", err)
+	}
+	if got := time.Since(start); got < 180*time.Millisecond || got > time.Second {
+		t.Errorf("WaitN(3000) took %v, want about 200ms", got)
+	}
+}
+
+func TestUnlimited(t *testing.T) {
+	ctx := context.Background()
+	for _, l := range []*Limiter{nil, NewLimiter(0, 0)} {
+		start := time.Now()
+		if err := l.WaitN(ctx, 1<<30); err != nil {
+			t.Fatalf("WaitN() failed: %v", err)
+		}
+		if got := time.Since(start); got > 100*time.Millisecond {
+			t.Errorf("WaitN() took %v on an unlimited limiter", got)
+		}
+	}
+}
+
+func TestSetLimit(t *testing.T) {
+	ctx := context.Background()
+	l := NewLimiter(1, 1)
+	if err := l.WaitN(ctx, 1); err != nil {
+		t.Fatalf("WaitN() failed: %v", err)
+	}
+	done := make(chan error)
+	go func() { done <- l.WaitN(ctx, 1000) }()
+	time.Sleep(20 * time.Millisecond)
+	// At 1 byte per second the waiter would take 1000s. Lifting the limit must wake it up.
+	l.SetLimit(0, 0)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("WaitN() failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("WaitN() did not return after the limit was lifted")
+	}
+	if rate, _ := l.Limit(); rate != 0 {
+		t.Errorf("Limit() = %d, want 0", rate)
+	}
+	l.SetLimit(2000, 500)
+	if rate, burst := l.Limit(); rate != 2000 || burst != 500 {
+		t.Errorf("Limit() = %d, %d, want 2000, 500", rate, burst)
+	}
+}
+
+func TestWaitNCanceled(t *testing.T) {
+	l := NewLimiter(1, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := l.WaitN(ctx, 100); err != context.DeadlineExceeded {
+		t.Errorf("WaitN() = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestFairSharing(t *testing.T) {
+	ctx := context.Background()
+	l := NewLimiter(10000, 100)
+	if err := l.WaitN(ctx, 100); err != nil {
+		t.Fatalf("WaitN() failed: %v", err)
+	}
+	// A large transfer waits for 1s in total, but a small one started after it must not wait
+	// for it to complete.
+	go l.WaitN(ctx, 10000)
+	time.Sleep(20 * time.Millisecond)
+	start := time.Now()
+	if err := l.WaitN(ctx, 100); err != nil {
+		t.Fatalf("WaitN() failed: %v", err)
+	}
+	if got := time.Since(start); got > 500*time.Millisecond {
+		t.Errorf("a small transfer waited %v behind a large one", got)
+	}
+}
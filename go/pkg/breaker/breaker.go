@@ -0,0 +1,252 @@
+// Package breaker implements circuit breakers, which make calls to an unhealthy backend fail fast
+// instead of waiting for timeouts and retries.
+//
+// A breaker starts closed, letting all calls through while tracking the fraction of them that
+// fail. Once that fraction reaches a threshold, the breaker opens and rejects all calls with
+// ErrOpen. After a cool down period it becomes half-open, and lets a few probe calls through: if
+// they succeed the breaker closes again, otherwise it opens for another cool down period.
+package breaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrOpen is returned, possibly wrapped, for calls rejected by an open breaker.
+var ErrOpen = errors.New("circuit breaker is open")
+
+// State is the state of a breaker.
+type State int
+
+const (
+	// Closed lets all calls through.
+	Closed State = iota
+	// Open rejects all calls.
+	Open
+	// HalfOpen lets a limited number of probe calls through.
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// Config configures a breaker. Zero fields are replaced with the corresponding value of
+// DefaultConfig.
+type Config struct {
+	// FailureRatio is the fraction of failed calls in a window that opens the breaker.
+	FailureRatio float64
+
+	// MinCalls is the minimum number of calls in a window before the breaker may open, so that a
+	// few failures of an otherwise idle client do not open it.
+	MinCalls int
+
+	// Window is the period over which failures are counted.
+	Window time.Duration
+
+	// OpenDuration is how long the breaker stays open before letting probe calls through.
+	OpenDuration time.Duration
+
+	// HalfOpenCalls is the number of successful probe calls needed to close the breaker.
+	HalfOpenCalls int
+
+	// IsFailure returns whether the error of a call indicates that the backend is unhealthy. Errors
+	// for which it returns false count as successes. Defaults to IsUnavailable.
+	IsFailure func(error) bool
+}
+
+// DefaultConfig returns the recommended breaker configuration.
+func DefaultConfig() Config {
+	return Config{
+		FailureRatio:  0.5,
+		MinCalls:      20,
+		Window:        10 * time.Second,
+		OpenDuration:  5 * time.Second,
+		HalfOpenCalls: 3,
+		IsFailure:     IsUnavailable,
+	}
+}
+
+// IsUnavailable returns whether the error indicates that the backend is unavailable or overloaded,
+// as opposed to errors that are specific to a request, like NotFound.
+func IsUnavailable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, ErrOpen) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	s, ok := status.FromError(err)
+	if !ok {
+		return false
+	}
+	switch s.Code() {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Internal:
+		return true
+	default:
+		return false
+	}
+}
+
+// Breaker is a circuit breaker. It is safe for concurrent use.
+type Breaker struct {
+	name string
+	cfg  Config
+	now  func() time.Time
+
+	mu          sync.Mutex
+	state       State
+	windowStart time.Time
+	calls       int
+	failures    int
+	openedAt    time.Time
+	probes      int // Probe calls in flight in the half-open state.
+	successes   int // Successful probe calls in the half-open state.
+}
+
+// New returns a closed breaker. The name is used in errors and logs.
+func New(name string, cfg Config) *Breaker {
+	def := DefaultConfig()
+	if cfg.FailureRatio <= 0 {
+		cfg.FailureRatio = def.FailureRatio
+	}
+	if cfg.MinCalls <= 0 {
+		cfg.MinCalls = def.MinCalls
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = def.Window
+	}
+	if cfg.OpenDuration <= 0 {
+		cfg.OpenDuration = def.OpenDuration
+	}
+	if cfg.HalfOpenCalls <= 0 {
+		cfg.HalfOpenCalls = def.HalfOpenCalls
+	}
+	if cfg.IsFailure == nil {
+		cfg.IsFailure = def.IsFailure
+	}
+	return &Breaker{name: name, cfg: cfg, now: time.Now, windowStart: time.Now()}
+}
+
+// State returns the current state of the breaker.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advance()
+	return b.state
+}
+
+// Allow returns an error wrapping ErrOpen if the call may not proceed. Otherwise, it returns a
+// function that must be called with the outcome of the call.
+func (b *Breaker) Allow() (done func(err error), err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advance()
+	switch b.state {
+	case Open:
+		return nil, fmt.Errorf("%s: %w", b.name, ErrOpen)
+	case HalfOpen:
+		if b.probes+b.successes >= b.cfg.HalfOpenCalls {
+			return nil, fmt.Errorf("%s: %w", b.name, ErrOpen)
+		}
+		b.probes++
+		return func(err error) { b.record(err, true) }, nil
+	}
+	return func(err error) { b.record(err, false) }, nil
+}
+
+// Do calls f if the breaker allows it, and records its outcome.
+func (b *Breaker) Do(f func() error) error {
+	done, err := b.Allow()
+	if err != nil {
+		return err
+	}
+	err = f()
+	done(err)
+	return err
+}
+
+// advance moves an open breaker whose cool down has elapsed to half-open, and starts a new window
+// for a closed one. It must be called with b.mu held.
+func (b *Breaker) advance() {
+	now := b.now()
+	switch b.state {
+	case Open:
+		if now.Sub(b.openedAt) >= b.cfg.OpenDuration {
+			b.setState(HalfOpen)
+			b.probes, b.successes = 0, 0
+		}
+	case Closed:
+		if now.Sub(b.windowStart) >= b.cfg.Window {
+			b.windowStart, b.calls, b.failures = now, 0, 0
+		}
+	}
+}
+
+func (b *Breaker) record(err error, probe bool) {
+	failed := err != nil && b.cfg.IsFailure(err)
+	if errors.Is(err, context.Canceled) {
+		// The caller gave up, which says nothing about the backend.
+		failed = false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if probe {
+		b.probes--
+	}
+	b.advance()
+	switch b.state {
+	case Closed:
+		if probe {
+			// A probe that outlived a previous half-open state.
+			return
+		}
+		b.calls++
+		if failed {
+			b.failures++
+		}
+		if b.calls >= b.cfg.MinCalls && float64(b.failures) >= b.cfg.FailureRatio*float64(b.calls) {
+			b.open()
+		}
+	case HalfOpen:
+		if !probe {
+			return
+		}
+		if failed {
+			b.open()
+			return
+		}
+		b.successes++
+		if b.successes >= b.cfg.HalfOpenCalls {
+			b.setState(Closed)
+			b.windowStart, b.calls, b.failures = b.now(), 0, 0
+		}
+	}
+}
+
+func (b *Breaker) open() {
+	b.setState(Open)
+	b.openedAt = b.now()
+}
+
+func (b *Breaker) setState(s State) {
+	if b.state != s {
+		log.Infof("Circuit breaker %s is now %s", b.name, s)
+	}
+	b.state = s
+}
@@ -0,0 +1,129 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func newTestBreaker(cfg Config) (*Breaker, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	b := New("test", cfg)
+	b.now = clock.now
+	b.windowStart = clock.t
+	return b, clock
+}
+
+var errUnavailable = status.Error(codes.Unavailable, "unavailable")
+
+func TestBreakerOpensOnFailures(t *testing.T) {
+	b, clock := newTestBreaker(Config{FailureRatio: 0.5, MinCalls: 4, OpenDuration: time.Second, HalfOpenCalls: 2})
+	// Errors that are specific to a request do not count.
+	for i := 0; i < 4; i++ {
+		b.Do(func() error { return status.Error(codes.NotFound, "not found") })
+	}
+	if got := b.State(); got != Closed {
+		t.Fatalf("State() = %v after NotFound errors, want %v", got, Closed)
+	}
+	for i := 0; i < 4; i++ {
+		b.Do(func() error { return errUnavailable })
+	}
+	if got := b.State(); got != Open {
+		t.Fatalf("State() = %v after failures, want %v", got, Open)
+	}
+	called := false
+	err := b.Do(func() error { called = true; return nil })
+	if !errors.Is(err, ErrOpen) || called {
+		t.Errorf("Do() = %v, called = %v, want ErrOpen without calling", err, called)
+	}
+
+	// After the cool down, a limited number of probes is let through.
+	clock.t = clock.t.Add(time.Second)
+	if got := b.State(); got != HalfOpen {
+		t.Fatalf("State() = %v after cool down, want %v", got, HalfOpen)
+	}
+	done1, err := b.Allow()
+	if err != nil {
+		t.Fatalf("Allow() = %v for the first probe", err)
+	}
+	done2, err := b.Allow()
+	if err != nil {
+		t.Fatalf("Allow() = %v for the second probe", err)
+	}
+	if _, err := b.Allow(); !errors.Is(err, ErrOpen) {
+		t.Errorf("Allow() = %v with all probes in flight, want ErrOpen", err)
+	}
+	done1(nil)
+	if got := b.State(); got != HalfOpen {
+		t.Errorf("State() = %v after one successful probe, want %v", got, HalfOpen)
+	}
+	done2(nil)
+	if got := b.State(); got != Closed {
+		t.Errorf("State() = %v after successful probes, want %v", got, Closed)
+	}
+}
+
+func TestBreakerReopensOnFailedProbe(t *testing.T) {
+	b, clock := newTestBreaker(Config{MinCalls: 1, OpenDuration: time.Second})
+	b.Do(func() error { return context.DeadlineExceeded })
+	if got := b.State(); got != Open {
+		t.Fatalf("State() = %v, want %v", got, Open)
+	}
+	clock.t = clock.t.Add(time.Second)
+	b.Do(func() error { return errUnavailable })
+	if got := b.State(); got != Open {
+		t.Errorf("State() = %v after a failed probe, want %v", got, Open)
+	}
+	clock.t = clock.t.Add(time.Second / 2)
+	if got := b.State(); got != Open {
+		t.Errorf("State() = %v before the new cool down elapsed, want %v", got, Open)
+	}
+}
+
+func TestBreakerWindow(t *testing.T) {
+	b, clock := newTestBreaker(Config{FailureRatio: 0.5, MinCalls: 4, Window: time.Second})
+	for i := 0; i < 3; i++ {
+		b.Do(func() error { return errUnavailable })
+	}
+	// The failures of the previous window are forgotten.
+	clock.t = clock.t.Add(time.Second)
+	for i := 0; i < 3; i++ {
+		b.Do(func() error { return nil })
+	}
+	b.Do(func() error { return errUnavailable })
+	if got := b.State(); got != Closed {
+		t.Errorf("State() = %v, want %v", got, Closed)
+	}
+}
+
+func TestIsUnavailable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{err: nil, want: false},
+		{err: errUnavailable, want: true},
+		{err: status.Error(codes.ResourceExhausted, ""), want: true},
+		{err: status.Error(codes.NotFound, ""), want: false},
+		{err: status.Error(codes.InvalidArgument, ""), want: false},
+		{err: context.DeadlineExceeded, want: true},
+		{err: context.Canceled, want: false},
+		{err: ErrOpen, want: false},
+		{err: errors.New("local error"), want: false},
+	}
+	for _, tc := range tests {
+		if got := IsUnavailable(tc.err); got != tc.want {
+			t.Errorf("IsUnavailable(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"io/fs"
 	"path/filepath"
 	"sort"
 	"strings"
@@ -14,6 +15,7 @@ import (
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/errors"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/io/impath"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/io/vfs"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/io/walker"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/retry"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/symlinkopts"
@@ -191,6 +193,7 @@ func (u *uploader) writeBytes(ctx context.Context, name string, r io.Reader, siz
 	ctx, ctxCancel := context.WithCancel(ctx)
 	defer ctxCancel()
 
+	callStart := time.Now()
 	stream, errStream := u.byteStream.Write(ctx)
 	if errStream != nil {
 		return stats, errors.Join(ErrGRPC, errStream)
@@ -201,6 +204,8 @@ func (u *uploader) writeBytes(ctx context.Context, name string, r io.Reader, siz
 
 	cacheHit := false
 	var err error
+	// errSend is the last error of sending a chunk other than io.EOF, which signals that the server closed the stream.
+	var errSend error
 	req := &bspb.WriteRequest{
 		ResourceName: name,
 		WriteOffset:  offset,
@@ -217,6 +222,10 @@ func (u *uploader) writeBytes(ctx context.Context, name string, r io.Reader, siz
 
 		req.Data = buf[:n]
 		req.FinishWrite = finish && errRead == io.EOF
+		if errLimit := u.ioCfg.UploadLimiter.WaitN(ctx, n); errLimit != nil {
+			err = errors.Join(errLimit, err)
+			break
+		}
 		errStream := retry.WithPolicy(ctx, u.streamRPCCfg.RetryPredicate, u.streamRPCCfg.RetryPolicy, func() error {
 			timer := time.NewTimer(u.streamRPCCfg.Timeout)
 			// Ensure the timer goroutine terminates if Send does not timeout.
@@ -239,6 +248,7 @@ func (u *uploader) writeBytes(ctx context.Context, name string, r io.Reader, siz
 		}
 
 		if errStream != nil {
+			errSend = errStream
 			err = errors.Join(ErrGRPC, errStream, err)
 			break
 		}
@@ -288,6 +298,13 @@ func (u *uploader) writeBytes(ctx context.Context, name string, r io.Reader, siz
 	}
 
 	res, errClose := stream.CloseAndRecv()
+	// The write is observed as a single call. The status of a throttled write is returned by CloseAndRecv, since Send only
+	// returns io.EOF once the server has closed the stream.
+	errCall := errClose
+	if errCall == nil {
+		errCall = errSend
+	}
+	u.streamThrottle.observe(time.Since(callStart), errCall)
 	if errClose != nil {
 		return stats, errors.Join(ErrGRPC, errClose, err)
 	}
@@ -401,10 +418,15 @@ func (u *BatchingUploader) Upload(ctx context.Context, reqs ...UploadRequest) ([
 
 // DigestTree returns the digest of the merkle tree for root.
 func (u *BatchingUploader) DigestTree(ctx context.Context, root impath.Absolute, slo symlinkopts.Options, exclude walker.Filter) (digest.Digest, Stats, error) {
+	return u.DigestTreeFS(ctx, vfs.OS, root, slo, exclude)
+}
+
+// DigestTreeFS is like DigestTree, but reads the tree from the specified file system.
+func (u *BatchingUploader) DigestTreeFS(ctx context.Context, fsys vfs.FS, root impath.Absolute, slo symlinkopts.Options, exclude walker.Filter) (digest.Digest, Stats, error) {
 	ch := make(chan UploadRequest)
 	resCh := u.streamPipe(ctx, ch)
 
-	req := UploadRequest{Path: root, SymlinkOptions: slo, Exclude: exclude, ctx: ctx, digestOnly: true}
+	req := UploadRequest{Path: root, SymlinkOptions: slo, Exclude: exclude, FS: fsys, ctx: ctx, digestOnly: true}
 	select {
 	// In both cases, the channel must be closed before proceeding beyong the select statement to ensure
 	// the proper closure of resCh.
@@ -437,6 +459,7 @@ func (u *BatchingUploader) DigestTree(ctx context.Context, root impath.Absolute,
 // This is useful when the list of paths is known and the root might have too many descendants such that traversing and filtering might add a significant overhead.
 //
 // All requests must share the same filter. Digest fields on the requests are ignored to ensure proper hierarchy caching via the internal digestion process.
+// Each request may read from a different file system, e.g. a vfs.Memory for generated inputs. See UploadRequest.FS.
 // remoteWorkingDir replaces workingDir inside the merkle tree such that the server is only aware of remoteWorkingDir.
 func (u *BatchingUploader) UploadTree(ctx context.Context, execRoot impath.Absolute, workingDir, remoteWorkingDir impath.Relative, reqs ...UploadRequest) (rootDigest digest.Digest, uploaded []digest.Digest, stats Stats, err error) {
 	contextmd.Infof(ctx, log.Level(1), "[casng] upload.tree; reqs=%d", len(reqs))
@@ -446,12 +469,21 @@ func (u *BatchingUploader) UploadTree(ctx context.Context, execRoot impath.Absol
 		return
 	}
 
+	// Remote requests are mounted as is, without being uploaded.
+	reqs, remoteReqs, err := splitRemoteRequests(execRoot, reqs)
+	if err != nil {
+		return
+	}
+
 	// 1st, Preprocess the set to deduplicate by ancestor and generate a deterministic filter ID.
 
 	// Multiple reqs sets may share some of the paths which would cause the the u.dirChildren lookup below to mix children from two different sets which would corrupt the merkle tree.
 	// Updating the filterID for the set to a deterministic one ensures it gets its unique keys that are still shared between identical sets.
 	// The deterministic ID is the digest of the sorted list of paths concatenated with the ID of the original filter.
-	filter := reqs[0].Exclude
+	var filter walker.Filter
+	if len(reqs) > 0 {
+		filter = reqs[0].Exclude
+	}
 	filterID := filter.String()
 	paths := make([]string, 0, len(reqs)+1)
 	paths = append(paths, filterID)
@@ -509,9 +541,11 @@ func (u *BatchingUploader) UploadTree(ctx context.Context, execRoot impath.Absol
 	contextmd.Infof(ctx, log.Level(1), "[casng] upload.tree; filter_id=%s, got=%d, uploading=%d", filterID, len(reqs), i)
 
 	// 2nd, Upload the requests first to digest the files and cache the nodes.
-	uploaded, stats, err = u.Upload(ctx, reqs...)
-	if err != nil {
-		return
+	if len(reqs) > 0 {
+		uploaded, stats, err = u.Upload(ctx, reqs...)
+		if err != nil {
+			return
+		}
 	}
 
 	// 3rd, Compute the shared ancestor nodes and upload them.
@@ -519,10 +553,15 @@ func (u *BatchingUploader) UploadTree(ctx context.Context, execRoot impath.Absol
 	// This block creates a flattened tree of the paths in reqs rooted at execRoot.
 	// Each key is an absolute path to a node in the tree and its value is a list of absolute paths that any of them can be a key as well.
 	// Example: /a: [/a/b /a/c], /a/b: [/a/b/foo.go], /a/c: [/a/c/bar.go]
-	dirChildren := make(map[impath.Absolute]map[impath.Absolute]proto.Message, len(pathSeen))
-	for _, r := range reqs {
+	dirChildren := make(map[impath.Absolute]map[impath.Absolute]proto.Message, len(pathSeen)+len(remoteReqs))
+	for _, r := range append(reqs, remoteReqs...) {
 		// Each request in reqs must correspond to a cached node.
-		node := u.Node(r)
+		var node proto.Message
+		if r.Remote {
+			node = remoteNode(r)
+		} else {
+			node = u.Node(r)
+		}
 		if node == nil {
 			err = fmt.Errorf("[casng] upload.tree; cannot construct the merkle tree with a missing node for path %q", r.Path)
 			return
@@ -658,6 +697,63 @@ func (u *BatchingUploader) UploadTree(ctx context.Context, execRoot impath.Absol
 	return
 }
 
+// splitRemoteRequests separates the remote requests from the local ones, and verifies that their paths do not overlap with the paths of any other request.
+func splitRemoteRequests(execRoot impath.Absolute, reqs []UploadRequest) (local []UploadRequest, remote []UploadRequest, err error) {
+	local = make([]UploadRequest, 0, len(reqs))
+	for _, r := range reqs {
+		if !r.Remote {
+			local = append(local, r)
+			continue
+		}
+		if r.Digest.Hash == "" {
+			return nil, nil, fmt.Errorf("[casng] upload.tree; remote request for path %q has no digest", r.Path)
+		}
+		if rel, errRel := impath.Descendant(execRoot, r.Path); errRel != nil || rel.String() == "." || rel.String() == "" {
+			return nil, nil, fmt.Errorf("[casng] upload.tree; remote request for path %q must be a descendant of the root %q", r.Path, execRoot)
+		}
+		remote = append(remote, r)
+	}
+	if len(remote) == 0 {
+		return local, nil, nil
+	}
+
+	// Map each path, and each of its ancestors, to the requests under it.
+	under := make(map[impath.Absolute]UploadRequest, len(reqs))
+	paths := make(map[impath.Absolute]UploadRequest, len(reqs))
+	for _, r := range reqs {
+		if other, ok := paths[r.Path]; ok && (r.Remote || other.Remote) {
+			return nil, nil, errors.Join(ErrInputConflict, fmt.Errorf("[casng] upload.tree; remote input %q conflicts with another input at the same path", r.Path))
+		}
+		paths[r.Path] = r
+		for p := r.Path.Dir(); p.String() != execRoot.String(); p = p.Dir() {
+			if _, ok := under[p]; ok {
+				break
+			}
+			under[p] = r
+		}
+	}
+	for _, r := range remote {
+		if other, ok := under[r.Path]; ok {
+			return nil, nil, errors.Join(ErrInputConflict, fmt.Errorf("[casng] upload.tree; remote input %q conflicts with input %q under it", r.Path, other.Path))
+		}
+		for p := r.Path.Dir(); p.String() != execRoot.String(); p = p.Dir() {
+			if other, ok := paths[p]; ok {
+				return nil, nil, errors.Join(ErrInputConflict, fmt.Errorf("[casng] upload.tree; remote input %q conflicts with input %q above it", r.Path, other.Path))
+			}
+		}
+	}
+	return local, remote, nil
+}
+
+// remoteNode returns the node of the remote request r.
+func remoteNode(r UploadRequest) proto.Message {
+	name := r.Path.Base().String()
+	if r.BytesFileMode&fs.ModeDir != 0 {
+		return &repb.DirectoryNode{Name: name, Digest: r.Digest.ToProto()}
+	}
+	return &repb.FileNode{Name: name, Digest: r.Digest.ToProto(), IsExecutable: isExec(r.BytesFileMode)}
+}
+
 // ReplaceWorkingDir swaps remoteWorkingDir for workingDir in path which must be prefixed by root.
 // workingDir is assumed to be prefixed by root, and the returned path will be a descendant of root, but not necessarily a descendant of remoteWorkingDir.
 // Example: path=/root/out/foo.c, root=/root, workdingDir=out/reclient, remoteWorkingDir=set_by_reclient/a, result=/root/set_by_reclient/foo.c
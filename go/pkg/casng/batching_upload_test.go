@@ -3,13 +3,16 @@ package casng_test
 import (
 	"context"
 	"io"
+	"io/fs"
 	"sort"
 	"testing"
 	"time"
 
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/casng"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/errors"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/io/impath"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/io/vfs"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/io/walker"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/retry"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/symlinkopts"
@@ -537,6 +540,83 @@ func TestUpload_BatchingTree(t *testing.T) {
 	}
 }
 
+func TestUpload_BatchingTreeRemote(t *testing.T) {
+	cc := &fakeCAS{
+		findMissingBlobs: func(ctx context.Context, in *repb.FindMissingBlobsRequest, opts ...grpc.CallOption) (*repb.FindMissingBlobsResponse, error) {
+			return &repb.FindMissingBlobsResponse{}, nil
+		},
+	}
+	ctx, ctxCancel := context.WithCancel(context.Background())
+	defer ctxCancel()
+	u, err := casng.NewBatchingUploader(ctx, cc, &fakeByteStreamClient{}, "", defaultRPCCfg, defaultRPCCfg, defaultRPCCfg, defaultIOCfg)
+	if err != nil {
+		t.Fatalf("error creating batching uploader: %v", err)
+	}
+	// The digest of rwd/e, as if it had been uploaded by another client.
+	remoteTmp := makeFs(t, map[string][]byte{"f/baz.go": []byte("baz")})
+	eDigest, _, err := u.DigestTree(ctx, impath.MustAbs(remoteTmp), symlinkopts.ResolveAlways(), walker.Filter{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The local tree does not have wd/e.
+	tmp := makeFs(t, map[string][]byte{"wd/a/b/c/foo.go": []byte("foo"), "wd/a/b/bar.go": []byte("bar")})
+	root := impath.MustAbs(tmp)
+	local := []casng.UploadRequest{
+		{Path: impath.MustAbs(tmp, "wd/a/b/c/foo.go")},
+		{Path: impath.MustAbs(tmp, "wd/a/b/bar.go")},
+	}
+	wantDigest := "4b29476de8abdfcce452b64003ed82517aa003d9e447ff943723e556e723d75c/78"
+
+	tests := []struct {
+		name    string
+		remote  []casng.UploadRequest
+		wantErr error
+	}{
+		{
+			name:   "directory",
+			remote: []casng.UploadRequest{{Path: impath.MustAbs(tmp, "wd/e"), Digest: eDigest, BytesFileMode: fs.ModeDir, Remote: true}},
+		},
+		{
+			name:   "file",
+			remote: []casng.UploadRequest{{Path: impath.MustAbs(tmp, "wd/e/f/baz.go"), Digest: digest.NewFromBlob([]byte("baz")), BytesFileMode: 0o644, Remote: true}},
+		},
+		{
+			name:    "conflict_above",
+			remote:  []casng.UploadRequest{{Path: impath.MustAbs(tmp, "wd/a"), Digest: eDigest, BytesFileMode: fs.ModeDir, Remote: true}},
+			wantErr: casng.ErrInputConflict,
+		},
+		{
+			name:    "conflict_below",
+			remote:  []casng.UploadRequest{{Path: impath.MustAbs(tmp, "wd/a/b/bar.go/x"), Digest: eDigest, Remote: true}},
+			wantErr: casng.ErrInputConflict,
+		},
+		{
+			name:    "conflict_same",
+			remote:  []casng.UploadRequest{{Path: impath.MustAbs(tmp, "wd/a/b/bar.go"), Digest: eDigest, Remote: true}},
+			wantErr: casng.ErrInputConflict,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			reqs := append(append([]casng.UploadRequest{}, local...), test.remote...)
+			rootDigest, _, _, err := u.UploadTree(ctx, root, impath.MustRel("wd"), impath.MustRel("rwd"), reqs...)
+			if test.wantErr != nil {
+				if !errors.Is(err, test.wantErr) {
+					t.Fatalf("error mismatch: want %v, got %v", test.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(wantDigest, rootDigest.String()); diff != "" {
+				t.Errorf("root digest mismatch, (-want +got): %s", diff)
+			}
+		})
+	}
+}
+
 func TestUpload_BatchingDigestTree(t *testing.T) {
 	ctx, ctxCancel := context.WithCancel(context.Background())
 	defer ctxCancel()
@@ -563,6 +643,49 @@ func TestUpload_BatchingDigestTree(t *testing.T) {
 	}
 }
 
+func TestUpload_BatchingDigestTreeFS(t *testing.T) {
+	ctx, ctxCancel := context.WithCancel(context.Background())
+	defer ctxCancel()
+
+	u, err := casng.NewBatchingUploader(ctx, &fakeCAS{}, &fakeByteStreamClient{}, "", defaultRPCCfg, defaultRPCCfg, defaultRPCCfg, defaultIOCfg)
+	if err != nil {
+		t.Fatalf("error creating batching uploader: %v", err)
+	}
+	wantDigest := "4b29476de8abdfcce452b64003ed82517aa003d9e447ff943723e556e723d75c/78"
+
+	t.Run("memory", func(t *testing.T) {
+		mem := vfs.NewMemory()
+		root := impath.MustAbs("/virtual")
+		for p, b := range map[string]string{"rwd/a/b/c/foo.go": "foo", "rwd/a/b/bar.go": "bar", "rwd/e/f/baz.go": "baz"} {
+			if err := mem.WriteFile(root.Append(impath.MustRel(p)), []byte(b), 0o644); err != nil {
+				t.Fatalf("WriteFile failed: %v", err)
+			}
+		}
+		rootDigest, _, err := u.DigestTreeFS(ctx, mem, root, symlinkopts.ResolveAlways(), walker.Filter{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if diff := cmp.Diff(wantDigest, rootDigest.String()); diff != "" {
+			t.Errorf("root digest mismatch, (-want +got): %s", diff)
+		}
+	})
+
+	t.Run("overlay", func(t *testing.T) {
+		tmp := makeFs(t, map[string][]byte{"rwd/a/b/c/foo.go": []byte("foo"), "rwd/a/b/bar.go": []byte("bar")})
+		mem := vfs.NewMemory()
+		if err := mem.WriteFile(impath.MustAbs(tmp, "rwd/e/f/baz.go"), []byte("baz"), 0o644); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+		rootDigest, _, err := u.DigestTreeFS(ctx, vfs.NewOverlay(vfs.OS, mem), impath.MustAbs(tmp), symlinkopts.ResolveAlways(), walker.Filter{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if diff := cmp.Diff(wantDigest, rootDigest.String()); diff != "" {
+			t.Errorf("root digest mismatch, (-want +got): %s", diff)
+		}
+	})
+}
+
 func TestUpload_ReplaceWorkingDir(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -5,9 +5,12 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"math"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/bandwidth"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/casng"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/errors"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/retry"
@@ -301,3 +304,88 @@ func TestUpload_WriteBytes(t *testing.T) {
 		})
 	}
 }
+
+func TestUpload_WriteBytesBandwidthLimit(t *testing.T) {
+	var bytesSent int64
+	bs := &fakeByteStreamClient{
+		write: func(_ context.Context, _ ...grpc.CallOption) (bsgrpc.ByteStream_WriteClient, error) {
+			return &fakeByteStreamWriteClient{
+				send: func(wr *bspb.WriteRequest) error {
+					bytesSent += int64(len(wr.Data))
+					return nil
+				},
+				closeAndRecv: func() (*bspb.WriteResponse, error) {
+					return &bspb.WriteResponse{CommittedSize: bytesSent}, nil
+				},
+			}, nil
+		},
+	}
+	ioCfg := defaultIOCfg
+	ioCfg.CompressionSizeThreshold = math.MaxInt64
+	ioCfg.BufferSize = 10
+	ioCfg.UploadLimiter = bandwidth.NewLimiter(1000, 100)
+	u, err := casng.NewBatchingUploader(context.Background(), &fakeCAS{}, bs, "", defaultRPCCfg, defaultRPCCfg, defaultRPCCfg, ioCfg)
+	if err != nil {
+		t.Fatalf("error creating batching uploader: %v", err)
+	}
+
+	// The first 100 bytes are the burst, the remaining 200 take 200ms.
+	b := []byte(strings.Repeat("a", 300))
+	start := time.Now()
+	if _, err := u.WriteBytes(context.Background(), casng.MakeWriteResourceName("instance", "hash", 0), bytes.NewReader(b), int64(len(b)), 0); err != nil {
+		t.Fatalf("WriteBytes failed: %v", err)
+	}
+	if got := time.Since(start); got < 180*time.Millisecond {
+		t.Errorf("WriteBytes took %v, want at least 200ms", got)
+	}
+	if bytesSent != 300 {
+		t.Errorf("WriteBytes sent %d bytes, want 300", bytesSent)
+	}
+}
+
+func TestUpload_WriteBytesAdaptiveConcurrency(t *testing.T) {
+	var throttled bool
+	bs := &fakeByteStreamClient{
+		write: func(_ context.Context, _ ...grpc.CallOption) (bsgrpc.ByteStream_WriteClient, error) {
+			bytesSent := int64(0)
+			return &fakeByteStreamWriteClient{
+				send: func(wr *bspb.WriteRequest) error {
+					bytesSent += int64(len(wr.Data))
+					return nil
+				},
+				// Like real servers, the fake only reports throttling when the stream is closed.
+				closeAndRecv: func() (*bspb.WriteResponse, error) {
+					if throttled {
+						return nil, status.Error(codes.ResourceExhausted, "too many writes")
+					}
+					return &bspb.WriteResponse{CommittedSize: bytesSent}, nil
+				},
+			}, nil
+		},
+	}
+	streamCfg := defaultRPCCfg
+	streamCfg.ConcurrentCallsLimit = 64
+	streamCfg.AdaptiveConcurrency = true
+	u, err := casng.NewBatchingUploader(context.Background(), &fakeCAS{}, bs, "", defaultRPCCfg, defaultRPCCfg, streamCfg, defaultIOCfg)
+	if err != nil {
+		t.Fatalf("error creating batching uploader: %v", err)
+	}
+	initial := u.ConcurrencyLimits().Stream
+	// The blob is sent in more chunks than the initial limit, which must count as a single call.
+	b := []byte(strings.Repeat("a", 2*int(defaultIOCfg.BufferSize)*initial))
+	name := casng.MakeWriteResourceName("instance", "hash", int64(len(b)))
+	if _, err := u.WriteBytes(context.Background(), name, bytes.NewReader(b), int64(len(b)), 0); err != nil {
+		t.Fatalf("WriteBytes failed: %v", err)
+	}
+	if got := u.ConcurrencyLimits().Stream; got != initial {
+		t.Errorf("stream limit is %d after a single write, want the initial limit %d", got, initial)
+	}
+
+	throttled = true
+	if _, err := u.WriteBytes(context.Background(), name, bytes.NewReader(b), int64(len(b)), 0); err == nil {
+		t.Fatalf("WriteBytes succeeded, want a throttling error")
+	}
+	if got := u.ConcurrencyLimits().Stream; got >= initial {
+		t.Errorf("stream limit is %d after a throttled write, want less than %d", got, initial)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"math"
 	"time"
 
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/bandwidth"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/retry"
 )
 
@@ -63,6 +64,12 @@ type GRPCConfig struct {
 	// Must be > 0.
 	ConcurrentCallsLimit int
 
+	// AdaptiveConcurrency enables adjusting the limit of concurrent calls between 1 and ConcurrentCallsLimit based on the observed
+	// latency and throttling errors: the limit is increased while the latency of the calls stays close to the lowest observed one,
+	// and decreased when it grows or when the server responds with RESOURCE_EXHAUSTED.
+	// The current limits are reported by ConcurrencyLimits.
+	AdaptiveConcurrency bool
+
 	// BytesLimit sets the upper bound for the size of each request.
 	// Comparisons against this value may not be exact due to padding and other serialization naunces.
 	// Clients should choose a value that is sufficiently lower than the max size limit for the corresponding gRPC connection.
@@ -87,6 +94,8 @@ type GRPCConfig struct {
 	Timeout time.Duration
 
 	// RetryPolicy sets the retry policy for calls using this config.
+	// Delays suggested by the server with google.rpc.RetryInfo are used when longer than the policy's backoff,
+	// up to its maximum delay, and retries are limited by the policy's retry budget, or the process-wide one. See retry.SetDefaultBudget.
 	RetryPolicy retry.BackoffPolicy
 
 	// RetryPredicate is called to determine if the error is retryable. If not set, nothing is retried.
@@ -146,6 +155,11 @@ type IOConfig struct {
 	// OptimizeForDiskLocality enables sorting files by path before they are written to disk to optimize for disk locality.
 	// Assuming files under the same directory are located close to each other on disk, then such files are batched together.
 	OptimizeForDiskLocality bool
+
+	// UploadLimiter limits the rate of the bytes sent by batching and streaming uploads. It may be shared with other uploaders,
+	// and its limit may be adjusted at any time.
+	// If nil, uploads are not limited.
+	UploadLimiter *bandwidth.Limiter
 }
 
 // Stats represents potential metrics reported by various methods.
@@ -0,0 +1,108 @@
+package casng
+
+import (
+	"container/heap"
+	"context"
+	"time"
+)
+
+const (
+	// PriorityDefault is the priority of requests that do not specify one.
+	PriorityDefault = 0
+
+	// PriorityAgingPeriod is how long a request waits in a queue of the uploader before its priority is raised by one level.
+	// This prevents a stream of high priority requests from starving lower priority ones.
+	PriorityAgingPeriod = time.Second
+
+	// minPriorityBufferSize is the minimum number of items buffered to be reordered by priority.
+	// It matters for processors with low concurrency limits, which would otherwise barely reorder anything.
+	minPriorityBufferSize = 16
+)
+
+type priorityKey struct{}
+
+// WithPriority returns a context that sets the priority of the queries made with it using StreamingUploader.MissingBlobs,
+// and of the upload requests made with it that do not set their own.
+// Higher values are processed first.
+func WithPriority(ctx context.Context, priority int) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+// priorityFromContext returns the priority set with WithPriority, or PriorityDefault.
+func priorityFromContext(ctx context.Context) int {
+	if p, ok := ctx.Value(priorityKey{}).(int); ok {
+		return p
+	}
+	return PriorityDefault
+}
+
+// priorityItem is an item of a priorityHeap.
+type priorityItem[T any] struct {
+	v T
+	// rank is the priority of the item minus the number of aging periods elapsed before it was queued.
+	// Comparing ranks is equivalent to comparing the effective priorities of aged items at any point in time.
+	rank float64
+	// seq breaks ties in the order the items were queued.
+	seq uint64
+}
+
+type priorityHeap[T any] []priorityItem[T]
+
+func (h priorityHeap[T]) Len() int { return len(h) }
+func (h priorityHeap[T]) Less(i, j int) bool {
+	if h[i].rank != h[j].rank {
+		return h[i].rank > h[j].rank
+	}
+	return h[i].seq < h[j].seq
+}
+func (h priorityHeap[T]) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *priorityHeap[T]) Push(x any)   { *h = append(*h, x.(priorityItem[T])) }
+func (h *priorityHeap[T]) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// prioritize forwards the items received on in to the returned channel, highest priority first.
+//
+// Up to size items, or minPriorityBufferSize if larger, are buffered to be reordered. Beyond that, prioritize stops
+// receiving until the consumer catches up, which preserves the back pressure of the pipeline.
+// The returned channel is closed after in is closed and all the buffered items are forwarded.
+func prioritize[T any](in <-chan T, size int, priority func(T) int) <-chan T {
+	if size < minPriorityBufferSize {
+		size = minPriorityBufferSize
+	}
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		var h priorityHeap[T]
+		var seq uint64
+		start := time.Now()
+		for in != nil || h.Len() > 0 {
+			recvCh := in
+			if h.Len() >= size {
+				recvCh = nil
+			}
+			var sendCh chan<- T
+			var next T
+			if h.Len() > 0 {
+				sendCh = out
+				next = h[0].v
+			}
+			select {
+			case v, ok := <-recvCh:
+				if !ok {
+					in = nil
+					continue
+				}
+				seq++
+				age := float64(time.Since(start)) / float64(PriorityAgingPeriod)
+				heap.Push(&h, priorityItem[T]{v: v, rank: float64(priority(v)) - age, seq: seq})
+			case sendCh <- next:
+				heap.Pop(&h)
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,222 @@
+package casng
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+)
+
+// UploadProgressEvent identifies the stage of an upload request that an UploadProgress reports on.
+type UploadProgressEvent int
+
+const (
+	// UploadProgressDigested reports that a blob of the request has been digested.
+	UploadProgressDigested UploadProgressEvent = iota
+
+	// UploadProgressQueried reports the result of querying the CAS for a blob of the request.
+	UploadProgressQueried
+
+	// UploadProgressSent reports that bytes of a blob of the request have been sent to the CAS.
+	UploadProgressSent
+)
+
+// UploadProgress is a progress event for a single upload request, which may represent a tree of files.
+//
+// BytesDigested and BytesSent are totals for the whole request so far, and they never decrease.
+type UploadProgress struct {
+	// ID is the ID of the request this event belongs to.
+	ID string
+
+	// Event is the stage that this event reports on.
+	Event UploadProgressEvent
+
+	// Digest identifies the blob this event is about.
+	Digest digest.Digest
+
+	// Missing is the result of the query for UploadProgressQueried events.
+	// It is false for other events.
+	Missing bool
+
+	// BytesDigested is the number of bytes of the request that have been digested.
+	BytesDigested int64
+
+	// BytesSent is the number of uncompressed bytes of the request that have been sent to the CAS.
+	BytesSent int64
+}
+
+// progressTracker accumulates the progress of a single request and reports it to the request's callback.
+// A nil tracker ignores all events.
+type progressTracker struct {
+	id string
+	fn func(UploadProgress)
+
+	// mu serializes the callbacks to keep the reported totals in order.
+	mu       sync.Mutex
+	digested int64
+	sent     int64
+}
+
+func newProgressTracker(id string, fn func(UploadProgress)) *progressTracker {
+	if fn == nil {
+		return nil
+	}
+	return &progressTracker{id: id, fn: fn}
+}
+
+func (p *progressTracker) report(event UploadProgressEvent, d digest.Digest, missing bool, digested, sent int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.digested += digested
+	p.sent += sent
+	p.fn(UploadProgress{
+		ID:            p.id,
+		Event:         event,
+		Digest:        d,
+		Missing:       missing,
+		BytesDigested: p.digested,
+		BytesSent:     p.sent,
+	})
+}
+
+// progressFanout reports the sent bytes of a blob to the trackers of all the requests that share its upload.
+// Trackers that are added after bytes were sent are caught up with a single event.
+type progressFanout struct {
+	d digest.Digest
+
+	mu       sync.Mutex
+	trackers []*progressTracker
+	sent     int64
+}
+
+func (f *progressFanout) add(pt *progressTracker) {
+	if pt == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.trackers = append(f.trackers, pt)
+	if f.sent > 0 {
+		pt.report(UploadProgressSent, f.d, false, 0, f.sent)
+	}
+}
+
+func (f *progressFanout) report(sent int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent += sent
+	for _, pt := range f.trackers {
+		pt.report(UploadProgressSent, f.d, false, 0, sent)
+	}
+}
+
+// progressReader reports the bytes read from r as sent bytes of its blob.
+type progressReader struct {
+	r io.Reader
+	f *progressFanout
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.f.report(int64(n))
+	}
+	return n, err
+}
+
+// requestCancel holds the cancel function of an in-flight request.
+// It is referenced by pointer to allow compare-and-delete on the registry.
+type requestCancel struct {
+	cancel context.CancelFunc
+}
+
+// Cancel aborts the in-flight upload request with the specified ID, which must have been set on UploadRequest.ID.
+//
+// Blobs of the request that are still queued are answered with context.Canceled without being uploaded,
+// and those that are being streamed are aborted, which releases their throttling slots.
+// A stream shared with other in-flight requests continues until all of them are cancelled.
+// Returns false if no in-flight request has that ID.
+func (u *StreamingUploader) Cancel(id string) bool {
+	v, ok := u.requestCancels.LoadAndDelete(id)
+	if !ok {
+		return false
+	}
+	v.(*requestCancel).cancel()
+	return true
+}
+
+// releaseRequest releases the cancel function of the request with the specified ID, if it is cancellable,
+// once all of its responses are delivered.
+func (u *uploader) releaseRequest(id string) {
+	if v, ok := u.requestCancels.LoadAndDelete(id); ok {
+		v.(*requestCancel).cancel()
+	}
+}
+
+// countBlob counts a blob towards the request with the specified ID before the blob is dispatched.
+func (u *uploader) countBlob(id string) {
+	if id != "" {
+		u.requestCounts.add(id, 1)
+	}
+}
+
+// blobDone uncounts a blob of the request with the specified ID before its response is delivered,
+// and releases the request if it was the last one.
+func (u *uploader) blobDone(id string) {
+	if id != "" && u.requestCounts.add(id, -1) {
+		u.releaseRequest(id)
+	}
+}
+
+// finishRequest marks the request with the specified ID as fully dispatched, and releases it if none of its blobs are in flight.
+// It must be called before the last response of the request is sent to the dispatcher.
+func (u *uploader) finishRequest(id string) {
+	if id != "" && u.requestCounts.finish(id) {
+		u.releaseRequest(id)
+	}
+}
+
+// requestCount tracks the number of in-flight blobs of each identified request.
+// Blobs are counted before being dispatched and uncounted before their responses are delivered,
+// which ensures a request is released before its requester observes its last response.
+type requestCount struct {
+	mu    sync.Mutex
+	count map[string]int
+	done  map[string]bool
+}
+
+// add adds c to the count of the request and returns true if the request is finished and has no blobs in flight.
+func (rc *requestCount) add(id string, c int) bool {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.count == nil {
+		rc.count = make(map[string]int)
+	}
+	rc.count[id] += c
+	return rc.settle(id)
+}
+
+// finish marks the request as fully dispatched and returns true if it has no blobs in flight.
+func (rc *requestCount) finish(id string) bool {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.done == nil {
+		rc.done = make(map[string]bool)
+	}
+	rc.done[id] = true
+	return rc.settle(id)
+}
+
+// settle forgets the request if it is finished and has no blobs in flight. The lock must be held by the caller.
+func (rc *requestCount) settle(id string) bool {
+	if !rc.done[id] || rc.count[id] > 0 {
+		return false
+	}
+	delete(rc.done, id)
+	delete(rc.count, id)
+	return true
+}
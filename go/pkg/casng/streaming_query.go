@@ -66,6 +66,8 @@ type missingBlobRequest struct {
 	id     string
 	tag    string
 	ctx    context.Context
+	// priority orders this request in the queue of the query processor.
+	priority int
 }
 
 // MissingBlobs is a non-blocking call that queries the CAS for incoming digests.
@@ -83,6 +85,8 @@ type missingBlobRequest struct {
 // This could indicate completion or cancellation (in case the context was canceled).
 // Slow consumption speed on the returned channel affects the consumption speed on in.
 //
+// The digests are queried in the order of the priority of ctx relative to other queries. See WithPriority.
+//
 // This method must not be called after cancelling the uploader's context.
 func (u *StreamingUploader) MissingBlobs(ctx context.Context, in <-chan digest.Digest) <-chan MissingBlobsResponse {
 	pipeIn := make(chan missingBlobRequest)
@@ -92,7 +96,7 @@ func (u *StreamingUploader) MissingBlobs(ctx context.Context, in <-chan digest.D
 		defer u.clientSenderWg.Done()
 		defer close(pipeIn)
 		for d := range in {
-			pipeIn <- missingBlobRequest{digest: d, ctx: ctx, id: uuid.New()}
+			pipeIn <- missingBlobRequest{digest: d, ctx: ctx, id: uuid.New(), priority: priorityFromContext(ctx)}
 		}
 	}()
 	return out
@@ -227,11 +231,13 @@ func (u *uploader) queryProcessor(ctx context.Context) {
 		bundleCtx = ctx
 	}
 
+	// Buffer up to a bundle of requests to bundle the higher priority ones first.
+	in := prioritize(u.queryCh, u.queryRPCCfg.ItemsLimit, func(r missingBlobRequest) int { return r.priority })
 	bundleTicker := time.NewTicker(u.queryRPCCfg.BundleTimeout)
 	defer bundleTicker.Stop()
 	for {
 		select {
-		case req, ok := <-u.queryCh:
+		case req, ok := <-in:
 			if !ok {
 				return
 			}
@@ -295,7 +301,9 @@ func (u *uploader) callMissingBlobs(ctx context.Context, digestTags, digestReqs
 	err = retry.WithPolicy(ctx, u.queryRPCCfg.RetryPredicate, u.queryRPCCfg.RetryPolicy, func() error {
 		ctx, ctxCancel := context.WithTimeout(ctx, u.queryRPCCfg.Timeout)
 		defer ctxCancel()
+		callStart := time.Now()
 		res, err = u.cas.FindMissingBlobs(ctx, req)
+		u.queryThrottler.observe(time.Since(callStart), err)
 		return err
 	})
 	log.V(3).Infof("[casng] query.grpc.duration; start=%d, end=%d", startTime.UnixNano(), time.Now().UnixNano())
@@ -2,12 +2,17 @@ package casng_test
 
 import (
 	"context"
+	"fmt"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/casng"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
 	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestMissingBlobs_StreamingAbort(t *testing.T) {
@@ -51,3 +56,62 @@ func TestMissingBlobs_Streaming(t *testing.T) {
 		}
 	}
 }
+
+func TestMissingBlobs_StreamingAdaptiveConcurrency(t *testing.T) {
+	var throttled atomic.Bool
+	fCas := &fakeCAS{findMissingBlobs: func(_ context.Context, _ *repb.FindMissingBlobsRequest, _ ...grpc.CallOption) (*repb.FindMissingBlobsResponse, error) {
+		if throttled.Load() {
+			return nil, status.Error(codes.ResourceExhausted, "too many calls")
+		}
+		time.Sleep(time.Millisecond)
+		return &repb.FindMissingBlobsResponse{}, nil
+	}}
+	rpcCfg := defaultRPCCfg
+	rpcCfg.ConcurrentCallsLimit = 64
+	rpcCfg.AdaptiveConcurrency = true
+	ctx, ctxCancel := context.WithCancel(context.Background())
+	defer ctxCancel()
+	u, err := casng.NewStreamingUploader(ctx, fCas, &fakeByteStreamClient{}, "", rpcCfg, defaultRPCCfg, defaultRPCCfg, defaultIOCfg)
+	if err != nil {
+		t.Fatalf("error creating streaming uploader: %v", err)
+	}
+	initial := u.ConcurrencyLimits()
+	if initial.Query >= rpcCfg.ConcurrentCallsLimit {
+		t.Fatalf("initial query limit is %d, want less than %d", initial.Query, rpcCfg.ConcurrentCallsLimit)
+	}
+	if initial.Batch != defaultRPCCfg.ConcurrentCallsLimit {
+		t.Errorf("batch limit is %d, want the static limit %d", initial.Batch, defaultRPCCfg.ConcurrentCallsLimit)
+	}
+
+	query := func(n int) (errCount int) {
+		reqChan := make(chan digest.Digest)
+		ch := u.MissingBlobs(ctx, reqChan)
+		go func() {
+			for i := 0; i < n; i++ {
+				reqChan <- digest.Digest{Hash: fmt.Sprintf("%d", i), Size: 1}
+			}
+			close(reqChan)
+		}()
+		for r := range ch {
+			if r.Err != nil {
+				errCount++
+			}
+		}
+		return errCount
+	}
+	if errCount := query(400); errCount > 0 {
+		t.Fatalf("%d queries failed", errCount)
+	}
+	grown := u.ConcurrencyLimits().Query
+	if grown <= initial.Query {
+		t.Errorf("query limit is %d after successful calls, want more than %d", grown, initial.Query)
+	}
+
+	throttled.Store(true)
+	if errCount := query(2); errCount == 0 {
+		t.Fatalf("queries succeeded, want errors")
+	}
+	if got := u.ConcurrencyLimits().Query; got >= grown {
+		t.Errorf("query limit is %d after a throttled call, want less than %d", got, grown)
+	}
+}
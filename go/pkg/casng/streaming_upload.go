@@ -6,18 +6,21 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
-	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/contextmd"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/errors"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/io/impath"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/io/vfs"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/io/walker"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/retry"
 	"github.com/pborman/uuid"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 
 	slo "github.com/bazelbuild/remote-apis-sdks/go/pkg/symlinkopts"
 	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
@@ -41,7 +44,7 @@ type UploadRequest struct {
 	// If Bytes is not nil (may be empty), Path is used as the corresponding path for the bytes content and is not used for traversal.
 	Bytes []byte
 
-	// BytesFileMode describes the bytes content. It is ignored if Bytes is not set.
+	// BytesFileMode describes the bytes content, or the remote entry if Remote is set. It is ignored otherwise.
 	BytesFileMode fs.FileMode
 
 	// Path is used to access and read files if Bytes is nil. Otherwise, Bytes is assumed to be the paths content (even if empty).
@@ -60,10 +63,41 @@ type UploadRequest struct {
 	// Using a different ID for effectively identical filters will reduce cache hit rates and increase digestion compute cost.
 	Exclude walker.Filter
 
+	// Remote mounts the blob identified by Digest, which must already be in the CAS, at Path without reading or uploading anything.
+	//
+	// If BytesFileMode has fs.ModeDir, Digest identifies a repb.Directory message whose entire tree must be in the CAS.
+	// Otherwise, it identifies a file, which is executable if BytesFileMode has any of the executable bits.
+	// Remote requests are only supported by BatchingUploader.UploadTree, and their paths must not overlap with the paths of any other request of the tree.
+	Remote bool
+
+	// FS is the file system that Path is read from. If nil, the real file system is used.
+	//
+	// Nodes and file digests are cached per file system ID. See vfs.FS.
+	// Use a vfs.Overlay to merge virtual entries into a real tree.
+	FS vfs.FS
+
+	// Priority orders this request, and the blobs of its tree, relative to the other requests queued in the uploader.
+	// Higher values are uploaded first, e.g. the inputs of an action that blocks a build before a background upload of large artifacts.
+	// Lower priority requests are not starved: their priority rises the longer they wait. See PriorityAgingPeriod.
+	// If zero, the priority of the requester's context is used. See WithPriority.
+	Priority int
+
+	// ID identifies this request in progress events and allows cancelling it using StreamingUploader.Cancel.
+	// It must be unique among the in-flight requests of the uploader: a request with the ID of another one is answered with ErrDuplicateRequestID.
+	// The ID can be reused once all the responses of the request are delivered.
+	// If not set, a random one is generated, which cannot be cancelled individually.
+	ID string
+
+	// Progress, if set, is called with progress events of this request and the blobs of its tree.
+	// Calls are serialized per request, but may come from the uploader's goroutines. It must not block.
+	Progress func(UploadProgress)
+
 	// Internal fields.
 
 	// id identifies this request internally for logging purposes.
 	id string
+	// progress tracks the progress of this request. It is shared by the blobs of the request's tree.
+	progress *progressTracker
 	// reader is used to keep a large file open while being handed over between workers.
 	reader io.ReadSeekCloser
 	// ctx is the requester's context which is used to extract metadata from and abort in-flight tasks for this request.
@@ -78,6 +112,19 @@ type UploadRequest struct {
 	digestOnly bool
 }
 
+// fileSystem returns the file system to read the request's paths from.
+func (r UploadRequest) fileSystem() vfs.FS {
+	if r.FS == nil {
+		return vfs.OS
+	}
+	return r.FS
+}
+
+// nodeCacheKey returns the key of path in the node cache for this request.
+func (r UploadRequest) nodeCacheKey(path impath.Absolute) string {
+	return path.String() + r.Exclude.String() + r.fileSystem().ID()
+}
+
 // UploadResponse represents an upload result for a single request (which may represent a tree of files).
 type UploadResponse struct {
 	// Digest identifies the blob associated with this response.
@@ -104,9 +151,10 @@ type UploadResponse struct {
 
 // uploadRequestBundleItem is a tuple of an upload request and a list of clients interested in the response.
 type uploadRequestBundleItem struct {
-	req  *repb.BatchUpdateBlobsRequest_Request
-	tags []string
-	reqs []string
+	req      *repb.BatchUpdateBlobsRequest_Request
+	tags     []string
+	reqs     []string
+	progress []*progressTracker
 }
 
 // uploadRequestBundle is used to aggregate (unify) requests by digest.
@@ -155,6 +203,11 @@ func (u *uploader) streamPipe(ctx context.Context, in <-chan UploadRequest) <-ch
 	// This broker should not remove the subscription until the sender tells it to.
 	tag, resChan := u.uploadPubSub.sub()
 
+	// Cancellable requests of this requester. Each one is released by the dispatcher once all of its responses are delivered,
+	// and the remaining ones are released once all the responses of the requester are delivered.
+	// The sender appends to this map before sending the done signal, which reaches the receiver after that.
+	cancels := make(map[string]*requestCancel)
+
 	// Forward the requests to the internal processor.
 	u.uploadSenderWg.Add(1)
 	go func() {
@@ -164,7 +217,25 @@ func (u *uploader) streamPipe(ctx context.Context, in <-chan UploadRequest) <-ch
 		for r := range in {
 			r.tag = tag
 			r.ctx = ctx
-			r.id = uuid.New()
+			r.id = r.ID
+			if r.id == "" {
+				r.id = uuid.New()
+			} else {
+				var cancel context.CancelFunc
+				r.ctx, cancel = context.WithCancel(ctx)
+				rc := &requestCancel{cancel: cancel}
+				if _, loaded := u.requestCancels.LoadOrStore(r.id, rc); loaded {
+					cancel()
+					// The receiver closes ch only after the done signal, which is sent below.
+					ch <- UploadResponse{Digest: r.Digest, Err: errors.Join(ErrDuplicateRequestID, fmt.Errorf("[casng] upload.stream_pipe; request id %q is already in flight", r.id))}
+					continue
+				}
+				cancels[r.id] = rc
+			}
+			r.progress = newProgressTracker(r.id, r.Progress)
+			if r.Priority == 0 {
+				r.Priority = priorityFromContext(ctx)
+			}
 			u.digesterCh <- r
 		}
 		// Let the processor know that no further requests are expected.
@@ -183,6 +254,10 @@ func (u *uploader) streamPipe(ctx context.Context, in <-chan UploadRequest) <-ch
 			r := rawR.(UploadResponse)
 			if r.done {
 				u.uploadPubSub.unsub(tag)
+				for id, rc := range cancels {
+					u.requestCancels.CompareAndDelete(id, rc)
+					rc.cancel()
+				}
 				continue
 			}
 			ch <- r
@@ -238,23 +313,42 @@ func (u *uploader) batcher(ctx context.Context) {
 		bundleCtx = ctx
 	}
 
+	// Buffer up to a bundle of requests to bundle the higher priority ones first.
+	in := prioritize(u.batcherCh, u.batchRPCCfg.ItemsLimit, func(r UploadRequest) int { return r.Priority })
 	bundleTicker := time.NewTicker(u.batchRPCCfg.BundleTimeout)
 	defer bundleTicker.Stop()
 	for {
 		select {
 		// The dispatcher guarantees that the dispatched blob is not oversized.
-		case req, ok := <-u.batcherCh:
+		case req, ok := <-in:
 			if !ok {
 				return
 			}
 			log.V(3).Infof("[casng] upload.batcher.req; digest=%s, req=%s, tag=%s", req.Digest, req.id, req.tag)
 
+			// Skip requests that were cancelled while queued.
+			if err := req.ctx.Err(); err != nil {
+				log.V(3).Infof("[casng] upload.batcher.cancelled; digest=%s, req=%s, tag=%s", req.Digest, req.id, req.tag)
+				if req.reader != nil {
+					u.releaseIOTokens()
+				}
+				u.dispatcherResCh <- UploadResponse{
+					Digest: req.Digest,
+					Stats:  Stats{BytesRequested: req.Digest.Size},
+					Err:    err,
+					tags:   []string{req.tag},
+					reqs:   []string{req.id},
+				}
+				continue
+			}
+
 			// Unify.
 			item, ok := bundle[req.Digest]
 			if ok {
 				// Duplicate tags are allowed to ensure the requester can match the number of responses to the number of requests.
 				item.tags = append(item.tags, req.tag)
 				item.reqs = append(item.reqs, req.id)
+				item.progress = append(item.progress, req.progress)
 				bundle[req.Digest] = item
 				log.V(3).Infof("[casng] upload.batcher.unified; digest=%s, bundle=%d, req=%s, tag=%s", req.Digest, len(item.tags), req.id, req.tag)
 				continue
@@ -288,7 +382,7 @@ func (u *uploader) batcher(ctx context.Context) {
 						}
 						log.V(3).Infof("[casng] upload.batcher.io_throttle.duration; start=%d, end=%d, req=%s, tag=%s", startTime.UnixNano(), time.Now().UnixNano(), req.id, req.tag)
 						defer u.ioThrottler.release()
-						f, err := os.Open(req.Path.String())
+						f, err := req.fileSystem().Open(req.Path)
 						if err != nil {
 							return errors.Join(ErrIO, err)
 						}
@@ -320,6 +414,8 @@ func (u *uploader) batcher(ctx context.Context) {
 			}
 
 			item.tags = append(item.tags, req.tag)
+			item.reqs = append(item.reqs, req.id)
+			item.progress = append(item.progress, req.progress)
 			item.req = &repb.BatchUpdateBlobsRequest_Request{
 				Digest: req.Digest.ToProto(),
 				Data:   req.Bytes, // TODO: add compression support as in https://github.com/bazelbuild/remote-apis-sdks/pull/443/files
@@ -356,13 +452,22 @@ func (u *uploader) callBatchUpload(ctx context.Context, bundle uploadRequestBund
 	startTime := time.Now()
 	err := retry.WithPolicy(ctx, u.batchRPCCfg.RetryPredicate, u.batchRPCCfg.RetryPolicy, func() error {
 		// This call can have partial failures. Only retry retryable failed requests.
+		if errLimit := u.ioCfg.UploadLimiter.WaitN(ctx, proto.Size(req)); errLimit != nil {
+			return errLimit
+		}
 		ctx, ctxCancel := context.WithTimeout(ctx, u.batchRPCCfg.Timeout)
 		defer ctxCancel()
+		callStart := time.Now()
 		res, errCall := u.cas.BatchUpdateBlobs(ctx, req)
+		callLatency := time.Since(callStart)
 		reqErr := errCall // return this error if nothing is retryable.
+		throttleErr := errCall
 		req.Requests = nil
 		for _, r := range res.Responses {
 			if errItem := status.FromProto(r.Status).Err(); errItem != nil {
+				if status.Code(errItem) == codes.ResourceExhausted {
+					throttleErr = errItem
+				}
 				if retry.TransientOnly(errItem) {
 					d := digest.NewFromProtoUnvalidated(r.Digest)
 					req.Requests = append(req.Requests, bundle[d].req)
@@ -376,6 +481,7 @@ func (u *uploader) callBatchUpload(ctx context.Context, bundle uploadRequestBund
 			}
 			uploaded = append(uploaded, digest.NewFromProtoUnvalidated(r.Digest))
 		}
+		u.uploadThrottler.observe(callLatency, throttleErr)
 		if l := len(req.Requests); l > 0 {
 			log.V(3).Infof("[casng] upload.batcher.call.retry; len=%d", l)
 		}
@@ -398,6 +504,9 @@ func (u *uploader) callBatchUpload(ctx context.Context, bundle uploadRequestBund
 		if r := digestRetryCount[d]; r > 0 {
 			s.TotalBytesMoved = d.Size * (r + 1)
 		}
+		for _, pt := range bundle[d].progress {
+			pt.report(UploadProgressSent, d, false, 0, d.Size)
+		}
 		u.dispatcherResCh <- UploadResponse{
 			Digest: d,
 			Stats:  s,
@@ -482,19 +591,38 @@ func (u *uploader) streamer(ctx context.Context) {
 	// Unify duplicate requests.
 	digestTags := make(map[digest.Digest][]string)
 	digestReqs := make(map[digest.Digest][]string)
+	digestCalls := make(map[digest.Digest]*streamCall)
 	streamResCh := make(chan UploadResponse)
 	pending := 0
+	// Buffer up to a round of concurrent calls to stream the higher priority blobs first.
+	in := prioritize(u.streamerCh, u.streamRPCCfg.ConcurrentCallsLimit, func(r UploadRequest) int { return r.Priority })
 	for {
 		select {
 		// The dispatcher closes this channel when it's done dispatching, which happens after the streamer
 		// had sent all pending responses.
-		case req, ok := <-u.streamerCh:
+		case req, ok := <-in:
 			if !ok {
 				return
 			}
 			shouldReleaseIOTokens := req.reader != nil
 			log.V(3).Infof("[casng] upload.streamer.req; digest=%s, large=%t, req=%s, tag=%s, pending=%d", req.Digest, shouldReleaseIOTokens, req.id, req.tag, pending)
 
+			// Skip requests that were cancelled while queued.
+			if err := req.ctx.Err(); err != nil {
+				log.V(3).Infof("[casng] upload.streamer.cancelled; digest=%s, req=%s, tag=%s", req.Digest, req.id, req.tag)
+				if shouldReleaseIOTokens {
+					u.releaseIOTokens()
+				}
+				u.dispatcherResCh <- UploadResponse{
+					Digest: req.Digest,
+					Stats:  Stats{BytesRequested: req.Digest.Size},
+					Err:    err,
+					tags:   []string{req.tag},
+					reqs:   []string{req.id},
+				}
+				continue
+			}
+
 			digestReqs[req.Digest] = append(digestReqs[req.Digest], req.id)
 			tags := digestTags[req.Digest]
 			tags = append(tags, req.tag)
@@ -505,9 +633,17 @@ func (u *uploader) streamer(ctx context.Context) {
 				if shouldReleaseIOTokens {
 					u.releaseIOTokens()
 				}
+				digestCalls[req.Digest].join(req)
 				continue
 			}
 
+			// The stream is shared by all the requests for the digest, so it must not be aborted by the context of this one alone.
+			callCtx, _ := contextmd.FromContexts(ctx, req.ctx) // ignore non-essential error.
+			callCtx, callCancel := context.WithCancel(callCtx)
+			call := &streamCall{cancel: callCancel, done: make(chan struct{}), progress: progressFanout{d: req.Digest}}
+			call.join(req)
+			digestCalls[req.Digest] = call
+
 			var name string
 			if req.Digest.Size >= u.ioCfg.CompressionSizeThreshold {
 				log.V(3).Infof("[casng] upload.streamer.compress; digest=%s, req=%s, tag=%s", req.Digest, req.id, req.tag)
@@ -535,7 +671,7 @@ func (u *uploader) streamer(ctx context.Context) {
 			u.workerWg.Add(1)
 			go func(req UploadRequest) {
 				defer u.workerWg.Done()
-				s, err := u.callStream(req.ctx, name, req)
+				s, err := u.callStream(callCtx, name, req, &call.progress)
 				// Release before sending on the channel to avoid blocking without actually using the gRPC resources.
 				u.streamThrottle.release()
 				streamResCh <- UploadResponse{Digest: req.Digest, Stats: s, Err: err}
@@ -546,6 +682,8 @@ func (u *uploader) streamer(ctx context.Context) {
 			delete(digestTags, r.Digest)
 			r.reqs = digestReqs[r.Digest]
 			delete(digestReqs, r.Digest)
+			digestCalls[r.Digest].finish()
+			delete(digestCalls, r.Digest)
 			u.dispatcherResCh <- r
 			pending--
 			if log.V(3) {
@@ -557,7 +695,46 @@ func (u *uploader) streamer(ctx context.Context) {
 	}
 }
 
-func (u *uploader) callStream(ctx context.Context, name string, req UploadRequest) (stats Stats, err error) {
+// streamCall is an in-flight stream of a blob, shared by all the requests for its digest.
+type streamCall struct {
+	cancel context.CancelFunc
+	// done is closed once the stream is over.
+	done     chan struct{}
+	progress progressFanout
+
+	mu sync.Mutex
+	// live is the number of requests for the blob whose contexts are not done.
+	live int
+}
+
+// join adds a request to the stream, which is cancelled once the contexts of all of its requests are done.
+func (c *streamCall) join(req UploadRequest) {
+	c.progress.add(req.progress)
+	c.mu.Lock()
+	c.live++
+	c.mu.Unlock()
+	go func() {
+		select {
+		case <-req.ctx.Done():
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			c.live--
+			if c.live == 0 {
+				c.cancel()
+			}
+		case <-c.done:
+		}
+	}()
+}
+
+// finish releases the resources of the stream once it is over.
+func (c *streamCall) finish() {
+	close(c.done)
+	c.cancel()
+}
+
+// callStream streams the blob of req, reporting the bytes sent to progress.
+func (u *uploader) callStream(ctx context.Context, name string, req UploadRequest, progress *progressFanout) (stats Stats, err error) {
 	var reader io.Reader
 
 	// In the off chance that the blob is mis-constructed (more than one content field is set), start
@@ -587,7 +764,7 @@ func (u *uploader) callStream(ctx context.Context, name string, req UploadReques
 		log.V(3).Infof("[casng] upload.streamer.io_throttle.duration; start=%d, end=%d, req=%s, tag=%s", startTime.UnixNano(), time.Now().UnixNano(), req.id, req.tag)
 		defer u.ioThrottler.release()
 
-		f, errOpen := os.Open(req.Path.String())
+		f, errOpen := req.fileSystem().Open(req.Path)
 		if errOpen != nil {
 			return Stats{BytesRequested: req.Digest.Size}, errors.Join(ErrIO, errOpen)
 		}
@@ -599,5 +776,6 @@ func (u *uploader) callStream(ctx context.Context, name string, req UploadReques
 		reader = f
 	}
 
+	reader = &progressReader{r: reader, f: progress}
 	return u.writeBytes(ctx, name, reader, req.Digest.Size, 0, true)
 }
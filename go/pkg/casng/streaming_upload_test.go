@@ -0,0 +1,442 @@
+package casng_test
+
+import (
+	"context"
+	"math"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/casng"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/errors"
+	"github.com/google/go-cmp/cmp"
+
+	// Redundant imports are required for the google3 mirror. Aliases should not be changed.
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	bsgrpc "google.golang.org/genproto/googleapis/bytestream"
+	bspb "google.golang.org/genproto/googleapis/bytestream"
+	"google.golang.org/grpc"
+)
+
+func TestUpload_StreamingPriority(t *testing.T) {
+	blobs := map[string][]byte{}
+	hashNames := map[string]string{}
+	for _, name := range []string{"first", "second", "low", "high"} {
+		b := []byte(strings.Repeat(name, 1000))
+		blobs[name] = b
+		hashNames[digest.NewFromBlob(b).Hash] = name
+	}
+
+	var mu sync.Mutex
+	var streamed []string
+	release := make(chan struct{})
+	var writes int
+	bs := &fakeByteStreamClient{
+		write: func(_ context.Context, _ ...grpc.CallOption) (bsgrpc.ByteStream_WriteClient, error) {
+			mu.Lock()
+			writes++
+			first := writes == 1
+			mu.Unlock()
+			if first {
+				// Hold the only stream until all the other blobs are queued.
+				<-release
+			}
+			var name string
+			var size int64
+			return &fakeByteStreamWriteClient{
+				send: func(wr *bspb.WriteRequest) error {
+					if name == "" {
+						parts := strings.Split(wr.ResourceName, "/")
+						name = hashNames[parts[len(parts)-2]]
+						mu.Lock()
+						streamed = append(streamed, name)
+						mu.Unlock()
+					}
+					size += int64(len(wr.Data))
+					return nil
+				},
+				closeAndRecv: func() (*bspb.WriteResponse, error) {
+					return &bspb.WriteResponse{CommittedSize: size}, nil
+				},
+			}, nil
+		},
+	}
+	cas := &fakeCAS{findMissingBlobs: func(_ context.Context, req *repb.FindMissingBlobsRequest, _ ...grpc.CallOption) (*repb.FindMissingBlobsResponse, error) {
+		return &repb.FindMissingBlobsResponse{MissingBlobDigests: req.BlobDigests}, nil
+	}}
+	streamCfg := defaultRPCCfg
+	streamCfg.ConcurrentCallsLimit = 1
+	ioCfg := defaultIOCfg
+	ioCfg.CompressionSizeThreshold = math.MaxInt64
+	ioCfg.BufferSize = 1024
+
+	ctx, ctxCancel := context.WithCancel(context.Background())
+	defer ctxCancel()
+	u, err := casng.NewStreamingUploader(ctx, cas, bs, "instance", defaultRPCCfg, defaultRPCCfg, streamCfg, ioCfg)
+	if err != nil {
+		t.Fatalf("error creating streaming uploader: %v", err)
+	}
+	in := make(chan casng.UploadRequest)
+	out := u.Upload(ctx, in)
+	outDone := make(chan struct{})
+	go func() {
+		defer close(outDone)
+		for r := range out {
+			if r.Err != nil {
+				t.Errorf("upload failed: %v", r.Err)
+			}
+		}
+	}()
+
+	// The first blob holds the stream, and the second one waits for it in the streamer.
+	// The low and high priority blobs are queued in that order behind them.
+	for _, name := range []string{"first", "second", "low"} {
+		in <- casng.UploadRequest{Bytes: blobs[name]}
+		time.Sleep(50 * time.Millisecond)
+	}
+	in <- casng.UploadRequest{Bytes: blobs["high"], Priority: 10}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	close(in)
+	<-outDone
+
+	mu.Lock()
+	defer mu.Unlock()
+	if diff := cmp.Diff([]string{"first", "second", "high", "low"}, streamed); diff != "" {
+		t.Errorf("stream order mismatch, (-want +got): %s", diff)
+	}
+}
+
+func TestUpload_StreamingProgress(t *testing.T) {
+	small := []byte("small")
+	large := []byte(strings.Repeat("large", 1000))
+	dSmall := digest.NewFromBlob(small)
+	dLarge := digest.NewFromBlob(large)
+
+	bs := &fakeByteStreamClient{
+		write: func(_ context.Context, _ ...grpc.CallOption) (bsgrpc.ByteStream_WriteClient, error) {
+			var size int64
+			return &fakeByteStreamWriteClient{
+				send: func(wr *bspb.WriteRequest) error {
+					size += int64(len(wr.Data))
+					return nil
+				},
+				closeAndRecv: func() (*bspb.WriteResponse, error) {
+					return &bspb.WriteResponse{CommittedSize: size}, nil
+				},
+			}, nil
+		},
+	}
+	cas := &fakeCAS{
+		findMissingBlobs: func(_ context.Context, req *repb.FindMissingBlobsRequest, _ ...grpc.CallOption) (*repb.FindMissingBlobsResponse, error) {
+			return &repb.FindMissingBlobsResponse{MissingBlobDigests: req.BlobDigests}, nil
+		},
+		batchUpdateBlobs: func(_ context.Context, req *repb.BatchUpdateBlobsRequest, _ ...grpc.CallOption) (*repb.BatchUpdateBlobsResponse, error) {
+			res := &repb.BatchUpdateBlobsResponse{}
+			for _, r := range req.Requests {
+				res.Responses = append(res.Responses, &repb.BatchUpdateBlobsResponse_Response{Digest: r.Digest})
+			}
+			return res, nil
+		},
+	}
+	ioCfg := defaultIOCfg
+	ioCfg.CompressionSizeThreshold = math.MaxInt64
+	ioCfg.BufferSize = 1000
+
+	ctx, ctxCancel := context.WithCancel(context.Background())
+	defer ctxCancel()
+	u, err := casng.NewStreamingUploader(ctx, cas, bs, "instance", defaultRPCCfg, defaultRPCCfg, defaultRPCCfg, ioCfg)
+	if err != nil {
+		t.Fatalf("error creating streaming uploader: %v", err)
+	}
+
+	var mu sync.Mutex
+	events := map[string][]casng.UploadProgress{}
+	progress := func(p casng.UploadProgress) {
+		mu.Lock()
+		defer mu.Unlock()
+		events[p.ID] = append(events[p.ID], p)
+	}
+	in := make(chan casng.UploadRequest)
+	out := u.Upload(ctx, in)
+	go func() {
+		defer close(in)
+		in <- casng.UploadRequest{Bytes: small, ID: "small", Progress: progress}
+		in <- casng.UploadRequest{Bytes: large, ID: "large", Progress: progress}
+	}()
+	for r := range out {
+		if r.Err != nil {
+			t.Errorf("upload failed: %v", r.Err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	wantSmall := []casng.UploadProgress{
+		{ID: "small", Event: casng.UploadProgressDigested, Digest: dSmall, BytesDigested: 5},
+		{ID: "small", Event: casng.UploadProgressQueried, Digest: dSmall, Missing: true, BytesDigested: 5},
+		{ID: "small", Event: casng.UploadProgressSent, Digest: dSmall, BytesDigested: 5, BytesSent: 5},
+	}
+	if diff := cmp.Diff(wantSmall, events["small"]); diff != "" {
+		t.Errorf("small progress mismatch, (-want +got): %s", diff)
+	}
+	wantLarge := []casng.UploadProgress{
+		{ID: "large", Event: casng.UploadProgressDigested, Digest: dLarge, BytesDigested: 5000},
+		{ID: "large", Event: casng.UploadProgressQueried, Digest: dLarge, Missing: true, BytesDigested: 5000},
+	}
+	for sent := int64(1000); sent <= 5000; sent += 1000 {
+		wantLarge = append(wantLarge, casng.UploadProgress{ID: "large", Event: casng.UploadProgressSent, Digest: dLarge, BytesDigested: 5000, BytesSent: sent})
+	}
+	if diff := cmp.Diff(wantLarge, events["large"]); diff != "" {
+		t.Errorf("large progress mismatch, (-want +got): %s", diff)
+	}
+}
+
+func TestUpload_StreamingCancel(t *testing.T) {
+	blocked := []byte(strings.Repeat("blocked", 1000))
+	next := []byte(strings.Repeat("next", 1000))
+	dBlocked := digest.NewFromBlob(blocked)
+	dNext := digest.NewFromBlob(next)
+
+	streaming := make(chan struct{})
+	bs := &fakeByteStreamClient{
+		write: func(ctx context.Context, _ ...grpc.CallOption) (bsgrpc.ByteStream_WriteClient, error) {
+			var size int64
+			return &fakeByteStreamWriteClient{
+				send: func(wr *bspb.WriteRequest) error {
+					if strings.Contains(wr.ResourceName, dBlocked.Hash) {
+						// Hold the only stream until the request is cancelled.
+						close(streaming)
+						<-ctx.Done()
+						return ctx.Err()
+					}
+					size += int64(len(wr.Data))
+					return nil
+				},
+				closeAndRecv: func() (*bspb.WriteResponse, error) {
+					return &bspb.WriteResponse{CommittedSize: size}, nil
+				},
+			}, nil
+		},
+	}
+	cas := &fakeCAS{findMissingBlobs: func(_ context.Context, req *repb.FindMissingBlobsRequest, _ ...grpc.CallOption) (*repb.FindMissingBlobsResponse, error) {
+		return &repb.FindMissingBlobsResponse{MissingBlobDigests: req.BlobDigests}, nil
+	}}
+	streamCfg := defaultRPCCfg
+	streamCfg.ConcurrentCallsLimit = 1
+	ioCfg := defaultIOCfg
+	ioCfg.CompressionSizeThreshold = math.MaxInt64
+
+	ctx, ctxCancel := context.WithCancel(context.Background())
+	defer ctxCancel()
+	u, err := casng.NewStreamingUploader(ctx, cas, bs, "instance", defaultRPCCfg, defaultRPCCfg, streamCfg, ioCfg)
+	if err != nil {
+		t.Fatalf("error creating streaming uploader: %v", err)
+	}
+	if u.Cancel("blocked") {
+		t.Errorf("Cancel returned true for an unknown request")
+	}
+
+	in := make(chan casng.UploadRequest)
+	out := u.Upload(ctx, in)
+	go func() {
+		defer close(in)
+		in <- casng.UploadRequest{Bytes: blocked, ID: "blocked"}
+		<-streaming
+		if !u.Cancel("blocked") {
+			t.Errorf("Cancel returned false for an in-flight request")
+		}
+		// The cancelled stream must release the only slot for this one.
+		in <- casng.UploadRequest{Bytes: next}
+	}()
+	errs := map[digest.Digest]error{}
+	for r := range out {
+		errs[r.Digest] = r.Err
+	}
+	if err := errs[dBlocked]; !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled request error mismatch: want %v, got %v", context.Canceled, err)
+	}
+	if err, ok := errs[dNext]; !ok || err != nil {
+		t.Errorf("next request failed: ok=%t, err=%v", ok, err)
+	}
+	if u.Cancel("blocked") {
+		t.Errorf("Cancel returned true for a completed request")
+	}
+}
+
+func TestUpload_StreamingSharedStream(t *testing.T) {
+	blob := []byte(strings.Repeat("shared", 1000))
+	dBlob := digest.NewFromBlob(blob)
+
+	streaming := make(chan struct{})
+	release := make(chan struct{})
+	var once sync.Once
+	bs := &fakeByteStreamClient{
+		write: func(ctx context.Context, _ ...grpc.CallOption) (bsgrpc.ByteStream_WriteClient, error) {
+			var size int64
+			return &fakeByteStreamWriteClient{
+				send: func(wr *bspb.WriteRequest) error {
+					// Hold the stream after the first chunk until the second request joins it.
+					blocked := false
+					once.Do(func() { blocked = true })
+					if blocked {
+						close(streaming)
+						select {
+						case <-release:
+						case <-ctx.Done():
+							return ctx.Err()
+						}
+					}
+					size += int64(len(wr.Data))
+					return nil
+				},
+				closeAndRecv: func() (*bspb.WriteResponse, error) {
+					return &bspb.WriteResponse{CommittedSize: size}, nil
+				},
+			}, nil
+		},
+	}
+	cas := &fakeCAS{findMissingBlobs: func(_ context.Context, req *repb.FindMissingBlobsRequest, _ ...grpc.CallOption) (*repb.FindMissingBlobsResponse, error) {
+		return &repb.FindMissingBlobsResponse{MissingBlobDigests: req.BlobDigests}, nil
+	}}
+	ioCfg := defaultIOCfg
+	ioCfg.CompressionSizeThreshold = math.MaxInt64
+	ioCfg.BufferSize = 1000
+
+	ctx, ctxCancel := context.WithCancel(context.Background())
+	defer ctxCancel()
+	u, err := casng.NewStreamingUploader(ctx, cas, bs, "instance", defaultRPCCfg, defaultRPCCfg, defaultRPCCfg, ioCfg)
+	if err != nil {
+		t.Fatalf("error creating streaming uploader: %v", err)
+	}
+
+	var mu sync.Mutex
+	var sentB int64
+	joined := make(chan struct{})
+	progressB := func(p casng.UploadProgress) {
+		if p.Event != casng.UploadProgressSent {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if sentB == 0 {
+			close(joined)
+		}
+		sentB = p.BytesSent
+	}
+
+	inA := make(chan casng.UploadRequest)
+	outA := u.Upload(ctx, inA)
+	doneA := make(chan struct{})
+	go func() {
+		defer close(doneA)
+		for range outA {
+		}
+	}()
+	inA <- casng.UploadRequest{Bytes: blob, ID: "a"}
+	close(inA)
+	<-streaming
+
+	inB := make(chan casng.UploadRequest)
+	outB := u.Upload(ctx, inB)
+	inB <- casng.UploadRequest{Bytes: blob, ID: "b", Progress: progressB}
+	close(inB)
+	// The second request is caught up with the bytes already sent once it joins the stream.
+	<-joined
+	if !u.Cancel("a") {
+		t.Errorf("Cancel returned false for an in-flight request")
+	}
+	// Give the cancellation a chance to abort the stream, which it must not do while the second request is interested.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	for r := range outB {
+		if r.Digest == dBlob && r.Err != nil {
+			t.Errorf("shared stream failed after cancelling the other request: %v", r.Err)
+		}
+	}
+	<-doneA
+	mu.Lock()
+	defer mu.Unlock()
+	if sentB != dBlob.Size {
+		t.Errorf("sent bytes mismatch: want %d, got %d", dBlob.Size, sentB)
+	}
+}
+
+func TestUpload_StreamingRequestID(t *testing.T) {
+	first := []byte(strings.Repeat("first", 1000))
+	dup := []byte(strings.Repeat("dup", 1000))
+	reused := []byte(strings.Repeat("reused", 1000))
+	dFirst := digest.NewFromBlob(first)
+	dDup := digest.NewFromBlob(dup)
+	dReused := digest.NewFromBlob(reused)
+
+	streaming := make(chan struct{})
+	release := make(chan struct{})
+	var once sync.Once
+	bs := &fakeByteStreamClient{
+		write: func(ctx context.Context, _ ...grpc.CallOption) (bsgrpc.ByteStream_WriteClient, error) {
+			var size int64
+			return &fakeByteStreamWriteClient{
+				send: func(wr *bspb.WriteRequest) error {
+					if strings.Contains(wr.ResourceName, dFirst.Hash) {
+						// Hold the first request in flight until the duplicate is rejected.
+						once.Do(func() { close(streaming) })
+						<-release
+					}
+					size += int64(len(wr.Data))
+					return nil
+				},
+				closeAndRecv: func() (*bspb.WriteResponse, error) {
+					return &bspb.WriteResponse{CommittedSize: size}, nil
+				},
+			}, nil
+		},
+	}
+	cas := &fakeCAS{findMissingBlobs: func(_ context.Context, req *repb.FindMissingBlobsRequest, _ ...grpc.CallOption) (*repb.FindMissingBlobsResponse, error) {
+		return &repb.FindMissingBlobsResponse{MissingBlobDigests: req.BlobDigests}, nil
+	}}
+	ioCfg := defaultIOCfg
+	ioCfg.CompressionSizeThreshold = math.MaxInt64
+
+	ctx, ctxCancel := context.WithCancel(context.Background())
+	defer ctxCancel()
+	u, err := casng.NewStreamingUploader(ctx, cas, bs, "instance", defaultRPCCfg, defaultRPCCfg, defaultRPCCfg, ioCfg)
+	if err != nil {
+		t.Fatalf("error creating streaming uploader: %v", err)
+	}
+
+	in := make(chan casng.UploadRequest)
+	out := u.Upload(ctx, in)
+	firstDone := make(chan struct{})
+	go func() {
+		defer close(in)
+		in <- casng.UploadRequest{Bytes: first, ID: "x"}
+		<-streaming
+		in <- casng.UploadRequest{Bytes: dup, Digest: dDup, ID: "x"}
+		<-firstDone
+		// The ID is released before the response of its request is delivered, even though the requester is still sending.
+		in <- casng.UploadRequest{Bytes: reused, ID: "x"}
+	}()
+	errs := map[digest.Digest]error{}
+	for r := range out {
+		errs[r.Digest] = r.Err
+		switch r.Digest {
+		case dDup:
+			close(release)
+		case dFirst:
+			close(firstDone)
+		}
+	}
+	if err := errs[dDup]; !errors.Is(err, casng.ErrDuplicateRequestID) {
+		t.Errorf("duplicate request error mismatch: want %v, got %v", casng.ErrDuplicateRequestID, err)
+	}
+	if err, ok := errs[dFirst]; !ok || err != nil {
+		t.Errorf("first request failed: ok=%t, err=%v", ok, err)
+	}
+	if err, ok := errs[dReused]; !ok || err != nil {
+		t.Errorf("request with a reused ID failed: ok=%t, err=%v", ok, err)
+	}
+}
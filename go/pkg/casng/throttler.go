@@ -2,11 +2,53 @@ package casng
 
 import (
 	"context"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// adaptiveInitialLimit is the initial limit of an adaptive throttler, unless its maximum is lower.
+	adaptiveInitialLimit = 8
+
+	// adaptiveLatencyTolerance is the ratio of the average latency of a window of calls to the baseline latency
+	// above which the calls are considered to be queued by the server and the limit is decreased.
+	adaptiveLatencyTolerance = 2
+
+	// adaptiveThrottledBackoff is the factor applied to the limit when a call is throttled by the server.
+	adaptiveThrottledBackoff = 0.5
+
+	// adaptiveLatencyBackoff is the factor applied to the limit when the latency of the calls is too high.
+	adaptiveLatencyBackoff = 0.9
+
+	// adaptiveBaselineDrift is how fast the baseline latency follows higher latencies, so that a lasting change
+	// in the latency of the backend or the network is eventually accepted as the new baseline.
+	adaptiveBaselineDrift = 0.01
 )
 
 // throttler provides a simple semaphore interface to limit in-flight goroutines.
+//
+// An adaptive throttler also adjusts its limit between 1 and its capacity based on the calls observed with observe.
+// The limit increases by one after each window of as many successful calls as the limit, unless their average latency
+// exceeds adaptiveLatencyTolerance times the baseline, in which case it decreases slightly. It is halved whenever
+// a call is throttled by the server (AIMD).
+// The capacity of the channel is the maximum limit. The difference with the current limit is occupied by the
+// throttler itself in reserved tokens. When the limit decreases while all tokens are acquired, the reservation is
+// deferred to the next releases, which keep their token instead of returning it.
 type throttler struct {
 	ch chan struct{}
+
+	adaptive bool
+	mu       sync.Mutex
+	limit    float64
+	reserved int
+	debt     int
+	baseline time.Duration
+	window   time.Duration
+	calls    int
 }
 
 // acquire blocks until a token can be acquired from the pool.
@@ -25,15 +67,125 @@ func (t *throttler) acquire(ctx context.Context) bool {
 
 // release returns a token to the pool. Must be called after acquire. Otherwise, it will block until acquire is called.
 func (t *throttler) release() {
+	if t.adaptive {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.debt > 0 {
+			// Keep the token as a reservation.
+			t.debt--
+			t.reserved++
+			return
+		}
+	}
 	<-t.ch
 }
 
 // len returns the number of acquired tokens.
 func (t *throttler) len() int {
+	if t.adaptive {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		return len(t.ch) - t.reserved
+	}
 	return len(t.ch)
 }
 
+// currentLimit returns the number of tokens that may be acquired.
+func (t *throttler) currentLimit() int {
+	if !t.adaptive {
+		return cap(t.ch)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return int(t.limit)
+}
+
+// observe adjusts the limit of an adaptive throttler based on a call that took latency and returned err.
+// It is a no-op for a non-adaptive throttler.
+func (t *throttler) observe(latency time.Duration, err error) {
+	if !t.adaptive {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err != nil {
+		if status.Code(err) == codes.ResourceExhausted {
+			t.setLimitLocked(t.limit * adaptiveThrottledBackoff)
+		}
+		// Other errors say nothing about the load of the server.
+		return
+	}
+	switch {
+	case t.baseline == 0 || latency < t.baseline:
+		t.baseline = latency
+	default:
+		t.baseline += time.Duration(float64(latency-t.baseline) * adaptiveBaselineDrift)
+	}
+	t.window += latency
+	t.calls++
+	if float64(t.calls) < t.limit {
+		return
+	}
+	avg := t.window / time.Duration(t.calls)
+	if avg > t.baseline*adaptiveLatencyTolerance {
+		t.setLimitLocked(t.limit * adaptiveLatencyBackoff)
+	} else {
+		t.setLimitLocked(t.limit + 1)
+	}
+}
+
+// setLimitLocked changes the limit, bounded by 1 and the capacity, and starts a new window of calls.
+func (t *throttler) setLimitLocked(limit float64) {
+	if limit < 1 {
+		limit = 1
+	}
+	if capacity := float64(cap(t.ch)); limit > capacity {
+		limit = capacity
+	}
+	t.window, t.calls = 0, 0
+	old := int(t.limit)
+	t.limit = limit
+	// Reserve or return tokens to match the difference between the capacity and the new limit.
+	for want := cap(t.ch) - int(limit); t.reserved+t.debt != want; {
+		if t.reserved+t.debt < want {
+			select {
+			case t.ch <- struct{}{}:
+				t.reserved++
+			default:
+				t.debt++
+			}
+			continue
+		}
+		if t.debt > 0 {
+			t.debt--
+			continue
+		}
+		<-t.ch
+		t.reserved--
+	}
+	if int(limit) != old {
+		log.V(1).Infof("[casng] throttler.limit; old=%d, new=%d", old, int(limit))
+	}
+}
+
 // newThrottler creates a new instance that allows up to n tokens to be acquired.
 func newThrottler(n int64) *throttler {
 	return &throttler{ch: make(chan struct{}, n)}
 }
+
+// newCallThrottler creates a throttler for the calls of a gRPC processor.
+func newCallThrottler(cfg GRPCConfig) *throttler {
+	if cfg.AdaptiveConcurrency {
+		return newAdaptiveThrottler(int64(cfg.ConcurrentCallsLimit))
+	}
+	return newThrottler(int64(cfg.ConcurrentCallsLimit))
+}
+
+// newAdaptiveThrottler creates a new adaptive instance that allows up to n tokens to be acquired.
+func newAdaptiveThrottler(n int64) *throttler {
+	t := &throttler{ch: make(chan struct{}, n), adaptive: true}
+	t.mu.Lock()
+	t.setLimitLocked(adaptiveInitialLimit)
+	t.mu.Unlock()
+	return t
+}
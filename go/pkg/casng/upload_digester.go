@@ -23,7 +23,6 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
-	"os"
 	"path/filepath"
 	"sort"
 	"strings"
@@ -34,6 +33,7 @@ import (
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/errors"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/filemetadata"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/io/impath"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/io/vfs"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/io/walker"
 	slo "github.com/bazelbuild/remote-apis-sdks/go/pkg/symlinkopts"
 	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
@@ -116,7 +116,7 @@ func (u *uploader) digester(ctx context.Context) {
 				} else {
 					node = &repb.FileNode{Digest: digest, Name: name, IsExecutable: isExec(req.BytesFileMode)}
 				}
-				key := req.Path.String() + req.Exclude.String()
+				key := req.nodeCacheKey(req.Path)
 				u.nodeCache.Store(key, node)
 				// This node cannot be added to the u.dirChildren cache because the cache is owned by the walker callback.
 				// Parent nodes may have already been generated and cached in u.nodeCache; updating the u.dirChildren cache will not regenerate them.
@@ -125,6 +125,8 @@ func (u *uploader) digester(ctx context.Context) {
 		}
 
 		if req.Digest.Hash != "" {
+			u.countBlob(req.id)
+			u.finishRequest(req.id)
 			u.dispatcherReqCh <- req
 			// Covers waiting on the node cache and waiting on the dispatcher.
 			log.V(3).Infof("[casng] upload.digester.req.duration; start=%d, end=%d, req=%s, tag=%s", startTime.UnixNano(), time.Now().UnixNano(), req.id, req.tag)
@@ -136,6 +138,7 @@ func (u *uploader) digester(ctx context.Context) {
 		startTimeThrottle := time.Now()
 		if !u.walkThrottler.acquire(req.ctx) {
 			log.V(3).Infof("[casng] upload.digester.walk.throttle.duration; start=%d, end=%d, req=%s, tag=%s", startTimeThrottle.UnixNano(), time.Now().UnixNano(), req.id, req.tag)
+			u.finishRequest(req.id)
 			continue
 		}
 		log.V(3).Infof("[casng] upload.digester.walk.throttle.duration; start=%d, end=%d, req=%s, tag=%s", startTimeThrottle.UnixNano(), time.Now().UnixNano(), req.id, req.tag)
@@ -168,7 +171,8 @@ func (u *uploader) digest(ctx context.Context, req UploadRequest) {
 	stats := Stats{}
 	var err error
 	deferredWg := make(map[string]*sync.WaitGroup)
-	walker.DepthFirst(req.Path, req.Exclude, walker.Callback{
+	fsys := req.fileSystem()
+	walker.DepthFirstFS(fsys, req.Path, req.Exclude, walker.Callback{
 		Err: func(path impath.Absolute, realPath impath.Absolute, errVisit error) bool {
 			log.V(3).Infof("[casng] upload.digester.visit.err; path=%s, real_path=%s, err=%v, req=%s, tag=%s, walk=%s", path, realPath, errVisit, req.id, req.tag, walkID)
 			err = errors.Join(errVisit, err)
@@ -192,7 +196,7 @@ func (u *uploader) digest(ctx context.Context, req UploadRequest) {
 			// A cache hit here indicates a cyclic symlink with the same requester or multiple requesters attempting to upload the exact same path with an identical filter.
 			// In both cases, deferring is the right call. Once the request is processed, all requestters will revisit the path to get the digestion result.
 			// If the path was not cached before, claim it by marking it as in-flight.
-			key := path.String() + req.Exclude.String()
+			key := req.nodeCacheKey(path)
 			wg := &sync.WaitGroup{}
 			wg.Add(1)
 			m, ok := u.nodeCache.LoadOrStore(key, wg)
@@ -223,7 +227,8 @@ func (u *uploader) digest(ctx context.Context, req UploadRequest) {
 			// Forward it to correctly account for a cache hit or upload if the original blob is blocked elsewhere.
 			switch node := node.(type) {
 			case *repb.FileNode:
-				u.dispatcherReqCh <- UploadRequest{Path: realPath, Digest: digest.NewFromProtoUnvalidated(node.Digest), id: req.id, tag: req.tag, ctx: req.ctx, digestOnly: req.digestOnly}
+				u.countBlob(req.id)
+				u.dispatcherReqCh <- UploadRequest{Path: realPath, Digest: digest.NewFromProtoUnvalidated(node.Digest), FS: req.FS, id: req.id, tag: req.tag, ctx: req.ctx, digestOnly: req.digestOnly, Priority: req.Priority, progress: req.progress}
 			case *repb.DirectoryNode:
 				// The blob of the directory node is the bytes of a repb.Directory message.
 				// Generate and forward it. If it was uploaded before, it'll be reported as a cache hit.
@@ -233,7 +238,8 @@ func (u *uploader) digest(ctx context.Context, req UploadRequest) {
 					err = errors.Join(errDigest, err)
 					return walker.SkipPath, false
 				}
-				u.dispatcherReqCh <- UploadRequest{Bytes: b, Digest: digest.NewFromProtoUnvalidated(node.Digest), id: req.id, tag: req.tag, ctx: req.ctx, digestOnly: req.digestOnly}
+				u.countBlob(req.id)
+				u.dispatcherReqCh <- UploadRequest{Bytes: b, Digest: digest.NewFromProtoUnvalidated(node.Digest), FS: req.FS, id: req.id, tag: req.tag, ctx: req.ctx, digestOnly: req.digestOnly, Priority: req.Priority, progress: req.progress}
 			case *repb.SymlinkNode:
 				// It was already appended as a child to its parent. Nothing to forward.
 			default:
@@ -257,8 +263,8 @@ func (u *uploader) digest(ctx context.Context, req UploadRequest) {
 			default:
 			}
 
-			key := path.String() + req.Exclude.String()
-			parentKey := path.Dir().String() + req.Exclude.String()
+			key := req.nodeCacheKey(path)
+			parentKey := req.nodeCacheKey(path.Dir())
 
 			// In post-access, the cache should have this walker's own wait group.
 			// Capture it here before it's overwritten with the actual result.
@@ -286,7 +292,8 @@ func (u *uploader) digest(ctx context.Context, req UploadRequest) {
 					return false
 				}
 				u.dirChildren.append(parentKey, node)
-				u.dispatcherReqCh <- UploadRequest{Bytes: b, Digest: digest.NewFromProtoUnvalidated(node.Digest), id: req.id, tag: req.tag, ctx: req.ctx, digestOnly: req.digestOnly}
+				u.countBlob(req.id)
+				u.dispatcherReqCh <- UploadRequest{Bytes: b, Digest: digest.NewFromProtoUnvalidated(node.Digest), FS: req.FS, id: req.id, tag: req.tag, ctx: req.ctx, digestOnly: req.digestOnly, Priority: req.Priority, progress: req.progress}
 				u.nodeCache.Store(key, node)
 				log.V(3).Infof("[casng] upload.digester.visit.post.dir; path=%s, real_path=%s, digset=%v, filter_id=%s, req=%s, tag=%s, walk=%s", path, realPath, node.Digest, req.Exclude, req.id, req.tag, walkID)
 				return true
@@ -294,7 +301,7 @@ func (u *uploader) digest(ctx context.Context, req UploadRequest) {
 			case info.Mode().IsRegular():
 				stats.DigestCount++
 				stats.InputFileCount++
-				node, blb, errDigest := u.digestFile(ctx, realPath, info, req.digestOnly, req.id, req.tag, walkID)
+				node, blb, errDigest := u.digestFile(ctx, fsys, realPath, info, req.digestOnly, req.id, req.tag, walkID)
 				if errDigest != nil {
 					err = errors.Join(errDigest, err)
 					return false
@@ -306,7 +313,8 @@ func (u *uploader) digest(ctx context.Context, req UploadRequest) {
 					log.V(3).Infof("[casng] upload.digester.visit.post.file.cached; path=%s, real_path=%s, digest=%v, filter_id=%s, req=%s, tag=%s, walk=%s", path, realPath, node.Digest, req.Exclude, req.id, req.tag, walkID)
 					return true
 				}
-				u.dispatcherReqCh <- UploadRequest{Bytes: blb.b, reader: blb.r, Digest: digest.NewFromProtoUnvalidated(node.Digest), id: req.id, tag: req.tag, ctx: req.ctx, digestOnly: req.digestOnly}
+				u.countBlob(req.id)
+				u.dispatcherReqCh <- UploadRequest{Bytes: blb.b, reader: blb.r, Digest: digest.NewFromProtoUnvalidated(node.Digest), FS: req.FS, id: req.id, tag: req.tag, ctx: req.ctx, digestOnly: req.digestOnly, Priority: req.Priority, progress: req.progress}
 				log.V(3).Infof("[casng] upload.digester.visit.post.file; path=%s, real_path=%s, digest=%v, filter_id=%s, req=%s, tag=%s, walk=%s", path, realPath, node.Digest, req.Exclude, req.id, req.tag, walkID)
 				return true
 
@@ -330,8 +338,8 @@ func (u *uploader) digest(ctx context.Context, req UploadRequest) {
 			default:
 			}
 
-			key := path.String() + req.Exclude.String()
-			parentKey := path.Dir().String() + req.Exclude.String()
+			key := req.nodeCacheKey(path)
+			parentKey := req.nodeCacheKey(path.Dir())
 
 			// In symlink post-access, the cache should have this walker's own wait group.
 			// Capture it here before it's overwritten with the actual result.
@@ -350,7 +358,7 @@ func (u *uploader) digest(ctx context.Context, req UploadRequest) {
 
 			stats.DigestCount++
 			stats.InputSymlinkCount++
-			node, nextStep, errDigest := digestSymlink(req.Path, realPath, req.SymlinkOptions)
+			node, nextStep, errDigest := digestSymlink(fsys, req.Path, realPath, req.SymlinkOptions)
 			if errDigest != nil {
 				err = errors.Join(errDigest, err)
 				return walker.SkipSymlink, false
@@ -368,6 +376,8 @@ func (u *uploader) digest(ctx context.Context, req UploadRequest) {
 		},
 	})
 
+	// All the blobs of the walk were dispatched.
+	u.finishRequest(req.id)
 	// Special case: this response didn't have a corresponding blob. The dispatcher should not decrement its counter.
 	// err includes any IO errors that happened during the walk.
 	u.dispatcherResCh <- UploadResponse{endOfWalk: true, tags: []string{req.tag}, reqs: []string{req.id}, Stats: stats, Err: err}
@@ -379,7 +389,7 @@ func (u *uploader) digest(ctx context.Context, req UploadRequest) {
 // For example: if the root is /a, the symlink is b/c and the target is /a/foo, the name will be c and the target will be ../foo.
 // Note that the target includes hierarchy information, without specific names.
 // Another example: if the root is /a, the symilnk is b/c and the target is foo, the name will be c, and the target will be foo.
-func digestSymlink(root impath.Absolute, path impath.Absolute, slo slo.Options) (*repb.SymlinkNode, walker.SymlinkAction, error) {
+func digestSymlink(fsys vfs.FS, root impath.Absolute, path impath.Absolute, slo slo.Options) (*repb.SymlinkNode, walker.SymlinkAction, error) {
 	if slo.Skip() {
 		return nil, walker.SkipSymlink, nil
 	}
@@ -389,7 +399,7 @@ func digestSymlink(root impath.Absolute, path impath.Absolute, slo slo.Options)
 		return nil, walker.Replace, nil
 	}
 
-	target, err := os.Readlink(path.String())
+	target, err := fsys.Readlink(path)
 	if err != nil {
 		return nil, walker.SkipSymlink, err
 	}
@@ -413,7 +423,7 @@ func digestSymlink(root impath.Absolute, path impath.Absolute, slo slo.Options)
 	}
 
 	if slo.NoDangling() {
-		_, err := os.Lstat(target)
+		_, err := fsys.Lstat(impath.MustAbs(target))
 		if err != nil {
 			return nil, walker.SkipSymlink, err
 		}
@@ -481,7 +491,7 @@ func digestDirectory(path impath.Absolute, children []proto.Message) (*repb.Dire
 // The caller must assume ownership of that token and release it.
 //
 // If the returned err is not nil, both tokens are released before returning.
-func (u *uploader) digestFile(ctx context.Context, path impath.Absolute, info fs.FileInfo, closeLargeFile bool, reqID string, tag string, walkID string) (node *repb.FileNode, blb *blob, err error) {
+func (u *uploader) digestFile(ctx context.Context, fsys vfs.FS, path impath.Absolute, info fs.FileInfo, closeLargeFile bool, reqID string, tag string, walkID string) (node *repb.FileNode, blb *blob, err error) {
 	// Always return a clone to ensure the cached version remains owned by the cache.
 	defer func() {
 		if node != nil {
@@ -490,11 +500,12 @@ func (u *uploader) digestFile(ctx context.Context, path impath.Absolute, info fs
 	}()
 
 	// Check the cache first. If not cached or previously claimed, claim it.
+	key := path.String() + fsys.ID()
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
 	// Keep trying to claim it unless it gets cached.
 	for {
-		m, ok := u.fileNodeCache.LoadOrStore(path, wg)
+		m, ok := u.fileNodeCache.LoadOrStore(key, wg)
 		// Claimed.
 		if !ok {
 			break
@@ -511,10 +522,10 @@ func (u *uploader) digestFile(ctx context.Context, path impath.Absolute, info fs
 	defer func() {
 		// In case of an error, unclaim it.
 		if err != nil {
-			u.fileNodeCache.Delete(path)
+			u.fileNodeCache.Delete(key)
 			return
 		}
-		u.fileNodeCache.Store(path, node)
+		u.fileNodeCache.Store(key, node)
 	}()
 
 	node = &repb.FileNode{
@@ -523,7 +534,8 @@ func (u *uploader) digestFile(ctx context.Context, path impath.Absolute, info fs
 	}
 
 	// TODO: do not import filemetadata.
-	if filemetadata.XattrDigestName != "" {
+	// Extended attributes are only available on the real file system.
+	if filemetadata.XattrDigestName != "" && fsys == vfs.OS {
 		if !xattr.XATTR_SUPPORTED {
 			return nil, nil, fmt.Errorf("[casng] failed to read digest from x-attribute for %q: x-attributes are not supported by the system; req=%s, tag=%s, walk=%s", path, reqID, tag, walkID)
 		}
@@ -576,7 +588,7 @@ func (u *uploader) digestFile(ctx context.Context, path impath.Absolute, info fs
 	// Small: in-memory blob.
 	if info.Size() <= u.ioCfg.SmallFileSizeThreshold {
 		log.V(3).Infof("[casng] upload.digester.file.small; path=%s, size=%d, req=%s, tag=%s, walk=%s", path, info.Size(), reqID, tag, walkID)
-		f, err := os.Open(path.String())
+		f, err := fsys.Open(path)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -598,7 +610,16 @@ func (u *uploader) digestFile(ctx context.Context, path impath.Absolute, info fs
 	// Medium: blob with path.
 	if info.Size() < u.ioCfg.LargeFileSizeThreshold {
 		log.V(3).Infof("[casng] upload.digester.file.medium; path=%s, size=%d, req=%s, tag=%s, walk=%s", path, info.Size(), reqID, tag, walkID)
-		dg, errDigest := digest.NewFromFile(path.String())
+		f, err := fsys.Open(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer func() {
+			if errClose := f.Close(); errClose != nil {
+				err = errors.Join(errClose, err)
+			}
+		}()
+		dg, errDigest := digest.NewFromReader(f)
 		if errDigest != nil {
 			return nil, nil, errDigest
 		}
@@ -608,7 +629,7 @@ func (u *uploader) digestFile(ctx context.Context, path impath.Absolute, info fs
 
 	// Large: blob with a reader.
 	log.V(3).Infof("[casng] upload.digester.file.large; path=%s, size=%d, req=%s, tag=%s, walk=%s", path, info.Size(), reqID, tag, walkID)
-	f, err := os.Open(path.String())
+	f, err := fsys.Open(path)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -64,6 +64,15 @@ func (u *uploader) dispatcher(queryCh chan<- missingBlobRequest, queryResCh <-ch
 			log.V(3).Infof("[casng] upload.dispatcher.req; digest=%s, bytes=%d, req=%s, tag=%s", req.Digest, len(req.Bytes), req.id, req.tag)
 			// Count before sending the request to avoid an edge case where the response makes it to the counter before the increment here.
 			counterCh <- tagCount{req.tag, 1}
+			if err := req.ctx.Err(); err != nil {
+				log.V(3).Infof("[casng] upload.dispatcher.req.cancelled; digest=%s, req=%s, tag=%s", req.Digest, req.id, req.tag)
+				if req.reader != nil {
+					u.releaseIOTokens()
+				}
+				u.dispatcherResCh <- UploadResponse{Digest: req.Digest, Stats: Stats{BytesRequested: req.Digest.Size}, Err: err, tags: []string{req.tag}, reqs: []string{req.id}}
+				continue
+			}
+			req.progress.report(UploadProgressDigested, req.Digest, false, req.Digest.Size, 0)
 			if req.digestOnly {
 				u.dispatcherResCh <- UploadResponse{Digest: req.Digest, Stats: Stats{}, tags: []string{req.tag}, reqs: []string{req.id}}
 				continue
@@ -113,7 +122,7 @@ func (u *uploader) dispatcher(queryCh chan<- missingBlobRequest, queryResCh <-ch
 				if len(reqs) > 1 {
 					continue
 				}
-				queryCh <- missingBlobRequest{digest: req.Digest, ctx: req.ctx, id: req.id}
+				queryCh <- missingBlobRequest{digest: req.Digest, ctx: req.ctx, id: req.id, priority: req.Priority}
 				// Covers waiting on the query processor.
 				log.V(3).Infof("[casng] upload.dispatcher.pipe.send.duration; start=%d, end=%d, req=%s, tag=%s", startTime.UnixNano(), time.Now().UnixNano(), req.id, req.tag)
 
@@ -128,6 +137,11 @@ func (u *uploader) dispatcher(queryCh chan<- missingBlobRequest, queryResCh <-ch
 				reqs := digestReqs[r.Digest]
 				delete(digestReqs, r.Digest)
 				res := UploadResponse{Digest: r.Digest, Err: r.Err}
+				if r.Err == nil {
+					for _, req := range reqs {
+						req.progress.report(UploadProgressQueried, r.Digest, r.Missing, 0, 0)
+					}
+				}
 
 				if !r.Missing {
 					res.Stats = Stats{
@@ -188,6 +202,11 @@ func (u *uploader) dispatcher(queryCh chan<- missingBlobRequest, queryResCh <-ch
 			if log.V(3) {
 				log.Infof("[casng] upload.dispatcher.res; digest=%s, cache_hit=%d, end_of_walk=%t, err=%v, req=%s, tag=%s", r.Digest, r.Stats.CacheHitCount, r.endOfWalk, r.Err, strings.Join(r.reqs, "|"), strings.Join(r.tags, "|"))
 			}
+			if !r.endOfWalk {
+				for _, id := range r.reqs {
+					u.blobDone(id)
+				}
+			}
 			// If multiple requesters are interested in this response, ensure stats are not double-counted.
 			if len(r.tags) == 1 {
 				u.uploadPubSub.pub(r, r.tags[0])
@@ -128,6 +128,12 @@ var (
 
 	// ErrTerminatedUploader indicates an attempt to use a terminated uploader.
 	ErrTerminatedUploader = errors.New("cannot use a terminated uploader")
+
+	// ErrInputConflict indicates that a remote entry of a tree overlaps with another entry of the same tree.
+	ErrInputConflict = errors.New("conflicting inputs")
+
+	// ErrDuplicateRequestID indicates an upload request with the ID of another in-flight request.
+	ErrDuplicateRequestID = errors.New("duplicate request id")
 )
 
 // MakeWriteResourceName returns a valid resource name for writing an uncompressed blob.
@@ -184,7 +190,7 @@ type uploader struct {
 	// have a different node associated with it.
 	// However, regular files will have duplicate nodes in this cache.
 	nodeCache sync.Map
-	// fileNodeCache is similar to nodeCache, but only holds file nodes. The keys are real paths and are not unique across walks.
+	// fileNodeCache is similar to nodeCache, but only holds file nodes. The keys are real paths, suffixed by the ID of the file system, and are not unique across walks.
 	// This cache ensures that regular files are only digested once, even across walks with different exclusion filters.
 	// It also ensures that nodeCache does not have duplicate nodes for identical files.
 	// In other words, nodeCache might hold different views of the same directory node, but fileNodeCache will always hold the canonical file node for the corresponding real path.
@@ -196,6 +202,10 @@ type uploader struct {
 	queryRequestBaseSize      int
 	uploadRequestBaseSize     int
 	uploadRequestItemBaseSize int
+	// requestCancels holds the cancel functions of in-flight requests by ID. See StreamingUploader.Cancel.
+	requestCancels sync.Map
+	// requestCounts tracks the in-flight blobs of identified requests to release their IDs once all responses are delivered.
+	requestCounts requestCount
 
 	// Concurrency controls.
 	clientSenderWg   sync.WaitGroup          // Batching API producers.
@@ -224,8 +234,7 @@ type uploader struct {
 //
 // Returns nil if no node corresponds to req.
 func (u *uploader) Node(req UploadRequest) proto.Message {
-	key := req.Path.String() + req.Exclude.String()
-	n, ok := u.nodeCache.Load(key)
+	n, ok := u.nodeCache.Load(req.nodeCacheKey(req.Path))
 	if !ok {
 		return nil
 	}
@@ -243,6 +252,7 @@ func (u *uploader) Node(req UploadRequest) proto.Message {
 // ctx is used to make unified calls and terminate saturated throttlers and in-flight workers.
 // ctx must be cancelled after all batching calls have returned to properly shutdown the uploader. It is only used for cancellation (not used with remote calls).
 // gRPC timeouts are multiplied by retries. Batched RPCs are retried per batch. Streaming PRCs are retried per chunk.
+// cas and byteStream may be connected through distinct connections to keep large streams from delaying small queries and batches.
 func NewBatchingUploader(
 	ctx context.Context, cas regrpc.ContentAddressableStorageClient, byteStream bsgrpc.ByteStreamClient, instanceName string,
 	queryCfg, batchCfg, streamCfg GRPCConfig, ioCfg IOConfig,
@@ -261,6 +271,7 @@ func NewBatchingUploader(
 // ctx is used to make unified calls and terminate saturated throttlers and in-flight workers.
 // ctx must be cancelled after all response channels have been closed to properly shutdown the uploader. It is only used for cancellation (not used with remote calls).
 // gRPC timeouts are multiplied by retries. Batched RPCs are retried per batch. Streaming PRCs are retried per chunk.
+// cas and byteStream may be connected through distinct connections to keep large streams from delaying small queries and batches.
 func NewStreamingUploader(
 	ctx context.Context, cas regrpc.ContentAddressableStorageClient, byteStream bsgrpc.ByteStreamClient, instanceName string,
 	queryCfg, batchCfg, streamCfg GRPCConfig, ioCfg IOConfig,
@@ -272,6 +283,23 @@ func NewStreamingUploader(
 	return &StreamingUploader{uploader: uploader}, nil
 }
 
+// ConcurrencyLimits are the current limits of concurrent calls of the gRPC processors of an uploader.
+// They only change over time for processors configured with GRPCConfig.AdaptiveConcurrency.
+type ConcurrencyLimits struct {
+	Query  int
+	Batch  int
+	Stream int
+}
+
+// ConcurrencyLimits returns the current limits of concurrent calls of the gRPC processors.
+func (u *uploader) ConcurrencyLimits() ConcurrencyLimits {
+	return ConcurrencyLimits{
+		Query:  u.queryThrottler.currentLimit(),
+		Batch:  u.uploadThrottler.currentLimit(),
+		Stream: u.streamThrottle.currentLimit(),
+	}
+}
+
 // TODO: support uploading repb.Tree.
 // TODO: support node properties as in https://github.com/bazelbuild/remote-apis-sdks/pull/475
 func newUploader(
@@ -303,9 +331,9 @@ func newUploader(
 		batchRPCCfg:  uploadCfg,
 		streamRPCCfg: streamCfg,
 
-		queryThrottler:  newThrottler(int64(queryCfg.ConcurrentCallsLimit)),
-		uploadThrottler: newThrottler(int64(uploadCfg.ConcurrentCallsLimit)),
-		streamThrottle:  newThrottler(int64(streamCfg.ConcurrentCallsLimit)),
+		queryThrottler:  newCallThrottler(queryCfg),
+		uploadThrottler: newCallThrottler(uploadCfg),
+		streamThrottle:  newCallThrottler(streamCfg),
 
 		ioCfg: ioCfg,
 		buffers: sync.Pool{
@@ -209,10 +209,11 @@ func TestBatchReadBlobsIndividualRequestRetries(t *testing.T) {
 	regrpc.RegisterContentAddressableStorageServer(server, fake)
 	go server.Serve(listener)
 	ctx := context.Background()
+	// The fake returns blobs that do not match their test digests.
 	client, err := client.NewClient(ctx, instance, client.DialParams{
 		Service:    listener.Addr().String(),
 		NoSecurity: true,
-	}, client.StartupCapabilities(false))
+	}, client.StartupCapabilities(false), client.VerifyDownloads(false))
 	if err != nil {
 		t.Fatalf("Error connecting to server: %v", err)
 	}
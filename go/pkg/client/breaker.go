@@ -0,0 +1,70 @@
+package client
+
+import (
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/breaker"
+)
+
+// RPCFamily is a group of RPCs that are usually served by the same backend, and share a circuit
+// breaker.
+type RPCFamily string
+
+const (
+	// CASFamily groups the ContentAddressableStorage and ByteStream RPCs.
+	CASFamily RPCFamily = "CAS"
+
+	// ActionCacheFamily groups the ActionCache RPCs.
+	ActionCacheFamily RPCFamily = "ActionCache"
+
+	// ExecutionFamily groups the Execution and Operations RPCs.
+	ExecutionFamily RPCFamily = "Execution"
+)
+
+// rpcFamilies maps the RPC names passed to CallWithTimeout to their family. RPCs that are not listed
+// are not guarded by a circuit breaker.
+var rpcFamilies = map[string]RPCFamily{
+	"Read":               CASFamily,
+	"Write":              CASFamily,
+	"QueryWriteStatus":   CASFamily,
+	"FindMissingBlobs":   CASFamily,
+	"BatchUpdateBlobs":   CASFamily,
+	"BatchReadBlobs":     CASFamily,
+	"GetTree":            CASFamily,
+	"GetActionResult":    ActionCacheFamily,
+	"UpdateActionResult": ActionCacheFamily,
+	"Execute":            ExecutionFamily,
+	"WaitExecution":      ExecutionFamily,
+	"GetOperation":       ExecutionFamily,
+	"ListOperations":     ExecutionFamily,
+	"CancelOperation":    ExecutionFamily,
+	"DeleteOperation":    ExecutionFamily,
+}
+
+// CircuitBreakers enables a circuit breaker for each RPCFamily with the given configuration. Once a
+// breaker opens, calls of its family fail fast with an error wrapping breaker.ErrOpen, which is not
+// retried by RetryTransient, until the breaker lets probe calls through again.
+type CircuitBreakers breaker.Config
+
+// Apply sets up the circuit breakers of the client.
+func (cb CircuitBreakers) Apply(c *Client) {
+	c.breakers = make(map[RPCFamily]*breaker.Breaker)
+	for _, f := range []RPCFamily{CASFamily, ActionCacheFamily, ExecutionFamily} {
+		c.breakers[f] = breaker.New(string(f), breaker.Config(cb))
+	}
+}
+
+// BreakerState returns the state of the circuit breaker of the RPC family. It is always
+// breaker.Closed if circuit breakers are not enabled.
+func (c *Client) BreakerState(f RPCFamily) breaker.State {
+	if b, ok := c.breakers[f]; ok {
+		return b.State()
+	}
+	return breaker.Closed
+}
+
+// rpcBreaker returns the circuit breaker guarding the RPC, or nil.
+func (c *Client) rpcBreaker(rpcName string) *breaker.Breaker {
+	if c.breakers == nil {
+		return nil
+	}
+	return c.breakers[rpcFamilies[rpcName]]
+}
@@ -0,0 +1,55 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/breaker"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/client"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/fakes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	// Redundant imports are required for the google3 mirror. Aliases should not be changed.
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+)
+
+func TestCircuitBreakers(t *testing.T) {
+	ctx := context.Background()
+	s, err := fakes.NewServer(t)
+	if err != nil {
+		t.Fatalf("Error starting fake server: %v", err)
+	}
+	defer s.Stop()
+	c, err := s.NewTestClient(ctx)
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer c.Close()
+	client.CircuitBreakers{MinCalls: 2, OpenDuration: time.Hour}.Apply(c)
+
+	s.ActionCache.SetError(status.Error(codes.Unavailable, "cache is down"))
+	acDg := digest.NewFromBlob([]byte("action"))
+	req := &repb.GetActionResultRequest{InstanceName: c.InstanceName, ActionDigest: acDg.ToProto()}
+	// The second attempt opens the breaker, so the retrier gives up on the third one.
+	if _, err := c.GetActionResult(ctx, req); !errors.Is(err, breaker.ErrOpen) {
+		t.Errorf("GetActionResult() = %v, want an error wrapping breaker.ErrOpen", err)
+	}
+	if n := s.ActionCache.Reads(acDg); n != 2 {
+		t.Errorf("server received %d GetActionResult calls, want 2", n)
+	}
+	if got := c.BreakerState(client.ActionCacheFamily); got != breaker.Open {
+		t.Errorf("BreakerState(ActionCache) = %v, want %v", got, breaker.Open)
+	}
+
+	// Other RPC families are not affected.
+	if got := c.BreakerState(client.CASFamily); got != breaker.Closed {
+		t.Errorf("BreakerState(CAS) = %v, want %v", got, breaker.Closed)
+	}
+	if _, err := c.WriteBlob(ctx, []byte("blob")); err != nil {
+		t.Errorf("WriteBlob() failed: %v", err)
+	}
+}
@@ -71,6 +71,9 @@ func (c *Client) writeChunked(ctx context.Context, name string, ch *chunker.Chun
 			if !ch.HasNext() && !doNotFinalize {
 				req.FinishWrite = true
 			}
+			if err := c.uploadLimiter.WaitN(ctx, len(req.Data)); err != nil {
+				return err
+			}
 			err = c.CallWithTimeout(ctx, "Write", func(_ context.Context) error { return stream.Send(req) })
 			if err == io.EOF {
 				break
@@ -157,6 +160,9 @@ func (c *Client) readStreamed(ctx context.Context, name string, offset, limit in
 			return 0, err
 		}
 		log.V(3).Infof("Read: resource:%s offset:%d len(data):%d", name, offset, len(resp.Data))
+		if err := c.downloadLimiter.WaitN(ctx, len(resp.Data)); err != nil {
+			return n, err
+		}
 		nm, err := w.Write(resp.Data)
 		if err != nil {
 			// Wrapping the error to ensure it may never get retried.
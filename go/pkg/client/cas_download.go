@@ -3,7 +3,9 @@ package client
 import (
 	"bytes"
 	"context"
+	"encoding/hex"
 	"fmt"
+	"hash"
 	"io"
 	"os"
 	"path/filepath"
@@ -19,12 +21,42 @@ import (
 	log "github.com/golang/glog"
 	"github.com/klauspost/compress/zstd"
 	syncpool "github.com/mostynb/zstdpool-syncpool"
+	"github.com/pkg/errors"
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
 )
 
+// ErrDigestMismatch is returned when a downloaded blob does not match its digest. The retrier retries
+// the download once after a mismatch, in case the corruption was transient, even if its backoff policy
+// allows no further attempts. A batch download that also had non-retriable failures is not retried,
+// but its error still wraps ErrDigestMismatch.
+var ErrDigestMismatch = errors.New("downloaded blob does not match its digest")
+
+// batchFailuresError is returned by a batch download with failures that are not all retriable.
+// It wraps the ErrDigestMismatch of a corrupted blob, if any, but the batch is not retried because of it.
+type batchFailuresError struct {
+	msg      string
+	mismatch error
+}
+
+func (e *batchFailuresError) Error() string {
+	return e.msg
+}
+
+func (e *batchFailuresError) Unwrap() error {
+	return e.mismatch
+}
+
+// verifyBlob returns ErrDigestMismatch if the digest of a downloaded blob differs from the expected one.
+func verifyBlob(want, got digest.Digest) error {
+	if got != want {
+		return errors.Wrapf(ErrDigestMismatch, "blob %v has digest %v", want, got)
+	}
+	return nil
+}
+
 // DownloadFiles downloads the output files under |outDir|.
 // It returns the number of logical and real bytes downloaded, which may be different from sum
 // of sizes of the files due to dedupping and compression.
@@ -222,16 +254,23 @@ func (c *Client) BatchDownloadBlobsWithStats(ctx context.Context, dgs []digest.D
 	closure := func() error {
 		var resp *repb.BatchReadBlobsResponse
 		err := c.CallWithTimeout(ctx, "BatchReadBlobs", func(ctx context.Context) (e error) {
-			resp, e = c.cas.BatchReadBlobs(ctx, req, opts...)
+			resp, e = c.batchReadBlobs(ctx, req, opts...)
 			return e
 		})
 		if err != nil {
 			return err
 		}
+		var respSize int
+		for _, r := range resp.Responses {
+			respSize += len(r.Data)
+		}
+		if err := c.downloadLimiter.WaitN(ctx, respSize); err != nil {
+			return err
+		}
 
 		numErrs, errDg, errMsg := 0, &repb.Digest{}, ""
 		var failedDgs []*repb.Digest
-		var retriableError error
+		var retriableError, mismatchErr error
 		allRetriable := true
 		for _, r := range resp.Responses {
 			st := status.FromProto(r.Status)
@@ -265,6 +304,17 @@ func (c *Client) BatchDownloadBlobsWithStats(ctx context.Context, dgs []digest.D
 					errMsg = fmt.Sprintf("blob returned with unsupported compressor %s", r.Compressor)
 					continue
 				}
+				if c.VerifyDownloads {
+					if err := verifyBlob(digest.NewFromProtoUnvalidated(r.Digest), digest.NewFromBlob(r.Data)); err != nil {
+						failedDgs = append(failedDgs, r.Digest)
+						retriableError = err
+						mismatchErr = err
+						numErrs++
+						errDg = r.Digest
+						errMsg = err.Error()
+						continue
+					}
+				}
 				bi := CompressedBlobInfo{
 					CompressedSize: int64(CompressedSize),
 					Data:           r.Data,
@@ -277,7 +327,11 @@ func (c *Client) BatchDownloadBlobsWithStats(ctx context.Context, dgs []digest.D
 			if allRetriable {
 				return retriableError // Retriable errors only, retry the failed digests.
 			}
-			return fmt.Errorf("downloading blobs as part of a batch resulted in %d failures, including blob %s: %s", numErrs, errDg, errMsg)
+			msg := fmt.Sprintf("downloading blobs as part of a batch resulted in %d failures, including blob %s: %s", numErrs, errDg, errMsg)
+			if mismatchErr != nil {
+				msg = fmt.Sprintf("%s; %v", msg, mismatchErr)
+			}
+			return &batchFailuresError{msg: msg, mismatch: mismatchErr}
 		}
 		return nil
 	}
@@ -371,9 +425,11 @@ func (c *Client) readBlobStreamed(ctx context.Context, d digest.Digest, offset,
 	if limit > 0 && limit < sz {
 		sz = limit
 	}
-	wt := newWriteTracker(w)
+	// Only full reads can be verified, since the digest covers the whole blob.
+	verify := bool(c.VerifyDownloads) && d.Size == sz
+	wt := newWriteTracker(w, verify)
 	defer func() { stats.LogicalMoved = wt.n }()
-	closure := func() (err error) {
+	read := func() (err error) {
 		name, wc, done, e := c.maybeCompressReadBlob(d, wt)
 		if e != nil {
 			return e
@@ -403,30 +459,30 @@ func (c *Client) readBlobStreamed(ctx context.Context, d digest.Digest, offset,
 		}
 		return nil
 	}
-	// Only retry on transient backend issues.
+	var mismatch error
+	closure := func() error {
+		if mismatch != nil {
+			// Start over after a digest mismatch, unless the data already written cannot be discarded.
+			if err := wt.reset(); err != nil {
+				return mismatch
+			}
+		}
+		if err := read(); err != nil {
+			return err
+		}
+		if !verify || wt.n != sz {
+			return nil
+		}
+		mismatch = verifyBlob(d, wt.digest())
+		return mismatch
+	}
+	// Only retry on transient backend issues and digest mismatches.
 	if err := c.Retrier.Do(ctx, closure); err != nil {
 		return stats, err
 	}
 	if wt.n != sz {
 		return stats, fmt.Errorf("partial read of digest %s returned %d bytes, expected %d bytes", d, wt.n, sz)
 	}
-
-	// Incomplete reads only, since we can't reliably calculate hash without the full blob
-	if d.Size == sz {
-		// Signal for writeTracker to take the digest of the data.
-		if err := wt.Close(); err != nil {
-			return stats, err
-		}
-		// Wait for the digest to be ready.
-		if err := <-wt.ready; err != nil {
-			return stats, err
-		}
-		close(wt.ready)
-		if wt.dg != d {
-			return stats, fmt.Errorf("calculated digest %s != expected digest %s", wt.dg, d)
-		}
-	}
-
 	return stats, nil
 }
 
@@ -510,6 +566,9 @@ func (c *Client) FlattenActionOutputs(ctx context.Context, ar *repb.ActionResult
 // It returns the number of logical and real bytes downloaded, which may be different from sum
 // of sizes of the files due to dedupping and compression.
 func (c *Client) DownloadActionOutputs(ctx context.Context, resPb *repb.ActionResult, outDir string, cache filemetadata.Cache) (*MovedBytesMetadata, error) {
+	if c.StreamingTreeDownloads {
+		return c.downloadActionOutputsStreamed(ctx, resPb, outDir, cache)
+	}
 	outs, err := c.FlattenActionOutputs(ctx, resPb)
 	if err != nil {
 		return nil, err
@@ -573,52 +632,59 @@ func NewCompressedWriteBuffer(w io.Writer) (io.WriteCloser, chan error, error) {
 // writerTracker is useful as an midware before writing to a Read caller's
 // underlying data. Since cas.go should be responsible for sanity checking data,
 // and potentially having to re-open files on disk to do a checking earlier
-// on the call stack, we dup the writes through a digest creator and track
+// on the call stack, we dup the writes through a hasher and track
 // how much data was written.
 type writerTracker struct {
-	w  io.Writer
-	pw *io.PipeWriter
-	dg digest.Digest
+	w io.Writer
+	// h is nil if the data is not verified.
+	h hash.Hash
 	// Tracked independently of the digest as we might want to retry
 	// on partial reads.
-	n     int64
-	ready chan error
+	n int64
 }
 
-func newWriteTracker(w io.Writer) *writerTracker {
-	pr, pw := io.Pipe()
-	wt := &writerTracker{
-		pw:    pw,
-		w:     w,
-		ready: make(chan error, 1),
-		n:     0,
+func newWriteTracker(w io.Writer, verify bool) *writerTracker {
+	wt := &writerTracker{w: w}
+	if verify {
+		wt.h = digest.HashFn.New()
 	}
-
-	go func() {
-		var err error
-		wt.dg, err = digest.NewFromReader(pr)
-		wt.ready <- err
-	}()
-
 	return wt
 }
 
 func (wt *writerTracker) Write(p []byte) (int, error) {
-	// Any error on this write will be reflected on the
-	// pipe reader end when trying to calculate the digest.
-	// Additionally, if we are not downloading the entire
-	// blob, we can't even verify the digest to begin with.
-	// So we can ignore errors on this pipewriter.
-	wt.pw.Write(p)
 	n, err := wt.w.Write(p)
+	if wt.h != nil {
+		wt.h.Write(p[:n])
+	}
 	wt.n += int64(n)
 	return n, err
 }
 
-// Close closes the pipe - which triggers the end of the
-// digest creation.
-func (wt *writerTracker) Close() error {
-	return wt.pw.Close()
+// digest returns the digest of the data written so far.
+func (wt *writerTracker) digest() digest.Digest {
+	return digest.Digest{Hash: hex.EncodeToString(wt.h.Sum(nil)), Size: wt.n}
+}
+
+// reset discards the data written so far, if the underlying writer supports it.
+func (wt *writerTracker) reset() error {
+	switch w := wt.w.(type) {
+	case *bytes.Buffer:
+		w.Reset()
+	case *os.File:
+		if err := w.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := w.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("cannot discard the data written to %T", wt.w)
+	}
+	if wt.h != nil {
+		wt.h.Reset()
+	}
+	wt.n = 0
+	return nil
 }
 
 type downloadRequest struct {
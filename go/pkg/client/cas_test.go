@@ -15,11 +15,13 @@ import (
 	"testing"
 	"time"
 
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/bandwidth"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/client"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/fakes"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/filemetadata"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/portpicker"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/retry"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/uploadinfo"
 	"github.com/google/go-cmp/cmp"
 	"golang.org/x/sync/errgroup"
@@ -1914,3 +1916,156 @@ func TestBatchDownloadBlobsCompressed(t *testing.T) {
 		t.Errorf("client.BatchDownloadBlobs(ctx, digests) had diff (want -> got):\n%s", diff)
 	}
 }
+
+func TestBandwidthLimits(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	for _, batch := range []bool{false, true} {
+		batch := batch
+		t.Run(fmt.Sprintf("batch=%v", batch), func(t *testing.T) {
+			t.Parallel()
+			s, err := fakes.NewServer(t)
+			if err != nil {
+				t.Fatalf("Error starting fake server: %v", err)
+			}
+			defer s.Stop()
+			limits := client.BandwidthLimits{
+				Upload:   bandwidth.NewLimiter(1000, 100),
+				Download: bandwidth.NewLimiter(1000, 100),
+			}
+			c, err := client.NewClient(ctx, instance, client.DialParams{Service: s.Target(), NoSecurity: true}, limits, client.UseBatchOps(batch))
+			if err != nil {
+				t.Fatalf("Error creating client: %v", err)
+			}
+			defer c.Close()
+
+			// The first 100 bytes are the burst, the remaining 200 take 200ms in each direction.
+			blob := bytes.Repeat([]byte("a"), 300)
+			start := time.Now()
+			dg, err := c.WriteBlob(ctx, blob)
+			if err != nil {
+				t.Fatalf("WriteBlob() failed: %v", err)
+			}
+			if got := time.Since(start); got < 180*time.Millisecond {
+				t.Errorf("WriteBlob() took %v, want at least 200ms", got)
+			}
+			start = time.Now()
+			if _, _, err := c.ReadBlob(ctx, dg); err != nil {
+				t.Fatalf("ReadBlob() failed: %v", err)
+			}
+			if got := time.Since(start); got < 180*time.Millisecond {
+				t.Errorf("ReadBlob() took %v, want at least 200ms", got)
+			}
+
+			// Lifting the limits at runtime takes effect immediately.
+			c.BandwidthLimits().Upload.SetLimit(0, 0)
+			c.BandwidthLimits().Download.SetLimit(0, 0)
+			start = time.Now()
+			if _, err := c.WriteBlob(ctx, bytes.Repeat([]byte("b"), 300)); err != nil {
+				t.Fatalf("WriteBlob() failed: %v", err)
+			}
+			if _, _, err := c.ReadBlob(ctx, dg); err != nil {
+				t.Fatalf("ReadBlob() failed: %v", err)
+			}
+			if got := time.Since(start); got > 150*time.Millisecond {
+				t.Errorf("unlimited WriteBlob() and ReadBlob() took %v", got)
+			}
+		})
+	}
+}
+
+func TestVerifyDownloads(t *testing.T) {
+	t.Parallel()
+	blob := []byte("verified")
+	reads := map[string]func(ctx context.Context, c *client.Client, dg digest.Digest) ([]byte, error){
+		"ReadBlob": func(ctx context.Context, c *client.Client, dg digest.Digest) ([]byte, error) {
+			b, _, err := c.ReadBlob(ctx, dg)
+			return b, err
+		},
+		"ReadBlobCompressed": func(ctx context.Context, c *client.Client, dg digest.Digest) ([]byte, error) {
+			client.CompressedBytestreamThreshold(0).Apply(c)
+			b, _, err := c.ReadBlob(ctx, dg)
+			return b, err
+		},
+		"ReadBlobToFile": func(ctx context.Context, c *client.Client, dg digest.Digest) ([]byte, error) {
+			path := filepath.Join(t.TempDir(), "blob")
+			if _, err := c.ReadBlobToFile(ctx, dg, path); err != nil {
+				return nil, err
+			}
+			return os.ReadFile(path)
+		},
+		"BatchDownloadBlobs": func(ctx context.Context, c *client.Client, dg digest.Digest) ([]byte, error) {
+			client.UseBatchCompression(true).Apply(c)
+			bs, err := c.BatchDownloadBlobs(ctx, []digest.Digest{dg})
+			return bs[dg], err
+		},
+	}
+	tests := []struct {
+		name        string
+		corruptions int
+		verify      bool
+		attempts    retry.Attempts
+		wantErr     bool
+		wantReads   int
+	}{
+		{name: "intact", corruptions: 0, verify: true, attempts: 6, wantReads: 1},
+		{name: "corrupted once", corruptions: 1, verify: true, attempts: 6, wantReads: 2},
+		{name: "corrupted once without retries", corruptions: 1, verify: true, attempts: 1, wantReads: 2},
+		{name: "corrupted twice", corruptions: 2, verify: true, attempts: 6, wantErr: true, wantReads: 2},
+		{name: "unverified", corruptions: 1, verify: false, attempts: 6, wantReads: 1},
+	}
+	for name, read := range reads {
+		for _, tc := range tests {
+			name, read, tc := name, read, tc
+			t.Run(name+"/"+tc.name, func(t *testing.T) {
+				t.Parallel()
+				ctx := context.Background()
+				e, cleanup := fakes.NewTestEnv(t)
+				defer cleanup()
+				c := e.Client.GrpcClient
+				(&client.Retrier{Backoff: retry.Immediately(tc.attempts), ShouldRetry: retry.TransientOnly}).Apply(c)
+				client.VerifyDownloads(tc.verify).Apply(c)
+				dg := e.Server.CAS.Put(blob)
+				e.Server.CAS.Corrupt(dg, tc.corruptions)
+
+				got, err := read(ctx, c, dg)
+				if tc.wantErr {
+					if !errors.Is(err, client.ErrDigestMismatch) {
+						t.Errorf("read gave error %v, want %v", err, client.ErrDigestMismatch)
+					}
+				} else {
+					if err != nil {
+						t.Fatalf("read failed: %v", err)
+					}
+					if tc.verify && !bytes.Equal(got, blob) {
+						t.Errorf("read gave %q, want %q", got, blob)
+					}
+				}
+				if n := e.Server.CAS.BlobReads(dg); n != tc.wantReads {
+					t.Errorf("read made %d reads, want %d", n, tc.wantReads)
+				}
+			})
+		}
+	}
+}
+
+func TestVerifyDownloadsBatchFailures(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	c := e.Client.GrpcClient
+	(&client.Retrier{Backoff: retry.Immediately(retry.Attempts(6)), ShouldRetry: retry.TransientOnly}).Apply(c)
+	dg := e.Server.CAS.Put([]byte("corrupted"))
+	e.Server.CAS.Corrupt(dg, 1)
+	missing := digest.NewFromBlob([]byte("missing"))
+
+	_, err := c.BatchDownloadBlobs(ctx, []digest.Digest{dg, missing})
+	if !errors.Is(err, client.ErrDigestMismatch) {
+		t.Errorf("BatchDownloadBlobs() gave error %v, want %v", err, client.ErrDigestMismatch)
+	}
+	// The blob that is not found cannot be fixed by a retry, so the batch is not retried.
+	if n := e.Server.CAS.BlobReads(dg); n != 1 {
+		t.Errorf("BatchDownloadBlobs() made %d reads, want 1", n)
+	}
+}
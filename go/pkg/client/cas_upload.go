@@ -156,6 +156,13 @@ func (c *Client) BatchWriteBlobs(ctx context.Context, blobs map[digest.Digest][]
 	}
 	opts := c.RPCOpts()
 	closure := func() error {
+		var reqSize int
+		for _, r := range reqs {
+			reqSize += len(r.Data)
+		}
+		if err := c.uploadLimiter.WaitN(ctx, reqSize); err != nil {
+			return err
+		}
 		var resp *repb.BatchUpdateBlobsResponse
 		err := c.CallWithTimeout(ctx, "BatchUpdateBlobs", func(ctx context.Context) (e error) {
 			resp, e = c.cas.BatchUpdateBlobs(ctx, &repb.BatchUpdateBlobsRequest{
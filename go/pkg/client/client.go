@@ -5,6 +5,7 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	stderrors "errors"
 	"fmt"
 	"math"
 	"net/http"
@@ -16,9 +17,12 @@ import (
 
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/actas"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/balancer"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/bandwidth"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/breaker"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/casng"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/chunker"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/recorder"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/retry"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/uploadinfo"
 	"github.com/pkg/errors"
@@ -26,6 +30,7 @@ import (
 	"golang.org/x/sync/semaphore"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/local"
 	"google.golang.org/grpc/credentials/oauth"
 	"google.golang.org/grpc/status"
 
@@ -134,8 +139,13 @@ type Client struct {
 	Retrier       *Retrier
 	Connection    *grpc.ClientConn
 	CASConnection *grpc.ClientConn // Can be different from Connection a separate CAS endpoint is provided.
+	// ByteStreamConnection is used for ByteStream calls. It is the CASConnection, unless
+	// DialParams.SeparateByteStreamConnections was set.
+	ByteStreamConnection *grpc.ClientConn
 	// StartupCapabilities denotes whether to load ServerCapabilities on startup.
 	StartupCapabilities StartupCapabilities
+	// VerifyDownloads denotes whether downloaded blobs are checked against their digests.
+	VerifyDownloads VerifyDownloads
 	// LegacyExecRootRelativeOutputs denotes whether outputs are relative to the exec root.
 	LegacyExecRootRelativeOutputs LegacyExecRootRelativeOutputs
 	// ChunkMaxSize is maximum chunk size to use for CAS uploads/downloads.
@@ -174,6 +184,10 @@ type Client struct {
 	UnifiedDownloadTickDuration UnifiedDownloadTickDuration
 	// TreeSymlinkOpts controls how symlinks are handled when constructing a tree.
 	TreeSymlinkOpts *TreeSymlinkOpts
+	// MerkleTreeCache specifies whether ComputeMerkleTree reuses unchanged subtrees across calls.
+	MerkleTreeCache MerkleTreeCache
+	// StreamingTreeDownloads specifies whether output directories are downloaded by streaming their trees.
+	StreamingTreeDownloads StreamingTreeDownloads
 
 	serverCaps          *repb.ServerCapabilities
 	useBatchOps         UseBatchOps
@@ -188,6 +202,13 @@ type Client struct {
 	uploadOnce          sync.Once
 	downloadOnce        sync.Once
 	useBatchCompression UseBatchCompression
+	breakers            map[RPCFamily]*breaker.Breaker
+	hedger              *hedger
+	uploadLimiter       *bandwidth.Limiter
+	downloadLimiter     *bandwidth.Limiter
+	casNgAdaptive       bool
+	treeCache           *merkleTreeCache
+	recorder            *recorder.Recorder
 }
 
 const (
@@ -212,19 +233,23 @@ const (
 	DefaultRegularMode = 0644
 )
 
-// Close closes the underlying gRPC connection(s).
+// Close closes the underlying gRPC connection(s), and the recorder of DialParams, if any.
 func (c *Client) Close() error {
 	// Close the channels & stop background operations.
 	UnifiedUploads(false).Apply(c)
 	UnifiedDownloads(false).Apply(c)
-	err := c.Connection.Close()
-	if err != nil {
-		return err
+	// Every distinct connection is closed, even if closing another one failed.
+	errs := []error{c.Connection.Close()}
+	if c.ByteStreamConnection != nil && c.ByteStreamConnection != c.CASConnection && c.ByteStreamConnection != c.Connection {
+		errs = append(errs, c.ByteStreamConnection.Close())
+	}
+	if c.CASConnection != nil && c.CASConnection != c.Connection {
+		errs = append(errs, c.CASConnection.Close())
 	}
-	if c.CASConnection != c.Connection {
-		return c.CASConnection.Close()
+	if c.recorder != nil {
+		errs = append(errs, c.recorder.Close())
 	}
-	return nil
+	return stderrors.Join(errs...)
 }
 
 // Opt is an option that can be passed to Dial in order to configure the behaviour of the client.
@@ -399,6 +424,26 @@ func (u UseBatchCompression) Apply(c *Client) {
 	c.useBatchCompression = u
 }
 
+// BandwidthLimits limits the rate of the bytes uploaded to and downloaded from the CAS, for both
+// ByteStream and batch calls. The limiters may be shared with other clients, and their limits may
+// be adjusted at any time. A nil limiter does not limit its direction.
+type BandwidthLimits struct {
+	Upload   *bandwidth.Limiter
+	Download *bandwidth.Limiter
+}
+
+// Apply sets the bandwidth limiters of a client.
+func (b BandwidthLimits) Apply(c *Client) {
+	c.uploadLimiter = b.Upload
+	c.downloadLimiter = b.Download
+}
+
+// BandwidthLimits returns the bandwidth limiters of the client, e.g. to adjust their limits at
+// runtime.
+func (c *Client) BandwidthLimits() BandwidthLimits {
+	return BandwidthLimits{Upload: c.uploadLimiter, Download: c.downloadLimiter}
+}
+
 // CASConcurrency is the number of simultaneous requests that will be issued for CAS upload and
 // download operations.
 type CASConcurrency int
@@ -421,6 +466,16 @@ func (cy CASConcurrency) Apply(c *Client) {
 	c.casDownloaders = semaphore.NewWeighted(c.casConcurrency)
 }
 
+// VerifyDownloads controls whether the client hashes downloaded blobs as they are written, after
+// decompression, and fails with ErrDigestMismatch if they do not match their digests. It is on by default.
+// Only full reads are verified.
+type VerifyDownloads bool
+
+// Apply sets the VerifyDownloads flag on a client.
+func (v VerifyDownloads) Apply(c *Client) {
+	c.VerifyDownloads = v
+}
+
 // StartupCapabilities controls whether the client should attempt to fetch the remote
 // server capabilities on New. If set to true, some configuration such as MaxBatchSize
 // is set according to the remote server capabilities instead of using the provided values.
@@ -458,6 +513,15 @@ func (o UseCASNG) Apply(c *Client) {
 	c.useCasNg = bool(o)
 }
 
+// CASNGAdaptiveConcurrency makes the casng uploader adjust the number of its concurrent calls up to
+// CASConcurrency based on the latency of the calls and the throttling errors of the server.
+type CASNGAdaptiveConcurrency bool
+
+// Apply sets the adaptive concurrency of the casng uploader of the Client.
+func (o CASNGAdaptiveConcurrency) Apply(c *Client) {
+	c.casNgAdaptive = bool(o)
+}
+
 func getImpersonatedRPCCreds(ctx context.Context, actAs string, cred credentials.PerRPCCredentials) credentials.PerRPCCredentials {
 	// Wrap in a ReuseTokenSource to cache valid tokens in memory (i.e., non-nil, with a non-expired
 	// access token).
@@ -485,11 +549,13 @@ func getRPCCreds(ctx context.Context, credFile string, useApplicationDefault boo
 
 // DialParams contains all the parameters that Dial needs.
 type DialParams struct {
-	// Service contains the address of remote execution service.
+	// Service contains the address of remote execution service. Besides host:port addresses, Unix
+	// domain sockets may be given as "unix:///path/to/socket" or "unix-abstract:name". Connections to
+	// sockets use local transport credentials instead of TLS unless TLS is configured explicitly.
 	Service string
 
 	// CASService contains the address of the CAS service, if it is separate from
-	// the remote execution service.
+	// the remote execution service. It accepts the same forms as Service.
 	CASService string
 
 	// UseApplicationDefault indicates that the default credentials should be used.
@@ -534,12 +600,32 @@ type DialParams struct {
 	// DialOpts defines the set of gRPC DialOptions to apply, in addition to any used internally.
 	DialOpts []grpc.DialOption
 
+	// Recorder, if set, records the gRPC traffic of the connections. NewClient hands it to the client,
+	// which closes it in Close, or closes it right away if the client cannot be created.
+	Recorder *recorder.Recorder
+
 	// MaxConcurrentRequests specifies the maximum number of concurrent RPCs on a single connection.
 	MaxConcurrentRequests uint32
 
 	// MaxConcurrentStreams specifies the maximum number of concurrent stream RPCs on a single connection.
 	MaxConcurrentStreams uint32
 
+	// ConnectionPool selects the pool balancer with the given configuration instead of the default
+	// GCP balancer. The pool balancer picks the connection with the fewest calls in flight, and
+	// replaces connections that keep failing. If unset, MaxStreams defaults to MaxConcurrentStreams,
+	// and Name to the dialed endpoint.
+	ConnectionPool *balancer.PoolConfig
+
+	// SeparateByteStreamConnections dials a distinct set of connections to the CAS service for
+	// ByteStream calls, so that large uploads and downloads do not stall FindMissingBlobs,
+	// GetActionResult and other small unary calls queued behind them on the same connections.
+	// It is ignored for local sockets.
+	SeparateByteStreamConnections bool
+
+	// MaxConcurrentByteStreams specifies the maximum number of concurrent ByteStream calls on a single
+	// connection when SeparateByteStreamConnections is set. Defaults to MaxConcurrentStreams.
+	MaxConcurrentByteStreams uint32
+
 	// TLSClientAuthCert specifies the public key in PEM format for using mTLS auth to connect to the RBE service.
 	//
 	// If this is specified, TLSClientAuthKey must also be specified.
@@ -604,12 +690,36 @@ func createTLSConfig(params DialParams) (*tls.Config, error) {
 	return c, nil
 }
 
+// IsLocalSocket returns whether the endpoint is a Unix domain socket, i.e. uses the "unix" or
+// "unix-abstract" gRPC name resolver, e.g. "unix:///tmp/proxy.sock" or "unix-abstract:proxy".
+func IsLocalSocket(endpoint string) bool {
+	return strings.HasPrefix(endpoint, "unix:") || strings.HasPrefix(endpoint, "unix-abstract:")
+}
+
+// transportCredentials returns the transport credentials to secure a connection to the endpoint.
+func transportCredentials(endpoint string, params DialParams) (credentials.TransportCredentials, error) {
+	// The peer of a local socket is authenticated by the file system permissions of the socket, and
+	// rarely has a certificate. Local credentials still allow per-RPC credentials to be sent. TLS is
+	// only used if it was configured explicitly.
+	if IsLocalSocket(endpoint) && params.TLSCACertFile == "" && params.TLSClientAuthCert == "" && params.TLSServerName == "" {
+		return local.NewCredentials(), nil
+	}
+	tlsConfig, err := createTLSConfig(params)
+	if err != nil {
+		return nil, fmt.Errorf("could not create TLS config: %v", err)
+	}
+	return credentials.NewTLS(tlsConfig), nil
+}
+
 // Dial dials a given endpoint and returns the grpc connection that is established.
 func Dial(ctx context.Context, endpoint string, params DialParams) (*grpc.ClientConn, AuthType, error) {
 	var authUsed AuthType
 
 	var opts []grpc.DialOption
 	opts = append(opts, params.DialOpts...)
+	if params.Recorder != nil {
+		opts = append(opts, params.Recorder.DialOptions()...)
+	}
 
 	if params.MaxConcurrentRequests == 0 {
 		params.MaxConcurrentRequests = DefaultMaxConcurrentRequests
@@ -623,11 +733,11 @@ func Dial(ctx context.Context, endpoint string, params DialParams) (*grpc.Client
 	} else if params.NoAuth {
 		authUsed = NoAuth
 		// Set the ServerName and RootCAs fields, if needed.
-		tlsConfig, err := createTLSConfig(params)
+		creds, err := transportCredentials(endpoint, params)
 		if err != nil {
-			return nil, authUsed, fmt.Errorf("could not create TLS config: %v", err)
+			return nil, authUsed, err
 		}
-		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+		opts = append(opts, grpc.WithTransportCredentials(creds))
 	} else if params.UseExternalAuthToken {
 		authUsed = ExternalTokenAuth
 		if params.ExternalPerRPCCreds == nil {
@@ -635,11 +745,11 @@ func Dial(ctx context.Context, endpoint string, params DialParams) (*grpc.Client
 		}
 		opts = append(opts, grpc.WithPerRPCCredentials(params.ExternalPerRPCCreds.Creds))
 		// Set the ServerName and RootCAs fields, if needed.
-		tlsConfig, err := createTLSConfig(params)
+		creds, err := transportCredentials(endpoint, params)
 		if err != nil {
-			return nil, authUsed, fmt.Errorf("could not create TLS config: %v", err)
+			return nil, authUsed, err
 		}
-		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+		opts = append(opts, grpc.WithTransportCredentials(creds))
 	} else {
 		credFile := params.CredFile
 		if strings.Contains(credFile, HomeDirMacro) {
@@ -667,17 +777,32 @@ func Dial(ctx context.Context, endpoint string, params DialParams) (*grpc.Client
 
 			opts = append(opts, grpc.WithPerRPCCredentials(rpcCreds))
 		}
-		tlsConfig, err := createTLSConfig(params)
+		creds, err := transportCredentials(endpoint, params)
 		if err != nil {
-			return nil, authUsed, fmt.Errorf("could not create TLS config: %v", err)
+			return nil, authUsed, err
 		}
-		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+		opts = append(opts, grpc.WithTransportCredentials(creds))
 	}
-	grpcInt := createGRPCInterceptor(params)
 	opts = append(opts, grpc.WithDisableServiceConfig())
-	opts = append(opts, grpc.WithDefaultServiceConfig(fmt.Sprintf(`{"loadBalancingConfig": [{"%s":{}}]}`, balancer.Name)))
-	opts = append(opts, grpc.WithUnaryInterceptor(grpcInt.GCPUnaryClientInterceptor))
-	opts = append(opts, grpc.WithStreamInterceptor(grpcInt.GCPStreamClientInterceptor))
+	switch {
+	case IsLocalSocket(endpoint):
+		// A local socket has a single peer, so there is nothing to balance, and a pool of connections
+		// to it would only waste file descriptors.
+	case params.ConnectionPool != nil:
+		cfg := *params.ConnectionPool
+		if cfg.MaxStreams == 0 {
+			cfg.MaxStreams = int(params.MaxConcurrentStreams)
+		}
+		if cfg.Name == "" {
+			cfg.Name = endpoint
+		}
+		opts = append(opts, grpc.WithDefaultServiceConfig(balancer.PoolServiceConfig(cfg)))
+	default:
+		grpcInt := createGRPCInterceptor(params)
+		opts = append(opts, grpc.WithDefaultServiceConfig(fmt.Sprintf(`{"loadBalancingConfig": [{"%s":{}}]}`, balancer.Name)))
+		opts = append(opts, grpc.WithUnaryInterceptor(grpcInt.GCPUnaryClientInterceptor))
+		opts = append(opts, grpc.WithStreamInterceptor(grpcInt.GCPStreamClientInterceptor))
+	}
 
 	conn, err := grpc.Dial(endpoint, opts...)
 	if err != nil {
@@ -698,7 +823,14 @@ func DialRaw(ctx context.Context, params DialParams) (*grpc.ClientConn, AuthType
 
 // NewClient connects to a remote execution service and returns a client suitable for higher-level
 // functionality.
-func NewClient(ctx context.Context, instanceName string, params DialParams, opts ...Opt) (*Client, error) {
+func NewClient(ctx context.Context, instanceName string, params DialParams, opts ...Opt) (_ *Client, err error) {
+	if params.Recorder != nil {
+		defer func() {
+			if err != nil {
+				params.Recorder.Close()
+			}
+		}()
+	}
 	if instanceName == "" {
 		log.Warning("Instance name was not specified.")
 	}
@@ -716,15 +848,56 @@ func NewClient(ctx context.Context, instanceName string, params DialParams, opts
 	if err != nil {
 		return nil, &InitError{Err: statusWrap(err), AuthUsed: authUsed}
 	}
-	client, err := NewClientFromConnection(ctx, instanceName, conn, casConn, opts...)
+	bsConn := casConn
+	casEndpoint := params.Service
+	if params.CASService != "" {
+		casEndpoint = params.CASService
+	}
+	if params.SeparateByteStreamConnections && !IsLocalSocket(casEndpoint) {
+		log.Infof("Connecting to CAS service %s for ByteStream calls", casEndpoint)
+		bsConn, authUsed, err = Dial(ctx, casEndpoint, byteStreamDialParams(casEndpoint, params))
+		if err != nil {
+			return nil, &InitError{Err: statusWrap(err), AuthUsed: authUsed}
+		}
+	}
+	client, err := newClientFromConnections(ctx, instanceName, conn, casConn, bsConn, opts...)
 	if err != nil {
 		return nil, &InitError{Err: err, AuthUsed: authUsed}
 	}
+	client.recorder = params.Recorder
 	return client, nil
 }
 
+// byteStreamDialParams returns the parameters to dial the connections to the endpoint dedicated to
+// ByteStream calls.
+func byteStreamDialParams(endpoint string, params DialParams) DialParams {
+	if params.MaxConcurrentStreams == 0 {
+		params.MaxConcurrentStreams = DefaultMaxConcurrentStreams
+	}
+	if params.MaxConcurrentByteStreams != 0 {
+		params.MaxConcurrentStreams = params.MaxConcurrentByteStreams
+	}
+	if params.ConnectionPool != nil {
+		cfg := *params.ConnectionPool
+		if cfg.Name == "" {
+			cfg.Name = endpoint
+		}
+		// The pools are distinct, so are their metrics.
+		cfg.Name += "/bytestream"
+		cfg.MaxStreams = int(params.MaxConcurrentStreams)
+		params.ConnectionPool = &cfg
+	}
+	return params
+}
+
 // NewClientFromConnection creates a client from gRPC connections to a remote execution service and a cas service.
 func NewClientFromConnection(ctx context.Context, instanceName string, conn, casConn *grpc.ClientConn, opts ...Opt) (*Client, error) {
+	return newClientFromConnections(ctx, instanceName, conn, casConn, casConn, opts...)
+}
+
+// newClientFromConnections creates a client that makes ByteStream calls on bsConn, and other CAS and
+// action cache calls on casConn.
+func newClientFromConnections(ctx context.Context, instanceName string, conn, casConn, bsConn *grpc.ClientConn, opts ...Opt) (*Client, error) {
 	if conn == nil {
 		return nil, fmt.Errorf("connection to remote execution service may not be nil")
 	}
@@ -734,13 +907,14 @@ func NewClientFromConnection(ctx context.Context, instanceName string, conn, cas
 	client := &Client{
 		InstanceName:                  instanceName,
 		actionCache:                   regrpc.NewActionCacheClient(casConn),
-		byteStream:                    bsgrpc.NewByteStreamClient(casConn),
+		byteStream:                    bsgrpc.NewByteStreamClient(bsConn),
 		cas:                           regrpc.NewContentAddressableStorageClient(casConn),
 		execution:                     regrpc.NewExecutionClient(conn),
 		operations:                    opgrpc.NewOperationsClient(conn),
 		rpcTimeouts:                   DefaultRPCTimeouts,
 		Connection:                    conn,
 		CASConnection:                 casConn,
+		ByteStreamConnection:          bsConn,
 		CompressedBytestreamThreshold: DefaultCompressedBytestreamThreshold,
 		ChunkMaxSize:                  chunker.DefaultChunkSize,
 		MaxBatchDigests:               DefaultMaxBatchDigests,
@@ -751,6 +925,7 @@ func NewClientFromConnection(ctx context.Context, instanceName string, conn, cas
 		RegularMode:                   DefaultRegularMode,
 		useBatchOps:                   true,
 		StartupCapabilities:           true,
+		VerifyDownloads:               true,
 		LegacyExecRootRelativeOutputs: false,
 		casConcurrency:                DefaultCASConcurrency,
 		casUploaders:                  semaphore.NewWeighted(DefaultCASConcurrency),
@@ -777,6 +952,7 @@ func NewClientFromConnection(ctx context.Context, instanceName string, conn, cas
 	if client.useCasNg {
 		queryCfg := casng.GRPCConfig{
 			ConcurrentCallsLimit: int(client.casConcurrency),
+			AdaptiveConcurrency:  client.casNgAdaptive,
 			BytesLimit:           int(client.MaxBatchSize),
 			ItemsLimit:           int(client.MaxQueryBatchDigests),
 			BundleTimeout:        10 * time.Millisecond, // Low value to fast track queries.
@@ -787,6 +963,7 @@ func NewClientFromConnection(ctx context.Context, instanceName string, conn, cas
 		}
 		batchCfg := casng.GRPCConfig{
 			ConcurrentCallsLimit: int(client.casConcurrency),
+			AdaptiveConcurrency:  client.casNgAdaptive,
 			BytesLimit:           int(client.MaxBatchSize),
 			ItemsLimit:           int(client.UnifiedUploadBufferSize),
 			BundleTimeout:        time.Duration(client.UnifiedUploadTickDuration), // Low value to fast track queries.
@@ -796,6 +973,7 @@ func NewClientFromConnection(ctx context.Context, instanceName string, conn, cas
 		}
 		streamCfg := casng.GRPCConfig{
 			ConcurrentCallsLimit: int(client.casConcurrency),
+			AdaptiveConcurrency:  client.casNgAdaptive,
 			BytesLimit:           1,                // Unused.
 			ItemsLimit:           1,                // Unused.
 			BundleTimeout:        time.Millisecond, // Unused.
@@ -811,6 +989,7 @@ func NewClientFromConnection(ctx context.Context, instanceName string, conn, cas
 			LargeFileSizeThreshold:   casng.DefaultLargeFileSizeThreshold,
 			CompressionSizeThreshold: int64(client.CompressedBytestreamThreshold),
 			BufferSize:               int(client.ChunkMaxSize),
+			UploadLimiter:            client.uploadLimiter,
 		}
 		if client.CompressedBytestreamThreshold < 0 {
 			ioCfg.CompressionSizeThreshold = math.MaxInt64
@@ -898,7 +1077,17 @@ func (c *Client) RPCOpts() []grpc.CallOption {
 // CallWithTimeout executes the given function f with a context that times out after an RPC timeout.
 //
 // This method is logically "protected" and is intended for use by extensions of Client.
-func (c *Client) CallWithTimeout(ctx context.Context, rpcName string, f func(ctx context.Context) error) error {
+//
+// If circuit breakers are enabled, the call fails fast while the breaker of the RPC's family is
+// open. See CircuitBreakers.
+func (c *Client) CallWithTimeout(ctx context.Context, rpcName string, f func(ctx context.Context) error) (err error) {
+	if b := c.rpcBreaker(rpcName); b != nil {
+		done, bErr := b.Allow()
+		if bErr != nil {
+			return bErr
+		}
+		defer func() { done(err) }()
+	}
 	timeout, ok := c.rpcTimeouts[rpcName]
 	if !ok {
 		if timeout, ok = c.rpcTimeouts["default"]; !ok {
@@ -917,7 +1106,9 @@ func (c *Client) CallWithTimeout(ctx context.Context, rpcName string, f func(ctx
 	return e
 }
 
-// Retrier applied to all client requests.
+// Retrier applied to all client requests. Delays suggested by the server with google.rpc.RetryInfo
+// are used when longer than those of Backoff, up to its maximum delay, and retries are limited by the retry budget of Backoff, or the
+// process-wide one. See retry.SetDefaultBudget.
 type Retrier struct {
 	Backoff     retry.BackoffPolicy
 	ShouldRetry retry.ShouldRetry
@@ -935,7 +1126,25 @@ func (r *Retrier) Do(ctx context.Context, f func() error) error {
 	if r == nil {
 		return f()
 	}
-	return retry.WithPolicy(ctx, r.ShouldRetry, r.Backoff, f)
+	// A digest mismatch is retried once, regardless of the policy, in case the corruption was transient.
+	mismatches := 0
+	shouldRetry := func(err error) bool {
+		var bf *batchFailuresError
+		if errors.As(err, &bf) {
+			return false
+		}
+		if errors.Is(err, ErrDigestMismatch) {
+			mismatches++
+			return mismatches == 1
+		}
+		return r.ShouldRetry(err)
+	}
+	err := retry.WithPolicy(ctx, shouldRetry, r.Backoff, f)
+	// The policy may have run out of attempts or budget before the retry of the mismatch.
+	if mismatches == 1 && errors.Is(err, ErrDigestMismatch) && ctx.Err() == nil {
+		return f()
+	}
+	return err
 }
 
 // RetryTransient is a default retry policy for transient status codes.
@@ -951,7 +1160,7 @@ func (c *Client) GetActionResult(ctx context.Context, req *repb.GetActionResultR
 	opts := c.RPCOpts()
 	err = c.Retrier.Do(ctx, func() (e error) {
 		return c.CallWithTimeout(ctx, "GetActionResult", func(ctx context.Context) (e error) {
-			res, e = c.actionCache.GetActionResult(ctx, req, opts...)
+			res, e = c.getActionResult(ctx, req, opts...)
 			return e
 		})
 	})
@@ -1046,7 +1255,7 @@ func (c *Client) BatchReadBlobs(ctx context.Context, req *repb.BatchReadBlobsReq
 	opts := c.RPCOpts()
 	err = c.Retrier.Do(ctx, func() (e error) {
 		return c.CallWithTimeout(ctx, "BatchReadBlobs", func(ctx context.Context) (e error) {
-			res, e = c.cas.BatchReadBlobs(ctx, req, opts...)
+			res, e = c.batchReadBlobs(ctx, req, opts...)
 			return e
 		})
 	})
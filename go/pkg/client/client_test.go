@@ -6,8 +6,11 @@ import (
 	"net"
 	"os"
 	"path"
+	"path/filepath"
 	"testing"
 
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/recorder"
+
 	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
 	svpb "github.com/bazelbuild/remote-apis/build/bazel/semver"
 	"google.golang.org/grpc"
@@ -204,6 +207,53 @@ func TestNewClient(t *testing.T) {
 	defer c.Close()
 }
 
+func TestNewClientClosesRecorder(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	noop := func(context.Context, string, interface{}, interface{}, *grpc.ClientConn, ...grpc.CallOption) error {
+		return nil
+	}
+	record := func(rec *recorder.Recorder) {
+		rec.UnaryClientInterceptor(ctx, "/test/Call", &repb.GetCapabilitiesRequest{}, &repb.ServerCapabilities{}, nil, noop)
+	}
+	tests := []struct {
+		name        string
+		params      DialParams
+		wantEntries int
+	}{
+		// The call made while the client is open is recorded, the one after it is closed is not.
+		{name: "closed client", params: DialParams{Service: "server", NoSecurity: true}, wantEntries: 1},
+		{name: "failed client", params: DialParams{}, wantEntries: 0},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			path := filepath.Join(t.TempDir(), "traffic")
+			rec, err := recorder.New(path, recorder.StorePayloads)
+			if err != nil {
+				t.Fatalf("recorder.New() failed: %v", err)
+			}
+			tc.params.Recorder = rec
+			c, err := NewClient(ctx, instance, tc.params, StartupCapabilities(false))
+			if err == nil {
+				record(rec)
+				if err := c.Close(); err != nil {
+					t.Errorf("Close() failed: %v", err)
+				}
+			}
+			record(rec)
+			entries, err := recorder.ReadFile(path)
+			if err != nil {
+				t.Fatalf("recorder.ReadFile() failed: %v", err)
+			}
+			if len(entries) != tc.wantEntries {
+				t.Errorf("recording has %d entries, want %d", len(entries), tc.wantEntries)
+			}
+		})
+	}
+}
+
 func TestNewClientFromConnection(t *testing.T) {
 	t.Parallel()
 	ctx := context.Background()
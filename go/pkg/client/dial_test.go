@@ -0,0 +1,208 @@
+package client_test
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"testing"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/balancer"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/client"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/fakes"
+	"google.golang.org/grpc/connectivity"
+)
+
+func TestIsLocalSocket(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		want     bool
+	}{
+		{endpoint: "localhost:8980", want: false},
+		{endpoint: "remotebuildexecution.googleapis.com:443", want: false},
+		{endpoint: "dns:///localhost:8980", want: false},
+		{endpoint: "unix:///tmp/proxy.sock", want: true},
+		{endpoint: "unix:proxy.sock", want: true},
+		{endpoint: "unix-abstract:proxy", want: true},
+	}
+	for _, tc := range tests {
+		if got := client.IsLocalSocket(tc.endpoint); got != tc.want {
+			t.Errorf("IsLocalSocket(%q) = %v, want %v", tc.endpoint, got, tc.want)
+		}
+	}
+}
+
+// fakePerRPCCreds counts the requests it was asked to authenticate.
+type fakePerRPCCreds struct {
+	calls int32
+}
+
+func (c *fakePerRPCCreds) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	atomic.AddInt32(&c.calls, 1)
+	return map[string]string{"authorization": "Bearer token"}, nil
+}
+
+func (c *fakePerRPCCreds) RequireTransportSecurity() bool {
+	return true
+}
+
+func TestDialLocalSocket(t *testing.T) {
+	tests := []struct {
+		name     string
+		abstract bool
+		creds    bool
+	}{
+		{name: "unix"},
+		{name: "unix with per-RPC credentials", creds: true},
+		{name: "unix-abstract", abstract: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.abstract && runtime.GOOS != "linux" {
+				t.Skip("abstract sockets are only supported on Linux")
+			}
+			ctx := context.Background()
+			addr := filepath.Join(t.TempDir(), "fake.sock")
+			if tc.abstract {
+				addr = "@" + t.Name()
+			}
+			lis, err := net.Listen("unix", addr)
+			if err != nil {
+				t.Fatalf("net.Listen(unix, %q) failed: %v", addr, err)
+			}
+			s := fakes.NewServerWithListener(t, lis)
+			defer s.Stop()
+
+			params := client.DialParams{Service: s.Target(), NoSecurity: true}
+			creds := &fakePerRPCCreds{}
+			if tc.creds {
+				params = client.DialParams{
+					Service:              s.Target(),
+					UseExternalAuthToken: true,
+					ExternalPerRPCCreds:  &client.PerRPCCreds{Creds: creds},
+				}
+			}
+			c, err := client.NewClient(ctx, "instance", params)
+			if err != nil {
+				t.Fatalf("NewClient(%q) failed: %v", params.Service, err)
+			}
+			defer c.Close()
+
+			blob := []byte("local")
+			dg, err := c.WriteBlob(ctx, blob)
+			if err != nil {
+				t.Fatalf("WriteBlob() failed: %v", err)
+			}
+			if dg != digest.NewFromBlob(blob) {
+				t.Errorf("WriteBlob() = %v, want %v", dg, digest.NewFromBlob(blob))
+			}
+			if got, ok := s.CAS.Get(dg); !ok || string(got) != string(blob) {
+				t.Errorf("blob %v was not written to the fake server", dg)
+			}
+			if tc.creds && atomic.LoadInt32(&creds.calls) == 0 {
+				t.Errorf("per-RPC credentials were not used")
+			}
+		})
+	}
+}
+
+func TestDialConnectionPool(t *testing.T) {
+	ctx := context.Background()
+	s, err := fakes.NewServer(t)
+	if err != nil {
+		t.Fatalf("Error starting fake server: %v", err)
+	}
+	defer s.Stop()
+	c, err := client.NewClient(ctx, "instance", client.DialParams{
+		Service:        s.Target(),
+		NoSecurity:     true,
+		ConnectionPool: &balancer.PoolConfig{Name: t.Name(), MinConnections: 2},
+	})
+	if err != nil {
+		t.Fatalf("NewClient() failed: %v", err)
+	}
+	defer c.Close()
+
+	for i := 0; i < 10; i++ {
+		if _, err := c.WriteBlob(ctx, []byte{byte(i)}); err != nil {
+			t.Fatalf("WriteBlob() failed: %v", err)
+		}
+	}
+	got := balancer.PoolMetrics(t.Name())
+	if got.SubConns != 2 || got.Picks < 10 || got.Outstanding != 0 {
+		t.Errorf("PoolMetrics() = %+v, want 2 sub-connections, at least 10 picks and no outstanding calls", got)
+	}
+}
+
+func TestDialSeparateByteStreamConnections(t *testing.T) {
+	ctx := context.Background()
+	s, err := fakes.NewServer(t)
+	if err != nil {
+		t.Fatalf("Error starting fake server: %v", err)
+	}
+	defer s.Stop()
+	c, err := client.NewClient(ctx, "instance", client.DialParams{
+		Service:                       s.Target(),
+		NoSecurity:                    true,
+		ConnectionPool:                &balancer.PoolConfig{Name: t.Name(), MinConnections: 1},
+		SeparateByteStreamConnections: true,
+	}, client.UseBatchOps(false))
+	if err != nil {
+		t.Fatalf("NewClient() failed: %v", err)
+	}
+	defer c.Close()
+	if c.ByteStreamConnection == c.CASConnection {
+		t.Fatalf("ByteStream calls share the CAS connection")
+	}
+
+	unaryPicks := balancer.PoolMetrics(t.Name()).Picks
+	dg, err := c.WriteBlob(ctx, []byte("stream"))
+	if err != nil {
+		t.Fatalf("WriteBlob() failed: %v", err)
+	}
+	if got := balancer.PoolMetrics(t.Name()).Picks; got != unaryPicks {
+		t.Errorf("WriteBlob() made %d calls on the unary connections, want 0", got-unaryPicks)
+	}
+	if got := balancer.PoolMetrics(t.Name() + "/bytestream").Picks; got == 0 {
+		t.Errorf("WriteBlob() made no calls on the ByteStream connections")
+	}
+	if _, err := c.MissingBlobs(ctx, []digest.Digest{dg}); err != nil {
+		t.Fatalf("MissingBlobs() failed: %v", err)
+	}
+	if got := balancer.PoolMetrics(t.Name()).Picks; got == unaryPicks {
+		t.Errorf("MissingBlobs() made no calls on the unary connections")
+	}
+}
+
+func TestCloseClosesAllConnections(t *testing.T) {
+	ctx := context.Background()
+	s, err := fakes.NewServer(t)
+	if err != nil {
+		t.Fatalf("Error starting fake server: %v", err)
+	}
+	defer s.Stop()
+	c, err := client.NewClient(ctx, "instance", client.DialParams{
+		Service: s.Target(),
+		// A distinct address of the same server, so that a separate CAS connection is dialed.
+		CASService:                    "passthrough:///" + s.Addr(),
+		NoSecurity:                    true,
+		SeparateByteStreamConnections: true,
+	}, client.StartupCapabilities(false))
+	if err != nil {
+		t.Fatalf("NewClient() failed: %v", err)
+	}
+	if c.CASConnection == c.Connection || c.ByteStreamConnection == c.CASConnection {
+		t.Fatalf("NewClient() did not dial distinct connections")
+	}
+	// Closing the ByteStream connection again fails, which must not leak the CAS connection.
+	c.ByteStreamConnection.Close()
+
+	if err := c.Close(); err == nil {
+		t.Errorf("Close() succeeded with a closed ByteStream connection, want error")
+	}
+	if got := c.CASConnection.GetState(); got != connectivity.Shutdown {
+		t.Errorf("CAS connection is in state %v after Close(), want %v", got, connectivity.Shutdown)
+	}
+}
@@ -0,0 +1,256 @@
+package client
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/balancer"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/retry"
+	"google.golang.org/grpc"
+
+	// Redundant imports are required for the google3 mirror. Aliases should not be changed.
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+)
+
+const (
+	// DefaultHedgeDelay is the default delay after which a call is hedged.
+	DefaultHedgeDelay = 50 * time.Millisecond
+
+	// DefaultMaxHedgeRatio is the default maximum fraction of calls that are hedged.
+	DefaultMaxHedgeRatio = 0.05
+
+	// DefaultMaxHedgeBatchBytes is the default maximum total size of the blobs of a hedged
+	// BatchReadBlobs call.
+	DefaultMaxHedgeBatchBytes = 64 * 1024
+
+	// hedgeBurst is the number of hedges that may be issued at once regardless of the hedge ratio.
+	hedgeBurst = 10
+
+	// latencySamples is the number of recent latencies per RPC used to compute the hedge delay.
+	latencySamples = 256
+
+	// minLatencySamples is the number of latencies per RPC required to use the percentile delay.
+	minLatencySamples = 20
+)
+
+// Hedging enables hedged requests for the idempotent read RPCs on the critical path of an action:
+// GetActionResult and small BatchReadBlobs calls. When a call does not complete within the hedge
+// delay, a duplicate call is issued on another sub-connection of the balancer, and the first
+// response is used. Zero fields are replaced with their defaults.
+type Hedging struct {
+	// Delay is the delay after which a call is hedged. When Percentile is set, it is only used until
+	// enough latencies were observed, and as a lower bound of the delay afterwards.
+	Delay time.Duration
+
+	// Percentile of the recently observed latencies of an RPC after which its calls are hedged, e.g.
+	// 95. If zero, Delay is always used.
+	Percentile float64
+
+	// MaxRatio is the maximum fraction of calls that are hedged, e.g. 0.05 for 5%.
+	MaxRatio float64
+
+	// MaxBatchBytes is the maximum total size of the blobs of a BatchReadBlobs call to be hedged.
+	MaxBatchBytes int64
+}
+
+// Apply enables hedging for the client.
+func (h Hedging) Apply(c *Client) {
+	if h.Delay <= 0 {
+		h.Delay = DefaultHedgeDelay
+	}
+	if h.MaxRatio <= 0 {
+		h.MaxRatio = DefaultMaxHedgeRatio
+	}
+	if h.MaxBatchBytes <= 0 {
+		h.MaxBatchBytes = DefaultMaxHedgeBatchBytes
+	}
+	c.hedger = &hedger{cfg: h, tokens: hedgeBurst, latencies: make(map[string]*latencyWindow)}
+}
+
+// HedgeStats are statistics about hedged requests.
+type HedgeStats struct {
+	// Calls is the number of calls that were eligible for hedging.
+	Calls int64
+	// Hedges is the number of duplicate calls that were issued.
+	Hedges int64
+	// HedgeWins is the number of calls whose successful response came from the duplicate call.
+	HedgeWins int64
+}
+
+// HedgeStats returns statistics about the hedged requests of the client.
+func (c *Client) HedgeStats() HedgeStats {
+	if c.hedger == nil {
+		return HedgeStats{}
+	}
+	return HedgeStats{
+		Calls:     atomic.LoadInt64(&c.hedger.stats.Calls),
+		Hedges:    atomic.LoadInt64(&c.hedger.stats.Hedges),
+		HedgeWins: atomic.LoadInt64(&c.hedger.stats.HedgeWins),
+	}
+}
+
+// latencyWindow holds the recent latencies of an RPC.
+type latencyWindow struct {
+	samples []time.Duration
+	next    int
+	// percentile is the cached hedge delay, recomputed every latencySamples/8 new samples.
+	percentile time.Duration
+	stale      int
+}
+
+type hedger struct {
+	cfg   Hedging
+	stats HedgeStats
+
+	mu        sync.Mutex
+	tokens    float64
+	latencies map[string]*latencyWindow
+}
+
+// delay returns how long to wait before hedging a call of the RPC. It also accounts for the call
+// in the hedge budget.
+func (h *hedger) delay(rpcName string) time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.tokens += h.cfg.MaxRatio
+	if h.tokens > hedgeBurst {
+		h.tokens = hedgeBurst
+	}
+	w, ok := h.latencies[rpcName]
+	if h.cfg.Percentile <= 0 || !ok || len(w.samples) < minLatencySamples {
+		return h.cfg.Delay
+	}
+	if w.percentile == 0 || w.stale >= latencySamples/8 {
+		sorted := append([]time.Duration(nil), w.samples...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		i := int(float64(len(sorted)) * h.cfg.Percentile / 100)
+		if i >= len(sorted) {
+			i = len(sorted) - 1
+		}
+		w.percentile, w.stale = sorted[i], 0
+	}
+	if w.percentile < h.cfg.Delay {
+		return h.cfg.Delay
+	}
+	return w.percentile
+}
+
+// allowHedge returns whether the hedge budget allows another hedge, and accounts for it if so.
+func (h *hedger) allowHedge() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.tokens < 1 {
+		return false
+	}
+	h.tokens--
+	return true
+}
+
+func (h *hedger) record(rpcName string, latency time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	w, ok := h.latencies[rpcName]
+	if !ok {
+		w = &latencyWindow{}
+		h.latencies[rpcName] = w
+	}
+	if len(w.samples) < latencySamples {
+		w.samples = append(w.samples, latency)
+	} else {
+		w.samples[w.next] = latency
+		w.next = (w.next + 1) % latencySamples
+	}
+	w.stale++
+}
+
+type hedgeResult struct {
+	res   interface{}
+	err   error
+	hedge bool
+}
+
+// do calls f, and calls it again concurrently if it does not complete within the hedge delay of the
+// RPC. It returns the result of the first call to complete, unless it failed with a transient error
+// while the other call is still in flight. The calls are made on distinct sub-connections when
+// possible. f must be idempotent.
+func (h *hedger) do(ctx context.Context, rpcName string, f func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	atomic.AddInt64(&h.stats.Calls, 1)
+	delay := h.delay(rpcName)
+	ctx, cancel := context.WithCancel(balancer.WithDistinctSubConns(ctx))
+	// Cancel the call that lost the race.
+	defer cancel()
+	results := make(chan hedgeResult, 2)
+	launch := func(hedge bool) {
+		go func() {
+			res, err := f(ctx)
+			results <- hedgeResult{res: res, err: err, hedge: hedge}
+		}()
+	}
+	start := time.Now()
+	launch(false)
+	inFlight := 1
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	timerC := timer.C
+	for {
+		select {
+		case <-timerC:
+			timerC = nil
+			if h.allowHedge() {
+				atomic.AddInt64(&h.stats.Hedges, 1)
+				launch(true)
+				inFlight++
+			}
+		case r := <-results:
+			inFlight--
+			if r.err != nil && inFlight > 0 && retry.TransientOnly(r.err) {
+				continue
+			}
+			if r.err == nil {
+				h.record(rpcName, time.Since(start))
+				if r.hedge {
+					atomic.AddInt64(&h.stats.HedgeWins, 1)
+				}
+			}
+			return r.res, r.err
+		}
+	}
+}
+
+// getActionResult calls GetActionResult, hedging it if enabled.
+func (c *Client) getActionResult(ctx context.Context, req *repb.GetActionResultRequest, opts ...grpc.CallOption) (*repb.ActionResult, error) {
+	if c.hedger == nil {
+		return c.actionCache.GetActionResult(ctx, req, opts...)
+	}
+	res, err := c.hedger.do(ctx, "GetActionResult", func(ctx context.Context) (interface{}, error) {
+		return c.actionCache.GetActionResult(ctx, req, opts...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.(*repb.ActionResult), nil
+}
+
+// batchReadBlobs calls BatchReadBlobs, hedging it if enabled and the requested blobs are small.
+func (c *Client) batchReadBlobs(ctx context.Context, req *repb.BatchReadBlobsRequest, opts ...grpc.CallOption) (*repb.BatchReadBlobsResponse, error) {
+	if c.hedger == nil {
+		return c.cas.BatchReadBlobs(ctx, req, opts...)
+	}
+	var sz int64
+	for _, d := range req.Digests {
+		sz += d.GetSizeBytes()
+	}
+	if sz > c.hedger.cfg.MaxBatchBytes {
+		return c.cas.BatchReadBlobs(ctx, req, opts...)
+	}
+	res, err := c.hedger.do(ctx, "BatchReadBlobs", func(ctx context.Context) (interface{}, error) {
+		return c.cas.BatchReadBlobs(ctx, req, opts...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.(*repb.BatchReadBlobsResponse), nil
+}
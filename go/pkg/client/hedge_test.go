@@ -0,0 +1,114 @@
+package client
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	// Redundant imports are required for the google3 mirror. Aliases should not be changed.
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+)
+
+// slowActionCache answers GetActionResult calls after a delay, except for those listed in fast or fail.
+type slowActionCache struct {
+	repb.ActionCacheClient
+	delay    time.Duration
+	fast     map[int32]bool
+	fail     map[int32]error
+	calls    int32
+	canceled int32
+}
+
+func (ac *slowActionCache) GetActionResult(ctx context.Context, req *repb.GetActionResultRequest, opts ...grpc.CallOption) (*repb.ActionResult, error) {
+	n := atomic.AddInt32(&ac.calls, 1)
+	if ac.fast[n] {
+		return &repb.ActionResult{ExitCode: n}, nil
+	}
+	if err := ac.fail[n]; err != nil {
+		return nil, err
+	}
+	select {
+	case <-time.After(ac.delay):
+		return &repb.ActionResult{ExitCode: n}, nil
+	case <-ctx.Done():
+		atomic.AddInt32(&ac.canceled, 1)
+		return nil, ctx.Err()
+	}
+}
+
+func TestHedgedGetActionResult(t *testing.T) {
+	ac := &slowActionCache{delay: time.Minute, fast: map[int32]bool{2: true}}
+	c := &Client{actionCache: ac}
+	Hedging{Delay: time.Millisecond}.Apply(c)
+
+	res, err := c.getActionResult(context.Background(), &repb.GetActionResultRequest{})
+	if err != nil {
+		t.Fatalf("getActionResult() failed: %v", err)
+	}
+	if res.ExitCode != 2 {
+		t.Errorf("getActionResult() returned the response of call %d, want the hedged call 2", res.ExitCode)
+	}
+	if want, got := (HedgeStats{Calls: 1, Hedges: 1, HedgeWins: 1}), c.HedgeStats(); got != want {
+		t.Errorf("HedgeStats() = %+v, want %+v", got, want)
+	}
+	// The slow call is canceled once the hedged call completes.
+	deadline := time.Now().Add(10 * time.Second)
+	for atomic.LoadInt32(&ac.canceled) != 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&ac.canceled); n != 1 {
+		t.Errorf("%d calls were canceled, want 1", n)
+	}
+}
+
+func TestHedgedGetActionResultFailure(t *testing.T) {
+	ac := &slowActionCache{delay: time.Minute, fail: map[int32]error{2: status.Error(codes.NotFound, "not found")}}
+	c := &Client{actionCache: ac}
+	Hedging{Delay: time.Millisecond}.Apply(c)
+
+	if _, err := c.getActionResult(context.Background(), &repb.GetActionResultRequest{}); status.Code(err) != codes.NotFound {
+		t.Fatalf("getActionResult() gave error %v, want the NotFound error of the hedged call", err)
+	}
+	// A failed hedged call does not count as a win.
+	if want, got := (HedgeStats{Calls: 1, Hedges: 1}), c.HedgeStats(); got != want {
+		t.Errorf("HedgeStats() = %+v, want %+v", got, want)
+	}
+}
+
+func TestHedgeRateLimit(t *testing.T) {
+	ac := &slowActionCache{delay: 20 * time.Millisecond}
+	c := &Client{actionCache: ac}
+	Hedging{Delay: time.Millisecond, MaxRatio: 0.05}.Apply(c)
+	for i := 0; i < 20; i++ {
+		if _, err := c.getActionResult(context.Background(), &repb.GetActionResultRequest{}); err != nil {
+			t.Fatalf("getActionResult() failed: %v", err)
+		}
+	}
+	// The burst allows 10 hedges, and the next 10 calls only earn half a hedge.
+	if got := c.HedgeStats().Hedges; got != hedgeBurst {
+		t.Errorf("HedgeStats().Hedges = %d, want %d", got, hedgeBurst)
+	}
+}
+
+func TestHedgeDelayPercentile(t *testing.T) {
+	c := &Client{}
+	Hedging{Delay: 5 * time.Millisecond, Percentile: 90}.Apply(c)
+	h := c.hedger
+	if got := h.delay("GetActionResult"); got != 5*time.Millisecond {
+		t.Errorf("delay() = %v without samples, want the fixed delay 5ms", got)
+	}
+	for i := 1; i <= 100; i++ {
+		h.record("GetActionResult", time.Duration(i)*time.Millisecond)
+	}
+	if got := h.delay("GetActionResult"); got != 91*time.Millisecond {
+		t.Errorf("delay() = %v, want the 90th percentile 91ms", got)
+	}
+	if got := h.delay("BatchReadBlobs"); got != 5*time.Millisecond {
+		t.Errorf("delay() = %v for another RPC, want the fixed delay 5ms", got)
+	}
+}
@@ -0,0 +1,146 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/filemetadata"
+	"github.com/pkg/errors"
+)
+
+// OutputManifest records the outputs of an action that were left in the CAS instead of being
+// downloaded, so that they can be fetched on demand later.
+type OutputManifest struct {
+	// ActionDigest is the digest of the action that produced the outputs.
+	ActionDigest digest.Digest `json:"action_digest"`
+
+	// OutDir is the local directory that the output paths are relative to.
+	OutDir string `json:"out_dir"`
+
+	// Outputs are the outputs that were not downloaded, sorted by path.
+	Outputs []*ManifestOutput `json:"outputs"`
+}
+
+// ManifestOutput is an output recorded in an OutputManifest.
+type ManifestOutput struct {
+	Path             string        `json:"path"`
+	Digest           digest.Digest `json:"digest"`
+	IsExecutable     bool          `json:"is_executable,omitempty"`
+	IsEmptyDirectory bool          `json:"is_empty_directory,omitempty"`
+	SymlinkTarget    string        `json:"symlink_target,omitempty"`
+}
+
+// NewOutputManifest returns a manifest of the given outputs of an action, relative to outDir.
+func NewOutputManifest(actionDg digest.Digest, outDir string, outs map[string]*TreeOutput) *OutputManifest {
+	m := &OutputManifest{ActionDigest: actionDg, OutDir: outDir}
+	for _, out := range outs {
+		m.Outputs = append(m.Outputs, &ManifestOutput{
+			Path:             out.Path,
+			Digest:           out.Digest,
+			IsExecutable:     out.IsExecutable,
+			IsEmptyDirectory: out.IsEmptyDirectory,
+			SymlinkTarget:    out.SymlinkTarget,
+		})
+	}
+	sort.Slice(m.Outputs, func(i, j int) bool { return m.Outputs[i].Path < m.Outputs[j].Path })
+	return m
+}
+
+// OutputManifestPath returns the path of the manifest of the undownloaded outputs of an action,
+// which is in a directory next to the exec root.
+func OutputManifestPath(execRoot string, actionDg digest.Digest) string {
+	execRoot = filepath.Clean(execRoot)
+	return filepath.Join(filepath.Dir(execRoot), filepath.Base(execRoot)+".outputs", actionDg.Hash+".json")
+}
+
+// WriteOutputManifest writes the manifest to path, creating its parent directories if needed.
+// The file is replaced atomically, so that concurrent readers never see a partial manifest.
+func WriteOutputManifest(path string, m *OutputManifest) error {
+	blob, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(blob)
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return errors.Wrapf(err, "failed to write output manifest %q", path)
+	}
+	return nil
+}
+
+// ReadOutputManifest reads the manifest at path.
+func ReadOutputManifest(path string) (*OutputManifest, error) {
+	blob, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := &OutputManifest{}
+	if err := json.Unmarshal(blob, m); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse output manifest %q", path)
+	}
+	return m, nil
+}
+
+// Select returns the outputs of the manifest that are at or under any of the given paths, relative to
+// OutDir, or all of them if no paths are given. It returns an error if a path does not match any output.
+func (m *OutputManifest) Select(paths []string) (map[string]*TreeOutput, error) {
+	outs := make(map[string]*TreeOutput)
+	add := func(o *ManifestOutput) {
+		outs[o.Path] = &TreeOutput{
+			Path:             o.Path,
+			Digest:           o.Digest,
+			IsExecutable:     o.IsExecutable,
+			IsEmptyDirectory: o.IsEmptyDirectory,
+			SymlinkTarget:    o.SymlinkTarget,
+		}
+	}
+	if len(paths) == 0 {
+		for _, o := range m.Outputs {
+			add(o)
+		}
+		return outs, nil
+	}
+	for _, p := range paths {
+		p = filepath.Clean(p)
+		found := false
+		for _, o := range m.Outputs {
+			if o.Path == p || strings.HasPrefix(o.Path, p+string(filepath.Separator)) {
+				add(o)
+				found = true
+			}
+		}
+		if !found {
+			return nil, errors.Errorf("path %q not found in the output manifest of action %v", p, m.ActionDigest)
+		}
+	}
+	return outs, nil
+}
+
+// DownloadManifestOutputs downloads the outputs of the manifest that are at or under any of the given
+// paths, or all of them if no paths are given, into the OutDir of the manifest.
+// It returns the number of logical and real bytes downloaded.
+func (c *Client) DownloadManifestOutputs(ctx context.Context, m *OutputManifest, paths []string, cache filemetadata.Cache) (*MovedBytesMetadata, error) {
+	outs, err := m.Select(paths)
+	if err != nil {
+		return nil, err
+	}
+	return c.DownloadOutputs(ctx, outs, m.OutDir, cache)
+}
@@ -0,0 +1,114 @@
+package client_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/client"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/fakes"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/filemetadata"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestOutputManifestWriteRead(t *testing.T) {
+	execRoot := filepath.Join(t.TempDir(), "root")
+	acDg := digest.NewFromBlob([]byte("action"))
+	path := client.OutputManifestPath(execRoot, acDg)
+	if want := filepath.Join(filepath.Dir(execRoot), "root.outputs", acDg.Hash+".json"); path != want {
+		t.Errorf("OutputManifestPath(%q, %v) = %q, want %q", execRoot, acDg, path, want)
+	}
+	m := client.NewOutputManifest(acDg, execRoot, map[string]*client.TreeOutput{
+		"b/foo": {Path: "b/foo", Digest: fooDg, IsExecutable: true},
+		"a/sl":  {Path: "a/sl", SymlinkTarget: "../b/foo"},
+		"a/dir": {Path: "a/dir", Digest: digest.Empty, IsEmptyDirectory: true},
+	})
+	if err := client.WriteOutputManifest(path, m); err != nil {
+		t.Fatalf("WriteOutputManifest(%q) failed: %v", path, err)
+	}
+	got, err := client.ReadOutputManifest(path)
+	if err != nil {
+		t.Fatalf("ReadOutputManifest(%q) failed: %v", path, err)
+	}
+	want := &client.OutputManifest{
+		ActionDigest: acDg,
+		OutDir:       execRoot,
+		Outputs: []*client.ManifestOutput{
+			{Path: "a/dir", Digest: digest.Empty, IsEmptyDirectory: true},
+			{Path: "a/sl", SymlinkTarget: "../b/foo"},
+			{Path: "b/foo", Digest: fooDg, IsExecutable: true},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ReadOutputManifest(%q) gave diff (-want +got):\n%s", path, diff)
+	}
+}
+
+func TestOutputManifestSelect(t *testing.T) {
+	m := &client.OutputManifest{
+		Outputs: []*client.ManifestOutput{
+			{Path: "a/bar", Digest: barDg},
+			{Path: "a/foo", Digest: fooDg},
+			{Path: "ab", Digest: bazDg},
+		},
+	}
+	tests := []struct {
+		paths []string
+		want  []string
+	}{
+		{paths: nil, want: []string{"a/bar", "a/foo", "ab"}},
+		{paths: []string{"a"}, want: []string{"a/bar", "a/foo"}},
+		{paths: []string{"a/foo/", "ab"}, want: []string{"a/foo", "ab"}},
+	}
+	for _, tc := range tests {
+		outs, err := m.Select(tc.paths)
+		if err != nil {
+			t.Fatalf("Select(%q) failed: %v", tc.paths, err)
+		}
+		var got []string
+		for p := range outs {
+			got = append(got, p)
+		}
+		sort.Strings(got)
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("Select(%q) gave diff (-want +got):\n%s", tc.paths, diff)
+		}
+	}
+	if _, err := m.Select([]string{"a/b"}); err == nil {
+		t.Errorf("Select(%q) succeeded, want error", "a/b")
+	}
+}
+
+func TestDownloadManifestOutputs(t *testing.T) {
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	e.Server.CAS.Put(fooBlob)
+	e.Server.CAS.Put(barBlob)
+	outDir := t.TempDir()
+	m := &client.OutputManifest{
+		OutDir: outDir,
+		Outputs: []*client.ManifestOutput{
+			{Path: "a/bar", Digest: barDg},
+			{Path: "a/foo", Digest: fooDg, IsExecutable: true},
+			{Path: "b/foo", Digest: fooDg},
+		},
+	}
+	if _, err := e.Client.GrpcClient.DownloadManifestOutputs(context.Background(), m, []string{"a"}, filemetadata.NewNoopCache()); err != nil {
+		t.Fatalf("DownloadManifestOutputs failed: %v", err)
+	}
+	for path, want := range map[string][]byte{"a/bar": barBlob, "a/foo": fooBlob} {
+		got, err := os.ReadFile(filepath.Join(outDir, path))
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", path, err)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("%s has diff (-want +got):\n%s", path, diff)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "b/foo")); !os.IsNotExist(err) {
+		t.Errorf("b/foo was downloaded, want it skipped: %v", err)
+	}
+}
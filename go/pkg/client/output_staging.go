@@ -0,0 +1,318 @@
+package client
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/filemetadata"
+	"github.com/pkg/errors"
+
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	log "github.com/golang/glog"
+)
+
+// stagingPrefix is the prefix of the name of the staging directories created in the output directory.
+const stagingPrefix = ".staging-"
+
+// DownloadActionOutputsAtomic downloads the outputs of the given action result like DownloadActionOutputs,
+// but replaces each output file, symlink and directory in outDir atomically, so that a failed download
+// leaves the existing outputs untouched. See DownloadOutputsAtomic.
+//
+// With StreamingTreeDownloads, the output directories are streamed into the staging directory instead of being
+// flattened in memory. The file metadata cache entries of their files are then invalidated rather than updated,
+// since the list of their files is not kept.
+func (c *Client) DownloadActionOutputsAtomic(ctx context.Context, resPb *repb.ActionResult, outDir string, cache filemetadata.Cache, preserveUnchangedMtime bool) (*MovedBytesMetadata, error) {
+	flattened := resPb
+	var streamed []*repb.OutputDirectory
+	if c.StreamingTreeDownloads {
+		flattened = &repb.ActionResult{
+			OutputFiles:             resPb.OutputFiles,
+			OutputFileSymlinks:      resPb.OutputFileSymlinks,
+			OutputDirectorySymlinks: resPb.OutputDirectorySymlinks,
+		}
+		streamed = resPb.OutputDirectories
+	}
+	outs, err := c.FlattenActionOutputs(ctx, flattened)
+	if err != nil {
+		return nil, err
+	}
+	var roots []string
+	for _, f := range resPb.OutputFiles {
+		roots = append(roots, f.Path)
+	}
+	for _, sm := range resPb.OutputFileSymlinks {
+		roots = append(roots, sm.Path)
+	}
+	for _, sm := range resPb.OutputDirectorySymlinks {
+		roots = append(roots, sm.Path)
+	}
+	for _, dir := range resPb.OutputDirectories {
+		roots = append(roots, dir.Path)
+	}
+	return c.downloadOutputsAtomic(ctx, outs, streamed, roots, outDir, cache, preserveUnchangedMtime)
+}
+
+// DownloadOutputsAtomic downloads the given outputs into a staging directory inside outDir, and then moves
+// each of the roots into place with a rename, replacing whatever was at its path. roots are the paths of the
+// top-level outputs relative to outDir, and every output must be at or under one of them; a root without any
+// outputs is removed. If the download fails, outDir is left untouched, and if moving a root fails, the roots
+// moved so far are restored to their previous contents.
+//
+// If preserveUnchangedMtime is set, the existing files that have the digest and executable bit of their
+// output are kept as they are, with their modification times, instead of being downloaded again.
+// It returns the number of logical and real bytes downloaded.
+func (c *Client) DownloadOutputsAtomic(ctx context.Context, outs map[string]*TreeOutput, roots []string, outDir string, cache filemetadata.Cache, preserveUnchangedMtime bool) (*MovedBytesMetadata, error) {
+	return c.downloadOutputsAtomic(ctx, outs, nil, roots, outDir, cache, preserveUnchangedMtime)
+}
+
+// downloadOutputsAtomic is DownloadOutputsAtomic, which also streams the given output directories into the
+// staging directory. Their paths must be among the roots.
+func (c *Client) downloadOutputsAtomic(ctx context.Context, outs map[string]*TreeOutput, dirs []*repb.OutputDirectory, roots []string, outDir string, cache filemetadata.Cache, preserveUnchangedMtime bool) (*MovedBytesMetadata, error) {
+	if err := os.MkdirAll(outDir, c.DirMode); err != nil {
+		return nil, err
+	}
+	staging, err := os.MkdirTemp(outDir, stagingPrefix)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := os.RemoveAll(staging); err != nil {
+			log.Warningf("failed to remove staging directory %q: %v", staging, err)
+		}
+	}()
+	stagedDir, backupDir := filepath.Join(staging, "out"), filepath.Join(staging, "backup")
+
+	var keep func(*TreeOutput) (bool, error)
+	if preserveUnchangedMtime {
+		keep = func(out *TreeOutput) (bool, error) {
+			return c.keepUnchangedOutput(out, outDir, stagedDir, cache)
+		}
+	}
+	downloads := make(map[string]*TreeOutput, len(outs))
+	for path, out := range outs {
+		if keep != nil {
+			kept, err := keep(out)
+			if err != nil {
+				return nil, err
+			}
+			if kept {
+				continue
+			}
+		}
+		downloads[path] = out
+	}
+	// The cache is updated below, once the outputs are at their final paths.
+	stats, err := c.DownloadOutputs(ctx, downloads, stagedDir, filemetadata.NewNoopCache())
+	if err != nil {
+		return stats, err
+	}
+	for _, dir := range dirs {
+		st := c.newStreamedTree(ctx, stagedDir, filemetadata.NewNoopCache())
+		st.keep = keep
+		dirStats, err := c.downloadOutputDirectoryStreamed(ctx, dir, st)
+		stats.addFrom(dirStats)
+		if err != nil {
+			return stats, err
+		}
+	}
+	// The roots are only listed now, since the top-level entries of streamed directories are not known before.
+	roots, err = outputRoots(roots, outs, outDir, stagedDir)
+	if err != nil {
+		return stats, err
+	}
+
+	var moved []*outputMove
+	for _, root := range roots {
+		m := &outputMove{
+			path:   filepath.Join(outDir, root),
+			staged: filepath.Join(stagedDir, root),
+			backup: filepath.Join(backupDir, root),
+		}
+		moved = append(moved, m)
+		if err := c.moveOutput(m); err != nil {
+			rollbackOutputs(moved)
+			return stats, errors.Wrapf(err, "failed to move output %q into place", root)
+		}
+	}
+	for _, out := range downloads {
+		if out.IsEmptyDirectory || out.SymlinkTarget != "" {
+			continue
+		}
+		md := &filemetadata.Metadata{Digest: out.Digest, IsExecutable: out.IsExecutable}
+		if err := cache.Update(filepath.Join(outDir, out.Path), md); err != nil {
+			return stats, err
+		}
+	}
+	for _, dir := range dirs {
+		if err := invalidateCache(cache, filepath.Join(outDir, dir.Path)); err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+// invalidateCache deletes the cache entries of the files under dir.
+func invalidateCache(cache filemetadata.Cache, dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		return cache.Delete(path)
+	})
+}
+
+// outputRoots returns the cleaned roots sorted by path, without the roots under other roots. A root equal
+// to outDir itself is replaced by the top-level entries of the outputs, of outDir and of stagedDir, so that
+// every root can be renamed.
+func outputRoots(roots []string, outs map[string]*TreeOutput, outDir, stagedDir string) ([]string, error) {
+	set := make(map[string]bool)
+	for _, r := range roots {
+		r = filepath.Clean(r)
+		if filepath.IsAbs(r) || r == ".." || strings.HasPrefix(r, ".."+string(filepath.Separator)) {
+			return nil, errors.Errorf("output path %q is not relative to the output directory", r)
+		}
+		if r != "." {
+			set[r] = true
+			continue
+		}
+		for path := range outs {
+			set[strings.SplitN(filepath.Clean(path), string(filepath.Separator), 2)[0]] = true
+		}
+		entries, err := os.ReadDir(outDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if !strings.HasPrefix(e.Name(), stagingPrefix) {
+				set[e.Name()] = true
+			}
+		}
+		// The staged directory does not exist if nothing was staged.
+		staged, err := os.ReadDir(stagedDir)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		for _, e := range staged {
+			set[e.Name()] = true
+		}
+	}
+	var res []string
+	for r := range set {
+		res = append(res, r)
+	}
+	sort.Strings(res)
+	uniq := res[:0]
+	for _, r := range res {
+		if len(uniq) > 0 && strings.HasPrefix(r, uniq[len(uniq)-1]+string(filepath.Separator)) {
+			continue
+		}
+		uniq = append(uniq, r)
+	}
+	for path := range outs {
+		if !underAny(filepath.Clean(path), uniq) {
+			return nil, errors.Errorf("output %q is not under any of the output paths", path)
+		}
+	}
+	return uniq, nil
+}
+
+func underAny(path string, roots []string) bool {
+	for _, r := range roots {
+		if path == r || strings.HasPrefix(path, r+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// keepUnchangedOutput links the existing file of the output into the staging directory if it has the
+// digest and executable bit of the output, and returns whether it did.
+func (c *Client) keepUnchangedOutput(out *TreeOutput, outDir, stagedDir string, cache filemetadata.Cache) (bool, error) {
+	if out.IsEmptyDirectory || out.SymlinkTarget != "" {
+		return false, nil
+	}
+	path := filepath.Join(outDir, out.Path)
+	md := cache.Get(path)
+	if md.Err != nil || md.Symlink != nil || md.IsDirectory || md.Digest != out.Digest || md.IsExecutable != out.IsExecutable {
+		return false, nil
+	}
+	staged := filepath.Join(stagedDir, out.Path)
+	if err := os.MkdirAll(filepath.Dir(staged), c.DirMode); err != nil {
+		return false, err
+	}
+	if err := os.Link(path, staged); err == nil {
+		return true, nil
+	}
+	// Hard links may not be supported, e.g. across bind mounts; copy the file and its mtime instead.
+	perm := c.RegularMode
+	if out.IsExecutable {
+		perm = c.ExecutableMode
+	}
+	if err := copyFile(outDir, stagedDir, out.Path, out.Path, perm); err != nil {
+		return false, err
+	}
+	return true, os.Chtimes(staged, time.Now(), md.MTime)
+}
+
+// outputMove is the replacement of an output path by its staged contents.
+type outputMove struct {
+	path, staged, backup string
+	// backedUp and placed record the steps that were done, to be undone on rollback.
+	backedUp, placed bool
+}
+
+// moveOutput moves the existing contents of the output path to its backup, and its staged contents to the path.
+func (c *Client) moveOutput(m *outputMove) error {
+	if _, err := os.Lstat(m.path); err == nil {
+		if err := os.MkdirAll(filepath.Dir(m.backup), c.DirMode); err != nil {
+			return err
+		}
+		if err := os.Rename(m.path, m.backup); err != nil {
+			return err
+		}
+		m.backedUp = true
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if _, err := os.Lstat(m.staged); os.IsNotExist(err) {
+		// The output has no contents, e.g. all of them were filtered out.
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(m.path), c.DirMode); err != nil {
+		return err
+	}
+	if err := os.Rename(m.staged, m.path); err != nil {
+		return err
+	}
+	m.placed = true
+	return nil
+}
+
+// rollbackOutputs restores the previous contents of the moved output paths, in reverse order.
+func rollbackOutputs(moved []*outputMove) {
+	for i := len(moved) - 1; i >= 0; i-- {
+		m := moved[i]
+		if m.placed {
+			if err := os.RemoveAll(m.path); err != nil {
+				log.Errorf("failed to remove output %q during rollback: %v", m.path, err)
+				continue
+			}
+		}
+		if m.backedUp {
+			if err := os.Rename(m.backup, m.path); err != nil {
+				log.Errorf("failed to restore output %q during rollback: %v", m.path, err)
+			}
+		}
+	}
+}
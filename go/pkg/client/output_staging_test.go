@@ -0,0 +1,121 @@
+package client_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/client"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/fakes"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/filemetadata"
+	"github.com/google/go-cmp/cmp"
+)
+
+// writeOutputs creates the given files under dir, with an old modification time.
+func writeOutputs(t *testing.T, dir string, files map[string]string) time.Time {
+	t.Helper()
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for path, content := range files {
+		path = filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatalf("failed to create %q: %v", filepath.Dir(path), err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %q: %v", path, err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatalf("failed to set the mtime of %q: %v", path, err)
+		}
+	}
+	return mtime
+}
+
+func TestDownloadOutputsAtomic(t *testing.T) {
+	for _, preserve := range []bool{false, true} {
+		preserve := preserve
+		t.Run(map[bool]string{false: "Replace", true: "PreserveUnchangedMtime"}[preserve], func(t *testing.T) {
+			e, cleanup := fakes.NewTestEnv(t)
+			defer cleanup()
+			e.Server.CAS.Put(fooBlob)
+			e.Server.CAS.Put(barBlob)
+			outDir := t.TempDir()
+			mtime := writeOutputs(t, outDir, map[string]string{"dir/foo": "foo", "dir/stale": "stale", "bar": "old", "other": "other"})
+			outs := map[string]*client.TreeOutput{
+				"dir/foo":   {Path: "dir/foo", Digest: fooDg},
+				"dir/a/bar": {Path: "dir/a/bar", Digest: barDg, IsExecutable: true},
+				"bar":       {Path: "bar", Digest: barDg},
+				"link":      {Path: "link", SymlinkTarget: "bar"},
+			}
+
+			_, err := e.Client.GrpcClient.DownloadOutputsAtomic(context.Background(), outs, []string{"dir", "bar", "link"}, outDir, filemetadata.NewNoopCache(), preserve)
+			if err != nil {
+				t.Fatalf("DownloadOutputsAtomic failed: %v", err)
+			}
+			want := map[string]string{
+				"dir":       "dir",
+				"dir/foo":   "foo",
+				"dir/a":     "dir",
+				"dir/a/bar": "bar+x",
+				"bar":       "bar",
+				"link":      "->bar",
+				"other":     "other",
+			}
+			if diff := cmp.Diff(want, readDirContents(t, outDir)); diff != "" {
+				t.Errorf("DownloadOutputsAtomic gave diff (-want +got):\n%s", diff)
+			}
+			info, err := os.Stat(filepath.Join(outDir, "dir/foo"))
+			if err != nil {
+				t.Fatalf("failed to stat dir/foo: %v", err)
+			}
+			if got := info.ModTime().Equal(mtime); got != preserve {
+				t.Errorf("dir/foo has mtime %v, want preserved mtime %v: %t", info.ModTime(), mtime, preserve)
+			}
+		})
+	}
+}
+
+func TestDownloadOutputsAtomicFailureKeepsOutputs(t *testing.T) {
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	e.Server.CAS.Put(fooBlob)
+	outDir := t.TempDir()
+	writeOutputs(t, outDir, map[string]string{"dir/foo": "old foo", "bar": "old bar"})
+	outs := map[string]*client.TreeOutput{
+		"dir/foo": {Path: "dir/foo", Digest: fooDg},
+		// bar is missing from the CAS.
+		"bar": {Path: "bar", Digest: barDg},
+	}
+
+	if _, err := e.Client.GrpcClient.DownloadOutputsAtomic(context.Background(), outs, []string{"dir", "bar"}, outDir, filemetadata.NewNoopCache(), false); err == nil {
+		t.Fatalf("DownloadOutputsAtomic succeeded with a missing blob, want error")
+	}
+	want := map[string]string{"dir": "dir", "dir/foo": "old foo", "bar": "old bar"}
+	if diff := cmp.Diff(want, readDirContents(t, outDir)); diff != "" {
+		t.Errorf("DownloadOutputsAtomic changed the outputs on failure (-want +got):\n%s", diff)
+	}
+}
+
+func TestDownloadOutputsAtomicRollback(t *testing.T) {
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	e.Server.CAS.Put(fooBlob)
+	e.Server.CAS.Put(barBlob)
+	outDir := t.TempDir()
+	writeOutputs(t, outDir, map[string]string{"a/foo": "old foo", "blocker": "file"})
+	outs := map[string]*client.TreeOutput{
+		"a/foo":       {Path: "a/foo", Digest: fooDg},
+		"blocker/bar": {Path: "blocker/bar", Digest: barDg},
+	}
+
+	// The parent of the second root is a file, so it cannot be moved into place.
+	_, err := e.Client.GrpcClient.DownloadOutputsAtomic(context.Background(), outs, []string{"a", "blocker/bar"}, outDir, filemetadata.NewNoopCache(), false)
+	if err == nil {
+		t.Fatalf("DownloadOutputsAtomic succeeded, want error")
+	}
+	want := map[string]string{"a": "dir", "a/foo": "old foo", "blocker": "file"}
+	if diff := cmp.Diff(want, readDirContents(t, outDir)); diff != "" {
+		t.Errorf("DownloadOutputsAtomic did not roll back the outputs (-want +got):\n%s", diff)
+	}
+}
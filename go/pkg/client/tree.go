@@ -3,6 +3,7 @@ package client
 // This module provides functionality for constructing a Merkle tree of uploadable inputs.
 import (
 	"context"
+	"crypto/sha256"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -14,11 +15,18 @@ import (
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/command"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/filemetadata"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/io/glob"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/io/impath"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/io/vfs"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/uploadinfo"
 	"github.com/pkg/errors"
 
+	"google.golang.org/protobuf/proto"
+
 	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
 	log "github.com/golang/glog"
+	tspb "google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
 // treeNode represents a file tree, which is an intermediate representation used to encode a Merkle
@@ -27,6 +35,8 @@ import (
 type treeNode struct {
 	leaves   map[string]*fileSysNode
 	children map[string]*treeNode
+	// fingerprint is only computed when the client has a MerkleTreeCache.
+	fingerprint [sha256.Size]byte
 }
 
 type fileNode struct {
@@ -38,10 +48,18 @@ type symlinkNode struct {
 	target string
 }
 
+// remoteNode is a file or a directory tree that is already in the CAS.
+type remoteNode struct {
+	dg           digest.Digest
+	isDirectory  bool
+	isExecutable bool
+}
+
 type fileSysNode struct {
 	file                 *fileNode
 	emptyDirectoryMarker bool
 	symlink              *symlinkNode
+	remote               *remoteNode
 	nodeProperties       *cpb.NodeProperties
 }
 
@@ -58,9 +76,21 @@ type TreeStats struct {
 	InputSymlinks int
 	// The overall number of bytes from all the inputs.
 	TotalInputBytes int64
+	// The number of directories whose subtree was reused from the MerkleTreeCache.
+	TreeCacheHits int
+	// The number of directories that were packaged because they were not in the MerkleTreeCache.
+	TreeCacheMisses int
 	// TODO(olaola): number of FileMetadata cache hits/misses go here.
 }
 
+// add accumulates the counts of other, except for cache hits and misses.
+func (s *TreeStats) add(other *TreeStats) {
+	s.InputFiles += other.InputFiles
+	s.InputDirectories += other.InputDirectories
+	s.InputSymlinks += other.InputSymlinks
+	s.TotalInputBytes += other.TotalInputBytes
+}
+
 // TreeSymlinkOpts controls how symlinks are handled when constructing a tree.
 type TreeSymlinkOpts struct {
 	// By default, a symlink is converted into its targeted file.
@@ -97,7 +127,11 @@ func treeSymlinkOpts(opts *TreeSymlinkOpts, sb command.SymlinkBehaviorType) *Tre
 }
 
 // shouldIgnore returns whether a given input should be excluded based on the given InputExclusions,
-func shouldIgnore(inp string, t command.InputType, excl []*command.InputExclusion) bool {
+// or the given exclusion glob patterns, which are matched against its exec root relative path.
+func shouldIgnore(inp, rel string, t command.InputType, excl []*command.InputExclusion, exclGlobs []*glob.Pattern) bool {
+	if rel != "." && glob.MatchAny(exclGlobs, filepath.ToSlash(rel)) {
+		return true
+	}
 	for _, r := range excl {
 		if r.Type != command.UnspecifiedInputType && r.Type != t {
 			continue
@@ -229,7 +263,7 @@ func evalParentSymlinks(execRoot, relPath string, materializeOutsideExecRoot boo
 
 // loadFiles reads all files specified by the given InputSpec (descending into subdirectories
 // recursively), and loads their contents into the provided map.
-func loadFiles(execRoot, localWorkingDir, remoteWorkingDir string, excl []*command.InputExclusion, filesToProcess []string, fs map[string]*fileSysNode, cache filemetadata.Cache, opts *TreeSymlinkOpts, nodeProperties map[string]*cpb.NodeProperties) error {
+func loadFiles(execRoot, localWorkingDir, remoteWorkingDir string, excl []*command.InputExclusion, exclGlobs []*glob.Pattern, filesToProcess []string, fs map[string]*fileSysNode, cache filemetadata.Cache, opts *TreeSymlinkOpts, nodeProperties map[string]*cpb.NodeProperties) error {
 	if opts == nil {
 		opts = DefaultTreeSymlinkOpts()
 	}
@@ -280,7 +314,7 @@ func loadFiles(execRoot, localWorkingDir, remoteWorkingDir string, excl []*comma
 			// file), we simply ignore this path in the finalized tree.
 			continue
 		} else if meta.Symlink != nil && opts.Preserved {
-			if shouldIgnore(absPath, command.SymlinkInputType, excl) {
+			if shouldIgnore(absPath, normPath, command.SymlinkInputType, excl, exclGlobs) {
 				continue
 			}
 			targetExecRoot, targetSymDir, err := getTargetRelPath(execRoot, normPath, meta.Symlink.Target)
@@ -328,7 +362,7 @@ func loadFiles(execRoot, localWorkingDir, remoteWorkingDir string, excl []*comma
 
 	processNonSymlink:
 		if meta.IsDirectory {
-			if shouldIgnore(absPath, command.DirectoryInputType, excl) {
+			if shouldIgnore(absPath, normPath, command.DirectoryInputType, excl, exclGlobs) {
 				continue
 			} else if meta.Err != nil {
 				return meta.Err
@@ -355,7 +389,7 @@ func loadFiles(execRoot, localWorkingDir, remoteWorkingDir string, excl []*comma
 				filesToProcess = append(filesToProcess, filepath.Join(normPath, f))
 			}
 		} else {
-			if shouldIgnore(absPath, command.FileInputType, excl) {
+			if shouldIgnore(absPath, normPath, command.FileInputType, excl, exclGlobs) {
 				continue
 			} else if meta.Err != nil {
 				return meta.Err
@@ -373,6 +407,86 @@ func loadFiles(execRoot, localWorkingDir, remoteWorkingDir string, excl []*comma
 	return nil
 }
 
+// ExpandInputGlobs returns the Inputs of the InputSpec followed by the sorted expansion of its InputGlobs under execRoot,
+// excluding the paths matching exclGlobs.
+func ExpandInputGlobs(execRoot string, is *command.InputSpec, exclGlobs []*glob.Pattern) ([]string, error) {
+	if len(is.InputGlobs) == 0 {
+		return is.Inputs, nil
+	}
+	inclGlobs, err := glob.CompileAll(is.InputGlobs)
+	if err != nil {
+		return nil, err
+	}
+	root, err := impath.Abs(execRoot)
+	if err != nil {
+		return nil, err
+	}
+	paths, err := glob.Expand(vfs.OS, root, inclGlobs, exclGlobs)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to expand input globs")
+	}
+	inputs := make([]string, 0, len(is.Inputs)+len(paths))
+	inputs = append(inputs, is.Inputs...)
+	for _, p := range paths {
+		inputs = append(inputs, filepath.FromSlash(p))
+	}
+	return inputs, nil
+}
+
+// addRemoteInputs adds the RemoteInputs of the InputSpec to fs.
+// It returns an error if the path of a remote input overlaps with the path of any other input.
+func addRemoteInputs(execRoot, workingDir, remoteWorkingDir string, is *command.InputSpec, fs map[string]*fileSysNode) error {
+	if len(is.RemoteInputs) == 0 {
+		return nil
+	}
+	// Map each ancestor of the paths in fs to one of its descendants.
+	under := make(map[string]string, len(fs))
+	addAncestors := func(p string) {
+		for dir := filepath.Dir(p); dir != "."; dir = filepath.Dir(dir) {
+			if _, ok := under[dir]; ok {
+				return
+			}
+			under[dir] = p
+		}
+	}
+	for p := range fs {
+		addAncestors(p)
+	}
+	for _, ri := range is.RemoteInputs {
+		if ri.Path == "" {
+			return errors.New("empty Path in RemoteInputs")
+		}
+		if err := ri.Digest.Validate(); err != nil {
+			return errors.Wrapf(err, "invalid digest of remote input %q", ri.Path)
+		}
+		absPath := filepath.Join(execRoot, ri.Path)
+		normPath, remoteNormPath, err := getExecRootRelPaths(absPath, execRoot, workingDir, remoteWorkingDir)
+		if err != nil {
+			return err
+		}
+		if normPath == "." {
+			return errors.Errorf("remote input %q cannot be the exec root", ri.Path)
+		}
+		if _, ok := fs[remoteNormPath]; ok {
+			return errors.Errorf("remote input %q conflicts with another input at the same path", remoteNormPath)
+		}
+		if other, ok := under[remoteNormPath]; ok {
+			return errors.Errorf("remote input %q conflicts with input %q under it", remoteNormPath, other)
+		}
+		for dir := filepath.Dir(remoteNormPath); dir != "."; dir = filepath.Dir(dir) {
+			if n, ok := fs[dir]; ok && !n.emptyDirectoryMarker {
+				return errors.Errorf("remote input %q conflicts with input %q above it", remoteNormPath, dir)
+			}
+		}
+		fs[remoteNormPath] = &fileSysNode{
+			remote:         &remoteNode{dg: ri.Digest, isDirectory: ri.IsDirectory, isExecutable: ri.IsExecutable},
+			nodeProperties: is.InputNodeProperties[remoteNormPath],
+		}
+		addAncestors(remoteNormPath)
+	}
+	return nil
+}
+
 // ComputeMerkleTree packages an InputSpec into uploadable inputs, returned as uploadinfo.Entrys
 func (c *Client) ComputeMerkleTree(ctx context.Context, execRoot, workingDir, remoteWorkingDir string, is *command.InputSpec, cache filemetadata.Cache) (root digest.Digest, inputs []*uploadinfo.Entry, stats *TreeStats, err error) {
 	stats = &TreeStats{}
@@ -401,7 +515,18 @@ func (c *Client) ComputeMerkleTree(ctx context.Context, execRoot, workingDir, re
 			nodeProperties: np,
 		}
 	}
-	if err := loadFiles(execRoot, workingDir, remoteWorkingDir, is.InputExclusions, is.Inputs, fs, cache, treeSymlinkOpts(c.TreeSymlinkOpts, is.SymlinkBehavior), is.InputNodeProperties); err != nil {
+	exclGlobs, err := glob.CompileAll(is.ExcludeGlobs)
+	if err != nil {
+		return digest.Empty, nil, nil, err
+	}
+	paths, err := ExpandInputGlobs(execRoot, is, exclGlobs)
+	if err != nil {
+		return digest.Empty, nil, nil, err
+	}
+	if err := loadFiles(execRoot, workingDir, remoteWorkingDir, is.InputExclusions, exclGlobs, paths, fs, cache, treeSymlinkOpts(c.TreeSymlinkOpts, is.SymlinkBehavior), is.InputNodeProperties); err != nil {
+		return digest.Empty, nil, nil, err
+	}
+	if err := addRemoteInputs(execRoot, workingDir, remoteWorkingDir, is, fs); err != nil {
 		return digest.Empty, nil, nil, err
 	}
 	ft, err := buildTree(fs)
@@ -413,7 +538,17 @@ func (c *Client) ComputeMerkleTree(ctx context.Context, execRoot, workingDir, re
 	if log.V(5) {
 		tree = make(map[string]digest.Digest)
 	}
-	root, blobs, err = packageTree(ft, stats, "", tree)
+	// The flattened tree is only populated from scratch.
+	treeCache := c.treeCache
+	if tree != nil {
+		treeCache = nil
+	}
+	if treeCache != nil {
+		if _, err := fingerprintTree(ft); err != nil {
+			return digest.Empty, nil, nil, err
+		}
+	}
+	root, blobs, err = packageTree(ft, stats, "", tree, treeCache, merkleTreeRoot{execRoot: execRoot, workingDir: workingDir, remoteWorkingDir: remoteWorkingDir})
 	if log.V(5) {
 		if s, ok := ctx.Value("cl_tree").(*string); ok {
 			treePaths := make([]string, 0, len(tree))
@@ -476,17 +611,51 @@ func buildTree(files map[string]*fileSysNode) (*treeNode, error) {
 
 // If tree is not nil, it will be populated with a flattened tree of path->digest.
 // prefix should always be provided as an empty string which will be used to accumolate path prefixes during recursion.
-func packageTree(t *treeNode, stats *TreeStats, prefix string, tree map[string]digest.Digest) (root digest.Digest, blobs map[digest.Digest]*uploadinfo.Entry, err error) {
+// If cache is not nil, the fingerprints of t must have been computed using fingerprintTree, and localRoot identifies the
+// local files of t.
+func packageTree(t *treeNode, stats *TreeStats, prefix string, tree map[string]digest.Digest, cache *merkleTreeCache, localRoot merkleTreeRoot) (root digest.Digest, blobs map[digest.Digest]*uploadinfo.Entry, err error) {
+	key := merkleTreeCacheKey{root: localRoot, path: prefix}
+	if cache != nil {
+		if e := cache.load(key, t.fingerprint); e != nil {
+			stats.add(&e.stats)
+			stats.TreeCacheHits++
+			blobs = make(map[digest.Digest]*uploadinfo.Entry, len(e.blobs))
+			for _, ue := range e.blobs {
+				blobs[ue.Digest] = ue
+			}
+			return e.digest, blobs, nil
+		}
+		stats.TreeCacheMisses++
+		// Capture the stats of this subtree to cache them.
+		before := *stats
+		defer func() {
+			if err != nil {
+				return
+			}
+			e := &merkleTreeCacheEntry{fingerprint: t.fingerprint, digest: root, blobs: make([]*uploadinfo.Entry, 0, len(blobs))}
+			for _, ue := range blobs {
+				e.blobs = append(e.blobs, ue)
+			}
+			e.stats = TreeStats{
+				InputFiles:       stats.InputFiles - before.InputFiles,
+				InputDirectories: stats.InputDirectories - before.InputDirectories,
+				InputSymlinks:    stats.InputSymlinks - before.InputSymlinks,
+				TotalInputBytes:  stats.TotalInputBytes - before.TotalInputBytes,
+			}
+			cache.store(key, e)
+		}()
+	}
+
 	dir := &repb.Directory{}
 	blobs = make(map[digest.Digest]*uploadinfo.Entry)
 
 	var path string
 	for name, child := range t.children {
-		if tree != nil {
+		if tree != nil || cache != nil {
 			path = prefix + "/" + name
 		}
 
-		dg, childBlobs, err := packageTree(child, stats, path, tree)
+		dg, childBlobs, err := packageTree(child, stats, path, tree, cache, localRoot)
 		if err != nil {
 			return digest.Empty, nil, err
 		}
@@ -500,10 +669,25 @@ func packageTree(t *treeNode, stats *TreeStats, prefix string, tree map[string]d
 			blobs[d] = b
 		}
 	}
-	sort.Slice(dir.Directories, func(i, j int) bool { return dir.Directories[i].Name < dir.Directories[j].Name })
 
 	for name, n := range t.leaves {
-		// A node can have exactly one of file/symlink/emptyDirectoryMarker.
+		// A node can have exactly one of file/symlink/remote/emptyDirectoryMarker.
+		if n.remote != nil {
+			// Remote nodes are already in the CAS, so they have no blobs to upload.
+			dg := n.remote.dg
+			if n.remote.isDirectory {
+				dir.Directories = append(dir.Directories, &repb.DirectoryNode{Name: name, Digest: dg.ToProto()})
+				stats.InputDirectories++
+			} else {
+				dir.Files = append(dir.Files, &repb.FileNode{Name: name, Digest: dg.ToProto(), IsExecutable: n.remote.isExecutable, NodeProperties: command.NodePropertiesToAPI(n.nodeProperties)})
+				stats.InputFiles++
+			}
+			stats.TotalInputBytes += dg.Size
+			if tree != nil {
+				tree[prefix+"/"+name] = dg
+			}
+			continue
+		}
 		if n.file != nil {
 			dg := n.file.ue.Digest
 			dir.Files = append(dir.Files, &repb.FileNode{Name: name, Digest: dg.ToProto(), IsExecutable: n.file.isExecutable, NodeProperties: command.NodePropertiesToAPI(n.nodeProperties)})
@@ -521,6 +705,7 @@ func packageTree(t *treeNode, stats *TreeStats, prefix string, tree map[string]d
 		}
 	}
 
+	sort.Slice(dir.Directories, func(i, j int) bool { return dir.Directories[i].Name < dir.Directories[j].Name })
 	sort.Slice(dir.Files, func(i, j int) bool { return dir.Files[i].Name < dir.Files[j].Name })
 	sort.Slice(dir.Symlinks, func(i, j int) bool { return dir.Symlinks[i].Name < dir.Symlinks[j].Name })
 
@@ -683,6 +868,14 @@ func packageDirectories(t *treeNode) (root *repb.Directory, files map[digest.Dig
 // The paths have to be relative to execRoot.
 // It also populates the remote ActionResult, packaging output directories as trees where required.
 func (c *Client) ComputeOutputsToUpload(execRoot, workingDir string, paths []string, cache filemetadata.Cache, sb command.SymlinkBehaviorType, nodeProperties map[string]*cpb.NodeProperties) (map[digest.Digest]*uploadinfo.Entry, *repb.ActionResult, error) {
+	return c.ComputeOutputsToUploadWithNodeProperties(execRoot, workingDir, paths, cache, sb, nodeProperties, nil)
+}
+
+// ComputeOutputsToUploadWithNodeProperties is like ComputeOutputsToUpload, but it also captures the named
+// node properties of the output files and symlinks, including those inside output directories, from the local
+// file system. The supported names are command.NodePropertyMtime and command.NodePropertyUnixMode; other names
+// are ignored. Captured properties take precedence over the corresponding ones in nodeProperties.
+func (c *Client) ComputeOutputsToUploadWithNodeProperties(execRoot, workingDir string, paths []string, cache filemetadata.Cache, sb command.SymlinkBehaviorType, nodeProperties map[string]*cpb.NodeProperties, capture []string) (map[digest.Digest]*uploadinfo.Entry, *repb.ActionResult, error) {
 	outs := make(map[digest.Digest]*uploadinfo.Entry)
 	resPb := &repb.ActionResult{}
 	for _, path := range paths {
@@ -703,16 +896,28 @@ func (c *Client) ComputeOutputsToUpload(execRoot, workingDir string, paths []str
 		}
 		if !meta.IsDirectory {
 			// A regular file.
+			np, err := captureNodeProperties(absPath, true, nodeProperties[normPath], capture)
+			if err != nil {
+				return nil, nil, err
+			}
 			ue := uploadinfo.EntryFromFile(meta.Digest, absPath)
 			outs[meta.Digest] = ue
-			resPb.OutputFiles = append(resPb.OutputFiles, &repb.OutputFile{Path: normPath, Digest: meta.Digest.ToProto(), IsExecutable: meta.IsExecutable, NodeProperties: command.NodePropertiesToAPI(nodeProperties[normPath])})
+			resPb.OutputFiles = append(resPb.OutputFiles, &repb.OutputFile{Path: normPath, Digest: meta.Digest.ToProto(), IsExecutable: meta.IsExecutable, NodeProperties: command.NodePropertiesToAPI(np)})
 			continue
 		}
 		// A directory.
 		fs := make(map[string]*fileSysNode)
-		if e := loadFiles(absPath, "", "", nil, []string{"."}, fs, cache, treeSymlinkOpts(c.TreeSymlinkOpts, sb), nodeProperties); e != nil {
+		if e := loadFiles(absPath, "", "", nil, nil, []string{"."}, fs, cache, treeSymlinkOpts(c.TreeSymlinkOpts, sb), nodeProperties); e != nil {
 			return nil, nil, e
 		}
+		for p, n := range fs {
+			if n.file == nil && n.symlink == nil {
+				continue
+			}
+			if n.nodeProperties, err = captureNodeProperties(filepath.Join(absPath, p), n.file != nil, n.nodeProperties, capture); err != nil {
+				return nil, nil, err
+			}
+		}
 		ft, err := buildTree(fs)
 		if err != nil {
 			return nil, nil, err
@@ -747,3 +952,32 @@ func (c *Client) ComputeOutputsToUpload(execRoot, workingDir string, paths []str
 	}
 	return outs, resPb, nil
 }
+
+// captureNodeProperties returns a copy of np with the node properties named in capture set from the file
+// at path. Symlinks are followed if follow is true. It returns np itself if nothing is captured.
+func captureNodeProperties(path string, follow bool, np *cpb.NodeProperties, capture []string) (*cpb.NodeProperties, error) {
+	if len(capture) == 0 {
+		return np, nil
+	}
+	stat := os.Lstat
+	if follow {
+		stat = os.Stat
+	}
+	info, err := stat(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to capture node properties of %q", path)
+	}
+	res := &cpb.NodeProperties{}
+	if np != nil {
+		res = proto.Clone(np).(*cpb.NodeProperties)
+	}
+	for _, name := range capture {
+		switch name {
+		case command.NodePropertyMtime:
+			res.Mtime = tspb.New(info.ModTime())
+		case command.NodePropertyUnixMode:
+			res.UnixMode = wrapperspb.UInt32(uint32(info.Mode().Perm()))
+		}
+	}
+	return res, nil
+}
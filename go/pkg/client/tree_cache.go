@@ -0,0 +1,154 @@
+package client
+
+// This module provides a cache of packaged subtrees for ComputeMerkleTree.
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+	"sort"
+	"sync"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/uploadinfo"
+	"google.golang.org/protobuf/proto"
+)
+
+// MerkleTreeCache is to specify whether the client caches the packaged subtrees of the input trees computed by ComputeMerkleTree.
+//
+// Directories are cached by their exec root, working directories and remote path, and are reused as long as the metadata of all
+// of their descendants is unchanged. The local root is part of the key because the cached blobs are uploaded from its files.
+// This saves re-marshalling and re-digesting the shared subtrees of many actions, e.g. toolchains.
+// The cache is not bounded; it holds one entry for every distinct local root and directory path seen by the client.
+type MerkleTreeCache bool
+
+// Apply sets the client's MerkleTreeCache, creating or dropping its cache.
+func (s MerkleTreeCache) Apply(c *Client) {
+	c.MerkleTreeCache = s
+	if !s {
+		c.treeCache = nil
+		return
+	}
+	if c.treeCache == nil {
+		c.treeCache = &merkleTreeCache{entries: make(map[merkleTreeCacheKey]*merkleTreeCacheEntry)}
+	}
+}
+
+// merkleTreeCache holds the latest packaged subtree for each directory path of each local root.
+type merkleTreeCache struct {
+	mu      sync.Mutex
+	entries map[merkleTreeCacheKey]*merkleTreeCacheEntry
+}
+
+// merkleTreeRoot identifies the local files of an input tree: the exec root, and the working directories that map remote
+// paths to local ones.
+type merkleTreeRoot struct {
+	execRoot, workingDir, remoteWorkingDir string
+}
+
+// merkleTreeCacheKey identifies a directory by its local root and its remote path.
+type merkleTreeCacheKey struct {
+	root merkleTreeRoot
+	path string
+}
+
+// merkleTreeCacheEntry is an immutable packaged subtree.
+type merkleTreeCacheEntry struct {
+	// fingerprint covers the names and metadata of all the descendants of the directory.
+	fingerprint [sha256.Size]byte
+	digest      digest.Digest
+	// blobs includes the blobs of all the descendants and the blob of the directory itself.
+	blobs []*uploadinfo.Entry
+	// stats are the stats of the subtree, without cache hits and misses.
+	stats TreeStats
+}
+
+// load returns the entry for key if its fingerprint matches.
+func (c *merkleTreeCache) load(key merkleTreeCacheKey, fingerprint [sha256.Size]byte) *merkleTreeCacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := c.entries[key]
+	if e == nil || e.fingerprint != fingerprint {
+		return nil
+	}
+	return e
+}
+
+// store replaces the entry for key, which invalidates any entry computed from different content.
+func (c *merkleTreeCache) store(key merkleTreeCacheKey, e *merkleTreeCacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = e
+}
+
+// fingerprintTree computes the fingerprints of t and all of its descendant directories.
+// The fingerprint of a directory changes whenever the name or metadata of any of its descendants changes.
+func fingerprintTree(t *treeNode) ([sha256.Size]byte, error) {
+	h := sha256.New()
+	names := make([]string, 0, len(t.children))
+	for name := range t.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		child := t.children[name]
+		fp, err := fingerprintTree(child)
+		if err != nil {
+			return fp, err
+		}
+		writeFingerprintString(h, "d")
+		writeFingerprintString(h, name)
+		h.Write(fp[:])
+	}
+
+	names = names[:0]
+	for name := range t.leaves {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		n := t.leaves[name]
+		switch {
+		case n.file != nil:
+			writeFingerprintString(h, "f")
+			writeFingerprintString(h, name)
+			writeFingerprintString(h, n.file.ue.Digest.String())
+			if n.file.isExecutable {
+				writeFingerprintString(h, "x")
+			} else {
+				writeFingerprintString(h, "-")
+			}
+		case n.remote != nil:
+			writeFingerprintString(h, "r")
+			writeFingerprintString(h, name)
+			writeFingerprintString(h, n.remote.dg.String())
+			if n.remote.isDirectory {
+				writeFingerprintString(h, "d")
+			} else if n.remote.isExecutable {
+				writeFingerprintString(h, "x")
+			} else {
+				writeFingerprintString(h, "-")
+			}
+		case n.symlink != nil:
+			writeFingerprintString(h, "s")
+			writeFingerprintString(h, name)
+			writeFingerprintString(h, n.symlink.target)
+		default:
+			continue
+		}
+		b, err := proto.MarshalOptions{Deterministic: true}.Marshal(n.nodeProperties)
+		if err != nil {
+			return [sha256.Size]byte{}, err
+		}
+		writeFingerprintString(h, string(b))
+	}
+
+	copy(t.fingerprint[:], h.Sum(nil))
+	return t.fingerprint, nil
+}
+
+// writeFingerprintString writes a length-prefixed string to avoid ambiguous concatenations.
+func writeFingerprintString(h hash.Hash, s string) {
+	var l [binary.MaxVarintLen64]byte
+	h.Write(l[:binary.PutUvarint(l[:], uint64(len(s)))])
+	h.Write([]byte(s))
+}
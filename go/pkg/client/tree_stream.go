@@ -0,0 +1,490 @@
+package client
+
+// This module provides streaming downloads of directory trees, which hold at most a bounded number of
+// pending files in memory regardless of the size of the tree.
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/filemetadata"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+)
+
+const (
+	// streamTreePageSize is the page size requested from GetTree.
+	streamTreePageSize = 1000
+
+	// streamTreeBatchSize is the number of outputs downloaded together.
+	streamTreeBatchSize = 1000
+
+	// streamTreeConcurrency is the maximum number of batches downloaded concurrently.
+	streamTreeConcurrency = 4
+)
+
+// StreamingTreeDownloads is to specify whether DownloadActionOutputs and DownloadActionOutputsAtomic download
+// each output directory by streaming its Tree, starting file downloads as soon as their directories are parsed,
+// instead of flattening all the trees in memory first. This bounds the memory used for very large output directories.
+type StreamingTreeDownloads bool
+
+// Apply sets the client's StreamingTreeDownloads.
+func (s StreamingTreeDownloads) Apply(c *Client) {
+	c.StreamingTreeDownloads = s
+}
+
+// DownloadDirectoryStreamed downloads the directory tree rooted at d into outDir, like DownloadDirectory,
+// but without holding the whole tree in memory: directories are processed breadth-first as they arrive, and
+// the downloads of their files start as soon as they are known.
+// The directories are fetched with GetTree, or level by level from the CAS if the server does not implement it.
+// It returns the number of logical and real bytes of files downloaded.
+func (c *Client) DownloadDirectoryStreamed(ctx context.Context, d digest.Digest, outDir string, cache filemetadata.Cache) (*MovedBytesMetadata, error) {
+	st := c.newStreamedTree(ctx, outDir, cache)
+	st.reference(d, "")
+	err := c.streamDirectoryTree(ctx, d, st.add)
+	if status.Code(err) == codes.Unimplemented {
+		err = c.walkDirectoryTree(ctx, st)
+	}
+	if err != nil {
+		st.abort()
+		return st.stats, err
+	}
+	return st.finish()
+}
+
+// DownloadOutputDirectoryStreamed downloads an output directory of an action result into outDir, streaming
+// its Tree message from the CAS and processing each Directory as soon as it is parsed, so that neither the
+// Tree nor the list of files is held in memory. It returns the number of logical and real bytes of files downloaded.
+func (c *Client) DownloadOutputDirectoryStreamed(ctx context.Context, dir *repb.OutputDirectory, outDir string, cache filemetadata.Cache) (*MovedBytesMetadata, error) {
+	return c.downloadOutputDirectoryStreamed(ctx, dir, c.newStreamedTree(ctx, outDir, cache))
+}
+
+// downloadOutputDirectoryStreamed streams the tree of the output directory into st.
+func (c *Client) downloadOutputDirectoryStreamed(ctx context.Context, dir *repb.OutputDirectory, st *streamedTree) (*MovedBytesMetadata, error) {
+	treeDg, err := digest.NewFromProto(dir.GetTreeDigest())
+	if err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := c.readBlobStreamed(ctx, treeDg, 0, 0, pw)
+		pw.CloseWithError(err)
+	}()
+	err = readTree(pr, treeDg.Size, func(d *repb.Directory, isRoot bool) error {
+		dg, err := digest.NewFromMessage(d)
+		if err != nil {
+			return err
+		}
+		if isRoot {
+			if err := st.reference(dg, dir.Path); err != nil {
+				return err
+			}
+		}
+		return st.add(d)
+	})
+	// Unblock the reader goroutine in case the parsing failed.
+	pr.CloseWithError(err)
+	<-done
+	if err != nil {
+		st.abort()
+		return st.stats, errors.Wrapf(err, "failed to read tree %v of output directory %q", treeDg, dir.Path)
+	}
+	return st.finish()
+}
+
+// streamDirectoryTree calls fn with every directory of the tree rooted at d, as returned by GetTree, one page at a time.
+func (c *Client) streamDirectoryTree(ctx context.Context, d digest.Digest, fn func(*repb.Directory) error) error {
+	if d.IsEmpty() {
+		return fn(&repb.Directory{})
+	}
+	pageTok := ""
+	closure := func(ctx context.Context) error {
+		stream, err := c.GetTree(ctx, &repb.GetTreeRequest{
+			InstanceName: c.InstanceName,
+			RootDigest:   d.ToProto(),
+			PageSize:     streamTreePageSize,
+			PageToken:    pageTok,
+		})
+		if err != nil {
+			return err
+		}
+		for {
+			resp, err := stream.Recv()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			for _, dir := range resp.Directories {
+				if err := fn(dir); err != nil {
+					return err
+				}
+			}
+			// A retry resumes after the last fully processed page.
+			pageTok = resp.NextPageToken
+		}
+	}
+	return c.Retrier.Do(ctx, func() error { return c.CallWithTimeout(ctx, "GetTree", closure) })
+}
+
+// walkDirectoryTree fetches the directories referenced but not yet received by st from the CAS, level by level.
+func (c *Client) walkDirectoryTree(ctx context.Context, st *streamedTree) error {
+	for len(st.pending) > 0 {
+		var level []digest.Digest
+		for dg := range st.pending {
+			level = append(level, dg)
+		}
+		dirs := make([]*repb.Directory, len(level))
+		eg, eCtx := errgroup.WithContext(ctx)
+		eg.SetLimit(streamTreeConcurrency)
+		for i, dg := range level {
+			i, dg := i, dg
+			eg.Go(func() error {
+				dirs[i] = &repb.Directory{}
+				_, err := c.ReadProto(eCtx, dg, dirs[i])
+				return err
+			})
+		}
+		if err := eg.Wait(); err != nil {
+			return err
+		}
+		for _, d := range dirs {
+			if err := st.add(d); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readTree parses a serialized repb.Tree of the given size from r, calling fn with each Directory as soon as it is read.
+// Unknown fields are skipped.
+func readTree(r io.Reader, size int64, fn func(d *repb.Directory, isRoot bool) error) error {
+	br := bufio.NewReader(r)
+	for {
+		tag, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		num, typ := protowire.DecodeTag(tag)
+		if num <= 0 {
+			return fmt.Errorf("invalid field number %d", num)
+		}
+		var n uint64
+		switch typ {
+		case protowire.VarintType:
+			if _, err := binary.ReadUvarint(br); err != nil {
+				return unexpectedEOF(err)
+			}
+			continue
+		case protowire.Fixed32Type:
+			n = 4
+		case protowire.Fixed64Type:
+			n = 8
+		case protowire.BytesType:
+			if n, err = binary.ReadUvarint(br); err != nil {
+				return unexpectedEOF(err)
+			}
+		default:
+			return fmt.Errorf("unsupported wire type %d of field %d", typ, num)
+		}
+		if n > uint64(size) {
+			return fmt.Errorf("field %d of length %d exceeds the size of the tree", num, n)
+		}
+		if typ != protowire.BytesType || (num != 1 && num != 2) {
+			if _, err := br.Discard(int(n)); err != nil {
+				return unexpectedEOF(err)
+			}
+			continue
+		}
+		blob := make([]byte, n)
+		if _, err := io.ReadFull(br, blob); err != nil {
+			return unexpectedEOF(err)
+		}
+		d := &repb.Directory{}
+		if err := proto.Unmarshal(blob, d); err != nil {
+			return err
+		}
+		if err := fn(d, num == 1); err != nil {
+			return err
+		}
+	}
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// streamedTree materializes a directory tree whose Directory messages arrive one at a time, in any order.
+type streamedTree struct {
+	c      *Client
+	outDir string
+	cache  filemetadata.Cache
+	// keep, if set, is called with every file before its download, and skips the download if it returns true.
+	keep func(*TreeOutput) (bool, error)
+
+	// pending are the paths of the directories that were referenced but not received yet.
+	pending map[digest.Digest][]string
+	// orphans are the directories that were received before being referenced.
+	orphans map[digest.Digest]*repb.Directory
+	// materialized is the first path of every directory that was processed. Later references to the
+	// same directory are copied locally from there once all downloads are done.
+	materialized map[digest.Digest]string
+	copies       []*dirCopy
+
+	batch map[string]*TreeOutput
+	eg    *errgroup.Group
+	egCtx context.Context
+	mu    sync.Mutex
+	stats *MovedBytesMetadata
+}
+
+type dirCopy struct {
+	from, to string
+	done     bool
+}
+
+func (c *Client) newStreamedTree(ctx context.Context, outDir string, cache filemetadata.Cache) *streamedTree {
+	eg, egCtx := errgroup.WithContext(ctx)
+	eg.SetLimit(streamTreeConcurrency)
+	return &streamedTree{
+		c:            c,
+		outDir:       outDir,
+		cache:        cache,
+		pending:      make(map[digest.Digest][]string),
+		orphans:      make(map[digest.Digest]*repb.Directory),
+		materialized: make(map[digest.Digest]string),
+		batch:        make(map[string]*TreeOutput),
+		eg:           eg,
+		egCtx:        egCtx,
+		stats:        &MovedBytesMetadata{},
+	}
+}
+
+// add processes a received directory, if it was referenced, or keeps it until it is.
+func (t *streamedTree) add(d *repb.Directory) error {
+	dg, err := digest.NewFromMessage(d)
+	if err != nil {
+		return err
+	}
+	if paths, ok := t.pending[dg]; ok {
+		delete(t.pending, dg)
+		return t.process(dg, d, paths)
+	}
+	if _, ok := t.materialized[dg]; !ok {
+		t.orphans[dg] = d
+	}
+	return nil
+}
+
+// reference records that the directory dg is at path.
+func (t *streamedTree) reference(dg digest.Digest, path string) error {
+	if from, ok := t.materialized[dg]; ok {
+		t.copies = append(t.copies, &dirCopy{from: from, to: path})
+		return nil
+	}
+	if d, ok := t.orphans[dg]; ok {
+		delete(t.orphans, dg)
+		return t.process(dg, d, []string{path})
+	}
+	t.pending[dg] = append(t.pending[dg], path)
+	return nil
+}
+
+// process materializes the directory d at the first of paths, and copies it to the others later.
+func (t *streamedTree) process(dg digest.Digest, d *repb.Directory, paths []string) error {
+	path := paths[0]
+	t.materialized[dg] = path
+	for _, p := range paths[1:] {
+		t.copies = append(t.copies, &dirCopy{from: path, to: p})
+	}
+	if len(d.Files)+len(d.Directories)+len(d.Symlinks) == 0 {
+		return t.emit(&TreeOutput{Path: path, Digest: digest.Empty, IsEmptyDirectory: true, NodeProperties: d.NodeProperties})
+	}
+	for _, f := range d.Files {
+		out := &TreeOutput{
+			Path:           filepath.Join(path, f.Name),
+			Digest:         digest.NewFromProtoUnvalidated(f.Digest),
+			IsExecutable:   f.IsExecutable,
+			NodeProperties: f.NodeProperties,
+		}
+		if err := t.emit(out); err != nil {
+			return err
+		}
+	}
+	for _, sm := range d.Symlinks {
+		out := &TreeOutput{
+			Path:           filepath.Join(path, sm.Name),
+			SymlinkTarget:  sm.Target,
+			NodeProperties: sm.NodeProperties,
+		}
+		if err := t.emit(out); err != nil {
+			return err
+		}
+	}
+	for _, sub := range d.Directories {
+		if err := t.reference(digest.NewFromProtoUnvalidated(sub.Digest), filepath.Join(path, sub.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// emit adds an output to the current batch, starting its download once the batch is full.
+func (t *streamedTree) emit(out *TreeOutput) error {
+	if t.keep != nil {
+		kept, err := t.keep(out)
+		if err != nil || kept {
+			return err
+		}
+	}
+	t.batch[out.Path] = out
+	if len(t.batch) < streamTreeBatchSize {
+		return nil
+	}
+	return t.flush()
+}
+
+// flush starts the download of the current batch. It blocks while the maximum number of batches are in flight.
+func (t *streamedTree) flush() error {
+	if len(t.batch) == 0 {
+		return nil
+	}
+	if err := t.egCtx.Err(); err != nil {
+		// A previous batch failed.
+		return err
+	}
+	batch := t.batch
+	t.batch = make(map[string]*TreeOutput)
+	t.eg.Go(func() error {
+		stats, err := t.c.DownloadOutputs(t.egCtx, batch, t.outDir, t.cache)
+		t.mu.Lock()
+		t.stats.addFrom(stats)
+		t.mu.Unlock()
+		return err
+	})
+	return nil
+}
+
+// abort waits for the in-flight downloads after a failure.
+func (t *streamedTree) abort() {
+	t.eg.Wait()
+}
+
+// finish downloads the remaining outputs and makes the copies of repeated directories.
+func (t *streamedTree) finish() (*MovedBytesMetadata, error) {
+	if err := t.flush(); err != nil {
+		t.abort()
+		return t.stats, err
+	}
+	if err := t.eg.Wait(); err != nil {
+		return t.stats, err
+	}
+	if len(t.pending) > 0 {
+		var missing []string
+		for dg, paths := range t.pending {
+			missing = append(missing, fmt.Sprintf("%s (%v)", paths[0], dg))
+		}
+		return t.stats, fmt.Errorf("directories missing from the tree: %s", strings.Join(missing, ", "))
+	}
+	for _, cp := range t.copies {
+		if err := t.copy(cp); err != nil {
+			return t.stats, err
+		}
+	}
+	return t.stats, nil
+}
+
+// copy copies a materialized directory, after the copies into it.
+func (t *streamedTree) copy(cp *dirCopy) error {
+	if cp.done {
+		return nil
+	}
+	cp.done = true
+	for _, other := range t.copies {
+		if strings.HasPrefix(other.to+string(filepath.Separator), cp.from+string(filepath.Separator)) {
+			if err := t.copy(other); err != nil {
+				return err
+			}
+		}
+	}
+	src, dst := filepath.Join(t.outDir, cp.from), filepath.Join(t.outDir, cp.to)
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(filepath.Join(dst, rel), t.c.DirMode)
+		case d.Type()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(target, filepath.Join(dst, rel))
+		default:
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			perm := t.c.RegularMode
+			if info.Mode()&0100 != 0 {
+				perm = t.c.ExecutableMode
+			}
+			return copyFile(src, dst, rel, rel, perm)
+		}
+	})
+}
+
+// downloadActionOutputsStreamed is DownloadActionOutputs for StreamingTreeDownloads.
+func (c *Client) downloadActionOutputsStreamed(ctx context.Context, resPb *repb.ActionResult, outDir string, cache filemetadata.Cache) (*MovedBytesMetadata, error) {
+	// Flatten the action result without its output directories.
+	outs, err := c.FlattenActionOutputs(ctx, &repb.ActionResult{
+		OutputFiles:             resPb.OutputFiles,
+		OutputFileSymlinks:      resPb.OutputFileSymlinks,
+		OutputDirectorySymlinks: resPb.OutputDirectorySymlinks,
+	})
+	if err != nil {
+		return nil, err
+	}
+	stats := &MovedBytesMetadata{}
+	for _, dir := range resPb.OutputDirectories {
+		if err := os.RemoveAll(filepath.Join(outDir, dir.Path)); err != nil {
+			return stats, err
+		}
+		dirStats, err := c.DownloadOutputDirectoryStreamed(ctx, dir, outDir, cache)
+		stats.addFrom(dirStats)
+		if err != nil {
+			return stats, err
+		}
+	}
+	fileStats, err := c.DownloadOutputs(ctx, outs, outDir, cache)
+	return stats.addFrom(fileStats), err
+}
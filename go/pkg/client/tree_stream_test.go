@@ -0,0 +1,180 @@
+package client_test
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/client"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/fakes"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/filemetadata"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/proto"
+
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+)
+
+// streamedTestTree puts a tree with repeated and empty subdirectories into the fake CAS and returns its
+// root, its Tree message, and the contents it is expected to have on disk as returned by readDirContents.
+func streamedTestTree(t *testing.T, fake *fakes.CAS) (*repb.Directory, *repb.Tree, map[string]string) {
+	t.Helper()
+	fooDg := fake.Put([]byte("foo"))
+	barDg := fake.Put([]byte("bar"))
+	leaf := &repb.Directory{
+		Files:    []*repb.FileNode{{Name: "bar", Digest: barDg.ToProto(), IsExecutable: true}},
+		Symlinks: []*repb.SymlinkNode{{Name: "link", Target: "bar"}},
+	}
+	empty := &repb.Directory{}
+	mid := &repb.Directory{
+		Files: []*repb.FileNode{{Name: "foo", Digest: fooDg.ToProto()}},
+		Directories: []*repb.DirectoryNode{
+			{Name: "empty", Digest: digest.TestNewFromMessage(empty).ToProto()},
+			{Name: "leaf", Digest: digest.TestNewFromMessage(leaf).ToProto()},
+		},
+	}
+	root := &repb.Directory{
+		Directories: []*repb.DirectoryNode{
+			{Name: "a", Digest: digest.TestNewFromMessage(mid).ToProto()},
+			{Name: "b", Digest: digest.TestNewFromMessage(mid).ToProto()},
+			{Name: "c", Digest: digest.TestNewFromMessage(leaf).ToProto()},
+		},
+	}
+	for _, d := range []*repb.Directory{leaf, empty, mid, root} {
+		blob, err := proto.Marshal(d)
+		if err != nil {
+			t.Fatalf("proto.Marshal(%v) failed: %v", d, err)
+		}
+		fake.Put(blob)
+	}
+	want := make(map[string]string)
+	for _, p := range []string{"a", "b"} {
+		want[p] = "dir"
+		want[p+"/foo"] = "foo"
+		want[p+"/empty"] = "dir"
+		want[p+"/leaf"] = "dir"
+		want[p+"/leaf/bar"] = "bar+x"
+		want[p+"/leaf/link"] = "->bar"
+	}
+	want["c"] = "dir"
+	want["c/bar"] = "bar+x"
+	want["c/link"] = "->bar"
+	return root, &repb.Tree{Root: root, Children: []*repb.Directory{mid, empty, leaf}}, want
+}
+
+// readDirContents returns the contents of the files under dir by relative path, with "+x" appended
+// for executable files, "->target" for symlinks and "dir" for directories.
+func readDirContents(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	got := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == dir {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		switch {
+		case d.IsDir():
+			got[rel] = "dir"
+		case d.Type()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			got[rel] = "->" + target
+		default:
+			blob, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			got[rel] = string(blob)
+			if info.Mode()&0100 != 0 {
+				got[rel] += "+x"
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to walk %q: %v", dir, err)
+	}
+	return got
+}
+
+func TestDownloadDirectoryStreamed(t *testing.T) {
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	root, _, want := streamedTestTree(t, e.Server.CAS)
+	outDir := t.TempDir()
+
+	stats, err := e.Client.GrpcClient.DownloadDirectoryStreamed(context.Background(), digest.TestNewFromMessage(root), outDir, filemetadata.NewNoopCache())
+	if err != nil {
+		t.Fatalf("DownloadDirectoryStreamed failed: %v", err)
+	}
+	if diff := cmp.Diff(want, readDirContents(t, outDir)); diff != "" {
+		t.Errorf("DownloadDirectoryStreamed gave diff (-want +got):\n%s", diff)
+	}
+	// The repeated directories are copied locally instead of being downloaded again.
+	if stats.Requested != 6 {
+		t.Errorf("DownloadDirectoryStreamed requested %d bytes, want 6", stats.Requested)
+	}
+}
+
+func TestDownloadDirectoryStreamedMissingDirectory(t *testing.T) {
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	root := &repb.Directory{
+		Directories: []*repb.DirectoryNode{{Name: "a", Digest: digest.NewFromBlob([]byte("missing")).ToProto()}},
+	}
+	blob, err := proto.Marshal(root)
+	if err != nil {
+		t.Fatalf("proto.Marshal failed: %v", err)
+	}
+	rootDg := e.Server.CAS.Put(blob)
+
+	if _, err := e.Client.GrpcClient.DownloadDirectoryStreamed(context.Background(), rootDg, t.TempDir(), filemetadata.NewNoopCache()); err == nil {
+		t.Errorf("DownloadDirectoryStreamed succeeded with a missing directory, want error")
+	}
+}
+
+func TestDownloadActionOutputsStreamed(t *testing.T) {
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	c := e.Client.GrpcClient
+	client.StreamingTreeDownloads(true).Apply(c)
+	_, tree, wantDir := streamedTestTree(t, e.Server.CAS)
+	treeBlob, err := proto.Marshal(tree)
+	if err != nil {
+		t.Fatalf("proto.Marshal failed: %v", err)
+	}
+	treeDg := e.Server.CAS.Put(treeBlob)
+	fooDg := digest.NewFromBlob([]byte("foo"))
+	ar := &repb.ActionResult{
+		OutputFiles:       []*repb.OutputFile{{Path: "foo", Digest: fooDg.ToProto()}},
+		OutputDirectories: []*repb.OutputDirectory{{Path: "out/dir", TreeDigest: treeDg.ToProto()}},
+	}
+	outDir := t.TempDir()
+	// Existing contents of the output directory are removed.
+	if err := os.MkdirAll(filepath.Join(outDir, "out/dir/stale"), 0777); err != nil {
+		t.Fatalf("failed to create stale output: %v", err)
+	}
+
+	if _, err := c.DownloadActionOutputs(context.Background(), ar, outDir, filemetadata.NewNoopCache()); err != nil {
+		t.Fatalf("DownloadActionOutputs failed: %v", err)
+	}
+	want := map[string]string{"foo": "foo", "out": "dir", "out/dir": "dir"}
+	for p, content := range wantDir {
+		want["out/dir/"+p] = content
+	}
+	if diff := cmp.Diff(want, readDirContents(t, outDir)); diff != "" {
+		t.Errorf("DownloadActionOutputs gave diff (-want +got):\n%s", diff)
+	}
+}
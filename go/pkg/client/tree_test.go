@@ -5,7 +5,9 @@ import (
 	"math/rand"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	cpb "github.com/bazelbuild/remote-apis-sdks/go/api/command"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/chunker"
@@ -19,8 +21,10 @@ import (
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 
 	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	tspb "google.golang.org/protobuf/types/known/timestamppb"
 )
 
 var (
@@ -1261,6 +1265,67 @@ func TestComputeMerkleTree(t *testing.T) {
 				TotalInputBytes:  barDg.Size + barDirDg.Size,
 			},
 		},
+		{
+			desc: "Glob inputs and exclusions",
+			input: []*inputPath{
+				{path: "fooDir/foo", fileContents: fooBlob, isExecutable: true},
+				{path: "fooDir/foo.txt", fileContents: fooBlob, isExecutable: true},
+				{path: "barDir/bar", fileContents: barBlob},
+				{path: "barDir/bar.txt", fileContents: barBlob},
+				{path: "barDir/testdata/bar", fileContents: barBlob},
+			},
+			spec: &command.InputSpec{
+				InputGlobs:          []string{"**/foo", "barDir/**"},
+				ExcludeGlobs:        []string{"**/*.txt", "barDir/testdata"},
+				InputNodeProperties: map[string]*cpb.NodeProperties{"fooDir/foo": fooProperties},
+			},
+			rootDir: &repb.Directory{Directories: []*repb.DirectoryNode{
+				{Name: "barDir", Digest: barDirDgPb},
+				{Name: "fooDir", Digest: fooDirDgPb},
+			}},
+			additionalBlobs: [][]byte{fooBlob, barBlob, fooDirBlob, barDirBlob},
+			wantCacheCalls: map[string]int{
+				"fooDir/foo": 1,
+				"barDir/bar": 1,
+			},
+			wantStats: &client.TreeStats{
+				InputDirectories: 3,
+				InputFiles:       2,
+				TotalInputBytes:  fooDg.Size + fooDirDg.Size + barDg.Size + barDirDg.Size,
+			},
+		},
+		{
+			desc: "Remote inputs",
+			input: []*inputPath{
+				{path: "fooDir/foo", fileContents: fooBlob, isExecutable: true},
+			},
+			spec: &command.InputSpec{
+				Inputs: []string{"fooDir"},
+				RemoteInputs: []*command.RemoteInput{
+					&command.RemoteInput{Path: "barDir", Digest: barDirDg, IsDirectory: true},
+					&command.RemoteInput{Path: "bar", Digest: barDg},
+				},
+				InputNodeProperties: map[string]*cpb.NodeProperties{"fooDir/foo": fooProperties},
+			},
+			rootDir: &repb.Directory{
+				Directories: []*repb.DirectoryNode{
+					{Name: "barDir", Digest: barDirDgPb},
+					{Name: "fooDir", Digest: fooDirDgPb},
+				},
+				Files: []*repb.FileNode{{Name: "bar", Digest: barDgPb}},
+			},
+			// The blobs of the remote inputs are not uploaded.
+			additionalBlobs: [][]byte{fooBlob, fooDirBlob},
+			wantCacheCalls: map[string]int{
+				"fooDir":     1,
+				"fooDir/foo": 1,
+			},
+			wantStats: &client.TreeStats{
+				InputDirectories: 3,
+				InputFiles:       2,
+				TotalInputBytes:  fooDg.Size + fooDirDg.Size + barDg.Size + barDirDg.Size,
+			},
+		},
 		{
 			desc: "Virtual inputs",
 			spec: &command.InputSpec{
@@ -1567,6 +1632,102 @@ func TestComputeMerkleTree(t *testing.T) {
 	}
 }
 
+func TestComputeMerkleTreeCache(t *testing.T) {
+	root := t.TempDir()
+	if err := construct(root, []*inputPath{
+		{path: "a/foo", fileContents: fooBlob},
+		{path: "b/bar", fileContents: barBlob},
+	}); err != nil {
+		t.Fatalf("failed to construct input dir structure: %v", err)
+	}
+	inputSpec := &command.InputSpec{Inputs: []string{"a", "b"}}
+
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	c := e.Client.GrpcClient
+	client.MerkleTreeCache(true).Apply(c)
+
+	compute := func(wantHits, wantMisses int) digest.Digest {
+		t.Helper()
+		rootDg, inputs, stats, err := c.ComputeMerkleTree(context.Background(), root, "", "", inputSpec, filemetadata.NewNoopCache())
+		if err != nil {
+			t.Fatalf("ComputeMerkleTree(...) = gave error %v, want success", err)
+		}
+		if stats.TreeCacheHits != wantHits || stats.TreeCacheMisses != wantMisses {
+			t.Errorf("ComputeMerkleTree(...) gave cache hits=%d, misses=%d, want hits=%d, misses=%d", stats.TreeCacheHits, stats.TreeCacheMisses, wantHits, wantMisses)
+		}
+		if stats.InputFiles != 2 || stats.InputDirectories != 3 {
+			t.Errorf("ComputeMerkleTree(...) gave files=%d, dirs=%d, want files=2, dirs=3", stats.InputFiles, stats.InputDirectories)
+		}
+		// 2 files and 3 directories.
+		if len(inputs) != 5 {
+			t.Errorf("ComputeMerkleTree(...) gave %d inputs, want 5", len(inputs))
+		}
+		return rootDg
+	}
+
+	first := compute(0, 3)
+	if second := compute(1, 0); second != first {
+		t.Errorf("ComputeMerkleTree(...) gave root %v from the cache, want %v", second, first)
+	}
+
+	// Changing a file invalidates its ancestors only.
+	if err := os.WriteFile(filepath.Join(root, "a/foo"), bazBlob, 0644); err != nil {
+		t.Fatalf("failed to modify a/foo: %v", err)
+	}
+	third := compute(1, 2)
+	client.MerkleTreeCache(false).Apply(c)
+	if want := compute(0, 0); third != want {
+		t.Errorf("ComputeMerkleTree(...) gave root %v with the cache, want %v", third, want)
+	}
+	if third == first {
+		t.Errorf("ComputeMerkleTree(...) gave the same root after modifying a file")
+	}
+}
+
+func TestComputeMerkleTreeCacheExecRoots(t *testing.T) {
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	c := e.Client.GrpcClient
+	client.MerkleTreeCache(true).Apply(c)
+	inputSpec := &command.InputSpec{Inputs: []string{"a", "b"}}
+	roots := []string{t.TempDir(), t.TempDir()}
+	for _, root := range roots {
+		if err := construct(root, []*inputPath{
+			{path: "a/foo", fileContents: fooBlob},
+			{path: "b/bar", fileContents: barBlob},
+		}); err != nil {
+			t.Fatalf("failed to construct input dir structure: %v", err)
+		}
+	}
+
+	var want digest.Digest
+	// The second exec root has the same relative inputs as the first, but is only a cache hit the second time.
+	for i, tc := range []struct {
+		root     string
+		wantHits int
+	}{{roots[0], 0}, {roots[1], 0}, {roots[1], 1}} {
+		rootDg, inputs, stats, err := c.ComputeMerkleTree(context.Background(), tc.root, "", "", inputSpec, filemetadata.NewNoopCache())
+		if err != nil {
+			t.Fatalf("ComputeMerkleTree(%v, ...) = gave error %v, want success", tc.root, err)
+		}
+		if stats.TreeCacheHits != tc.wantHits {
+			t.Errorf("ComputeMerkleTree(%v, ...) gave %d cache hits, want %d", tc.root, stats.TreeCacheHits, tc.wantHits)
+		}
+		if i == 0 {
+			want = rootDg
+		} else if rootDg != want {
+			t.Errorf("ComputeMerkleTree(%v, ...) gave root %v, want %v", tc.root, rootDg, want)
+		}
+		// The files are uploaded from the exec root of the call, not from the one the subtrees were first cached for.
+		for _, ue := range inputs {
+			if ue.IsFile() && !strings.HasPrefix(ue.Path, tc.root+string(filepath.Separator)) {
+				t.Errorf("ComputeMerkleTree(%v, ...) gave input %v from another exec root", tc.root, ue.Path)
+			}
+		}
+	}
+}
+
 func TestComputeMerkleTreeErrors(t *testing.T) {
 	tests := []struct {
 		desc     string
@@ -1590,6 +1751,44 @@ func TestComputeMerkleTreeErrors(t *testing.T) {
 			desc: "missing input",
 			spec: &command.InputSpec{Inputs: []string{"foo"}},
 		},
+		{
+			desc:  "remote input conflicting with a local input",
+			input: []*inputPath{{path: "a", fileContents: []byte("a")}},
+			spec: &command.InputSpec{
+				Inputs:       []string{"a"},
+				RemoteInputs: []*command.RemoteInput{&command.RemoteInput{Path: "a", Digest: digest.NewFromBlob([]byte("a"))}},
+			},
+		},
+		{
+			desc:  "remote input above a local input",
+			input: []*inputPath{{path: "dir/a", fileContents: []byte("a")}},
+			spec: &command.InputSpec{
+				Inputs:       []string{"dir/a"},
+				RemoteInputs: []*command.RemoteInput{&command.RemoteInput{Path: "dir", Digest: digest.Empty, IsDirectory: true}},
+			},
+		},
+		{
+			desc: "remote input below a virtual input",
+			spec: &command.InputSpec{
+				VirtualInputs: []*command.VirtualInput{&command.VirtualInput{Path: "a", Contents: []byte("a")}},
+				RemoteInputs:  []*command.RemoteInput{&command.RemoteInput{Path: "a/b", Digest: digest.Empty}},
+			},
+		},
+		{
+			desc: "remote input without a digest",
+			spec: &command.InputSpec{
+				RemoteInputs: []*command.RemoteInput{&command.RemoteInput{Path: "a"}},
+			},
+		},
+		{
+			desc:  "input glob matching nothing",
+			input: []*inputPath{{path: "a", fileContents: []byte("a")}},
+			spec:  &command.InputSpec{InputGlobs: []string{"*", "dir/*"}},
+		},
+		{
+			desc: "malformed glob",
+			spec: &command.InputSpec{Inputs: []string{"."}, ExcludeGlobs: []string{"[a"}},
+		},
 		{
 			desc: "missing nested input",
 			input: []*inputPath{
@@ -2154,3 +2353,79 @@ func BenchmarkComputeMerkleTree(b *testing.B) {
 		}
 	}
 }
+
+func TestComputeOutputsToUploadCapturesNodeProperties(t *testing.T) {
+	root := t.TempDir()
+	input := []*inputPath{
+		{path: "foo", fileContents: fooBlob, isExecutable: true},
+		{path: "out/bar", fileContents: barBlob},
+		{path: "out/link", isSymlink: true, relSymlinkTarget: "bar"},
+	}
+	if err := construct(root, input); err != nil {
+		t.Fatalf("failed to construct input dir structure: %v", err)
+	}
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for path, mode := range map[string]os.FileMode{"foo": 0o751, "out/bar": 0o640} {
+		if err := os.Chmod(filepath.Join(root, path), mode); err != nil {
+			t.Fatalf("failed to chmod %q: %v", path, err)
+		}
+		if err := os.Chtimes(filepath.Join(root, path), mtime, mtime); err != nil {
+			t.Fatalf("failed to set mtime of %q: %v", path, err)
+		}
+	}
+	linkInfo, err := os.Lstat(filepath.Join(root, "out/link"))
+	if err != nil {
+		t.Fatalf("failed to stat symlink: %v", err)
+	}
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	capture := []string{command.NodePropertyMtime, command.NodePropertyUnixMode}
+	outs, gotResult, err := e.Client.GrpcClient.ComputeOutputsToUploadWithNodeProperties(root, "", []string{"foo", "out"}, filemetadata.NewNoopCache(), command.PreserveSymlink, map[string]*cpb.NodeProperties{"foo": fooProperties}, capture)
+	if err != nil {
+		t.Fatalf("ComputeOutputsToUploadWithNodeProperties(...) = gave error %v, want success", err)
+	}
+
+	wantFile := &repb.OutputFile{
+		Path:         "foo",
+		Digest:       fooDgPb,
+		IsExecutable: true,
+		NodeProperties: &repb.NodeProperties{
+			Properties: []*repb.NodeProperty{{Name: "fooName", Value: "fooValue"}},
+			Mtime:      tspb.New(mtime),
+			UnixMode:   wrapperspb.UInt32(0o751),
+		},
+	}
+	if diff := cmp.Diff([]*repb.OutputFile{wantFile}, gotResult.OutputFiles, protocmp.Transform()); diff != "" {
+		t.Errorf("ComputeOutputsToUploadWithNodeProperties(...) gave diff on output files (-want +got):\n%s", diff)
+	}
+	if len(gotResult.OutputDirectories) != 1 {
+		t.Fatalf("ComputeOutputsToUploadWithNodeProperties(...) returned %d output directories, want 1", len(gotResult.OutputDirectories))
+	}
+	treeDg, err := digest.NewFromProto(gotResult.OutputDirectories[0].TreeDigest)
+	if err != nil {
+		t.Fatalf("invalid tree digest: %v", err)
+	}
+	ue, ok := outs[treeDg]
+	if !ok {
+		t.Fatalf("tree %v missing from the outputs to upload", treeDg)
+	}
+	treePb := &repb.Tree{}
+	if err := proto.Unmarshal(ue.Contents, treePb); err != nil {
+		t.Fatalf("failed to unmarshal tree: %v", err)
+	}
+	wantRoot := &repb.Directory{
+		Files: []*repb.FileNode{{
+			Name:           "bar",
+			Digest:         barDgPb,
+			NodeProperties: &repb.NodeProperties{Mtime: tspb.New(mtime), UnixMode: wrapperspb.UInt32(0o640)},
+		}},
+		Symlinks: []*repb.SymlinkNode{{
+			Name:           "link",
+			Target:         "bar",
+			NodeProperties: &repb.NodeProperties{Mtime: tspb.New(linkInfo.ModTime()), UnixMode: wrapperspb.UInt32(uint32(linkInfo.Mode().Perm()))},
+		}},
+	}
+	if diff := cmp.Diff(wantRoot, treePb.Root, protocmp.Transform()); diff != "" {
+		t.Errorf("ComputeOutputsToUploadWithNodeProperties(...) gave diff on output directory (-want +got):\n%s", diff)
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/io/glob"
 	"github.com/pborman/uuid"
 
 	cpb "github.com/bazelbuild/remote-apis-sdks/go/api/command"
@@ -96,6 +97,22 @@ type VirtualInput struct {
 	IsEmptyDirectory bool
 }
 
+// RemoteInput represents an input that already exists in the CAS. It is mounted in the input
+// tree without being read from disk or uploaded.
+type RemoteInput struct {
+	// The path for the input to be mounted at, relative to the ExecRoot.
+	Path string
+
+	// The digest of the file, or of the root repb.Directory of the tree if IsDirectory is set.
+	Digest digest.Digest
+
+	// Whether Digest identifies a repb.Directory. The entire tree must be in the CAS.
+	IsDirectory bool
+
+	// Whether the file should be staged as executable. It is ignored for directories.
+	IsExecutable bool
+}
+
 // InputSpec represents all the required inputs to a remote command.
 type InputSpec struct {
 	// Input paths (files or directories) that need to be present for the command execution.
@@ -104,6 +121,9 @@ type InputSpec struct {
 	// Inputs not present on the local file system, but should be staged for command execution.
 	VirtualInputs []*VirtualInput
 
+	// Inputs already present in the CAS. Their paths must not overlap with the paths of other inputs.
+	RemoteInputs []*RemoteInput
+
 	// Inputs matching these patterns will be excluded.
 	InputExclusions []*InputExclusion
 
@@ -115,6 +135,14 @@ type InputSpec struct {
 
 	// Node properties of inputs.
 	InputNodeProperties map[string]*cpb.NodeProperties
+
+	// Glob patterns of input files, relative to the ExecRoot, e.g. "src/**/*.go".
+	// The matching files are added to Inputs in lexical order. A pattern that matches no files is an error.
+	InputGlobs []string
+
+	// Inputs matching these glob patterns, relative to the ExecRoot, will be excluded, e.g. "**/testdata".
+	// A pattern that matches a directory excludes its whole subtree.
+	ExcludeGlobs []string
 }
 
 // String returns the string representation of the VirtualInput.
@@ -122,6 +150,11 @@ func (s *VirtualInput) String() string {
 	return fmt.Sprintf("%+v", *s)
 }
 
+// String returns the string representation of the RemoteInput.
+func (s *RemoteInput) String() string {
+	return fmt.Sprintf("%+v", *s)
+}
+
 // String returns the string representation of the InputExclusion.
 func (s *InputExclusion) String() string {
 	return fmt.Sprintf("%+v", *s)
@@ -182,6 +215,12 @@ type Command struct {
 	// The files and directories will likely be merged into a single Outputs field in the future.
 	OutputDirs []string
 
+	// OutputGlobs are glob patterns, relative to the working directory, matching additional output files.
+	// Remote executions capture the base directory of each pattern as an output directory, and only the
+	// files matching a pattern are downloaded from it. For local executions, the patterns are expanded
+	// after the command ran.
+	OutputGlobs []string
+
 	// Timeout is an optional duration to wait for command execution before timing out.
 	Timeout time.Duration
 
@@ -235,6 +274,9 @@ func (c *Command) Validate() error {
 		return fmt.Errorf("invalid RemoteWorkingDir=%q[%v level(s)], it's expected to have the same depth as WorkingDir=%q[%v level(s)]",
 			c.RemoteWorkingDir, levels(c.RemoteWorkingDir), c.WorkingDir, levels(c.WorkingDir))
 	}
+	if _, err := glob.CompileAll(c.OutputGlobs); err != nil {
+		return fmt.Errorf("invalid OutputGlobs: %v", err)
+	}
 	// TODO(olaola): make Platform required?
 	return nil
 }
@@ -247,11 +289,19 @@ func (c *Command) stableID() string {
 	buf = append(buf, []byte(c.WorkingDir)...)
 	marshallSortedSlice(c.OutputFiles, &buf)
 	marshallSortedSlice(c.OutputDirs, &buf)
+	marshallSortedSlice(c.OutputGlobs, &buf)
 	buf = append(buf, []byte(c.Timeout.String())...)
 	marshallMap(c.Platform, &buf)
 	if c.InputSpec != nil {
 		marshallMap(c.InputSpec.EnvironmentVariables, &buf)
 		marshallSortedSlice(c.InputSpec.Inputs, &buf)
+		marshallSortedSlice(c.InputSpec.InputGlobs, &buf)
+		marshallSortedSlice(c.InputSpec.ExcludeGlobs, &buf)
+		remoteInputs := make([]string, 0, len(c.InputSpec.RemoteInputs))
+		for _, ri := range c.InputSpec.RemoteInputs {
+			remoteInputs = append(remoteInputs, fmt.Sprintf("%s:%s:%t:%t", ri.Path, ri.Digest, ri.IsDirectory, ri.IsExecutable))
+		}
+		marshallSortedSlice(remoteInputs, &buf)
 		inputExclusions := make([]*InputExclusion, len(c.InputSpec.InputExclusions))
 		copy(inputExclusions, c.InputSpec.InputExclusions)
 		sort.Slice(inputExclusions, func(i, j int) bool {
@@ -309,7 +359,18 @@ type ExecutionOptions struct {
 	// Download command outputs after execution. Defaults to true.
 	DownloadOutputs bool
 
-	// Preserve mtimes for unchanged outputs when downloading. Defaults to false.
+	// DownloadPolicy selects which outputs are downloaded after execution. If specified, it takes
+	// precedence over DownloadOutputs, and the outputs that are not downloaded are recorded in an
+	// output manifest next to the exec root, from which they can be fetched later.
+	// Defaults to UnspecifiedDownloadPolicy.
+	DownloadPolicy DownloadPolicy
+
+	// DownloadMatcher returns whether the output at the given path, relative to the working
+	// directory, should be downloaded. It is only used with DownloadMatchingOutputs.
+	DownloadMatcher func(path string) bool
+
+	// Preserve mtimes for unchanged outputs when downloading: existing output files with the expected
+	// contents are kept in place instead of being downloaded again. Defaults to false.
 	PreserveUnchangedOutputMtime bool
 
 	// Download command stdout and stderr. Defaults to true. If StreamOutErr is also set, this value
@@ -324,8 +385,61 @@ type ExecutionOptions struct {
 	// is also set. The client may expect a delay in this scenario as the streams are downloaded after
 	// the fact.
 	StreamOutErr bool
+
+	// OutputNodeProperties are the names of the node properties to capture for output files, directories
+	// and symlinks, e.g. NodePropertyMtime and NodePropertyUnixMode. They are requested from the server
+	// for remote executions, and read from the local file system when caching local execution results.
+	// Defaults to none.
+	OutputNodeProperties []string
+}
+
+// DownloadPolicy specifies which outputs of a command are downloaded.
+type DownloadPolicy int
+
+const (
+	// UnspecifiedDownloadPolicy means that either all or no outputs are downloaded, depending on
+	// ExecutionOptions.DownloadOutputs.
+	UnspecifiedDownloadPolicy DownloadPolicy = iota
+
+	// DownloadNoOutputs means that no outputs are downloaded.
+	DownloadNoOutputs
+
+	// DownloadAllOutputs means that all outputs are downloaded.
+	DownloadAllOutputs
+
+	// DownloadTopLevelOutputs means that output files and symlinks are downloaded, but not the
+	// contents of output directories.
+	DownloadTopLevelOutputs
+
+	// DownloadMatchingOutputs means that only the outputs accepted by
+	// ExecutionOptions.DownloadMatcher are downloaded.
+	DownloadMatchingOutputs
+)
+
+var downloadPolicies = [...]string{
+	"UnspecifiedDownloadPolicy",
+	"DownloadNoOutputs",
+	"DownloadAllOutputs",
+	"DownloadTopLevelOutputs",
+	"DownloadMatchingOutputs",
+}
+
+// String returns the policy as a human-readable string.
+func (p DownloadPolicy) String() string {
+	if UnspecifiedDownloadPolicy <= p && p <= DownloadMatchingOutputs {
+		return downloadPolicies[p]
+	}
+	return fmt.Sprintf("InvalidDownloadPolicy(%d)", p)
 }
 
+const (
+	// NodePropertyMtime is the output node property holding the modification time of an output.
+	NodePropertyMtime = "mtime"
+
+	// NodePropertyUnixMode is the output node property holding the unix permission bits of an output.
+	NodePropertyUnixMode = "unix_mode"
+)
+
 // DefaultExecutionOptions returns the recommended ExecutionOptions.
 func DefaultExecutionOptions() *ExecutionOptions {
 	return &ExecutionOptions{
@@ -543,6 +657,9 @@ type Metadata struct {
 	StderrDigest digest.Digest
 	// StdoutDigest is a digest of the standard output after being executed.
 	StdoutDigest digest.Digest
+	// OutputManifest is the path of the manifest recording the outputs that were not downloaded,
+	// if any. See ExecutionOptions.DownloadPolicy.
+	OutputManifest string
 	// TODO(olaola): Add a lot of other fields.
 }
 
@@ -559,18 +676,18 @@ func (c *Command) ToREProto(useOutputPathsField bool) *repb.Command {
 		WorkingDirectory: workingDir,
 	}
 
+	outDirs := append(append([]string(nil), c.OutputDirs...), c.outputGlobDirs()...)
 	// In v2.1 of the RE API the `output_{files, directories}` fields were
 	// replaced by a single field: `output_paths`.
 	if useOutputPathsField {
-		cmdPb.OutputPaths = append(c.OutputFiles, c.OutputDirs...)
+		cmdPb.OutputPaths = append(append([]string(nil), c.OutputFiles...), outDirs...)
 		sort.Strings(cmdPb.OutputPaths)
 	} else {
 		cmdPb.OutputFiles = make([]string, len(c.OutputFiles))
 		copy(cmdPb.OutputFiles, c.OutputFiles)
 		sort.Strings(cmdPb.OutputFiles)
 
-		cmdPb.OutputDirectories = make([]string, len(c.OutputDirs))
-		copy(cmdPb.OutputDirectories, c.OutputDirs)
+		cmdPb.OutputDirectories = outDirs
 		sort.Strings(cmdPb.OutputDirectories)
 	}
 
@@ -588,6 +705,41 @@ func (c *Command) ToREProto(useOutputPathsField bool) *repb.Command {
 	return cmdPb
 }
 
+// outputGlobDirs returns the base directories of the output globs that are not covered by the output
+// directories or by one another. A pattern based at the working directory yields the empty path, which
+// captures the entire working directory. Malformed patterns are skipped; see Validate.
+func (c *Command) outputGlobDirs() []string {
+	var bases []string
+	for _, g := range c.OutputGlobs {
+		p, err := glob.Compile(g)
+		if err != nil {
+			continue
+		}
+		base := p.Base()
+		if base == "." {
+			base = ""
+		}
+		bases = append(bases, base)
+	}
+	// Sorting places every directory before its descendants.
+	sort.Strings(bases)
+	covered := func(base string, dirs []string) bool {
+		for _, d := range dirs {
+			if d == "" || base == d || strings.HasPrefix(base, d+"/") {
+				return true
+			}
+		}
+		return false
+	}
+	var dirs []string
+	for _, base := range bases {
+		if !covered(base, c.OutputDirs) && !covered(base, dirs) {
+			dirs = append(dirs, base)
+		}
+	}
+	return dirs
+}
+
 func FromREProto(cmdPb *repb.Command) *Command {
 	cmd := &Command{
 		InputSpec: &InputSpec{
@@ -637,6 +789,7 @@ func FromProto(p *cpb.Command) *Command {
 		InputSpec:        is,
 		OutputFiles:      p.GetOutput().GetOutputFiles(),
 		OutputDirs:       p.GetOutput().GetOutputDirectories(),
+		OutputGlobs:      p.GetOutput().GetOutputGlobs(),
 		Timeout:          time.Duration(p.ExecutionTimeout) * time.Second,
 		Platform:         p.Platform,
 	}
@@ -661,13 +814,25 @@ func inputSpecFromProto(is *cpb.InputSpec) *InputSpec {
 			IsEmptyDirectory: vi.IsEmptyDirectory,
 		})
 	}
+	var ris []*RemoteInput
+	for _, ri := range is.GetRemoteInputs() {
+		ris = append(ris, &RemoteInput{
+			Path:         ri.Path,
+			Digest:       digest.Digest{Hash: ri.Hash, Size: ri.SizeBytes},
+			IsDirectory:  ri.IsDirectory,
+			IsExecutable: ri.IsExecutable,
+		})
+	}
 	return &InputSpec{
 		Inputs:               is.GetInputs(),
 		VirtualInputs:        vis,
+		RemoteInputs:         ris,
 		InputExclusions:      excl,
 		EnvironmentVariables: is.GetEnvironmentVariables(),
 		SymlinkBehavior:      symlinkBehaviorFromProto(is.GetSymlinkBehavior()),
 		InputNodeProperties:  is.GetInputNodeProperties(),
+		InputGlobs:           is.GetInputGlobs(),
+		ExcludeGlobs:         is.GetExcludeGlobs(),
 	}
 }
 
@@ -730,13 +895,26 @@ func inputSpecToProto(is *InputSpec) *cpb.InputSpec {
 			IsEmptyDirectory: vi.IsEmptyDirectory,
 		})
 	}
+	var ris []*cpb.RemoteInput
+	for _, ri := range is.RemoteInputs {
+		ris = append(ris, &cpb.RemoteInput{
+			Path:         ri.Path,
+			Hash:         ri.Digest.Hash,
+			SizeBytes:    ri.Digest.Size,
+			IsDirectory:  ri.IsDirectory,
+			IsExecutable: ri.IsExecutable,
+		})
+	}
 	return &cpb.InputSpec{
 		Inputs:               is.Inputs,
 		VirtualInputs:        vis,
+		RemoteInputs:         ris,
 		ExcludeInputs:        excl,
 		EnvironmentVariables: is.EnvironmentVariables,
 		SymlinkBehavior:      symlinkBehaviorToProto(is.SymlinkBehavior),
 		InputNodeProperties:  is.InputNodeProperties,
+		InputGlobs:           is.InputGlobs,
+		ExcludeGlobs:         is.ExcludeGlobs,
 	}
 }
 
@@ -834,7 +1012,7 @@ func ToProto(cmd *Command) *cpb.Command {
 	cPb := &cpb.Command{
 		ExecRoot:               cmd.ExecRoot,
 		Input:                  inputSpecToProto(cmd.InputSpec),
-		Output:                 &cpb.OutputSpec{OutputFiles: cmd.OutputFiles, OutputDirectories: cmd.OutputDirs},
+		Output:                 &cpb.OutputSpec{OutputFiles: cmd.OutputFiles, OutputDirectories: cmd.OutputDirs, OutputGlobs: cmd.OutputGlobs},
 		Args:                   cmd.Args,
 		ExecutionTimeout:       int32(cmd.Timeout.Seconds()),
 		WorkingDirectory:       cmd.WorkingDir,
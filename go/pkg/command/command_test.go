@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"google.golang.org/protobuf/proto"
@@ -133,6 +134,11 @@ func TestStableId_DifferentCommands(t *testing.T) {
 			A:     &Command{OutputDirs: []string{"a", "b", "c"}},
 			B:     &Command{OutputDirs: []string{"c", "b", "c"}},
 		},
+		{
+			label: "output globs",
+			A:     &Command{OutputGlobs: []string{"*.o"}},
+			B:     &Command{OutputGlobs: []string{"*.a"}},
+		},
 		{
 			label: "platform",
 			A:     &Command{Platform: map[string]string{"a": "1", "b": "2", "c": "3"}},
@@ -294,6 +300,16 @@ func TestValidate_Errors(t *testing.T) {
 				RemoteWorkingDir: "bar/baz",
 			},
 		},
+		{
+			label: "malformed output glob",
+			Command: &Command{
+				Identifiers: &Identifiers{},
+				Args:        []string{"a"},
+				ExecRoot:    "a",
+				InputSpec:   &InputSpec{},
+				OutputGlobs: []string{"../*.o"},
+			},
+		},
 	}
 	for _, tc := range testcases {
 		if err := tc.Command.Validate(); err == nil {
@@ -349,6 +365,16 @@ func TestToREProto(t *testing.T) {
 			cmd:     &Command{OutputDirs: []string{"foo", "bar", "abc"}},
 			wantCmd: &repb.Command{OutputDirectories: []string{"abc", "bar", "foo"}},
 		},
+		{
+			name:    "output glob base directories",
+			cmd:     &Command{OutputDirs: []string{"out"}, OutputGlobs: []string{"out/**/*.o", "lib/*.a", "gen/**", "gen/x/*.h"}},
+			wantCmd: &repb.Command{OutputDirectories: []string{"gen", "lib", "out"}},
+		},
+		{
+			name:    "output glob at working directory",
+			cmd:     &Command{OutputDirs: []string{"out"}, OutputGlobs: []string{"**/*.o"}},
+			wantCmd: &repb.Command{OutputDirectories: []string{"", "out"}},
+		},
 		{
 			name: "sort environment variables",
 			cmd: &Command{
@@ -415,6 +441,16 @@ func TestToREProtoWithOutputPaths(t *testing.T) {
 			cmd:     &Command{OutputDirs: []string{"foo", "bar", "abc"}},
 			wantCmd: &repb.Command{OutputPaths: []string{"abc", "bar", "foo"}},
 		},
+		{
+			name:    "output glob base directories",
+			cmd:     &Command{OutputFiles: []string{"foo"}, OutputDirs: []string{"out"}, OutputGlobs: []string{"out/**/*.o", "lib/*.a", "gen/**", "gen/x/*.h"}},
+			wantCmd: &repb.Command{OutputPaths: []string{"foo", "gen", "lib", "out"}},
+		},
+		{
+			name:    "output glob at working directory",
+			cmd:     &Command{OutputDirs: []string{"out"}, OutputGlobs: []string{"**/*.o"}},
+			wantCmd: &repb.Command{OutputPaths: []string{"", "out"}},
+		},
 		{
 			name: "sort environment variables",
 			cmd: &Command{
@@ -495,8 +531,18 @@ func TestToFromProto(t *testing.T) {
 				"k1": "v1",
 			},
 			SymlinkBehavior: ResolveSymlink,
+			RemoteInputs: []*RemoteInput{
+				&RemoteInput{
+					Path:        "sdk",
+					Digest:      digest.Digest{Hash: "a", Size: 1},
+					IsDirectory: true,
+				},
+			},
+			InputGlobs:   []string{"src/**/*.h"},
+			ExcludeGlobs: []string{"**/testdata"},
 		},
 		OutputFiles: []string{"a/b/out"},
+		OutputGlobs: []string{"gen/**/*.o"},
 	}
 	gotCmd := FromProto(ToProto(cmd))
 	if diff := cmp.Diff(cmd, gotCmd, cmpopts.EquateEmpty()); diff != "" {
@@ -0,0 +1,628 @@
+// Package conformance checks that a Remote Execution API server implements the parts of the API
+// this SDK relies on, and reports which checks failed.
+//
+// The checks only write blobs and action results derived from a random nonce, so they can be run
+// against production instances. They are intended to be run against new backends before switching
+// clients over to them, and are also run against the fake server in the fakes package.
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/client"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pborman/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	// Redundant imports are required for the google3 mirror. Aliases should not be changed.
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	oppb "google.golang.org/genproto/googleapis/longrunning"
+)
+
+// Options configures a conformance run.
+type Options struct {
+	// Tests is the list of names of the checks to run. All checks are run if it is empty.
+	Tests []string
+
+	// SkipExecution skips the checks that execute actions, e.g. for cache-only backends.
+	SkipExecution bool
+
+	// Platform is the platform of the actions executed by the execution checks.
+	Platform map[string]string
+}
+
+// Result is the outcome of a single check.
+type Result struct {
+	// Name is the name of the check.
+	Name string
+
+	// Err is the reason the check failed, or nil if it passed or was skipped.
+	Err error
+
+	// SkipReason is set if the check was not run, e.g. because the server does not advertise the
+	// capability it checks.
+	SkipReason string
+
+	// Duration is how long the check took.
+	Duration time.Duration
+}
+
+// Skipped returns whether the check was skipped.
+func (r *Result) Skipped() bool {
+	return r.SkipReason != ""
+}
+
+type check struct {
+	name string
+	run  func(ctx context.Context, e *env) error
+}
+
+// skipError is returned by a check that cannot run against the server.
+type skipError string
+
+func (s skipError) Error() string {
+	return string(s)
+}
+
+var checks = []*check{
+	{"bytestream_roundtrip", checkByteStreamRoundTrip},
+	{"batch_roundtrip", checkBatchRoundTrip},
+	{"bytestream_zstd", checkByteStreamZstd},
+	{"batch_zstd", checkBatchZstd},
+	{"find_missing_blobs", checkFindMissingBlobs},
+	{"empty_blob", checkEmptyBlob},
+	{"action_cache", checkActionCache},
+	{"execute", checkExecute},
+	{"get_tree", checkGetTree},
+}
+
+// Names returns the names of all the checks, in the order in which they are run.
+func Names() []string {
+	var res []string
+	for _, c := range checks {
+		res = append(res, c.name)
+	}
+	return res
+}
+
+// env is the state shared by the checks of a run.
+type env struct {
+	c     *client.Client
+	caps  *repb.ServerCapabilities
+	opts  *Options
+	nonce string
+	rnd   *rand.Rand
+}
+
+// Run runs the selected checks against the server the client is connected to. It only returns an
+// error if the checks could not be run at all; check failures are reported in the results.
+func Run(ctx context.Context, c *client.Client, opts *Options) ([]*Result, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	var unknown []string
+	for _, name := range opts.Tests {
+		if !contains(Names(), name) {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("unknown conformance tests %v, expected a subset of %v", unknown, Names())
+	}
+	caps, err := c.GetCapabilities(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get server capabilities: %w", err)
+	}
+	e := &env{
+		c:     c,
+		caps:  caps,
+		opts:  opts,
+		nonce: uuid.New(),
+		rnd:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	var results []*Result
+	for _, ch := range checks {
+		if len(opts.Tests) > 0 && !contains(opts.Tests, ch.name) {
+			continue
+		}
+		start := time.Now()
+		err := ch.run(ctx, e)
+		r := &Result{Name: ch.name, Duration: time.Since(start)}
+		var skip skipError
+		if errors.As(err, &skip) {
+			r.SkipReason = string(skip)
+		} else {
+			r.Err = err
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+// Report writes a human readable summary of the results to w and returns the number of failed
+// checks.
+func Report(w io.Writer, results []*Result) int {
+	failed, skipped := 0, 0
+	for _, r := range results {
+		switch {
+		case r.Skipped():
+			skipped++
+			fmt.Fprintf(w, "SKIP %s: %s\n", r.Name, r.SkipReason)
+		case r.Err != nil:
+			failed++
+			fmt.Fprintf(w, "FAIL %s (%v): %v\n", r.Name, r.Duration.Round(time.Millisecond), r.Err)
+		default:
+			fmt.Fprintf(w, "PASS %s (%v)\n", r.Name, r.Duration.Round(time.Millisecond))
+		}
+	}
+	fmt.Fprintf(w, "%d passed, %d failed, %d skipped\n", len(results)-failed-skipped, failed, skipped)
+	return failed
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// blob returns a blob of the given size that was not uploaded by any previous run. Blobs shorter
+// than the nonce are random, but may already exist on the server.
+func (e *env) blob(size int) []byte {
+	b := make([]byte, size)
+	e.rnd.Read(b)
+	copy(b, e.nonce)
+	return b
+}
+
+// compressibleBlob returns a blob of the given size that zstd can compress.
+func (e *env) compressibleBlob(size int) []byte {
+	line := []byte(fmt.Sprintf("conformance %s\n", e.nonce))
+	return bytes.Repeat(line, size/len(line)+1)[:size]
+}
+
+func hasCompressor(cs []repb.Compressor_Value, want repb.Compressor_Value) bool {
+	for _, c := range cs {
+		if c == want {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *env) readResource(dg digest.Digest) string {
+	name, _ := e.c.ResourceName("blobs", dg.Hash, fmt.Sprint(dg.Size))
+	return name
+}
+
+func (e *env) readCompressedResource(dg digest.Digest) string {
+	name, _ := e.c.ResourceName("compressed-blobs", "zstd", dg.Hash, fmt.Sprint(dg.Size))
+	return name
+}
+
+func checkByteStreamRoundTrip(ctx context.Context, e *env) error {
+	// Cover single byte blobs, single chunk blobs, blobs spanning a few chunks and large blobs.
+	sizes := []int{1, 100 * 1024, 2*int(e.c.ChunkMaxSize) + 7, 10 * 1024 * 1024}
+	for _, size := range sizes {
+		b := e.blob(size)
+		dg := digest.NewFromBlob(b)
+		if err := e.c.WriteBytes(ctx, e.c.ResourceNameWrite(dg.Hash, dg.Size), b); err != nil {
+			return fmt.Errorf("writing %d byte blob %v: %w", size, dg, err)
+		}
+		got, err := e.c.ReadBytes(ctx, e.readResource(dg))
+		if err != nil {
+			return fmt.Errorf("reading %d byte blob %v: %w", size, dg, err)
+		}
+		if !bytes.Equal(got, b) {
+			return fmt.Errorf("read of blob %v returned %d bytes with digest %v", dg, len(got), digest.NewFromBlob(got))
+		}
+	}
+	return nil
+}
+
+// batchRoundTrip uploads the blobs in a single batch, reads them back in a single batch and checks
+// the contents.
+func (e *env) batchRoundTrip(ctx context.Context, blobs [][]byte, compressor repb.Compressor_Value) error {
+	want := make(map[digest.Digest][]byte)
+	upReq := &repb.BatchUpdateBlobsRequest{InstanceName: e.c.InstanceName}
+	readReq := &repb.BatchReadBlobsRequest{InstanceName: e.c.InstanceName}
+	for _, b := range blobs {
+		dg := digest.NewFromBlob(b)
+		want[dg] = b
+		data := b
+		if compressor == repb.Compressor_ZSTD {
+			data = zstdEncoder.EncodeAll(b, nil)
+		}
+		upReq.Requests = append(upReq.Requests, &repb.BatchUpdateBlobsRequest_Request{Digest: dg.ToProto(), Data: data, Compressor: compressor})
+		readReq.Digests = append(readReq.Digests, dg.ToProto())
+	}
+	if compressor != repb.Compressor_IDENTITY {
+		readReq.AcceptableCompressors = []repb.Compressor_Value{compressor}
+	}
+	upRes, err := e.c.BatchUpdateBlobs(ctx, upReq)
+	if err != nil {
+		return fmt.Errorf("BatchUpdateBlobs: %w", err)
+	}
+	if len(upRes.Responses) != len(upReq.Requests) {
+		return fmt.Errorf("BatchUpdateBlobs returned %d responses for %d requests", len(upRes.Responses), len(upReq.Requests))
+	}
+	for _, r := range upRes.Responses {
+		if st := status.FromProto(r.Status); st.Code() != codes.OK {
+			return fmt.Errorf("BatchUpdateBlobs of %v: %w", digest.NewFromProtoUnvalidated(r.Digest), st.Err())
+		}
+	}
+	readRes, err := e.c.BatchReadBlobs(ctx, readReq)
+	if err != nil {
+		return fmt.Errorf("BatchReadBlobs: %w", err)
+	}
+	if len(readRes.Responses) != len(readReq.Digests) {
+		return fmt.Errorf("BatchReadBlobs returned %d responses for %d digests", len(readRes.Responses), len(readReq.Digests))
+	}
+	for _, r := range readRes.Responses {
+		dg := digest.NewFromProtoUnvalidated(r.Digest)
+		if st := status.FromProto(r.Status); st.Code() != codes.OK {
+			return fmt.Errorf("BatchReadBlobs of %v: %w", dg, st.Err())
+		}
+		data := r.Data
+		switch r.Compressor {
+		case repb.Compressor_IDENTITY:
+		case repb.Compressor_ZSTD:
+			if data, err = zstdDecoder.DecodeAll(r.Data, nil); err != nil {
+				return fmt.Errorf("BatchReadBlobs returned invalid zstd data for %v: %w", dg, err)
+			}
+		default:
+			return fmt.Errorf("BatchReadBlobs returned %v with unrequested compressor %v", dg, r.Compressor)
+		}
+		b, ok := want[dg]
+		if !ok {
+			return fmt.Errorf("BatchReadBlobs returned unrequested digest %v", dg)
+		}
+		if !bytes.Equal(data, b) {
+			return fmt.Errorf("BatchReadBlobs returned %d bytes with digest %v for %v", len(data), digest.NewFromBlob(data), dg)
+		}
+	}
+	return nil
+}
+
+func checkBatchRoundTrip(ctx context.Context, e *env) error {
+	return e.batchRoundTrip(ctx, [][]byte{e.blob(100), e.blob(1024), e.blob(64 * 1024)}, repb.Compressor_IDENTITY)
+}
+
+var (
+	// zstdEncoder and zstdDecoder are stateless instances, and must only be used with EncodeAll and
+	// DecodeAll.
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+func checkByteStreamZstd(ctx context.Context, e *env) error {
+	if !hasCompressor(e.caps.GetCacheCapabilities().GetSupportedCompressors(), repb.Compressor_ZSTD) {
+		return skipError("server does not advertise zstd support")
+	}
+	b := e.compressibleBlob(3 * int(e.c.ChunkMaxSize))
+	dg := digest.NewFromBlob(b)
+	if err := e.c.WriteBytes(ctx, e.c.ResourceNameCompressedWrite(dg.Hash, dg.Size), zstdEncoder.EncodeAll(b, nil)); err != nil {
+		return fmt.Errorf("writing compressed blob %v: %w", dg, err)
+	}
+	got, err := e.c.ReadBytes(ctx, e.readResource(dg))
+	if err != nil {
+		return fmt.Errorf("reading compressed blob %v uncompressed: %w", dg, err)
+	}
+	if !bytes.Equal(got, b) {
+		return fmt.Errorf("uncompressed read of blob %v returned %d bytes with digest %v", dg, len(got), digest.NewFromBlob(got))
+	}
+	compressed, err := e.c.ReadBytes(ctx, e.readCompressedResource(dg))
+	if err != nil {
+		return fmt.Errorf("reading blob %v compressed: %w", dg, err)
+	}
+	if got, err = zstdDecoder.DecodeAll(compressed, nil); err != nil {
+		return fmt.Errorf("compressed read of blob %v returned invalid zstd data: %w", dg, err)
+	}
+	if !bytes.Equal(got, b) {
+		return fmt.Errorf("compressed read of blob %v returned %d bytes with digest %v", dg, len(got), digest.NewFromBlob(got))
+	}
+	return nil
+}
+
+func checkBatchZstd(ctx context.Context, e *env) error {
+	if !hasCompressor(e.caps.GetCacheCapabilities().GetSupportedBatchUpdateCompressors(), repb.Compressor_ZSTD) {
+		return skipError("server does not advertise zstd support for batch updates")
+	}
+	return e.batchRoundTrip(ctx, [][]byte{e.compressibleBlob(100), e.compressibleBlob(64 * 1024)}, repb.Compressor_ZSTD)
+}
+
+func (e *env) findMissing(ctx context.Context, dgs ...digest.Digest) (map[digest.Digest]bool, error) {
+	req := &repb.FindMissingBlobsRequest{InstanceName: e.c.InstanceName}
+	for _, dg := range dgs {
+		req.BlobDigests = append(req.BlobDigests, dg.ToProto())
+	}
+	res, err := e.c.FindMissingBlobs(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("FindMissingBlobs: %w", err)
+	}
+	requested := make(map[digest.Digest]bool)
+	for _, dg := range dgs {
+		requested[dg] = true
+	}
+	missing := make(map[digest.Digest]bool)
+	for _, d := range res.MissingBlobDigests {
+		dg := digest.NewFromProtoUnvalidated(d)
+		if !requested[dg] {
+			return nil, fmt.Errorf("FindMissingBlobs returned unrequested digest %v", dg)
+		}
+		missing[dg] = true
+	}
+	return missing, nil
+}
+
+func checkFindMissingBlobs(ctx context.Context, e *env) error {
+	if _, err := e.findMissing(ctx); err != nil {
+		return fmt.Errorf("request without digests: %w", err)
+	}
+	present, absent := e.blob(1024), e.blob(1024)
+	presentDg, absentDg := digest.NewFromBlob(present), digest.NewFromBlob(absent)
+	if err := e.c.WriteBytes(ctx, e.c.ResourceNameWrite(presentDg.Hash, presentDg.Size), present); err != nil {
+		return fmt.Errorf("writing blob %v: %w", presentDg, err)
+	}
+	missing, err := e.findMissing(ctx, presentDg, absentDg, absentDg, presentDg)
+	if err != nil {
+		return err
+	}
+	if missing[presentDg] {
+		return fmt.Errorf("uploaded blob %v reported as missing", presentDg)
+	}
+	if !missing[absentDg] {
+		return fmt.Errorf("never uploaded blob %v not reported as missing", absentDg)
+	}
+	return nil
+}
+
+func checkEmptyBlob(ctx context.Context, e *env) error {
+	missing, err := e.findMissing(ctx, digest.Empty)
+	if err != nil {
+		return err
+	}
+	if missing[digest.Empty] {
+		return fmt.Errorf("empty blob reported as missing")
+	}
+	got, err := e.c.ReadBytes(ctx, e.readResource(digest.Empty))
+	if err != nil {
+		return fmt.Errorf("reading the empty blob: %w", err)
+	}
+	if len(got) != 0 {
+		return fmt.Errorf("read of the empty blob returned %d bytes", len(got))
+	}
+	res, err := e.c.BatchReadBlobs(ctx, &repb.BatchReadBlobsRequest{
+		InstanceName: e.c.InstanceName,
+		Digests:      []*repb.Digest{digest.Empty.ToProto()},
+	})
+	if err != nil {
+		return fmt.Errorf("BatchReadBlobs of the empty blob: %w", err)
+	}
+	if len(res.Responses) != 1 {
+		return fmt.Errorf("BatchReadBlobs of the empty blob returned %d responses", len(res.Responses))
+	}
+	if st := status.FromProto(res.Responses[0].Status); st.Code() != codes.OK {
+		return fmt.Errorf("BatchReadBlobs of the empty blob: %w", st.Err())
+	}
+	if len(res.Responses[0].Data) != 0 {
+		return fmt.Errorf("BatchReadBlobs of the empty blob returned %d bytes", len(res.Responses[0].Data))
+	}
+	return nil
+}
+
+func checkActionCache(ctx context.Context, e *env) error {
+	if !e.caps.GetCacheCapabilities().GetActionCacheUpdateCapabilities().GetUpdateEnabled() {
+		return skipError("server does not allow action cache updates")
+	}
+	stdout := e.blob(100)
+	stdoutDg, err := e.c.WriteBlob(ctx, stdout)
+	if err != nil {
+		return fmt.Errorf("writing stdout blob: %w", err)
+	}
+	// The action is never executed, so nothing it references needs to exist.
+	acDg, err := digest.NewFromMessage(&repb.Action{CommandDigest: stdoutDg.ToProto(), Salt: []byte(e.nonce)})
+	if err != nil {
+		return err
+	}
+	_, err = e.c.GetActionResult(ctx, &repb.GetActionResultRequest{InstanceName: e.c.InstanceName, ActionDigest: acDg.ToProto()})
+	if status.Code(err) != codes.NotFound {
+		return fmt.Errorf("GetActionResult of a new action returned %v, want NotFound", err)
+	}
+	ar := &repb.ActionResult{ExitCode: 3, StdoutDigest: stdoutDg.ToProto()}
+	if _, err := e.c.UpdateActionResult(ctx, &repb.UpdateActionResultRequest{InstanceName: e.c.InstanceName, ActionDigest: acDg.ToProto(), ActionResult: ar}); err != nil {
+		return fmt.Errorf("UpdateActionResult: %w", err)
+	}
+	got, err := e.c.GetActionResult(ctx, &repb.GetActionResultRequest{InstanceName: e.c.InstanceName, ActionDigest: acDg.ToProto()})
+	if err != nil {
+		return fmt.Errorf("GetActionResult after UpdateActionResult: %w", err)
+	}
+	if got.ExitCode != ar.ExitCode || !proto.Equal(got.StdoutDigest, ar.StdoutDigest) {
+		return fmt.Errorf("GetActionResult returned %v, want %v", got, ar)
+	}
+	return nil
+}
+
+func checkExecute(ctx context.Context, e *env) error {
+	if e.opts.SkipExecution {
+		return skipError("execution checks disabled")
+	}
+	if !e.caps.GetExecutionCapabilities().GetExecEnabled() {
+		return skipError("server does not support execution")
+	}
+	cmd := &repb.Command{Arguments: []string{"echo", e.nonce}, Platform: &repb.Platform{}}
+	for k, v := range e.opts.Platform {
+		cmd.Platform.Properties = append(cmd.Platform.Properties, &repb.Platform_Property{Name: k, Value: v})
+	}
+	sort.Slice(cmd.Platform.Properties, func(i, j int) bool { return cmd.Platform.Properties[i].Name < cmd.Platform.Properties[j].Name })
+	cmdDg, err := e.c.WriteProto(ctx, cmd)
+	if err != nil {
+		return fmt.Errorf("writing Command: %w", err)
+	}
+	ac := &repb.Action{
+		CommandDigest:   cmdDg.ToProto(),
+		InputRootDigest: digest.Empty.ToProto(),
+		DoNotCache:      true,
+		Salt:            []byte(e.nonce),
+		Platform:        cmd.Platform,
+	}
+	acDg, err := e.c.WriteProto(ctx, ac)
+	if err != nil {
+		return fmt.Errorf("writing Action: %w", err)
+	}
+	op, err := e.c.ExecuteAndWait(ctx, &repb.ExecuteRequest{
+		InstanceName:    e.c.InstanceName,
+		ActionDigest:    acDg.ToProto(),
+		SkipCacheLookup: true,
+	})
+	if err != nil {
+		return fmt.Errorf("executing action %v: %w", acDg, err)
+	}
+	r, ok := op.Result.(*oppb.Operation_Response)
+	if !ok {
+		return fmt.Errorf("execution of action %v finished with %v", acDg, op.Result)
+	}
+	res := &repb.ExecuteResponse{}
+	if err := r.Response.UnmarshalTo(res); err != nil {
+		return fmt.Errorf("operation response is not an ExecuteResponse: %w", err)
+	}
+	if st := status.FromProto(res.Status); st.Code() != codes.OK {
+		return fmt.Errorf("execution of action %v failed: %w", acDg, st.Err())
+	}
+	if res.Result == nil {
+		return fmt.Errorf("execution of action %v returned no ActionResult", acDg)
+	}
+	if res.Result.ExitCode != 0 {
+		return fmt.Errorf("execution of action %v exited with %d", acDg, res.Result.ExitCode)
+	}
+	stdout := res.Result.StdoutRaw
+	if len(stdout) == 0 && res.Result.StdoutDigest != nil {
+		if stdout, _, err = e.c.ReadBlob(ctx, digest.NewFromProtoUnvalidated(res.Result.StdoutDigest)); err != nil {
+			return fmt.Errorf("reading stdout of action %v: %w", acDg, err)
+		}
+	}
+	// Fake servers do not actually run the command, so only check the output if there is one.
+	if len(stdout) > 0 && strings.TrimSpace(string(stdout)) != e.nonce {
+		return fmt.Errorf("execution of action %v printed %q, want %q", acDg, stdout, e.nonce)
+	}
+	return nil
+}
+
+// getTree reads all the pages of the tree rooted at dg, and returns the directories and the number
+// of pages read.
+func (e *env) getTree(ctx context.Context, dg digest.Digest, pageSize int32) ([]*repb.Directory, int, error) {
+	var dirs []*repb.Directory
+	pages := 0
+	token := ""
+	for {
+		stream, err := e.c.GetTree(ctx, &repb.GetTreeRequest{
+			InstanceName: e.c.InstanceName,
+			RootDigest:   dg.ToProto(),
+			PageSize:     pageSize,
+			PageToken:    token,
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+		token = ""
+		for {
+			resp, err := stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, 0, err
+			}
+			pages++
+			if pageSize > 0 && len(resp.Directories) > int(pageSize) {
+				return nil, 0, fmt.Errorf("GetTree returned a page of %d directories for page size %d", len(resp.Directories), pageSize)
+			}
+			dirs = append(dirs, resp.Directories...)
+			token = resp.NextPageToken
+		}
+		// A server may end the stream before the tree is complete, in which case the last page
+		// token is used to resume in a new call.
+		if token == "" {
+			return dirs, pages, nil
+		}
+		if pages > 10000 {
+			return nil, 0, fmt.Errorf("GetTree did not finish after %d pages", pages)
+		}
+	}
+}
+
+func checkGetTree(ctx context.Context, e *env) error {
+	// Build a tree of depth 3 with 3 children per directory, and a file in every directory that
+	// makes the tree unique.
+	blobs := make(map[digest.Digest][]byte)
+	var mkDir func(depth int, name string) (digest.Digest, error)
+	mkDir = func(depth int, name string) (digest.Digest, error) {
+		content := []byte(e.nonce + "/" + name)
+		fileDg := digest.NewFromBlob(content)
+		blobs[fileDg] = content
+		dir := &repb.Directory{Files: []*repb.FileNode{{Name: "file", Digest: fileDg.ToProto()}}}
+		if depth > 0 {
+			for i := 0; i < 3; i++ {
+				sub := fmt.Sprintf("dir%d", i)
+				dg, err := mkDir(depth-1, name+"/"+sub)
+				if err != nil {
+					return digest.Digest{}, err
+				}
+				dir.Directories = append(dir.Directories, &repb.DirectoryNode{Name: sub, Digest: dg.ToProto()})
+			}
+		}
+		b, err := proto.Marshal(dir)
+		if err != nil {
+			return digest.Digest{}, err
+		}
+		dg := digest.NewFromBlob(b)
+		blobs[dg] = b
+		return dg, nil
+	}
+	root, err := mkDir(2, "root")
+	if err != nil {
+		return err
+	}
+	const numDirs = 1 + 3 + 9
+	if err := e.c.WriteBlobs(ctx, blobs); err != nil {
+		return fmt.Errorf("writing tree: %w", err)
+	}
+
+	for _, pageSize := range []int32{0, 4} {
+		dirs, pages, err := e.getTree(ctx, root, pageSize)
+		if err != nil {
+			return fmt.Errorf("GetTree with page size %d: %w", pageSize, err)
+		}
+		got := make(map[digest.Digest]bool)
+		for _, d := range dirs {
+			dg, err := digest.NewFromMessage(d)
+			if err != nil {
+				return err
+			}
+			if _, ok := blobs[dg]; !ok {
+				return fmt.Errorf("GetTree with page size %d returned unknown directory %v", pageSize, dg)
+			}
+			got[dg] = true
+		}
+		if len(got) != numDirs {
+			return fmt.Errorf("GetTree with page size %d returned %d distinct directories, want %d", pageSize, len(got), numDirs)
+		}
+		if pageSize > 0 && pages < (numDirs+int(pageSize)-1)/int(pageSize) {
+			return fmt.Errorf("GetTree with page size %d returned %d pages, want at least %d", pageSize, pages, (numDirs+int(pageSize)-1)/int(pageSize))
+		}
+	}
+	return nil
+}
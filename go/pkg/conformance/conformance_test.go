@@ -0,0 +1,75 @@
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/fakes"
+)
+
+func TestRunAgainstFake(t *testing.T) {
+	ctx := context.Background()
+	s, err := fakes.NewServer(t)
+	if err != nil {
+		t.Fatalf("Error starting fake server: %v", err)
+	}
+	defer s.Stop()
+	c, err := s.NewTestClient(ctx)
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer c.Close()
+
+	results, err := Run(ctx, c, nil)
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	if len(results) != len(Names()) {
+		t.Errorf("Run() returned %d results, want %d", len(results), len(Names()))
+	}
+	for _, r := range results {
+		if r.Err != nil || r.Skipped() {
+			t.Errorf("check %s: err=%v, skipped=%q", r.Name, r.Err, r.SkipReason)
+		}
+	}
+	buf := &bytes.Buffer{}
+	if failed := Report(buf, results); failed != 0 {
+		t.Errorf("Report() = %d failed, want 0", failed)
+	}
+	if !strings.Contains(buf.String(), "0 failed, 0 skipped") {
+		t.Errorf("Report() output %q does not contain the summary", buf.String())
+	}
+}
+
+func TestRunSelectedTests(t *testing.T) {
+	ctx := context.Background()
+	s, err := fakes.NewServer(t)
+	if err != nil {
+		t.Fatalf("Error starting fake server: %v", err)
+	}
+	defer s.Stop()
+	c, err := s.NewTestClient(ctx)
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer c.Close()
+
+	results, err := Run(ctx, c, &Options{Tests: []string{"empty_blob", "execute"}, SkipExecution: true})
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Run() returned %d results, want 2", len(results))
+	}
+	if r := results[0]; r.Name != "empty_blob" || r.Err != nil || r.Skipped() {
+		t.Errorf("results[0] = %+v, want passing empty_blob", r)
+	}
+	if r := results[1]; r.Name != "execute" || !r.Skipped() {
+		t.Errorf("results[1] = %+v, want skipped execute", r)
+	}
+	if _, err := Run(ctx, c, &Options{Tests: []string{"no_such_test"}}); err == nil {
+		t.Errorf("Run() with an unknown test succeeded, want error")
+	}
+}
@@ -19,6 +19,7 @@ type ActionCache struct {
 	results map[digest.Digest]*repb.ActionResult
 	reads   map[digest.Digest]int
 	writes  map[digest.Digest]int
+	err     error
 }
 
 // NewActionCache returns a new empty ActionCache.
@@ -35,6 +36,15 @@ func (c *ActionCache) Clear() {
 	c.results = make(map[digest.Digest]*repb.ActionResult)
 	c.reads = make(map[digest.Digest]int)
 	c.writes = make(map[digest.Digest]int)
+	c.err = nil
+}
+
+// SetError makes all subsequent calls fail with the given error, e.g. to simulate an unavailable
+// cache. A nil error restores normal operation.
+func (c *ActionCache) SetError(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.err = err
 }
 
 // PutAction sets a fake result for a given action, and returns the action digest.
@@ -82,6 +92,9 @@ func (c *ActionCache) GetActionResult(ctx context.Context, req *repb.GetActionRe
 		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("invalid digest received: %v", req.ActionDigest))
 	}
 	c.reads[dg]++
+	if c.err != nil {
+		return nil, c.err
+	}
 	if res, ok := c.results[dg]; ok {
 		return res, nil
 	}
@@ -99,6 +112,9 @@ func (c *ActionCache) UpdateActionResult(ctx context.Context, req *repb.UpdateAc
 	if req.ActionResult == nil {
 		return nil, status.Error(codes.InvalidArgument, "no action result received")
 	}
+	if c.err != nil {
+		return nil, c.err
+	}
 	c.results[dg] = req.ActionResult
 	c.writes[dg]++
 	return req.ActionResult, nil
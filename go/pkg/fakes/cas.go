@@ -3,7 +3,6 @@ package fakes
 import (
 	"bytes"
 	"context"
-	"fmt"
 	"io"
 	"math/rand"
 	"strconv"
@@ -271,6 +270,7 @@ type CAS struct {
 	reads             map[digest.Digest]int
 	writes            map[digest.Digest]int
 	missingReqs       map[digest.Digest]int
+	corruptions       map[digest.Digest]int
 	mu                sync.RWMutex
 	batchReqs         int
 	writeReqs         int
@@ -300,6 +300,7 @@ func (f *CAS) Clear() {
 	f.reads = make(map[digest.Digest]int)
 	f.writes = make(map[digest.Digest]int)
 	f.missingReqs = make(map[digest.Digest]int)
+	f.corruptions = make(map[digest.Digest]int)
 	f.batchReqs = 0
 	f.writeReqs = 0
 	f.concReqs = 0
@@ -315,6 +316,13 @@ func (f *CAS) Put(blob []byte) digest.Digest {
 	return d
 }
 
+// Delete removes the blob with the given digest from the cache.
+func (f *CAS) Delete(d digest.Digest) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.blobs, d)
+}
+
 // Get returns the bytes corresponding to the given digest, and whether it was found.
 func (f *CAS) Get(d digest.Digest) ([]byte, bool) {
 	f.mu.RLock()
@@ -323,6 +331,25 @@ func (f *CAS) Get(d digest.Digest) ([]byte, bool) {
 	return res, ok
 }
 
+// Corrupt makes the next given number of reads of the blob with the given digest return corrupted contents.
+func (f *CAS) Corrupt(d digest.Digest, reads int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.corruptions[d] = reads
+}
+
+// maybeCorrupt returns a corrupted copy of the blob if its next read was requested to be corrupted.
+// It must be called with f.mu held.
+func (f *CAS) maybeCorrupt(d digest.Digest, blob []byte) []byte {
+	if f.corruptions[d] == 0 || len(blob) == 0 {
+		return blob
+	}
+	f.corruptions[d]--
+	corrupted := append([]byte(nil), blob...)
+	corrupted[0] ^= 0xff
+	return corrupted
+}
+
 // BlobReads returns the total number of read requests for a particular digest.
 func (f *CAS) BlobReads(d digest.Digest) int {
 	f.mu.RLock()
@@ -504,6 +531,7 @@ func (f *CAS) BatchReadBlobs(ctx context.Context, req *repb.BatchReadBlobsReques
 		}
 		f.mu.Lock()
 		f.reads[dg]++
+		data = f.maybeCorrupt(dg, data)
 		f.mu.Unlock()
 
 		useZSTDCompression := false
@@ -528,62 +556,67 @@ func (f *CAS) BatchReadBlobs(ctx context.Context, req *repb.BatchReadBlobsReques
 	return &repb.BatchReadBlobsResponse{Responses: resps}, nil
 }
 
-// GetTree implements the corresponding RE API function.
+// GetTree implements the corresponding RE API function. Directories are returned in breadth-first
+// order, starting with the root. If the request sets a page size, the response is paginated, and
+// the page token is the index of the first directory of the next page.
 func (f *CAS) GetTree(req *repb.GetTreeRequest, stream regrpc.ContentAddressableStorage_GetTreeServer) error {
 	f.maybeSleep()
 	rootDigest, err := digest.NewFromProto(req.RootDigest)
 	if err != nil {
-		return fmt.Errorf("unable to parsse root digest %v", req.RootDigest)
+		return status.Errorf(codes.InvalidArgument, "unable to parse root digest %v", req.RootDigest)
 	}
-	blob, ok := f.Get(rootDigest)
-	if !ok {
-		return fmt.Errorf("root digest %v not found", rootDigest)
+	start := 0
+	if req.PageToken != "" {
+		if start, err = strconv.Atoi(req.PageToken); err != nil || start < 0 {
+			return status.Errorf(codes.InvalidArgument, "invalid page token %q", req.PageToken)
+		}
 	}
-	rootDir := &repb.Directory{}
-	proto.Unmarshal(blob, rootDir)
 
-	res := []*repb.Directory{rootDir}
-	queue := []*repb.Directory{rootDir}
+	var res []*repb.Directory
+	queue := []digest.Digest{rootDigest}
 	for len(queue) > 0 {
-		ele := queue[0]
-		res = append(res, ele)
+		dg := queue[0]
 		queue = queue[1:]
-
-		for _, inpFile := range ele.GetFiles() {
-			fd, err := digest.NewFromProto(inpFile.GetDigest())
-			if err != nil {
-				return fmt.Errorf("unable to parse file digest %v", inpFile.GetDigest())
-			}
-			blob, ok := f.Get(fd)
-			if !ok {
-				return fmt.Errorf("file digest %v not found", fd)
-			}
-			dir := &repb.Directory{}
-			proto.Unmarshal(blob, dir)
-			queue = append(queue, dir)
-			res = append(res, dir)
+		blob, ok := f.Get(dg)
+		if !ok {
+			return status.Errorf(codes.NotFound, "directory digest %v not found", dg)
 		}
-
-		for _, dir := range ele.GetDirectories() {
-			fd, err := digest.NewFromProto(dir.GetDigest())
+		dir := &repb.Directory{}
+		if err := proto.Unmarshal(blob, dir); err != nil {
+			return status.Errorf(codes.InvalidArgument, "blob %v is not a Directory: %v", dg, err)
+		}
+		res = append(res, dir)
+		for _, sub := range dir.GetDirectories() {
+			subDg, err := digest.NewFromProto(sub.GetDigest())
 			if err != nil {
-				return fmt.Errorf("unable to parse directory digest %v", dir.GetDigest())
-			}
-			blob, ok := f.Get(fd)
-			if !ok {
-				return fmt.Errorf("directory digest %v not found", fd)
+				return status.Errorf(codes.InvalidArgument, "unable to parse directory digest %v", sub.GetDigest())
 			}
-			directory := &repb.Directory{}
-			proto.Unmarshal(blob, directory)
-			queue = append(queue, directory)
-			res = append(res, directory)
+			queue = append(queue, subDg)
 		}
 	}
-
-	resp := &repb.GetTreeResponse{
-		Directories: res,
+	if start > len(res) {
+		return status.Errorf(codes.InvalidArgument, "page token %q out of range", req.PageToken)
+	}
+	res = res[start:]
+	if req.PageSize <= 0 {
+		return stream.Send(&repb.GetTreeResponse{Directories: res})
 	}
-	return stream.Send(resp)
+	for len(res) > 0 {
+		n := int(req.PageSize)
+		if n > len(res) {
+			n = len(res)
+		}
+		resp := &repb.GetTreeResponse{Directories: res[:n]}
+		start += n
+		res = res[n:]
+		if len(res) > 0 {
+			resp.NextPageToken = strconv.Itoa(start)
+		}
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Write implements the corresponding RE API function.
@@ -727,6 +760,9 @@ func (f *CAS) Read(req *bspb.ReadRequest, stream bsgrpc.ByteStream_ReadServer) e
 	blob, ok := f.blobs[dg]
 	f.mu.Lock()
 	f.reads[dg]++
+	if ok {
+		blob = f.maybeCorrupt(dg, blob)
+	}
 	f.mu.Unlock()
 	if !ok {
 		return status.Errorf(codes.NotFound, "test fake missing blob with digest %s was requested", dg)
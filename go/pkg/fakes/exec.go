@@ -3,8 +3,11 @@ package fakes
 import (
 	"context"
 	"fmt"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/client"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
@@ -19,8 +22,11 @@ import (
 	anypb "google.golang.org/protobuf/types/known/anypb"
 )
 
-// Exec implements the complete RE execution interface for a single execution, returning a fixed
-// result or an error.
+// Exec implements the complete RE execution interface.
+//
+// By default it models a single execution, returning a fixed result or an error. Distinct results
+// for many different actions can be added with AddResult, in which case the fake can serve any
+// number of concurrent executions.
 type Exec struct {
 	// Execution will check the action cache first, and update the action cache upon completion.
 	ac *ActionCache
@@ -45,8 +51,84 @@ type Exec struct {
 	numExecCalls int32
 	// Used for errors.
 	t testing.TB
-	// The digest of the fake action.
+	// The digest of the fake action. If unset, any action in the CAS is executed.
 	adg digest.Digest
+
+	mu          sync.Mutex
+	rules       []*execRule
+	calls       []*ExecCall
+	inFlight    int
+	maxInFlight int
+}
+
+// ExecResult is the fake result of the executions of the actions matched by an ActionMatcher.
+type ExecResult struct {
+	// The returned completed result, if any.
+	ActionResult *repb.ActionResult
+	// Returned completed execution status, if not Ok.
+	Status *status.Status
+	// Whether the action was fake-fetched from the action cache upon execution.
+	Cached bool
+	// Any blobs that will be put in the CAS after the fake execution completes.
+	OutputBlobs [][]byte
+	// How long the execution stays in the QUEUED stage before it starts executing.
+	QueuedDelay time.Duration
+	// How long the execution stays in the EXECUTING stage before it completes.
+	ExecutingDelay time.Duration
+}
+
+// ActionMatcher decides whether an ExecResult applies to an action. The command is nil if it is not
+// in the CAS.
+type ActionMatcher func(dg digest.Digest, ac *repb.Action, cmd *repb.Command) bool
+
+// MatchDigest matches the action with the given digest.
+func MatchDigest(want digest.Digest) ActionMatcher {
+	return func(dg digest.Digest, _ *repb.Action, _ *repb.Command) bool {
+		return dg == want
+	}
+}
+
+// MatchArgs matches actions whose command has exactly the given arguments.
+func MatchArgs(args ...string) ActionMatcher {
+	return func(_ digest.Digest, _ *repb.Action, cmd *repb.Command) bool {
+		return cmd != nil && equalStrings(cmd.Arguments, args)
+	}
+}
+
+// MatchArgsPrefix matches actions whose command arguments start with the given prefix.
+func MatchArgsPrefix(prefix ...string) ActionMatcher {
+	return func(_ digest.Digest, _ *repb.Action, cmd *repb.Command) bool {
+		return cmd != nil && len(cmd.Arguments) >= len(prefix) && equalStrings(cmd.Arguments[:len(prefix)], prefix)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+type execRule struct {
+	match ActionMatcher
+	res   *ExecResult
+}
+
+// ExecCall is a record of a single Execute call received by the fake.
+type ExecCall struct {
+	// ActionDigest is the digest of the executed action.
+	ActionDigest digest.Digest
+	// Start is the time at which the call was received.
+	Start time.Time
+	// End is the time at which the call completed, or zero if it is still in progress.
+	End time.Time
+	// InFlight is the number of Execute calls in progress when the call started, including itself.
+	InFlight int
 }
 
 // NewExec returns a new empty Exec.
@@ -56,13 +138,27 @@ func NewExec(t testing.TB, ac *ActionCache, cas *CAS) *Exec {
 	return c
 }
 
-// Clear removes all preset results from the fake.
+// Clear removes all preset results from the fake, and forgets all recorded calls.
 func (s *Exec) Clear() {
 	s.ActionResult = nil
 	s.Status = nil
 	s.Cached = false
 	s.OutputBlobs = nil
 	atomic.StoreInt32(&s.numExecCalls, 0)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rules = nil
+	s.calls = nil
+	s.maxInFlight = 0
+}
+
+// AddResult makes executions of the actions matched by match return the given result. Matchers are
+// tried in the order in which they were added; actions that no matcher accepts get the result set
+// directly on the Exec fields.
+func (s *Exec) AddResult(match ActionMatcher, res *ExecResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rules = append(s.rules, &execRule{match: match, res: res})
 }
 
 // ExecuteCalls returns the total number of Execute calls.
@@ -70,14 +166,88 @@ func (s *Exec) ExecuteCalls() int {
 	return int(atomic.LoadInt32(&s.numExecCalls))
 }
 
+// Calls returns the Execute calls received so far, in the order in which they started.
+func (s *Exec) Calls() []ExecCall {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make([]ExecCall, len(s.calls))
+	for i, c := range s.calls {
+		res[i] = *c
+	}
+	return res
+}
+
+// MaxInFlight returns the highest number of concurrent Execute calls observed.
+func (s *Exec) MaxInFlight() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.maxInFlight
+}
+
+func (s *Exec) startCall(dg digest.Digest) *ExecCall {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inFlight++
+	if s.inFlight > s.maxInFlight {
+		s.maxInFlight = s.inFlight
+	}
+	c := &ExecCall{ActionDigest: dg, Start: time.Now(), InFlight: s.inFlight}
+	s.calls = append(s.calls, c)
+	return c
+}
+
+func (s *Exec) endCall(c *ExecCall) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inFlight--
+	c.End = time.Now()
+}
+
+const fakeOPPrefix = "fake-action-"
+
 func fakeOPName(adg digest.Digest) string {
-	return "fake-action-" + adg.String()
+	return fakeOPPrefix + adg.String()
 }
 
-func (s *Exec) fakeExecution(dg digest.Digest, skipCacheLookup bool) (*oppb.Operation, error) {
-	ar := s.ActionResult
-	st := s.Status
-	cached := s.Cached
+// result returns the fake result for the action with the given digest.
+func (s *Exec) result(dg digest.Digest) (*ExecResult, error) {
+	s.mu.Lock()
+	rules := s.rules
+	s.mu.Unlock()
+	if len(rules) > 0 {
+		apb := &repb.Action{}
+		var cmd *repb.Command
+		if blob, ok := s.cas.Get(dg); ok && proto.Unmarshal(blob, apb) == nil {
+			if cdg, err := digest.NewFromProto(apb.CommandDigest); err == nil {
+				if blob, ok := s.cas.Get(cdg); ok {
+					cmd = &repb.Command{}
+					if err := proto.Unmarshal(blob, cmd); err != nil {
+						cmd = nil
+					}
+				}
+			}
+		}
+		for _, r := range rules {
+			if r.match(dg, apb, cmd) {
+				return r.res, nil
+			}
+		}
+	}
+	if s.adg != (digest.Digest{}) && dg != s.adg {
+		return nil, fmt.Errorf("unexpected action digest received by fake: expected %v, got %v", s.adg, dg)
+	}
+	return &ExecResult{
+		ActionResult: s.ActionResult,
+		Status:       s.Status,
+		Cached:       s.Cached,
+		OutputBlobs:  s.OutputBlobs,
+	}, nil
+}
+
+func (s *Exec) fakeExecution(dg digest.Digest, res *ExecResult, skipCacheLookup bool) (*oppb.Operation, error) {
+	ar := res.ActionResult
+	st := res.Status
+	cached := res.Cached
 	// Check action cache first, unless instructed not to.
 	if !skipCacheLookup {
 		cr := s.ac.Get(dg)
@@ -96,10 +266,13 @@ func (s *Exec) fakeExecution(dg digest.Digest, skipCacheLookup bool) (*oppb.Oper
 	if err := proto.Unmarshal(blob, apb); err != nil {
 		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("error unmarshalling %v as Action", blob))
 	}
-	if !apb.DoNotCache {
+	if ar == nil && st == nil {
+		ar = &repb.ActionResult{}
+	}
+	if !apb.DoNotCache && ar != nil {
 		s.ac.Put(dg, ar)
 	}
-	for _, out := range s.OutputBlobs {
+	for _, out := range res.OutputBlobs {
 		s.cas.Put(out)
 	}
 	execResp := &repb.ExecuteResponse{
@@ -131,13 +304,32 @@ func (s *Exec) GetCapabilities(ctx context.Context, req *repb.GetCapabilitiesReq
 			ActionCacheUpdateCapabilities: &repb.ActionCacheUpdateCapabilities{
 				UpdateEnabled: true,
 			},
-			MaxBatchTotalSizeBytes:      client.DefaultMaxBatchSize,
-			SymlinkAbsolutePathStrategy: repb.SymlinkAbsolutePathStrategy_DISALLOWED,
+			MaxBatchTotalSizeBytes:          client.DefaultMaxBatchSize,
+			SymlinkAbsolutePathStrategy:     repb.SymlinkAbsolutePathStrategy_DISALLOWED,
+			SupportedCompressors:            []repb.Compressor_Value{repb.Compressor_ZSTD},
+			SupportedBatchUpdateCompressors: []repb.Compressor_Value{repb.Compressor_ZSTD},
 		},
 	}
 	return res, nil
 }
 
+// sendStage sends an in progress operation in the given stage and waits for the delay.
+func sendStage(stream regrpc.Execution_ExecuteServer, dg digest.Digest, stage repb.ExecutionStage_Value, delay time.Duration) error {
+	md, err := anypb.New(&repb.ExecuteOperationMetadata{Stage: stage, ActionDigest: dg.ToProto()})
+	if err != nil {
+		return err
+	}
+	if err := stream.Send(&oppb.Operation{Name: fakeOPName(dg), Metadata: md}); err != nil {
+		return err
+	}
+	select {
+	case <-time.After(delay):
+		return nil
+	case <-stream.Context().Done():
+		return status.FromContextError(stream.Context().Err()).Err()
+	}
+}
+
 // Execute returns the saved result ActionResult, or a Status. It also puts it in the action cache
 // unless the execute request specified
 func (s *Exec) Execute(req *repb.ExecuteRequest, stream regrpc.Execution_ExecuteServer) (err error) {
@@ -145,8 +337,11 @@ func (s *Exec) Execute(req *repb.ExecuteRequest, stream regrpc.Execution_Execute
 	if err != nil {
 		return status.Error(codes.InvalidArgument, fmt.Sprintf("invalid digest received: %v", req.ActionDigest))
 	}
-	if dg != s.adg {
-		s.t.Errorf("unexpected action digest received by fake: expected %v, got %v", s.adg, dg)
+	call := s.startCall(dg)
+	defer s.endCall(call)
+	res, err := s.result(dg)
+	if err != nil {
+		s.t.Errorf("%v", err)
 		return status.Error(codes.InvalidArgument, fmt.Sprintf("unexpected digest received: %v", req.ActionDigest))
 	}
 	if s.StdOutStreamName != "" || s.StdErrStreamName != "" {
@@ -161,7 +356,17 @@ func (s *Exec) Execute(req *repb.ExecuteRequest, stream regrpc.Execution_Execute
 			return err
 		}
 	}
-	if op, err := s.fakeExecution(dg, req.SkipCacheLookup); err != nil {
+	if res.QueuedDelay > 0 {
+		if err := sendStage(stream, dg, repb.ExecutionStage_QUEUED, res.QueuedDelay); err != nil {
+			return err
+		}
+	}
+	if res.ExecutingDelay > 0 {
+		if err := sendStage(stream, dg, repb.ExecutionStage_EXECUTING, res.ExecutingDelay); err != nil {
+			return err
+		}
+	}
+	if op, err := s.fakeExecution(dg, res, req.SkipCacheLookup); err != nil {
 		return err
 	} else if err = stream.Send(op); err != nil {
 		return err
@@ -171,10 +376,15 @@ func (s *Exec) Execute(req *repb.ExecuteRequest, stream regrpc.Execution_Execute
 }
 
 func (s *Exec) WaitExecution(req *repb.WaitExecutionRequest, stream regrpc.Execution_WaitExecutionServer) (err error) {
-	if req.Name != fakeOPName(s.adg) {
+	dg, err := digest.NewFromString(strings.TrimPrefix(req.Name, fakeOPPrefix))
+	if err != nil || !strings.HasPrefix(req.Name, fakeOPPrefix) {
+		return status.Errorf(codes.NotFound, "requested operation %v not found", req.Name)
+	}
+	res, err := s.result(dg)
+	if err != nil {
 		return status.Errorf(codes.NotFound, "requested operation %v not found", req.Name)
 	}
-	if op, err := s.fakeExecution(s.adg, true); err != nil {
+	if op, err := s.fakeExecution(dg, res, true); err != nil {
 		return err
 	} else {
 		return stream.Send(op)
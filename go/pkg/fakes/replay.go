@@ -0,0 +1,196 @@
+package fakes
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/recorder"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoregistry"
+
+	// Redundant imports are required for the google3 mirror. Aliases should not be changed.
+	rc "github.com/bazelbuild/remote-apis-sdks/go/pkg/client"
+	bspb "google.golang.org/genproto/googleapis/bytestream"
+)
+
+// Replay is a fake gRPC server that serves the RPCs of a recording made by the recorder package.
+//
+// Incoming calls are matched to recorded calls by method and first request message. ByteStream
+// upload UUIDs are ignored when matching, as they are random for every upload. When several
+// recorded calls match, they are served in the order in which they were originally started, which
+// makes the replay deterministic regardless of the order in which concurrent calls arrive.
+type Replay struct {
+	mu       sync.Mutex
+	pending  map[string][]*recorder.Entry
+	reqTypes map[string]string
+	listener net.Listener
+	srv      *grpc.Server
+}
+
+// NewReplayServer starts a server replaying the recording at the given path.
+func NewReplayServer(path string) (*Replay, error) {
+	entries, err := recorder.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewReplayServerFromEntries(entries)
+}
+
+// NewReplayServerFromEntries starts a server replaying the given recorded entries.
+func NewReplayServerFromEntries(entries []*recorder.Entry) (r *Replay, err error) {
+	r = &Replay{
+		pending:  make(map[string][]*recorder.Entry),
+		reqTypes: make(map[string]string),
+	}
+	for _, e := range entries {
+		if len(e.Requests) == 0 {
+			// The call failed before sending anything, so it cannot be matched.
+			continue
+		}
+		typeURL, err := e.Requests[0].TypeURL()
+		if err != nil {
+			return nil, fmt.Errorf("invalid request in recorded %s call %d: %v", e.Method, e.Seq, err)
+		}
+		req, err := e.Requests[0].Proto()
+		if err != nil {
+			return nil, fmt.Errorf("invalid request in recorded %s call %d: %v", e.Method, e.Seq, err)
+		}
+		r.reqTypes[e.Method] = typeURL
+		k, err := replayKey(e.Method, req)
+		if err != nil {
+			return nil, err
+		}
+		r.pending[k] = append(r.pending[k], e)
+	}
+	r.listener, err = net.Listen("tcp", ":0")
+	if err != nil {
+		return nil, err
+	}
+	r.srv = grpc.NewServer(grpc.UnknownServiceHandler(r.handle))
+	go r.srv.Serve(r.listener)
+	return r, nil
+}
+
+// Stop shuts down the server.
+func (r *Replay) Stop() {
+	r.listener.Close()
+	r.srv.Stop()
+}
+
+// Addr returns the address the server is listening on.
+func (r *Replay) Addr() string {
+	return r.listener.Addr().String()
+}
+
+// NewTestClient returns a new Client connected to this server. The instance name must match the
+// one used in the recording.
+func (r *Replay) NewTestClient(ctx context.Context, instanceName string, opts ...rc.Opt) (*rc.Client, error) {
+	return rc.NewClient(ctx, instanceName, rc.DialParams{Service: r.Addr(), NoSecurity: true}, opts...)
+}
+
+// Unreplayed returns the number of recorded calls that have not been served yet.
+func (r *Replay) Unreplayed() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, es := range r.pending {
+		n += len(es)
+	}
+	return n
+}
+
+func (r *Replay) handle(_ interface{}, stream grpc.ServerStream) error {
+	method, ok := grpc.MethodFromServerStream(stream)
+	if !ok {
+		return status.Error(codes.Internal, "replay fake could not determine the called method")
+	}
+	r.mu.Lock()
+	typeURL, ok := r.reqTypes[method]
+	r.mu.Unlock()
+	if !ok {
+		return status.Errorf(codes.Unimplemented, "recording contains no %s calls", method)
+	}
+	mt, err := protoregistry.GlobalTypes.FindMessageByURL(typeURL)
+	if err != nil {
+		return status.Errorf(codes.Internal, "unknown recorded request type %q: %v", typeURL, err)
+	}
+	// Client-streaming calls are matched on their first request, but the whole stream is consumed
+	// before responding, as the original server would have done.
+	var first proto.Message
+	for {
+		req := mt.New().Interface()
+		if err := stream.RecvMsg(req); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if first == nil {
+			first = req
+		}
+	}
+	if first == nil {
+		return status.Errorf(codes.InvalidArgument, "replay fake received no request for %s", method)
+	}
+	k, err := replayKey(method, first)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	r.mu.Lock()
+	es := r.pending[k]
+	if len(es) == 0 {
+		r.mu.Unlock()
+		return status.Errorf(codes.FailedPrecondition, "no recorded %s call matches request %v", method, first)
+	}
+	e := es[0]
+	r.pending[k] = es[1:]
+	r.mu.Unlock()
+
+	for _, m := range e.Responses {
+		if m.DataDigest != "" {
+			return status.Errorf(codes.FailedPrecondition, "recorded %s call %d does not contain its payload (recorded with payload hashing)", method, e.Seq)
+		}
+		resp, err := m.Proto()
+		if err != nil {
+			return status.Errorf(codes.Internal, "invalid response in recorded %s call %d: %v", method, e.Seq, err)
+		}
+		if err := stream.SendMsg(resp); err != nil {
+			return err
+		}
+	}
+	return e.Err()
+}
+
+// replayKey returns the key used to match a call to its recording.
+func replayKey(method string, req proto.Message) (string, error) {
+	if wr, ok := req.(*bspb.WriteRequest); ok {
+		wr = proto.Clone(wr).(*bspb.WriteRequest)
+		wr.Data = nil
+		wr.ResourceName = stripUploadUUID(wr.ResourceName)
+		req = wr
+	}
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal %s request: %v", method, err)
+	}
+	return method + "\x00" + string(b), nil
+}
+
+// stripUploadUUID removes the random UUID from a resource name of the form
+// "[instance/]uploads/<uuid>/blobs/...".
+func stripUploadUUID(name string) string {
+	segs := strings.Split(name, "/")
+	for i := 0; i+1 < len(segs); i++ {
+		if segs[i] == "uploads" {
+			segs[i+1] = ""
+			break
+		}
+	}
+	return strings.Join(segs, "/")
+}
@@ -6,6 +6,7 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"testing"
 	"time"
@@ -42,15 +43,21 @@ type Server struct {
 }
 
 // NewServer creates a server that is ready to accept requests.
-func NewServer(t testing.TB) (s *Server, err error) {
-	cas := NewCAS()
-	ls := NewLogStreams()
-	ac := NewActionCache()
-	s = &Server{Exec: NewExec(t, ac, cas), CAS: cas, LogStreams: ls, ActionCache: ac}
-	s.listener, err = net.Listen("tcp", ":0")
+func NewServer(t testing.TB) (*Server, error) {
+	lis, err := net.Listen("tcp", ":0")
 	if err != nil {
 		return nil, err
 	}
+	return NewServerWithListener(t, lis), nil
+}
+
+// NewServerWithListener creates a server that accepts requests on the given listener, e.g. one on a
+// Unix domain socket. The server takes ownership of the listener.
+func NewServerWithListener(t testing.TB, lis net.Listener) *Server {
+	cas := NewCAS()
+	ls := NewLogStreams()
+	ac := NewActionCache()
+	s := &Server{Exec: NewExec(t, ac, cas), CAS: cas, LogStreams: ls, ActionCache: ac, listener: lis}
 	s.srv = grpc.NewServer()
 	bsgrpc.RegisterByteStreamServer(s.srv, s)
 	regrpc.RegisterContentAddressableStorageServer(s.srv, s.CAS)
@@ -58,7 +65,7 @@ func NewServer(t testing.TB) (s *Server, err error) {
 	regrpc.RegisterCapabilitiesServer(s.srv, s.Exec)
 	regrpc.RegisterExecutionServer(s.srv, s.Exec)
 	go s.srv.Serve(s.listener)
-	return s, nil
+	return s
 }
 
 // Clear clears the fake results.
@@ -87,9 +94,27 @@ func (s *Server) NewClientConn(ctx context.Context) (*grpc.ClientConn, error) {
 	return conn, err
 }
 
+// Addr returns the address the server is listening on.
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Target returns the gRPC dial target of the server, which is its address for TCP listeners and a
+// "unix://" or "unix-abstract:" URI for Unix domain sockets.
+func (s *Server) Target() string {
+	addr := s.listener.Addr()
+	if addr.Network() != "unix" {
+		return addr.String()
+	}
+	if strings.HasPrefix(addr.String(), "@") {
+		return "unix-abstract:" + strings.TrimPrefix(addr.String(), "@")
+	}
+	return "unix://" + addr.String()
+}
+
 func (s *Server) dialParams() rc.DialParams {
 	return rc.DialParams{
-		Service:    s.listener.Addr().String(),
+		Service:    s.Target(),
 		NoSecurity: true,
 	}
 }
@@ -214,6 +239,10 @@ func (e *TestEnv) Set(cmd *command.Command, opt *command.ExecutionOptions, res *
 	}
 
 	cmdPb := cmd.ToREProto(false)
+	if len(opt.OutputNodeProperties) > 0 {
+		cmdPb.OutputNodeProperties = append([]string(nil), opt.OutputNodeProperties...)
+		sort.Strings(cmdPb.OutputNodeProperties)
+	}
 	bytes, err := proto.Marshal(cmdPb)
 	if err != nil {
 		e.t.Fatalf("error inserting command digest blob into CAS %v", err)
@@ -362,12 +391,13 @@ func BuildDir(path string, s *Server, execRoot string) (root *repb.Directory, ch
 		fn := file.Name()
 		fp := filepath.Join(execRoot, path, fn)
 		if file.IsDir() {
-			root, _, err := BuildDir(fp, s, execRoot)
+			root, rootCh, err := BuildDir(filepath.Join(path, fn), s, execRoot)
 			if err != nil {
 				return nil, nil, fmt.Errorf("failed to build directory tree: %v", err)
 			}
 			res.Directories = append(res.Directories, &repb.DirectoryNode{Name: fn, Digest: digest.TestNewFromMessage(root).ToProto()})
 			ch = append(ch, root)
+			ch = append(ch, rootCh...)
 		} else {
 			content, err := os.ReadFile(fp)
 			if err != nil {
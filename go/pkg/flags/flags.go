@@ -4,11 +4,15 @@ package flags
 import (
 	"context"
 	"flag"
+	"fmt"
 	"time"
 
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/balancer"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/bandwidth"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/client"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/moreflag"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/recorder"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/retry"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/keepalive"
 
@@ -40,13 +44,13 @@ var (
 	// UseExternalAuthToken specifies whether to use an externally provided auth token, given via PerRPCCreds dial option, should be used.
 	UseExternalAuthToken = flag.Bool("use_external_auth_token", false, "If true, se an externally provided auth token, given via PerRPCCreds when the SDK is initialized.")
 	// Service represents the host (and, if applicable, port) of the remote execution service.
-	Service = flag.String("service", "", "The remote execution service to dial when calling via gRPC, including port, such as 'localhost:8790' or 'remotebuildexecution.googleapis.com:443'")
+	Service = flag.String("service", "", "The remote execution service to dial when calling via gRPC, including port, such as 'localhost:8790' or 'remotebuildexecution.googleapis.com:443', or a Unix domain socket, such as 'unix:///tmp/reproxy.sock' or 'unix-abstract:reproxy'")
 	// ServiceNoSecurity can be set to connect to the gRPC service without TLS and without authentication (enables --service_no_auth).
 	ServiceNoSecurity = flag.Bool("service_no_security", false, "If true, do not use TLS or authentication when connecting to the gRPC service.")
 	// ServiceNoAuth can be set to disable authentication while still using TLS.
 	ServiceNoAuth = flag.Bool("service_no_auth", false, "If true, do not authenticate with the service (implied by --service_no_security).")
 	// CASService represents the host (and, if applicable, port) of the CAS service, if different from the remote execution service.
-	CASService = flag.String("cas_service", "", "The CAS service to dial when calling via gRPC, including port, such as 'localhost:8790' or 'remotebuildexecution.googleapis.com:443'")
+	CASService = flag.String("cas_service", "", "The CAS service to dial when calling via gRPC, including port, such as 'localhost:8790' or 'remotebuildexecution.googleapis.com:443', or a Unix domain socket, such as 'unix:///tmp/reproxy.sock' or 'unix-abstract:reproxy'")
 	// Instance gives the instance of remote execution to test (in
 	// projects/[PROJECT_ID]/instances/[INSTANCE_NAME] format for Google RBE).
 	Instance = flag.String("instance", "", "The instance ID to target when calling remote execution via gRPC (e.g., projects/$PROJECT/instances/default_instance for Google RBE).")
@@ -74,6 +78,46 @@ var (
 	KeepAliveTimeout = flag.Duration("grpc_keepalive_timeout", 20*time.Second, "After having pinged for keepalive check, the client waits for a duration of Timeout and if no activity is seen even after that the connection is closed. Default is 20s.")
 	// KeepAlivePermitWithoutStream specifies gRPCs keepalive permitWithoutStream parameter.
 	KeepAlivePermitWithoutStream = flag.Bool("grpc_keepalive_permit_without_stream", false, "If true, client sends keepalive pings even with no active RPCs; otherwise, doesn't send pings even if time and timeout are set. Default is false.")
+	// RecordGRPCTraffic is the path of a file to record all the gRPC traffic of the client to.
+	RecordGRPCTraffic = flag.String("record_grpc_traffic", "", "If set, record every gRPC request and response to this file. The recording can be replayed with fakes.Replay.")
+	// RecordGRPCPayloads specifies how ByteStream payloads are recorded with --record_grpc_traffic.
+	RecordGRPCPayloads = flag.String("record_grpc_payloads", "store", "How ByteStream payloads are recorded with --record_grpc_traffic: 'store' keeps them so they can be replayed, 'hash' replaces them with their digest.")
+	// RetryBudgetRatio is the fraction of calls that may be retried process-wide.
+	RetryBudgetRatio = flag.Float64("retry_budget_ratio", 0, "If positive, limit retries of all the RPCs of the process to this fraction of the calls made, e.g. 0.1 for 10%, on top of --retry_budget_reserve retries. Zero means retries are only limited per call.")
+	// RetryBudgetReserve is the number of retries allowed on top of those earned by --retry_budget_ratio.
+	RetryBudgetReserve = flag.Int("retry_budget_reserve", 100, "The number of retries allowed on top of those earned by --retry_budget_ratio, so that a process that made few calls can still retry them.")
+	// CircuitBreakerFailureRatio enables circuit breakers for the CAS, action cache and execution RPCs.
+	CircuitBreakerFailureRatio = flag.Float64("circuit_breaker_failure_ratio", 0, "If positive, enable circuit breakers that make the CAS, action cache or execution RPCs fail fast once this fraction of their calls fail. Zero disables circuit breakers.")
+	// CircuitBreakerOpenDuration is how long an open circuit breaker rejects calls before probing the service again.
+	CircuitBreakerOpenDuration = flag.Duration("circuit_breaker_open_duration", 5*time.Second, "How long an open circuit breaker rejects calls before letting probe calls through.")
+	// GRPCBalancer selects the load balancer of the gRPC connections.
+	GRPCBalancer = flag.String("grpc_balancer", "gcp", "The load balancer of the gRPC connections: 'gcp' for the GCP balancer, or 'pool' for a pool that sends each call on the connection with the fewest calls in flight and replaces failing connections.")
+	// PoolMaxConnections is the maximum number of connections of the pool balancer.
+	PoolMaxConnections = flag.Int("pool_max_connections", 0, "The maximum number of gRPC sub-connections with --grpc_balancer=pool. Zero means no limit.")
+	// SeparateByteStreamConnections dials distinct connections for ByteStream calls.
+	SeparateByteStreamConnections = flag.Bool("separate_bytestream_conns", false, "If true, make ByteStream calls on connections distinct from those of the other CAS and action cache calls, so that large uploads and downloads do not delay small calls.")
+	// MaxConcurrentByteStreams denotes the maximum number of concurrent ByteStream calls on a single gRPC connection.
+	MaxConcurrentByteStreams = flag.Uint("max_concurrent_bytestreams_per_conn", 0, "Maximum number of concurrent ByteStream calls on a single gRPC connection with --separate_bytestream_conns. Zero means --max_concurrent_streams_per_conn.")
+	// UploadBandwidthLimit limits the rate of the bytes uploaded to the CAS.
+	UploadBandwidthLimit = flag.Int64("upload_bandwidth_limit", 0, "If positive, limit CAS uploads to this many bytes per second. Zero means no limit.")
+	// UploadBandwidthBurst is the number of bytes that may be uploaded at once regardless of --upload_bandwidth_limit.
+	UploadBandwidthBurst = flag.Int64("upload_bandwidth_burst", 0, "The number of bytes that may be uploaded at once with --upload_bandwidth_limit. Zero means one second worth of bytes.")
+	// DownloadBandwidthLimit limits the rate of the bytes downloaded from the CAS.
+	DownloadBandwidthLimit = flag.Int64("download_bandwidth_limit", 0, "If positive, limit CAS downloads to this many bytes per second. Zero means no limit.")
+	// DownloadBandwidthBurst is the number of bytes that may be downloaded at once regardless of --download_bandwidth_limit.
+	DownloadBandwidthBurst = flag.Int64("download_bandwidth_burst", 0, "The number of bytes that may be downloaded at once with --download_bandwidth_limit. Zero means one second worth of bytes.")
+	// CASNGAdaptiveConcurrency makes the casng uploader adjust its number of concurrent calls.
+	CASNGAdaptiveConcurrency = flag.Bool("casng_adaptive_concurrency", false, "If true, the casng uploader adjusts its number of concurrent calls up to --cas_concurrency based on their latency and the throttling errors of the server.")
+	// MerkleTreeCache makes the client reuse unchanged input subtrees across actions.
+	MerkleTreeCache = flag.Bool("merkle_tree_cache", false, "If true, the client caches the Merkle trees of input directories and reuses them for subsequent actions as long as their contents are unchanged.")
+	// VerifyDownloads makes the client check downloaded blobs against their digests.
+	VerifyDownloads = flag.Bool("verify_downloads", true, "If true, the client hashes downloaded blobs as they are written and fails if they do not match their digests, after retrying the download once.")
+	// HedgeDelay enables hedging of GetActionResult and small BatchReadBlobs calls.
+	HedgeDelay = flag.Duration("hedge_delay", 0, "If positive, issue a duplicate GetActionResult or small BatchReadBlobs call on another connection when the call has not completed after this delay, and use the first response. Zero disables hedging.")
+	// HedgePercentile hedges calls after this percentile of their recent latencies instead of --hedge_delay.
+	HedgePercentile = flag.Float64("hedge_percentile", 0, "If positive, hedge calls after this percentile of the recent latencies of the RPC, e.g. 95, but never earlier than --hedge_delay.")
+	// HedgeMaxRatio is the maximum fraction of calls that are hedged.
+	HedgeMaxRatio = flag.Float64("hedge_max_ratio", client.DefaultMaxHedgeRatio, "The maximum fraction of the eligible calls that are hedged.")
 )
 
 func init() {
@@ -86,10 +130,120 @@ func init() {
 	flag.Var((*moreflag.StringMapValue)(&RPCTimeouts), "rpc_timeouts", "Comma-separated key value pairs in the form rpc_name=timeout. The key for default RPC is named default. 0 indicates no timeout. Example: GetActionResult=500ms,Execute=0,default=10s.")
 }
 
+// ConnectionFlags are the flags that select the service, instance and credentials of a client. They are
+// registered with a prefix, so that a binary can connect to several services at once, e.g. to copy
+// from one to another.
+type ConnectionFlags struct {
+	// Service is the remote execution service to dial, like --service.
+	Service *string
+	// CASService is the CAS service to dial, if different from Service, like --cas_service.
+	CASService *string
+	// Instance is the instance of remote execution to target, like --instance.
+	Instance *string
+	// CredFile is the name of a file that contains service account credentials, like --credential_file.
+	CredFile *string
+	// UseApplicationDefaultCreds is whether to use application default credentials, like --use_application_default_credentials.
+	UseApplicationDefaultCreds *bool
+	// UseGCECredentials is whether to use the default GCE credentials, like --use_gce_credentials.
+	UseGCECredentials *bool
+	// UseRPCCredentials can be set to false to disable all per-RPC credentials, like --use_rpc_credentials.
+	UseRPCCredentials *bool
+	// ServiceNoSecurity disables TLS and authentication, like --service_no_security.
+	ServiceNoSecurity *bool
+	// ServiceNoAuth disables authentication, like --service_no_auth.
+	ServiceNoAuth *bool
+	// TLSServerName overrides the server name sent in the TLS session, like --tls_server_name.
+	TLSServerName *string
+	// TLSCACert loads CA certificates from a file, like --tls_ca_cert.
+	TLSCACert *string
+	// TLSClientAuthCert is the certificate used for mTLS, like --tls_client_auth_cert.
+	TLSClientAuthCert *string
+	// TLSClientAuthKey is the key used for mTLS, like --tls_client_auth_key.
+	TLSClientAuthKey *string
+}
+
+// NewConnectionFlags registers the connection flags in fs with the given prefix, e.g. "dst_" for
+// --dst_service, --dst_instance and so on.
+func NewConnectionFlags(fs *flag.FlagSet, prefix string) *ConnectionFlags {
+	return &ConnectionFlags{
+		Service:                    fs.String(prefix+"service", "", "The remote execution service to dial, like --service."),
+		CASService:                 fs.String(prefix+"cas_service", "", "The CAS service to dial, if different from the remote execution service, like --cas_service."),
+		Instance:                   fs.String(prefix+"instance", "", "The instance ID to target, like --instance."),
+		CredFile:                   fs.String(prefix+"credential_file", "", "The name of a file that contains service account credentials, like --credential_file."),
+		UseApplicationDefaultCreds: fs.Bool(prefix+"use_application_default_credentials", false, "If true, use application default credentials, like --use_application_default_credentials."),
+		UseGCECredentials:          fs.Bool(prefix+"use_gce_credentials", false, "If true, use the default GCE credentials, like --use_gce_credentials."),
+		UseRPCCredentials:          fs.Bool(prefix+"use_rpc_credentials", true, "If false, no per-RPC credentials will be used, like --use_rpc_credentials."),
+		ServiceNoSecurity:          fs.Bool(prefix+"service_no_security", false, "If true, do not use TLS or authentication, like --service_no_security."),
+		ServiceNoAuth:              fs.Bool(prefix+"service_no_auth", false, "If true, do not authenticate with the service, like --service_no_auth."),
+		TLSServerName:              fs.String(prefix+"tls_server_name", "", "Override the TLS server name, like --tls_server_name."),
+		TLSCACert:                  fs.String(prefix+"tls_ca_cert", "", "Load TLS CA certificates from this file, like --tls_ca_cert."),
+		TLSClientAuthCert:          fs.String(prefix+"tls_client_auth_cert", "", "Certificate to use for mTLS, like --tls_client_auth_cert."),
+		TLSClientAuthKey:           fs.String(prefix+"tls_client_auth_key", "", "Key to use for mTLS, like --tls_client_auth_key."),
+	}
+}
+
+// NewClient connects to the service given by the connection flags. The other settings of the client,
+// e.g. its concurrency, timeouts and retries, are taken from the unprefixed flags, except that gRPC
+// traffic is not recorded.
+func (f *ConnectionFlags) NewClient(ctx context.Context, opts ...client.Opt) (*client.Client, error) {
+	return newClient(ctx, *f.Instance, client.DialParams{
+		Service:               *f.Service,
+		NoSecurity:            *f.ServiceNoSecurity,
+		NoAuth:                *f.ServiceNoAuth,
+		CASService:            *f.CASService,
+		CredFile:              *f.CredFile,
+		UseApplicationDefault: *f.UseApplicationDefaultCreds,
+		UseComputeEngine:      *f.UseGCECredentials,
+		TransportCredsOnly:    !*f.UseRPCCredentials,
+		TLSServerName:         *f.TLSServerName,
+		TLSCACertFile:         *f.TLSCACert,
+		TLSClientAuthCert:     *f.TLSClientAuthCert,
+		TLSClientAuthKey:      *f.TLSClientAuthKey,
+	}, opts...)
+}
+
 // NewClientFromFlags connects to a remote execution service and returns a client suitable for higher-level
 // functionality. It uses the flags from above to configure the connection to remote execution.
 func NewClientFromFlags(ctx context.Context, opts ...client.Opt) (*client.Client, error) {
-	opts = append(opts, []client.Opt{client.CASConcurrency(*CASConcurrency), client.StartupCapabilities(*StartupCapabilities)}...)
+	params := client.DialParams{
+		Service:               *Service,
+		NoSecurity:            *ServiceNoSecurity,
+		NoAuth:                *ServiceNoAuth,
+		CASService:            *CASService,
+		CredFile:              *CredFile,
+		UseApplicationDefault: *UseApplicationDefaultCreds,
+		UseComputeEngine:      *UseGCECredentials,
+		TransportCredsOnly:    !*UseRPCCredentials,
+		TLSServerName:         *TLSServerName,
+		TLSCACertFile:         *TLSCACert,
+		TLSClientAuthCert:     *TLSClientAuthCert,
+		TLSClientAuthKey:      *TLSClientAuthKey,
+	}
+	if *RecordGRPCTraffic != "" {
+		mode, err := recorder.ParsePayloadMode(*RecordGRPCPayloads)
+		if err != nil {
+			return nil, err
+		}
+		// The client closes the recording file when it is closed.
+		rec, err := recorder.New(*RecordGRPCTraffic, mode)
+		if err != nil {
+			return nil, err
+		}
+		log.Infof("Recording gRPC traffic to %v", *RecordGRPCTraffic)
+		params.Recorder = rec
+	}
+	c, err := newClient(ctx, *Instance, params, opts...)
+	if err != nil && params.Recorder != nil {
+		// The flags may be rejected before the client takes the recorder.
+		params.Recorder.Close()
+	}
+	return c, err
+}
+
+// newClient connects to the service of the given dial params, setting the rest of the params and the
+// options of the client from the flags.
+func newClient(ctx context.Context, instance string, params client.DialParams, opts ...client.Opt) (*client.Client, error) {
+	opts = append(opts, []client.Opt{client.CASConcurrency(*CASConcurrency), client.StartupCapabilities(*StartupCapabilities), client.CASNGAdaptiveConcurrency(*CASNGAdaptiveConcurrency), client.MerkleTreeCache(*MerkleTreeCache), client.VerifyDownloads(*VerifyDownloads)}...)
 	if len(RPCTimeouts) > 0 {
 		timeouts := make(map[string]time.Duration)
 		for rpc, d := range client.DefaultRPCTimeouts {
@@ -113,33 +267,43 @@ func NewClientFromFlags(ctx context.Context, opts ...client.Opt) (*client.Client
 		}
 	}
 
-	dialOpts := make([]grpc.DialOption, 0)
+	if *CircuitBreakerFailureRatio > 0 {
+		opts = append(opts, client.CircuitBreakers{FailureRatio: *CircuitBreakerFailureRatio, OpenDuration: *CircuitBreakerOpenDuration})
+	}
+	if *HedgeDelay > 0 {
+		opts = append(opts, client.Hedging{Delay: *HedgeDelay, Percentile: *HedgePercentile, MaxRatio: *HedgeMaxRatio})
+	}
+	// The limiters are created even without limits, so that the limits can be set at runtime with
+	// Client.BandwidthLimits.
+	opts = append(opts, client.BandwidthLimits{
+		Upload:   bandwidth.NewLimiter(*UploadBandwidthLimit, *UploadBandwidthBurst),
+		Download: bandwidth.NewLimiter(*DownloadBandwidthLimit, *DownloadBandwidthBurst),
+	})
+	if *RetryBudgetRatio > 0 {
+		retry.SetDefaultBudget(retry.NewBudget(*RetryBudgetRatio, *RetryBudgetReserve))
+	}
+
 	if *KeepAliveTime > 0*time.Second {
-		params := keepalive.ClientParameters{
+		kaParams := keepalive.ClientParameters{
 			Time:                *KeepAliveTime,
 			Timeout:             *KeepAliveTimeout,
 			PermitWithoutStream: *KeepAlivePermitWithoutStream,
 		}
-		log.V(1).Infof("KeepAlive params = %v", params)
-		dialOpts = append(dialOpts, grpc.WithKeepaliveParams(params))
+		log.V(1).Infof("KeepAlive params = %v", kaParams)
+		params.DialOpts = append(params.DialOpts, grpc.WithKeepaliveParams(kaParams))
 	}
-	return client.NewClient(ctx, *Instance, client.DialParams{
-		Service:               *Service,
-		NoSecurity:            *ServiceNoSecurity,
-		NoAuth:                *ServiceNoAuth,
-		CASService:            *CASService,
-		CredFile:              *CredFile,
-		DialOpts:              dialOpts,
-		UseApplicationDefault: *UseApplicationDefaultCreds,
-		UseComputeEngine:      *UseGCECredentials,
-		UseExternalAuthToken:  *UseExternalAuthToken,
-		ExternalPerRPCCreds:   perRPCCreds,
-		TransportCredsOnly:    !*UseRPCCredentials,
-		TLSServerName:         *TLSServerName,
-		TLSCACertFile:         *TLSCACert,
-		TLSClientAuthCert:     *TLSClientAuthCert,
-		TLSClientAuthKey:      *TLSClientAuthKey,
-		MaxConcurrentRequests: uint32(*MaxConcurrentRequests),
-		MaxConcurrentStreams:  uint32(*MaxConcurrentStreams),
-	}, opts...)
+	switch *GRPCBalancer {
+	case "gcp":
+	case "pool":
+		params.ConnectionPool = &balancer.PoolConfig{MinConnections: balancer.MinConnections, MaxConnections: *PoolMaxConnections}
+	default:
+		return nil, fmt.Errorf("unknown --grpc_balancer %q, must be 'gcp' or 'pool'", *GRPCBalancer)
+	}
+	params.UseExternalAuthToken = *UseExternalAuthToken
+	params.ExternalPerRPCCreds = perRPCCreds
+	params.MaxConcurrentRequests = uint32(*MaxConcurrentRequests)
+	params.MaxConcurrentStreams = uint32(*MaxConcurrentStreams)
+	params.SeparateByteStreamConnections = *SeparateByteStreamConnections
+	params.MaxConcurrentByteStreams = uint32(*MaxConcurrentByteStreams)
+	return client.NewClient(ctx, instance, params, opts...)
 }
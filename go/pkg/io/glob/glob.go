@@ -0,0 +1,231 @@
+// Package glob provides glob patterns for matching slash-separated relative paths, with support for "**".
+package glob
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/errors"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/io/impath"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/io/vfs"
+)
+
+var (
+	// ErrBadPattern indicates that a pattern is malformed.
+	ErrBadPattern = errors.New("bad glob pattern")
+
+	// ErrNoMatch indicates that a pattern did not match any file.
+	ErrNoMatch = errors.New("glob pattern matched no files")
+)
+
+// doubleStar is the segment that matches zero or more segments.
+const doubleStar = "**"
+
+// Pattern is a compiled glob pattern.
+//
+// A pattern is a slash-separated relative path. Each segment is matched against a single path segment using
+// the syntax of path.Match, i.e. "*", "?", "[...]" and "\" escapes, except for a "**" segment,
+// which matches zero or more segments.
+type Pattern struct {
+	raw  string
+	segs []string
+}
+
+// Compile parses pattern.
+// It returns an error wrapping ErrBadPattern if the pattern is empty, absolute, has ".." segments, or is syntactically malformed.
+func Compile(pattern string) (*Pattern, error) {
+	if pattern == "" || strings.HasPrefix(pattern, "/") {
+		return nil, errors.Join(ErrBadPattern, fmt.Errorf("pattern %q must be a non-empty relative path", pattern))
+	}
+	var segs []string
+	for _, s := range strings.Split(pattern, "/") {
+		switch s {
+		case "", ".":
+			continue
+		case "..":
+			return nil, errors.Join(ErrBadPattern, fmt.Errorf("pattern %q must not have \"..\" segments", pattern))
+		case doubleStar:
+			// Consecutive "**" segments are equivalent to a single one.
+			if len(segs) > 0 && segs[len(segs)-1] == doubleStar {
+				continue
+			}
+		default:
+			if _, err := path.Match(s, ""); err != nil {
+				return nil, errors.Join(ErrBadPattern, fmt.Errorf("pattern %q: %w", pattern, err))
+			}
+		}
+		segs = append(segs, s)
+	}
+	if len(segs) == 0 {
+		return nil, errors.Join(ErrBadPattern, fmt.Errorf("pattern %q must be a non-empty relative path", pattern))
+	}
+	return &Pattern{raw: pattern, segs: segs}, nil
+}
+
+// CompileAll compiles all patterns, failing on the first malformed one.
+func CompileAll(patterns []string) ([]*Pattern, error) {
+	ps := make([]*Pattern, 0, len(patterns))
+	for _, p := range patterns {
+		c, err := Compile(p)
+		if err != nil {
+			return nil, err
+		}
+		ps = append(ps, c)
+	}
+	return ps, nil
+}
+
+// String returns the pattern as it was specified.
+func (p *Pattern) String() string {
+	return p.raw
+}
+
+// Match returns true if the slash-separated relative path rel matches the pattern.
+func (p *Pattern) Match(rel string) bool {
+	return matchSegments(p.segs, splitPath(rel), false)
+}
+
+// MatchPrefix returns true if the slash-separated relative path rel, or any of its descendants, may match the pattern.
+// It is used to prune directories that cannot hold any matches.
+func (p *Pattern) MatchPrefix(rel string) bool {
+	return matchSegments(p.segs, splitPath(rel), true)
+}
+
+// Base returns the slash-separated directory holding all the matches of the pattern, i.e. the leading segments
+// without special characters, or "." if the pattern starts with one.
+func (p *Pattern) Base() string {
+	return path.Join(append([]string{"."}, p.literalPrefix()...)...)
+}
+
+// literalPrefix returns the leading segments of the pattern that have no special characters, excluding the last segment.
+func (p *Pattern) literalPrefix() []string {
+	var prefix []string
+	for _, s := range p.segs[:len(p.segs)-1] {
+		if s == doubleStar || strings.ContainsAny(s, `*?[\`) {
+			break
+		}
+		prefix = append(prefix, s)
+	}
+	return prefix
+}
+
+func splitPath(rel string) []string {
+	rel = path.Clean(rel)
+	if rel == "." {
+		return nil
+	}
+	return strings.Split(rel, "/")
+}
+
+// matchSegments matches the path segments against the pattern segments.
+// If prefix is true, a path that is exhausted before the pattern also matches.
+func matchSegments(pat, segs []string, prefix bool) bool {
+	for len(pat) > 0 {
+		if pat[0] == doubleStar {
+			if len(pat) == 1 {
+				return true
+			}
+			// Try consuming zero or more segments.
+			for i := 0; i <= len(segs); i++ {
+				if matchSegments(pat[1:], segs[i:], prefix) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segs) == 0 {
+			return prefix
+		}
+		if ok, _ := path.Match(pat[0], segs[0]); !ok {
+			return false
+		}
+		pat, segs = pat[1:], segs[1:]
+	}
+	return len(segs) == 0
+}
+
+// MatchAny returns true if rel matches any of the patterns.
+func MatchAny(patterns []*Pattern, rel string) bool {
+	for _, p := range patterns {
+		if p.Match(rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// Expand returns the sorted and deduplicated slash-separated paths, relative to root, of the non-directory entries of fsys
+// that match any of the include patterns and none of the exclude patterns.
+//
+// Symlinks are matched as entries and are not followed. Directories matching an exclude pattern are not descended into.
+// The expansion is deterministic: it depends only on the entries of the tree, not on the order in which they are listed.
+// If an include pattern does not match any entry, an error wrapping ErrNoMatch and naming all such patterns is returned
+// along with the matches of the other patterns.
+func Expand(fsys vfs.FS, root impath.Absolute, include, exclude []*Pattern) ([]string, error) {
+	seen := map[string]bool{}
+	var unmatched []string
+	for _, p := range include {
+		n := 0
+		err := expand(fsys, root, p.Base(), p, exclude, func(rel string) {
+			n++
+			seen[rel] = true
+		})
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			unmatched = append(unmatched, p.String())
+		}
+	}
+	paths := make([]string, 0, len(seen))
+	for p := range seen {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	if len(unmatched) > 0 {
+		return paths, errors.Join(ErrNoMatch, fmt.Errorf("patterns %q under %q", unmatched, root))
+	}
+	return paths, nil
+}
+
+// expand walks the tree under the slash-separated path rel of root, calling fn for every matching entry.
+func expand(fsys vfs.FS, root impath.Absolute, rel string, p *Pattern, exclude []*Pattern, fn func(string)) error {
+	if rel != "." && MatchAny(exclude, rel) {
+		return nil
+	}
+	abs := root
+	if rel != "." {
+		abs = root.Append(impath.MustRel(filepath.FromSlash(rel)))
+	}
+	info, err := fsys.Lstat(abs)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		if p.Match(rel) {
+			fn(rel)
+		}
+		return nil
+	}
+	if !p.MatchPrefix(rel) {
+		return nil
+	}
+	names, err := fsys.ReadDirNames(abs)
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := expand(fsys, root, path.Join(rel, name), p, exclude, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package glob_test
+
+import (
+	"testing"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/errors"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/io/glob"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/io/impath"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/io/vfs"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern     string
+		path        string
+		match       bool
+		matchPrefix bool
+	}{
+		{pattern: "*.go", path: "a.go", match: true, matchPrefix: true},
+		{pattern: "*.go", path: "a/b.go", match: false, matchPrefix: false},
+		{pattern: "a/*.go", path: "a", match: false, matchPrefix: true},
+		{pattern: "**/*.go", path: "a.go", match: true, matchPrefix: true},
+		{pattern: "**/*.go", path: "a/b/c.go", match: true, matchPrefix: true},
+		{pattern: "**/*.go", path: "a/b/c.txt", match: false, matchPrefix: true},
+		{pattern: "a/**", path: "a/b/c", match: true, matchPrefix: true},
+		{pattern: "a/**", path: "b", match: false, matchPrefix: false},
+		{pattern: "a/**/testdata/**", path: "a/x/y/testdata/f", match: true, matchPrefix: true},
+		{pattern: "a/**/testdata/**", path: "a/x/testdatb", match: false, matchPrefix: true},
+		{pattern: "./a//b?", path: "a/bc", match: true, matchPrefix: true},
+		{pattern: "a/[bc]/d", path: "a/c", match: false, matchPrefix: true},
+		{pattern: "a/[bc]/d", path: "a/e", match: false, matchPrefix: false},
+	}
+	for _, tc := range tests {
+		p, err := glob.Compile(tc.pattern)
+		if err != nil {
+			t.Fatalf("Compile(%q) failed: %v", tc.pattern, err)
+		}
+		if got := p.Match(tc.path); got != tc.match {
+			t.Errorf("%q.Match(%q): want %t, got %t", tc.pattern, tc.path, tc.match, got)
+		}
+		if got := p.MatchPrefix(tc.path); got != tc.matchPrefix {
+			t.Errorf("%q.MatchPrefix(%q): want %t, got %t", tc.pattern, tc.path, tc.matchPrefix, got)
+		}
+	}
+}
+
+func TestCompile_Bad(t *testing.T) {
+	for _, pattern := range []string{"", "/a", "a/../b", "a/[", "."} {
+		if _, err := glob.Compile(pattern); !errors.Is(err, glob.ErrBadPattern) {
+			t.Errorf("Compile(%q): want %v, got %v", pattern, glob.ErrBadPattern, err)
+		}
+	}
+}
+
+func TestExpand(t *testing.T) {
+	m := vfs.NewMemory()
+	for _, p := range []string{"/root/a/x.go", "/root/a/y.txt", "/root/a/testdata/z.go", "/root/b/c/w.go", "/root/v.go"} {
+		if err := m.WriteFile(impath.MustAbs(p), []byte(p), 0o644); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+	if err := m.Symlink("x.go", impath.MustAbs("/root/a/l.go")); err != nil {
+		t.Fatalf("Symlink failed: %v", err)
+	}
+	root := impath.MustAbs("/root")
+	mustCompile := func(patterns ...string) []*glob.Pattern {
+		ps, err := glob.CompileAll(patterns)
+		if err != nil {
+			t.Fatalf("CompileAll failed: %v", err)
+		}
+		return ps
+	}
+
+	got, err := glob.Expand(m, root, mustCompile("**/*.go", "a/*.txt"), mustCompile("**/testdata"))
+	if err != nil {
+		t.Fatalf("Expand failed: %v", err)
+	}
+	want := []string{"a/l.go", "a/x.go", "a/y.txt", "b/c/w.go", "v.go"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("paths mismatch, (-want +got): %s", diff)
+	}
+
+	got, err = glob.Expand(m, root, mustCompile("a/*.go", "a/*.c", "missing/**"), nil)
+	if !errors.Is(err, glob.ErrNoMatch) {
+		t.Fatalf("Expand: want %v, got %v", glob.ErrNoMatch, err)
+	}
+	if diff := cmp.Diff([]string{"a/l.go", "a/x.go"}, got); diff != "" {
+		t.Errorf("partial paths mismatch, (-want +got): %s", diff)
+	}
+	if want := `patterns ["a/*.c" "missing/**"] under "/root"`; !cmp.Equal(err.Error(), glob.ErrNoMatch.Error()+"\n"+want) {
+		t.Errorf("error message mismatch: got %q", err)
+	}
+}
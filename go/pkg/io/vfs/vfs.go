@@ -0,0 +1,385 @@
+// Package vfs provides a minimal read-only file system abstraction for walking and digesting trees that are not, or not entirely, on disk.
+package vfs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/errors"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/io/impath"
+	"github.com/pborman/uuid"
+)
+
+// File is an open regular file or directory of a file system.
+type File interface {
+	io.ReadSeekCloser
+
+	// Readdirnames returns the names of at most n of the remaining entries of the directory, like os.File.Readdirnames:
+	// if n > 0, it returns io.EOF once there are no entries left, otherwise it returns all the remaining names at once.
+	Readdirnames(n int) ([]string, error)
+}
+
+// FS is a read-only file system addressed by absolute paths.
+//
+// Implementations must be safe for concurrent use.
+// Errors for paths that do not exist must match fs.ErrNotExist using errors.Is.
+type FS interface {
+	// Lstat returns the info of the entry at path without following symlinks.
+	Lstat(path impath.Absolute) (fs.FileInfo, error)
+
+	// Open opens the regular file or the directory at path for reading.
+	// Large directories should be read in batches through File.Readdirnames to bound memory.
+	Open(path impath.Absolute) (File, error)
+
+	// ReadDirNames returns the names of the entries of the directory at path, in no particular order.
+	ReadDirNames(path impath.Absolute) ([]string, error)
+
+	// Readlink returns the target of the symlink at path.
+	Readlink(path impath.Absolute) (string, error)
+
+	// ID identifies the content of this file system. Consumers may use it to key caches of content read through it.
+	// Two file systems with different content must have different IDs. The real file system has the empty ID.
+	ID() string
+}
+
+// OS is the real file system of the host.
+var OS FS = osFS{}
+
+type osFS struct{}
+
+func (osFS) Lstat(path impath.Absolute) (fs.FileInfo, error) {
+	return os.Lstat(path.String())
+}
+
+func (osFS) Open(path impath.Absolute) (File, error) {
+	f, err := os.Open(path.String())
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (osFS) ReadDirNames(path impath.Absolute) ([]string, error) {
+	f, err := os.Open(path.String())
+	if err != nil {
+		return nil, err
+	}
+	// Ignoring the error here is acceptable because the file was not modified in any way.
+	defer f.Close()
+	return f.Readdirnames(-1)
+}
+
+func (osFS) Readlink(path impath.Absolute) (string, error) {
+	return os.Readlink(path.String())
+}
+
+func (osFS) ID() string {
+	return ""
+}
+
+// memEntry is a file, a directory, or a symlink in a Memory file system.
+type memEntry struct {
+	mode     fs.FileMode
+	content  []byte
+	target   string
+	children map[string]bool
+}
+
+// memInfo implements fs.FileInfo for Memory entries.
+type memInfo struct {
+	name string
+	size int64
+	mode fs.FileMode
+}
+
+func (i *memInfo) Name() string       { return i.name }
+func (i *memInfo) Size() int64        { return i.size }
+func (i *memInfo) Mode() fs.FileMode  { return i.mode }
+func (i *memInfo) ModTime() time.Time { return time.Time{} }
+func (i *memInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *memInfo) Sys() any           { return nil }
+
+// memFile implements File for Memory files and directories.
+type memFile struct {
+	*bytes.Reader
+	path string
+	// names are the entries of a directory that were not read yet. It is nil for regular files.
+	names []string
+}
+
+func (*memFile) Close() error { return nil }
+
+func (f *memFile) Readdirnames(n int) ([]string, error) {
+	if f.names == nil {
+		return nil, &fs.PathError{Op: "readdirent", Path: f.path, Err: fmt.Errorf("not a directory")}
+	}
+	if n > 0 && len(f.names) == 0 {
+		return []string{}, io.EOF
+	}
+	if n <= 0 || n > len(f.names) {
+		n = len(f.names)
+	}
+	names := f.names[:n]
+	f.names = f.names[n:]
+	return names, nil
+}
+
+// dirFile returns a File for a directory with the given entries.
+func dirFile(path impath.Absolute, names []string) File {
+	if names == nil {
+		names = []string{}
+	}
+	return &memFile{Reader: bytes.NewReader(nil), path: path.String(), names: names}
+}
+
+// Memory is an in-memory file system. The zero value is not usable; use NewMemory.
+//
+// Parent directories are created implicitly when adding entries.
+// Its ID changes with every modification. Modifying it while it is being walked results in an inconsistent view of the tree.
+type Memory struct {
+	mu      sync.RWMutex
+	id      string
+	gen     int
+	entries map[string]*memEntry
+}
+
+// NewMemory returns an empty in-memory file system.
+func NewMemory() *Memory {
+	return &Memory{
+		id:      uuid.New(),
+		entries: map[string]*memEntry{impath.Root: {mode: fs.ModeDir | 0o755, children: map[string]bool{}}},
+	}
+}
+
+// WriteFile adds a regular file at path with the specified content and permission bits, replacing any existing file.
+func (m *Memory) WriteFile(path impath.Absolute, content []byte, perm fs.FileMode) error {
+	return m.add(path, &memEntry{mode: perm.Perm(), content: content})
+}
+
+// Mkdir adds a directory at path, along with any missing parents. It is a no-op if the directory already exists.
+func (m *Memory) Mkdir(path impath.Absolute) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.mkdirLocked(path)
+}
+
+// Symlink adds a symlink at path that points to target, which may be relative to the directory of path.
+func (m *Memory) Symlink(target string, path impath.Absolute) error {
+	return m.add(path, &memEntry{mode: fs.ModeSymlink | 0o777, target: target})
+}
+
+func (m *Memory) add(path impath.Absolute, e *memEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if old, ok := m.entries[path.String()]; ok && old.mode.IsDir() {
+		return &fs.PathError{Op: "add", Path: path.String(), Err: fs.ErrExist}
+	}
+	if err := m.mkdirLocked(path.Dir()); err != nil {
+		return err
+	}
+	m.entries[path.String()] = e
+	m.entries[path.Dir().String()].children[path.Base().String()] = true
+	m.gen++
+	return nil
+}
+
+func (m *Memory) mkdirLocked(path impath.Absolute) error {
+	if e, ok := m.entries[path.String()]; ok {
+		if !e.mode.IsDir() {
+			return &fs.PathError{Op: "mkdir", Path: path.String(), Err: fmt.Errorf("not a directory")}
+		}
+		return nil
+	}
+	parent := path.Dir()
+	if err := m.mkdirLocked(parent); err != nil {
+		return err
+	}
+	m.entries[path.String()] = &memEntry{mode: fs.ModeDir | 0o755, children: map[string]bool{}}
+	m.entries[parent.String()].children[path.Base().String()] = true
+	m.gen++
+	return nil
+}
+
+func (m *Memory) lookup(op string, path impath.Absolute) (*memEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	e, ok := m.entries[path.String()]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: path.String(), Err: fs.ErrNotExist}
+	}
+	return e, nil
+}
+
+// Lstat implements FS.
+func (m *Memory) Lstat(path impath.Absolute) (fs.FileInfo, error) {
+	e, err := m.lookup("lstat", path)
+	if err != nil {
+		return nil, err
+	}
+	return &memInfo{name: path.Base().String(), size: int64(len(e.content)), mode: e.mode}, nil
+}
+
+// Open implements FS.
+func (m *Memory) Open(path impath.Absolute) (File, error) {
+	e, err := m.lookup("open", path)
+	if err != nil {
+		return nil, err
+	}
+	if e.mode.IsDir() {
+		names, err := m.ReadDirNames(path)
+		if err != nil {
+			return nil, err
+		}
+		return dirFile(path, names), nil
+	}
+	if !e.mode.IsRegular() {
+		return nil, &fs.PathError{Op: "open", Path: path.String(), Err: fmt.Errorf("not a regular file")}
+	}
+	return &memFile{Reader: bytes.NewReader(e.content), path: path.String()}, nil
+}
+
+// ReadDirNames implements FS.
+func (m *Memory) ReadDirNames(path impath.Absolute) ([]string, error) {
+	e, err := m.lookup("readdirent", path)
+	if err != nil {
+		return nil, err
+	}
+	if !e.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdirent", Path: path.String(), Err: fmt.Errorf("not a directory")}
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	names := make([]string, 0, len(e.children))
+	for n := range e.children {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Readlink implements FS.
+func (m *Memory) Readlink(path impath.Absolute) (string, error) {
+	e, err := m.lookup("readlink", path)
+	if err != nil {
+		return "", err
+	}
+	if e.mode&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: path.String(), Err: fmt.Errorf("invalid argument")}
+	}
+	return e.target, nil
+}
+
+// ID implements FS.
+func (m *Memory) ID() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return fmt.Sprintf("mem:%s:%d", m.id, m.gen)
+}
+
+// Overlay merges the entries of an upper file system into a lower one.
+//
+// An entry in the upper file system shadows the entry at the same path in the lower one, unless both are directories,
+// in which case the directory lists the entries of both.
+type Overlay struct {
+	lower FS
+	upper FS
+}
+
+// NewOverlay returns a file system that merges upper into lower.
+// A typical use is merging virtual entries from a Memory file system into a real tree from OS.
+func NewOverlay(lower, upper FS) *Overlay {
+	return &Overlay{lower: lower, upper: upper}
+}
+
+// Lstat implements FS.
+func (o *Overlay) Lstat(path impath.Absolute) (fs.FileInfo, error) {
+	info, err := o.upper.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o.lower.Lstat(path)
+	}
+	return info, err
+}
+
+// Open implements FS.
+//
+// A directory that exists in both file systems is read at once to merge their entries.
+func (o *Overlay) Open(path impath.Absolute) (File, error) {
+	info, err := o.upper.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o.lower.Open(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return o.upper.Open(path)
+	}
+	lowerInfo, err := o.lower.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && !lowerInfo.IsDir()) {
+		return o.upper.Open(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	names, err := o.ReadDirNames(path)
+	if err != nil {
+		return nil, err
+	}
+	return dirFile(path, names), nil
+}
+
+// ReadDirNames implements FS.
+func (o *Overlay) ReadDirNames(path impath.Absolute) ([]string, error) {
+	info, err := o.upper.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o.lower.ReadDirNames(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	names, err := o.upper.ReadDirNames(path)
+	if err != nil || !info.IsDir() {
+		return names, err
+	}
+	lowerInfo, err := o.lower.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && !lowerInfo.IsDir()) {
+		return names, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	lowerNames, err := o.lower.ReadDirNames(path)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(names))
+	for _, n := range names {
+		seen[n] = true
+	}
+	for _, n := range lowerNames {
+		if !seen[n] {
+			names = append(names, n)
+		}
+	}
+	return names, nil
+}
+
+// Readlink implements FS.
+func (o *Overlay) Readlink(path impath.Absolute) (string, error) {
+	_, err := o.upper.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o.lower.Readlink(path)
+	}
+	return o.upper.Readlink(path)
+}
+
+// ID implements FS.
+func (o *Overlay) ID() string {
+	return fmt.Sprintf("overlay:%s:%s", o.lower.ID(), o.upper.ID())
+}
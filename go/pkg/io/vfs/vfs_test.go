@@ -0,0 +1,153 @@
+package vfs_test
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/io/impath"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/io/vfs"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMemory(t *testing.T) {
+	m := vfs.NewMemory()
+	id := m.ID()
+	if err := m.WriteFile(impath.MustAbs("/a/b/foo.c"), []byte("foo"), 0o755); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := m.Symlink("foo.c", impath.MustAbs("/a/b/bar.c")); err != nil {
+		t.Fatalf("Symlink failed: %v", err)
+	}
+	if err := m.Mkdir(impath.MustAbs("/a/c")); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	if m.ID() == id {
+		t.Errorf("ID did not change after modifications")
+	}
+
+	names, err := m.ReadDirNames(impath.MustAbs("/a/b"))
+	if err != nil {
+		t.Fatalf("ReadDirNames failed: %v", err)
+	}
+	if diff := cmp.Diff([]string{"bar.c", "foo.c"}, names); diff != "" {
+		t.Errorf("names mismatch, (-want +got): %s", diff)
+	}
+
+	info, err := m.Lstat(impath.MustAbs("/a/b/foo.c"))
+	if err != nil {
+		t.Fatalf("Lstat failed: %v", err)
+	}
+	if !info.Mode().IsRegular() || info.Mode().Perm() != 0o755 || info.Size() != 3 || info.Name() != "foo.c" {
+		t.Errorf("unexpected file info: mode=%v, size=%d, name=%s", info.Mode(), info.Size(), info.Name())
+	}
+	f, err := m.Open(impath.MustAbs("/a/b/foo.c"))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	if string(b) != "foo" {
+		t.Errorf("content mismatch: want foo, got %q", b)
+	}
+
+	info, err = m.Lstat(impath.MustAbs("/a/c"))
+	if err != nil || !info.IsDir() {
+		t.Errorf("Lstat of a directory: info=%v, err=%v", info, err)
+	}
+	target, err := m.Readlink(impath.MustAbs("/a/b/bar.c"))
+	if err != nil || target != "foo.c" {
+		t.Errorf("Readlink mismatch: target=%q, err=%v", target, err)
+	}
+	if _, err := m.Lstat(impath.MustAbs("/a/d")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Lstat of a missing path: want %v, got %v", fs.ErrNotExist, err)
+	}
+	if err := m.WriteFile(impath.MustAbs("/a/b/foo.c/baz.c"), nil, 0o644); err == nil {
+		t.Errorf("WriteFile under a file did not fail")
+	}
+}
+
+func TestOverlay(t *testing.T) {
+	tmp := t.TempDir()
+	for p, b := range map[string]string{"a/foo.c": "real foo", "a/bar.c": "real bar", "b": "real b"} {
+		p = filepath.Join(tmp, p)
+		if err := os.MkdirAll(filepath.Dir(p), 0o777); err != nil {
+			t.Fatalf("MkdirAll failed: %v", err)
+		}
+		if err := os.WriteFile(p, []byte(b), 0o644); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+	m := vfs.NewMemory()
+	for p, b := range map[string]string{"a/foo.c": "virtual foo", "a/baz.c": "virtual baz", "b/qux.c": "virtual qux"} {
+		if err := m.WriteFile(impath.MustAbs(tmp, p), []byte(b), 0o644); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+	o := vfs.NewOverlay(vfs.OS, m)
+
+	names, err := o.ReadDirNames(impath.MustAbs(tmp, "a"))
+	if err != nil {
+		t.Fatalf("ReadDirNames failed: %v", err)
+	}
+	sort.Strings(names)
+	if diff := cmp.Diff([]string{"bar.c", "baz.c", "foo.c"}, names); diff != "" {
+		t.Errorf("merged names mismatch, (-want +got): %s", diff)
+	}
+
+	// A virtual directory shadows a real file.
+	names, err = o.ReadDirNames(impath.MustAbs(tmp, "b"))
+	if err != nil {
+		t.Fatalf("ReadDirNames failed: %v", err)
+	}
+	if diff := cmp.Diff([]string{"qux.c"}, names); diff != "" {
+		t.Errorf("shadowed names mismatch, (-want +got): %s", diff)
+	}
+
+	for p, want := range map[string]string{"a/foo.c": "virtual foo", "a/bar.c": "real bar"} {
+		f, err := o.Open(impath.MustAbs(tmp, p))
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		b, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("ReadAll failed: %v", err)
+		}
+		if string(b) != want {
+			t.Errorf("content mismatch for %q: want %q, got %q", p, want, b)
+		}
+	}
+	f, err := o.Open(impath.MustAbs(tmp, "a"))
+	if err != nil {
+		t.Fatalf("Open of a merged directory failed: %v", err)
+	}
+	defer f.Close()
+	names = nil
+	for {
+		batch, err := f.Readdirnames(2)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Readdirnames failed: %v", err)
+		}
+		if len(batch) > 2 {
+			t.Errorf("Readdirnames(2) returned %d names", len(batch))
+		}
+		names = append(names, batch...)
+	}
+	sort.Strings(names)
+	if diff := cmp.Diff([]string{"bar.c", "baz.c", "foo.c"}, names); diff != "" {
+		t.Errorf("batched names mismatch, (-want +got): %s", diff)
+	}
+	if o.ID() == vfs.OS.ID() || o.ID() == m.ID() {
+		t.Errorf("overlay ID %q is not distinct from its layers", o.ID())
+	}
+}
@@ -1,6 +1,13 @@
 package walker
 
-import "io/fs"
+import (
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/io/glob"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/io/impath"
+)
 
 // Filter defines an interface for matching paths during traversal.
 // The matching semantics, whether matches are included or excluded, is defined by the consumer.
@@ -38,3 +45,54 @@ func (f Filter) String() string {
 	}
 	return f.ID()
 }
+
+// NewGlobFilter returns a filter that matches the paths under root that match any of the exclude patterns.
+// If include is not empty, it also matches the non-directory entries that do not match any of the include patterns,
+// and the directories that cannot have any such entries as descendants.
+// Used as an exclusion filter, it retains the include patterns minus the exclude patterns. Paths outside root never match.
+func NewGlobFilter(root impath.Absolute, include, exclude []*glob.Pattern) Filter {
+	relPath := func(path string) (string, bool) {
+		abs, err := impath.Abs(path)
+		if err != nil {
+			return "", false
+		}
+		rel, err := impath.Descendant(root, abs)
+		if err != nil || rel.String() == "." || rel.String() == "" {
+			return "", false
+		}
+		return filepath.ToSlash(rel.String()), true
+	}
+	var id strings.Builder
+	id.WriteString("glob:")
+	id.WriteString(root.String())
+	for _, p := range include {
+		id.WriteString(":+")
+		id.WriteString(p.String())
+	}
+	for _, p := range exclude {
+		id.WriteString(":-")
+		id.WriteString(p.String())
+	}
+	return Filter{
+		Path: func(path string) bool {
+			rel, ok := relPath(path)
+			return ok && glob.MatchAny(exclude, rel)
+		},
+		File: func(path string, mode fs.FileMode) bool {
+			rel, ok := relPath(path)
+			if !ok || len(include) == 0 {
+				return false
+			}
+			if !mode.IsDir() {
+				return !glob.MatchAny(include, rel)
+			}
+			for _, p := range include {
+				if p.MatchPrefix(rel) {
+					return false
+				}
+			}
+			return true
+		},
+		ID: id.String,
+	}
+}
@@ -4,9 +4,9 @@ package walker
 import (
 	"io"
 	"io/fs"
-	"os"
 
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/io/impath"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/io/vfs"
 )
 
 // Internal shared actions.
@@ -101,6 +101,11 @@ type elem struct {
 //
 // The client may at any point return false from any callback to cancel the entire walk.
 func DepthFirst(root impath.Absolute, exclude Filter, cb Callback) {
+	DepthFirstFS(vfs.OS, root, exclude, cb)
+}
+
+// DepthFirstFS is like DepthFirst, but walks the specified file system instead of the real one.
+func DepthFirstFS(fsys vfs.FS, root impath.Absolute, exclude Filter, cb Callback) {
 	pending := &stack{}
 	pending.push(elem{realPath: root, path: root})
 	// parentIndex keeps track of the last directory so deferred children can be scheduled to be visited before it.
@@ -120,7 +125,7 @@ func DepthFirst(root impath.Absolute, exclude Filter, cb Callback) {
 
 		// If it's a previously pre-accessed directory, process its children.
 		if !e.deferredParent && e.info != nil && e.info.IsDir() {
-			deferred, action := processDir(e, exclude, cb)
+			deferred, action := processDir(fsys, e, exclude, cb)
 			if action == aCancel {
 				return
 			}
@@ -142,7 +147,7 @@ func DepthFirst(root impath.Absolute, exclude Filter, cb Callback) {
 
 		// For new paths, pre-access.
 		// For pre-accessed paths (including processed directories), post-access.
-		deferredElem, action := visit(e, exclude, cb)
+		deferredElem, action := visit(fsys, e, exclude, cb)
 		if action == aCancel {
 			return
 		}
@@ -171,7 +176,7 @@ func DepthFirst(root impath.Absolute, exclude Filter, cb Callback) {
 //
 //	*elem is nil unless the visit has a deferred path, which is either a directory that was not post-accessed or a symlink target.
 //	int is an action that is one of aRead, aDefer, aSkip, or aCancel.
-func visit(e elem, exclude Filter, cb Callback) (*elem, int) {
+func visit(fsys vfs.FS, e elem, exclude Filter, cb Callback) (*elem, int) {
 	// If the filter applies to the path only, use it here.
 	if exclude.MatchPath(e.path.String()) {
 		return nil, aSkip
@@ -185,7 +190,7 @@ func visit(e elem, exclude Filter, cb Callback) (*elem, int) {
 			return nil, int(action)
 		}
 
-		info, err := os.Lstat(e.realPath.String())
+		info, err := fsys.Lstat(e.realPath)
 		if err != nil {
 			if ok := cb.Err(e.path, e.realPath, err); !ok {
 				return nil, aCancel
@@ -221,7 +226,7 @@ func visit(e elem, exclude Filter, cb Callback) (*elem, int) {
 		return nil, aSkip
 	}
 
-	content, errTarget := os.Readlink(e.realPath.String())
+	content, errTarget := fsys.Readlink(e.realPath)
 	if errTarget != nil {
 		if ok := cb.Err(e.path, e.realPath, errTarget); !ok {
 			return nil, aCancel
@@ -254,10 +259,10 @@ func visit(e elem, exclude Filter, cb Callback) (*elem, int) {
 //
 //	[]any is nil unless the directory had deferred-children, which includes directories and client-deferred paths.
 //	int is an action that is forwarded from the visit.
-func processDir(dirElem elem, exclude Filter, cb Callback) ([]any, int) {
+func processDir(fsys vfs.FS, dirElem elem, exclude Filter, cb Callback) ([]any, int) {
 	var deferred []any
 
-	f, errOpen := os.Open(dirElem.realPath.String())
+	f, errOpen := fsys.Open(dirElem.realPath)
 	if errOpen != nil {
 		if ok := cb.Err(dirElem.path, dirElem.realPath, errOpen); !ok {
 			return deferred, aCancel
@@ -287,7 +292,7 @@ func processDir(dirElem elem, exclude Filter, cb Callback) ([]any, int) {
 
 			// Pre-access.
 			e := elem{realPath: rp, path: p}
-			deferredElem, action := visit(e, exclude, cb)
+			deferredElem, action := visit(fsys, e, exclude, cb)
 			if action == aCancel {
 				return deferred, aCancel
 			}
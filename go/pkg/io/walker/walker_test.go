@@ -4,10 +4,13 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"testing"
 
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/io/glob"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/io/impath"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/io/vfs"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/io/walker"
 	"github.com/google/go-cmp/cmp"
 )
@@ -400,3 +403,41 @@ func validateSequence(t *testing.T, seq []string, fsLayout map[string][]string)
 	}
 	return pathVisitCount
 }
+
+func TestWalker_GlobFilter(t *testing.T) {
+	m := vfs.NewMemory()
+	for _, p := range []string{"/root/a/x.go", "/root/a/y.txt", "/root/a/testdata/z.go", "/root/b/w.txt", "/root/v.go"} {
+		if err := m.WriteFile(impath.MustAbs(p), nil, 0o644); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+	include, err := glob.CompileAll([]string{"**/*.go"})
+	if err != nil {
+		t.Fatalf("CompileAll failed: %v", err)
+	}
+	exclude, err := glob.CompileAll([]string{"**/testdata"})
+	if err != nil {
+		t.Fatalf("CompileAll failed: %v", err)
+	}
+	root := impath.MustAbs("/root")
+	var visited []string
+	walker.DepthFirstFS(m, root, walker.NewGlobFilter(root, include, exclude), walker.Callback{
+		Err: func(_ impath.Absolute, _ impath.Absolute, err error) bool {
+			t.Errorf("unexpected error: %v", err)
+			return false
+		},
+		Pre: func(_ impath.Absolute, _ impath.Absolute) (walker.PreAction, bool) {
+			return walker.Access, true
+		},
+		Post: func(path impath.Absolute, _ impath.Absolute, info fs.FileInfo) bool {
+			if !info.IsDir() {
+				visited = append(visited, path.String())
+			}
+			return true
+		},
+	})
+	sort.Strings(visited)
+	if diff := cmp.Diff([]string{"/root/a/x.go", "/root/v.go"}, visited); diff != "" {
+		t.Errorf("visited files mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,217 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	// Redundant imports are required for the google3 mirror. Aliases should not be changed.
+	bsgrpc "google.golang.org/genproto/googleapis/bytestream"
+	bspb "google.golang.org/genproto/googleapis/bytestream"
+)
+
+// readChunkSize is the maximum size of the data of a single ReadResponse.
+const readChunkSize = 1024 * 1024
+
+// parseBlobName parses the digest and compression of a resource name of the form
+// "[instance/][uploads/<uuid>/]{blobs|compressed-blobs/zstd}/<hash>/<size>[/<metadata>]". The instance
+// name is ignored.
+func parseBlobName(name string, upload bool) (dg digest.Digest, compressed bool, err error) {
+	segs := strings.Split(name, "/")
+	i := 0
+	if upload {
+		for i < len(segs) && segs[i] != "uploads" {
+			i++
+		}
+		i += 2
+	} else {
+		for i < len(segs) && segs[i] != "blobs" && segs[i] != "compressed-blobs" {
+			i++
+		}
+	}
+	if i >= len(segs) {
+		return digest.Digest{}, false, status.Errorf(codes.InvalidArgument, "invalid resource name %q", name)
+	}
+	switch segs[i] {
+	case "blobs":
+		i++
+	case "compressed-blobs":
+		if i+1 >= len(segs) || segs[i+1] != "zstd" {
+			return digest.Digest{}, false, status.Errorf(codes.InvalidArgument, "unsupported compressor in resource name %q", name)
+		}
+		compressed = true
+		i += 2
+	default:
+		return digest.Digest{}, false, status.Errorf(codes.InvalidArgument, "invalid resource name %q", name)
+	}
+	if i+1 >= len(segs) {
+		return digest.Digest{}, false, status.Errorf(codes.InvalidArgument, "invalid resource name %q", name)
+	}
+	size, err := strconv.ParseInt(segs[i+1], 10, 64)
+	if err != nil {
+		return digest.Digest{}, false, status.Errorf(codes.InvalidArgument, "invalid size in resource name %q", name)
+	}
+	dg, err = digest.New(segs[i], size)
+	if err != nil {
+		return digest.Digest{}, false, status.Errorf(codes.InvalidArgument, "invalid digest in resource name %q: %v", name, err)
+	}
+	return dg, compressed, nil
+}
+
+// Read implements the corresponding ByteStream API function. The blob is served from the local disk
+// CAS, after downloading it from the backend if needed.
+func (s *Server) Read(req *bspb.ReadRequest, stream bsgrpc.ByteStream_ReadServer) error {
+	dg, compressed, err := parseBlobName(req.ResourceName, false)
+	if err != nil {
+		return err
+	}
+	if req.ReadOffset < 0 || req.ReadLimit < 0 {
+		return status.Error(codes.OutOfRange, "negative read offset or limit")
+	}
+	if err := s.fetch(stream.Context(), dg); err != nil {
+		return toStatus(err)
+	}
+	var r io.Reader = strings.NewReader("")
+	if !dg.IsEmpty() {
+		f, err := s.disk.open(dg)
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		defer f.Close()
+		r = f
+	}
+	if compressed {
+		pr, pw := io.Pipe()
+		defer pr.Close()
+		go func(src io.Reader) {
+			enc, err := zstd.NewWriter(pw)
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			if _, err := io.Copy(enc, src); err != nil {
+				enc.Close()
+				pw.CloseWithError(err)
+				return
+			}
+			pw.CloseWithError(enc.Close())
+		}(r)
+		r = pr
+	}
+	if req.ReadOffset > 0 {
+		if _, err := io.CopyN(io.Discard, r, req.ReadOffset); err != nil {
+			return status.Errorf(codes.OutOfRange, "read offset %d is beyond the end of %s", req.ReadOffset, req.ResourceName)
+		}
+	}
+	if req.ReadLimit > 0 {
+		r = io.LimitReader(r, req.ReadLimit)
+	}
+	buf := make([]byte, readChunkSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if sErr := stream.Send(&bspb.ReadResponse{Data: buf[:n]}); sErr != nil {
+				return sErr
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+	}
+}
+
+// Write implements the corresponding ByteStream API function. The blob is stored in the local disk
+// CAS, and then uploaded to the backend unless it is already present there.
+func (s *Server) Write(stream bsgrpc.ByteStream_WriteServer) error {
+	req, err := stream.Recv()
+	if err == io.EOF {
+		return status.Error(codes.InvalidArgument, "no write request received")
+	}
+	if err != nil {
+		return err
+	}
+	dg, compressed, err := parseBlobName(req.ResourceName, true)
+	if err != nil {
+		return err
+	}
+	f, err := s.disk.tempFile()
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	defer os.Remove(f.Name())
+	committed, err := receive(stream, req, f)
+	if cErr := f.Close(); err == nil && cErr != nil {
+		err = status.Error(codes.Internal, cErr.Error())
+	}
+	if err != nil {
+		return err
+	}
+	src, err := os.Open(f.Name())
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	defer src.Close()
+	var r io.Reader = src
+	if compressed {
+		dec, err := zstd.NewReader(src)
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		defer dec.Close()
+		r = dec
+	}
+	if err := s.disk.write(dg, r); err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid data for %s: %v", req.ResourceName, err)
+	}
+	if err := s.upload(stream.Context(), dg); err != nil {
+		return toStatus(err)
+	}
+	return stream.SendAndClose(&bspb.WriteResponse{CommittedSize: committed})
+}
+
+// receive writes the data of all the requests of a Write stream to w, starting with the already
+// received first request, and returns the number of bytes written.
+func receive(stream bsgrpc.ByteStream_WriteServer, req *bspb.WriteRequest, w io.Writer) (int64, error) {
+	var off int64
+	for {
+		if req.WriteOffset != off {
+			return 0, status.Errorf(codes.InvalidArgument, "write offset %d, expected %d", req.WriteOffset, off)
+		}
+		if _, err := w.Write(req.Data); err != nil {
+			return 0, status.Error(codes.Internal, err.Error())
+		}
+		off += int64(len(req.Data))
+		if req.FinishWrite {
+			return off, nil
+		}
+		var err error
+		if req, err = stream.Recv(); err == io.EOF {
+			return 0, status.Error(codes.InvalidArgument, "write stream ended without finish_write")
+		} else if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// QueryWriteStatus implements the corresponding ByteStream API function. Resumable writes are not
+// supported, so it only reports whether the blob is already complete.
+func (s *Server) QueryWriteStatus(ctx context.Context, req *bspb.QueryWriteStatusRequest) (*bspb.QueryWriteStatusResponse, error) {
+	dg, _, err := parseBlobName(req.ResourceName, true)
+	if err != nil {
+		return nil, err
+	}
+	if s.isPresent(dg) {
+		return &bspb.QueryWriteStatusResponse{CommittedSize: dg.Size, Complete: true}, nil
+	}
+	return &bspb.QueryWriteStatusResponse{}, nil
+}
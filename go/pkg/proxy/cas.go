@@ -0,0 +1,313 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"sync/atomic"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/uploadinfo"
+	"github.com/klauspost/compress/zstd"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	// Redundant imports are required for the google3 mirror. Aliases should not be changed.
+	regrpc "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+)
+
+var (
+	// zstdEncoder and zstdDecoder are stateless instances, and must only be used with EncodeAll and
+	// DecodeAll.
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// missingQuery is an in flight FindMissingBlobs query for a single digest.
+type missingQuery struct {
+	done    chan struct{}
+	missing bool
+	err     error
+}
+
+func (s *Server) isPresent(dg digest.Digest) bool {
+	return dg.IsEmpty() || s.present.has(dg)
+}
+
+func (s *Server) markPresent(dg digest.Digest) {
+	s.present.add(dg)
+}
+
+// findMissing returns the digests that are missing from the remote CAS. Digests already known to
+// be present are not forwarded, and digests that are being queried by another caller are not
+// queried again. The query outlives the caller that started it, so every caller only stops waiting
+// for it when its own context is done.
+func (s *Server) findMissing(ctx context.Context, dgs []digest.Digest) ([]digest.Digest, error) {
+	atomic.AddInt64(&s.stats.FindMissingDigests, int64(len(dgs)))
+	var mine []digest.Digest
+	waits := make(map[digest.Digest]*missingQuery)
+	s.mu.Lock()
+	for _, dg := range dgs {
+		if _, ok := waits[dg]; ok || s.isPresent(dg) {
+			continue
+		}
+		q, ok := s.queries[dg]
+		if !ok {
+			q = &missingQuery{done: make(chan struct{})}
+			s.queries[dg] = q
+			mine = append(mine, dg)
+		}
+		waits[dg] = q
+	}
+	s.mu.Unlock()
+
+	if len(mine) > 0 {
+		atomic.AddInt64(&s.stats.FindMissingForwarded, int64(len(mine)))
+		go s.queryMissing(mine)
+	}
+
+	var res []digest.Digest
+	for dg, q := range waits {
+		select {
+		case <-q.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if q.err != nil {
+			return nil, q.err
+		}
+		if q.missing {
+			res = append(res, dg)
+		}
+	}
+	return res, nil
+}
+
+// queryMissing queries the backend for the given digests and completes their in flight queries.
+func (s *Server) queryMissing(dgs []digest.Digest) {
+	ctx, cancel := s.sharedCtx()
+	defer cancel()
+	missing, err := s.c.MissingBlobs(ctx, dgs)
+	isMissing := make(map[digest.Digest]bool)
+	for _, dg := range missing {
+		isMissing[dg] = true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, dg := range dgs {
+		q := s.queries[dg]
+		q.missing, q.err = isMissing[dg], err
+		if err == nil && !q.missing {
+			s.markPresent(dg)
+		}
+		delete(s.queries, dg)
+		close(q.done)
+	}
+}
+
+// upload uploads a blob of the local disk CAS, unless it is known to be present remotely or is
+// already being uploaded. The upload is shared with the concurrent callers, so it continues if ctx is
+// done, but the caller stops waiting for it.
+func (s *Server) upload(ctx context.Context, dg digest.Digest) error {
+	if s.isPresent(dg) {
+		atomic.AddInt64(&s.stats.UploadsDeduped, 1)
+		return nil
+	}
+	return wait(ctx, func() error {
+		uploaded := false
+		_, err := s.uploads.LoadOrStore(dg, func() (interface{}, error) {
+			uploaded = true
+			atomic.AddInt64(&s.stats.Uploads, 1)
+			ctx, cancel := s.sharedCtx()
+			defer cancel()
+			_, _, err := s.c.UploadIfMissing(ctx, uploadinfo.EntryFromFile(dg, s.disk.path(dg)))
+			if err == nil {
+				s.markPresent(dg)
+			}
+			return nil, err
+		})
+		if !uploaded {
+			atomic.AddInt64(&s.stats.UploadsDeduped, 1)
+		}
+		// The blob is now known to be present, or the upload failed and may be retried.
+		s.uploads.Delete(dg)
+		return err
+	})
+}
+
+// fetch makes sure that a blob is in the local disk CAS, downloading it if necessary. The download is
+// shared with the concurrent callers, so it continues if ctx is done, but the caller stops waiting for it.
+func (s *Server) fetch(ctx context.Context, dg digest.Digest) error {
+	if dg.IsEmpty() || s.disk.has(dg) {
+		atomic.AddInt64(&s.stats.LocalReads, 1)
+		return nil
+	}
+	return wait(ctx, func() error {
+		return s.download(dg)
+	})
+}
+
+// download downloads a blob into the local disk CAS, unless it is already being downloaded.
+func (s *Server) download(dg digest.Digest) error {
+	_, err := s.downloads.LoadOrStore(dg, func() (interface{}, error) {
+		atomic.AddInt64(&s.stats.RemoteReads, 1)
+		f, err := s.disk.tempFile()
+		if err != nil {
+			return nil, err
+		}
+		f.Close()
+		ctx, cancel := s.sharedCtx()
+		defer cancel()
+		if _, err := s.c.ReadBlobToFile(ctx, dg, f.Name()); err != nil {
+			os.Remove(f.Name())
+			return nil, err
+		}
+		if err := s.disk.commit(f.Name(), dg); err != nil {
+			return nil, err
+		}
+		s.markPresent(dg)
+		return nil, nil
+	})
+	// The blob is now on disk, or the download failed and may be retried.
+	s.downloads.Delete(dg)
+	return err
+}
+
+// wait runs fn, which is shared with other callers, in the background and waits for it until ctx is done.
+func wait(ctx context.Context, fn func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// FindMissingBlobs implements the corresponding RE API function.
+func (s *Server) FindMissingBlobs(ctx context.Context, req *repb.FindMissingBlobsRequest) (*repb.FindMissingBlobsResponse, error) {
+	var dgs []digest.Digest
+	for _, d := range req.BlobDigests {
+		dg, err := digest.NewFromProto(d)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		dgs = append(dgs, dg)
+	}
+	missing, err := s.findMissing(ctx, dgs)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	res := &repb.FindMissingBlobsResponse{}
+	for _, dg := range missing {
+		res.MissingBlobDigests = append(res.MissingBlobDigests, dg.ToProto())
+	}
+	return res, nil
+}
+
+// BatchUpdateBlobs implements the corresponding RE API function.
+func (s *Server) BatchUpdateBlobs(ctx context.Context, req *repb.BatchUpdateBlobsRequest) (*repb.BatchUpdateBlobsResponse, error) {
+	res := &repb.BatchUpdateBlobsResponse{Responses: make([]*repb.BatchUpdateBlobsResponse_Response, len(req.Requests))}
+	eg, eCtx := errgroup.WithContext(ctx)
+	for i, r := range req.Requests {
+		i, r := i, r
+		res.Responses[i] = &repb.BatchUpdateBlobsResponse_Response{Digest: r.Digest}
+		eg.Go(func() error {
+			res.Responses[i].Status = status.Convert(toStatus(s.batchUpdate(eCtx, r))).Proto()
+			return nil
+		})
+	}
+	eg.Wait()
+	return res, nil
+}
+
+func (s *Server) batchUpdate(ctx context.Context, r *repb.BatchUpdateBlobsRequest_Request) error {
+	dg, err := digest.NewFromProto(r.Digest)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	data := r.Data
+	switch r.Compressor {
+	case repb.Compressor_IDENTITY:
+	case repb.Compressor_ZSTD:
+		if data, err = zstdDecoder.DecodeAll(r.Data, nil); err != nil {
+			return status.Errorf(codes.InvalidArgument, "invalid zstd data: %v", err)
+		}
+	default:
+		return status.Errorf(codes.InvalidArgument, "unsupported compressor %v", r.Compressor)
+	}
+	if err := s.disk.write(dg, bytes.NewReader(data)); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return s.upload(ctx, dg)
+}
+
+// BatchReadBlobs implements the corresponding RE API function.
+func (s *Server) BatchReadBlobs(ctx context.Context, req *repb.BatchReadBlobsRequest) (*repb.BatchReadBlobsResponse, error) {
+	useZstd := false
+	for _, c := range req.AcceptableCompressors {
+		useZstd = useZstd || c == repb.Compressor_ZSTD
+	}
+	res := &repb.BatchReadBlobsResponse{Responses: make([]*repb.BatchReadBlobsResponse_Response, len(req.Digests))}
+	eg, eCtx := errgroup.WithContext(ctx)
+	for i, d := range req.Digests {
+		i, d := i, d
+		r := &repb.BatchReadBlobsResponse_Response{Digest: d}
+		res.Responses[i] = r
+		eg.Go(func() error {
+			data, err := s.readBlob(eCtx, d)
+			if err != nil {
+				r.Status = status.Convert(toStatus(err)).Proto()
+				return nil
+			}
+			r.Data = data
+			if useZstd {
+				r.Data = zstdEncoder.EncodeAll(data, nil)
+				r.Compressor = repb.Compressor_ZSTD
+			}
+			r.Status = status.New(codes.OK, "").Proto()
+			return nil
+		})
+	}
+	eg.Wait()
+	return res, nil
+}
+
+func (s *Server) readBlob(ctx context.Context, d *repb.Digest) ([]byte, error) {
+	dg, err := digest.NewFromProto(d)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if dg.IsEmpty() {
+		return nil, nil
+	}
+	if err := s.fetch(ctx, dg); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(s.disk.path(dg))
+}
+
+// GetTree implements the corresponding RE API function.
+func (s *Server) GetTree(req *repb.GetTreeRequest, stream regrpc.ContentAddressableStorage_GetTreeServer) error {
+	req = proto.Clone(req).(*repb.GetTreeRequest)
+	req.InstanceName = s.c.InstanceName
+	up, err := s.c.GetTree(stream.Context(), req)
+	if err != nil {
+		return toStatus(err)
+	}
+	for {
+		resp, err := up.Recv()
+		if err != nil {
+			return relayEnd(err)
+		}
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+)
+
+// diskCAS is a content addressable store of blobs in a local directory. Blobs are written to
+// temporary files and renamed into place once verified, so several processes can share the
+// directory.
+type diskCAS struct {
+	root string
+}
+
+func newDiskCAS(root string) (*diskCAS, error) {
+	if err := os.MkdirAll(filepath.Join(root, "tmp"), 0o755); err != nil {
+		return nil, fmt.Errorf("proxy: failed to create cache directory: %w", err)
+	}
+	return &diskCAS{root: root}, nil
+}
+
+func (d *diskCAS) path(dg digest.Digest) string {
+	return filepath.Join(d.root, dg.Hash[:2], fmt.Sprintf("%s_%d", dg.Hash, dg.Size))
+}
+
+func (d *diskCAS) has(dg digest.Digest) bool {
+	_, err := os.Stat(d.path(dg))
+	return err == nil
+}
+
+func (d *diskCAS) open(dg digest.Digest) (*os.File, error) {
+	return os.Open(d.path(dg))
+}
+
+func (d *diskCAS) tempFile() (*os.File, error) {
+	return os.CreateTemp(filepath.Join(d.root, "tmp"), "blob")
+}
+
+// commit verifies that the temporary file has the expected digest and moves it into place. The
+// temporary file is removed in all cases.
+func (d *diskCAS) commit(tmp string, dg digest.Digest) error {
+	defer os.Remove(tmp)
+	got, err := digest.NewFromFile(tmp)
+	if err != nil {
+		return err
+	}
+	if got != dg {
+		return fmt.Errorf("blob has digest %v, expected %v", got, dg)
+	}
+	p := d.path(dg)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+// write stores a blob with the given digest, read from r.
+func (d *diskCAS) write(dg digest.Digest, r io.Reader) error {
+	if d.has(dg) {
+		return nil
+	}
+	f, err := d.tempFile()
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cErr := f.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return d.commit(f.Name(), dg)
+}
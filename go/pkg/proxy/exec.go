@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"context"
+	"io"
+
+	"google.golang.org/protobuf/proto"
+
+	// Redundant imports are required for the google3 mirror. Aliases should not be changed.
+	regrpc "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+)
+
+// relayEnd returns the status with which to end a relayed stream after receiving err from the
+// backend.
+func relayEnd(err error) error {
+	if err == io.EOF {
+		return nil
+	}
+	return toStatus(err)
+}
+
+// GetCapabilities implements the corresponding RE API function. ByteStream and batch compression
+// are handled by the proxy, so zstd is always advertised.
+func (s *Server) GetCapabilities(ctx context.Context, req *repb.GetCapabilitiesRequest) (*repb.ServerCapabilities, error) {
+	caps, err := s.c.GetCapabilities(ctx)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	caps = proto.Clone(caps).(*repb.ServerCapabilities)
+	if cc := caps.CacheCapabilities; cc != nil {
+		cc.SupportedCompressors = []repb.Compressor_Value{repb.Compressor_ZSTD}
+		cc.SupportedBatchUpdateCompressors = []repb.Compressor_Value{repb.Compressor_ZSTD}
+	}
+	return caps, nil
+}
+
+// GetActionResult implements the corresponding RE API function.
+func (s *Server) GetActionResult(ctx context.Context, req *repb.GetActionResultRequest) (*repb.ActionResult, error) {
+	req = proto.Clone(req).(*repb.GetActionResultRequest)
+	req.InstanceName = s.c.InstanceName
+	res, err := s.c.GetActionResult(ctx, req)
+	return res, toStatus(err)
+}
+
+// UpdateActionResult implements the corresponding RE API function.
+func (s *Server) UpdateActionResult(ctx context.Context, req *repb.UpdateActionResultRequest) (*repb.ActionResult, error) {
+	req = proto.Clone(req).(*repb.UpdateActionResultRequest)
+	req.InstanceName = s.c.InstanceName
+	res, err := s.c.UpdateActionResult(ctx, req)
+	return res, toStatus(err)
+}
+
+// Execute implements the corresponding RE API function. Operations are relayed as they are
+// received from the backend.
+func (s *Server) Execute(req *repb.ExecuteRequest, stream regrpc.Execution_ExecuteServer) error {
+	req = proto.Clone(req).(*repb.ExecuteRequest)
+	req.InstanceName = s.c.InstanceName
+	up, err := s.c.Execute(stream.Context(), req)
+	if err != nil {
+		return toStatus(err)
+	}
+	return relayOperations(up, stream)
+}
+
+// WaitExecution implements the corresponding RE API function.
+func (s *Server) WaitExecution(req *repb.WaitExecutionRequest, stream regrpc.Execution_WaitExecutionServer) error {
+	up, err := s.c.WaitExecution(stream.Context(), req)
+	if err != nil {
+		return toStatus(err)
+	}
+	return relayOperations(up, stream)
+}
+
+func relayOperations(up regrpc.Execution_ExecuteClient, down regrpc.Execution_ExecuteServer) error {
+	for {
+		op, err := up.Recv()
+		if err != nil {
+			return relayEnd(err)
+		}
+		if err := down.Send(op); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+)
+
+// presentSet holds the digests recently seen in the remote CAS. Each digest is forgotten after a TTL,
+// since the backend may evict it, and the oldest digests are forgotten first when the set is full.
+type presentSet struct {
+	ttl time.Duration
+	max int
+	// now is time.Now, replaced in tests.
+	now func() time.Time
+
+	mu sync.Mutex
+	// added is the time at which each digest was added.
+	added map[digest.Digest]time.Time
+	// order are the additions, oldest first. An addition is stale if its digest was added again since.
+	order []presentEntry
+}
+
+type presentEntry struct {
+	dg    digest.Digest
+	added time.Time
+}
+
+func newPresentSet(ttl time.Duration, max int) *presentSet {
+	return &presentSet{
+		ttl:   ttl,
+		max:   max,
+		now:   time.Now,
+		added: make(map[digest.Digest]time.Time),
+	}
+}
+
+// has returns whether the digest was added less than the TTL ago.
+func (p *presentSet) has(dg digest.Digest) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t, ok := p.added[dg]
+	return ok && p.now().Sub(t) < p.ttl
+}
+
+// add adds the digest, unless it is already present, and forgets the expired and excess digests.
+func (p *presentSet) add(dg digest.Digest) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	if t, ok := p.added[dg]; ok && now.Sub(t) < p.ttl {
+		return
+	}
+	p.added[dg] = now
+	p.order = append(p.order, presentEntry{dg: dg, added: now})
+	for len(p.order) > 0 {
+		e := p.order[0]
+		if t, ok := p.added[e.dg]; ok && t.Equal(e.added) {
+			if len(p.added) <= p.max && now.Sub(t) < p.ttl {
+				break
+			}
+			delete(p.added, e.dg)
+		}
+		p.order = p.order[1:]
+	}
+}
@@ -0,0 +1,224 @@
+// Package proxy implements a local REAPI server that forwards all the requests it receives to a
+// remote backend through a single client.Client.
+//
+// Many short-lived SDK clients (e.g. one rexec process per action) can connect to the proxy over a
+// Unix socket instead of each dialing and authenticating with the backend. The proxy shares their
+// upload deduplication state: concurrent identical uploads and FindMissingBlobs queries are only
+// forwarded once, blobs known to be present remotely are never uploaded again, and all blobs that
+// pass through the proxy are kept in a local disk CAS from which subsequent reads are served.
+//
+// The backend calls shared by several callers run on a context of the proxy, with a timeout, so that
+// a caller going away does not fail the others. Blobs are only assumed to be present remotely for a
+// limited time, since the backend may evict them. The local disk CAS is never evicted and grows without
+// bound; it should be cleaned up externally, e.g. removed while no proxy is using it.
+//
+// The proxy serves a single instance, the one of its client. The instance name of incoming requests
+// is ignored.
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/cache"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/client"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	// Redundant imports are required for the google3 mirror. Aliases should not be changed.
+	regrpc "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	bsgrpc "google.golang.org/genproto/googleapis/bytestream"
+)
+
+const (
+	// DefaultCallTimeout is the default timeout of the backend calls shared by several callers.
+	DefaultCallTimeout = 10 * time.Minute
+	// DefaultPresentTTL is the default time for which a blob is assumed to be present remotely.
+	DefaultPresentTTL = 10 * time.Minute
+	// DefaultMaxPresent is the default maximum number of digests remembered as present remotely.
+	DefaultMaxPresent = 1 << 20
+)
+
+// Options configures a Server.
+type Options struct {
+	// CacheDir is the directory of the local disk CAS. It is created if it does not exist, and may
+	// be shared by several proxies. Blobs are never removed from it.
+	CacheDir string
+	// CallTimeout is the timeout of the backend calls shared by several callers, which do not run on
+	// the context of any of them. Defaults to DefaultCallTimeout.
+	CallTimeout time.Duration
+	// PresentTTL is the time for which a blob seen in the remote CAS is assumed to still be there.
+	// Defaults to DefaultPresentTTL.
+	PresentTTL time.Duration
+	// MaxPresent is the maximum number of digests remembered as present remotely. The oldest ones are
+	// forgotten first. Defaults to DefaultMaxPresent.
+	MaxPresent int
+}
+
+// Stats are counters of the work done by a Server.
+type Stats struct {
+	// FindMissingDigests is the number of digests queried by callers.
+	FindMissingDigests int64
+	// FindMissingForwarded is the number of digests queried from the backend.
+	FindMissingForwarded int64
+	// Uploads is the number of blobs uploaded to the backend.
+	Uploads int64
+	// UploadsDeduped is the number of blob uploads by callers that were not forwarded, because the
+	// blob was already present or being uploaded.
+	UploadsDeduped int64
+	// LocalReads is the number of blob reads served from the local disk CAS.
+	LocalReads int64
+	// RemoteReads is the number of blobs downloaded from the backend.
+	RemoteReads int64
+}
+
+// Server is the proxy server. It is safe for concurrent use.
+type Server struct {
+	c    *client.Client
+	disk *diskCAS
+
+	// ctx is the context of the shared backend calls, cancelled when the proxy stops.
+	ctx         context.Context
+	cancel      context.CancelFunc
+	callTimeout time.Duration
+
+	// present holds the digests that were recently seen in the remote CAS.
+	present *presentSet
+	// uploads deduplicates concurrent uploads of the same blob.
+	uploads cache.SingleFlight
+	// downloads deduplicates concurrent downloads of the same blob.
+	downloads cache.SingleFlight
+
+	mu sync.Mutex
+	// queries are the in flight FindMissingBlobs queries, by digest.
+	queries map[digest.Digest]*missingQuery
+
+	stats Stats
+	srv   *grpc.Server
+}
+
+// New returns a proxy forwarding requests through c.
+func New(c *client.Client, opts *Options) (*Server, error) {
+	if opts == nil || opts.CacheDir == "" {
+		return nil, errors.New("proxy: a cache directory must be specified")
+	}
+	disk, err := newDiskCAS(opts.CacheDir)
+	if err != nil {
+		return nil, err
+	}
+	callTimeout := opts.CallTimeout
+	if callTimeout <= 0 {
+		callTimeout = DefaultCallTimeout
+	}
+	ttl := opts.PresentTTL
+	if ttl <= 0 {
+		ttl = DefaultPresentTTL
+	}
+	maxPresent := opts.MaxPresent
+	if maxPresent <= 0 {
+		maxPresent = DefaultMaxPresent
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Server{
+		c:           c,
+		disk:        disk,
+		ctx:         ctx,
+		cancel:      cancel,
+		callTimeout: callTimeout,
+		present:     newPresentSet(ttl, maxPresent),
+		queries:     make(map[digest.Digest]*missingQuery),
+	}, nil
+}
+
+// Register registers the REAPI services of the proxy on a gRPC server.
+func (s *Server) Register(srv *grpc.Server) {
+	regrpc.RegisterCapabilitiesServer(srv, s)
+	regrpc.RegisterActionCacheServer(srv, s)
+	regrpc.RegisterContentAddressableStorageServer(srv, s)
+	regrpc.RegisterExecutionServer(srv, s)
+	bsgrpc.RegisterByteStreamServer(srv, s)
+}
+
+// Serve serves the proxy on the listener until Stop is called.
+func (s *Server) Serve(lis net.Listener) error {
+	s.mu.Lock()
+	if s.srv != nil {
+		s.mu.Unlock()
+		return errors.New("proxy: already serving")
+	}
+	s.srv = grpc.NewServer()
+	s.Register(s.srv)
+	srv := s.srv
+	s.mu.Unlock()
+	return srv.Serve(lis)
+}
+
+// Stop stops serving, waiting for in flight requests to complete, and cancels the remaining backend calls.
+func (s *Server) Stop() {
+	s.mu.Lock()
+	srv := s.srv
+	s.mu.Unlock()
+	if srv != nil {
+		srv.GracefulStop()
+	}
+	s.cancel()
+}
+
+// sharedCtx returns the context of a backend call that may be shared by several callers. It is detached
+// from the context of the caller that starts the call, so that the others do not fail if it goes away.
+func (s *Server) sharedCtx() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(s.ctx, s.callTimeout)
+}
+
+// Stats returns a snapshot of the counters of the proxy.
+func (s *Server) Stats() Stats {
+	return Stats{
+		FindMissingDigests:   atomic.LoadInt64(&s.stats.FindMissingDigests),
+		FindMissingForwarded: atomic.LoadInt64(&s.stats.FindMissingForwarded),
+		Uploads:              atomic.LoadInt64(&s.stats.Uploads),
+		UploadsDeduped:       atomic.LoadInt64(&s.stats.UploadsDeduped),
+		LocalReads:           atomic.LoadInt64(&s.stats.LocalReads),
+		RemoteReads:          atomic.LoadInt64(&s.stats.RemoteReads),
+	}
+}
+
+// ListenUnix listens on a Unix socket at path, removing a stale socket left by a previous proxy.
+// Clients can connect to it with a service of the form "unix:///path".
+func ListenUnix(path string) (net.Listener, error) {
+	if fi, err := os.Stat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("proxy: %s exists and is not a socket", path)
+		}
+		// Only remove the socket if nobody is serving on it.
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("proxy: %s is already being served", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen("unix", path)
+}
+
+// toStatus returns err as a gRPC status error, preserving the code of wrapped status errors.
+func toStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	if st, ok := status.FromError(err); ok {
+		return status.Error(st.Code(), err.Error())
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return status.FromContextError(err).Err()
+	}
+	return status.Error(codes.Unknown, err.Error())
+}
@@ -0,0 +1,259 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/client"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/command"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/fakes"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/filemetadata"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/outerr"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/rexec"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	// Redundant imports are required for the google3 mirror. Aliases should not be changed.
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+)
+
+type testEnv struct {
+	backend *fakes.Server
+	proxy   *Server
+	socket  string
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	ctx := context.Background()
+	backend, err := fakes.NewServer(t)
+	if err != nil {
+		t.Fatalf("Error starting fake server: %v", err)
+	}
+	t.Cleanup(backend.Stop)
+	c, err := backend.NewTestClient(ctx)
+	if err != nil {
+		t.Fatalf("Error connecting to fake server: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	p, err := New(c, &Options{CacheDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	socket := filepath.Join(t.TempDir(), "proxy.sock")
+	lis, err := ListenUnix(socket)
+	if err != nil {
+		t.Fatalf("ListenUnix(%q) failed: %v", socket, err)
+	}
+	go p.Serve(lis)
+	t.Cleanup(p.Stop)
+	return &testEnv{backend: backend, proxy: p, socket: socket}
+}
+
+func (e *testEnv) newClient(t *testing.T, opts ...client.Opt) *client.Client {
+	t.Helper()
+	c, err := client.NewClient(context.Background(), "instance", client.DialParams{
+		Service:    "unix://" + e.socket,
+		NoSecurity: true,
+	}, opts...)
+	if err != nil {
+		t.Fatalf("Error connecting to proxy: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestProxyDedupsUploads(t *testing.T) {
+	ctx := context.Background()
+	e := newTestEnv(t)
+	blob := bytes.Repeat([]byte("proxy"), 100000)
+	dg := digest.NewFromBlob(blob)
+
+	const numClients = 10
+	var wg sync.WaitGroup
+	for i := 0; i < numClients; i++ {
+		c := e.newClient(t)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.WriteBlob(ctx, blob); err != nil {
+				t.Errorf("WriteBlob() failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got, ok := e.backend.CAS.Get(dg); !ok || !bytes.Equal(got, blob) {
+		t.Errorf("blob %v was not uploaded to the backend", dg)
+	}
+	if n := e.backend.CAS.BlobWrites(dg); n != 1 {
+		t.Errorf("backend received %d writes of %v, want 1", n, dg)
+	}
+	st := e.proxy.Stats()
+	if st.Uploads != 1 || st.UploadsDeduped != numClients-1 {
+		t.Errorf("Stats() = %+v, want 1 upload and %d deduped", st, numClients-1)
+	}
+
+	// The blob is now known to be present, so queries for it are not forwarded.
+	absent := digest.NewFromBlob([]byte("absent"))
+	missing, err := e.newClient(t).MissingBlobs(ctx, []digest.Digest{dg, absent})
+	if err != nil {
+		t.Fatalf("MissingBlobs() failed: %v", err)
+	}
+	if len(missing) != 1 || missing[0] != absent {
+		t.Errorf("MissingBlobs() = %v, want [%v]", missing, absent)
+	}
+	if n := e.backend.CAS.BlobMissingReqs(dg); n != 1 {
+		t.Errorf("backend received %d queries for %v, want 1", n, dg)
+	}
+}
+
+func TestProxyServesReadsFromDisk(t *testing.T) {
+	ctx := context.Background()
+	e := newTestEnv(t)
+	blob := []byte("read me once")
+	dg := e.backend.CAS.Put(blob)
+
+	for i, c := range []*client.Client{e.newClient(t), e.newClient(t, client.CompressedBytestreamThreshold(0))} {
+		got, _, err := c.ReadBlob(ctx, dg)
+		if err != nil {
+			t.Fatalf("client %d: ReadBlob() failed: %v", i, err)
+		}
+		if !bytes.Equal(got, blob) {
+			t.Errorf("client %d: ReadBlob() = %q, want %q", i, got, blob)
+		}
+	}
+	got, err := e.newClient(t).BatchDownloadBlobs(ctx, []digest.Digest{dg})
+	if err != nil {
+		t.Fatalf("BatchDownloadBlobs() failed: %v", err)
+	}
+	if !bytes.Equal(got[dg], blob) {
+		t.Errorf("BatchDownloadBlobs() = %q, want %q", got[dg], blob)
+	}
+	if n := e.backend.CAS.BlobReads(dg); n != 1 {
+		t.Errorf("backend received %d reads of %v, want 1", n, dg)
+	}
+	if st := e.proxy.Stats(); st.RemoteReads != 1 || st.LocalReads != 2 {
+		t.Errorf("Stats() = %+v, want 1 remote read and 2 local reads", st)
+	}
+}
+
+func TestProxyCompressedWrites(t *testing.T) {
+	ctx := context.Background()
+	e := newTestEnv(t)
+	c := e.newClient(t, client.CompressedBytestreamThreshold(0))
+	blob := bytes.Repeat([]byte("compressed"), 1000)
+	dg, err := c.WriteBlob(ctx, blob)
+	if err != nil {
+		t.Fatalf("WriteBlob() failed: %v", err)
+	}
+	if got, ok := e.backend.CAS.Get(dg); !ok || !bytes.Equal(got, blob) {
+		t.Errorf("blob %v was not uploaded to the backend", dg)
+	}
+	if err := c.BatchWriteBlobs(ctx, map[digest.Digest][]byte{digest.NewFromBlob([]byte("batch")): []byte("batch")}); err != nil {
+		t.Fatalf("BatchWriteBlobs() failed: %v", err)
+	}
+	if _, ok := e.backend.CAS.Get(digest.NewFromBlob([]byte("batch"))); !ok {
+		t.Errorf("batch blob was not uploaded to the backend")
+	}
+}
+
+func TestProxyExecute(t *testing.T) {
+	ctx := context.Background()
+	e := newTestEnv(t)
+	e.backend.Exec.AddResult(fakes.MatchArgs("tool", "arg"), &fakes.ExecResult{
+		ActionResult: &repb.ActionResult{ExitCode: 3, StdoutRaw: []byte("proxied")},
+	})
+	rc := &rexec.Client{FileMetadataCache: filemetadata.NewNoopCache(), GrpcClient: e.newClient(t)}
+	cmd := &command.Command{Args: []string{"tool", "arg"}, ExecRoot: t.TempDir()}
+	oe := outerr.NewRecordingOutErr()
+	res, _ := rc.Run(ctx, cmd, &command.ExecutionOptions{AcceptCached: true, DownloadOutErr: true}, oe)
+	if res.Status != command.NonZeroExitResultStatus || res.ExitCode != 3 {
+		t.Errorf("Run() = %+v, want exit code 3", res)
+	}
+	if got := string(oe.Stdout()); got != "proxied" {
+		t.Errorf("Run() stdout = %q, want %q", got, "proxied")
+	}
+	_, err := rc.GrpcClient.GetActionResult(ctx, &repb.GetActionResultRequest{
+		InstanceName: "instance",
+		ActionDigest: digest.NewFromBlob([]byte("no such action")).ToProto(),
+	})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("GetActionResult() of an unknown action returned %v, want NotFound", err)
+	}
+}
+
+func TestProxyFindMissingOutlivesCaller(t *testing.T) {
+	e := newTestEnv(t)
+	e.backend.CAS.ReqSleepDuration = 200 * time.Millisecond
+	dg := digest.NewFromBlob([]byte("missing"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	firstErr := make(chan error, 1)
+	go func() {
+		_, err := e.proxy.findMissing(ctx, []digest.Digest{dg})
+		firstErr <- err
+	}()
+	// Wait for the first caller to start the query, so that the second one waits for it.
+	for {
+		e.proxy.mu.Lock()
+		_, started := e.proxy.queries[dg]
+		e.proxy.mu.Unlock()
+		if started {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	secondRes := make(chan []digest.Digest, 1)
+	secondErr := make(chan error, 1)
+	go func() {
+		missing, err := e.proxy.findMissing(context.Background(), []digest.Digest{dg})
+		secondRes <- missing
+		secondErr <- err
+	}()
+	cancel()
+
+	if err := <-firstErr; !errors.Is(err, context.Canceled) {
+		t.Errorf("findMissing() of the cancelled caller returned %v, want %v", err, context.Canceled)
+	}
+	if missing, err := <-secondRes, <-secondErr; err != nil || len(missing) != 1 || missing[0] != dg {
+		t.Errorf("findMissing() = %v, %v, want [%v]", missing, err, dg)
+	}
+	if n := e.backend.CAS.BlobMissingReqs(dg); n != 1 {
+		t.Errorf("backend received %d queries for %v, want 1", n, dg)
+	}
+}
+
+func TestPresentSet(t *testing.T) {
+	now := time.Now()
+	p := newPresentSet(time.Minute, 2)
+	p.now = func() time.Time { return now }
+	a, b, c := digest.NewFromBlob([]byte("a")), digest.NewFromBlob([]byte("b")), digest.NewFromBlob([]byte("c"))
+
+	p.add(a)
+	now = now.Add(time.Second)
+	p.add(b)
+	if !p.has(a) || !p.has(b) {
+		t.Errorf("has() = %t, %t after adding a and b, want true, true", p.has(a), p.has(b))
+	}
+	// The set is full, so the oldest digest is forgotten.
+	p.add(c)
+	if p.has(a) || !p.has(b) || !p.has(c) {
+		t.Errorf("has() = %t, %t, %t after adding c, want false, true, true", p.has(a), p.has(b), p.has(c))
+	}
+	// The digests expire after the TTL.
+	now = now.Add(time.Minute)
+	if p.has(b) || p.has(c) {
+		t.Errorf("has() = %t, %t after the TTL, want false, false", p.has(b), p.has(c))
+	}
+	p.add(a)
+	if !p.has(a) || len(p.added) != 1 || len(p.order) != 1 {
+		t.Errorf("after adding a again, has(a) = %t with %d digests and %d additions, want true with 1 and 1", p.has(a), len(p.added), len(p.order))
+	}
+}
@@ -0,0 +1,380 @@
+// Package recorder provides gRPC client interceptors that record the RPC traffic of a client to a
+// file, so that it can later be replayed by fakes.Replay to reproduce client bugs without access to
+// the original backend.
+//
+// A recording is a sequence of JSON lines, one per completed RPC. Every request and response
+// message is stored as a protojson-encoded google.protobuf.Any, so any message type linked into the
+// binary can be recorded and decoded.
+package recorder
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	// Redundant imports are required for the google3 mirror. Aliases should not be changed.
+	log "github.com/golang/glog"
+	bspb "google.golang.org/genproto/googleapis/bytestream"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	anypb "google.golang.org/protobuf/types/known/anypb"
+)
+
+// PayloadMode controls how ByteStream payloads are written to a recording.
+type PayloadMode int
+
+const (
+	// StorePayloads keeps ByteStream payloads in the recording, which allows them to be replayed.
+	StorePayloads PayloadMode = iota
+
+	// HashPayloads replaces ByteStream payloads with their digest. Recordings are much smaller, but
+	// ByteStream reads cannot be replayed.
+	HashPayloads
+)
+
+// String returns the flag representation of the mode.
+func (m PayloadMode) String() string {
+	switch m {
+	case StorePayloads:
+		return "store"
+	case HashPayloads:
+		return "hash"
+	}
+	return fmt.Sprintf("unknown payload mode %d", int(m))
+}
+
+// ParsePayloadMode parses the flag representation of a PayloadMode.
+func ParsePayloadMode(s string) (PayloadMode, error) {
+	switch s {
+	case "store":
+		return StorePayloads, nil
+	case "hash":
+		return HashPayloads, nil
+	}
+	return 0, fmt.Errorf("unknown payload mode %q, expected one of [store hash]", s)
+}
+
+// Message is a single recorded request or response message.
+type Message struct {
+	// Msg is the protojson encoding of the message wrapped in a google.protobuf.Any.
+	Msg json.RawMessage `json:"msg"`
+
+	// DataDigest is the digest of the ByteStream payload that was removed from the message, if the
+	// recording was made with HashPayloads.
+	DataDigest string `json:"data_digest,omitempty"`
+}
+
+// Proto decodes the recorded message.
+func (m *Message) Proto() (proto.Message, error) {
+	a := &anypb.Any{}
+	if err := protojson.Unmarshal(m.Msg, a); err != nil {
+		return nil, err
+	}
+	return a.UnmarshalNew()
+}
+
+// TypeURL returns the type URL of the recorded message.
+func (m *Message) TypeURL() (string, error) {
+	a := &anypb.Any{}
+	if err := protojson.Unmarshal(m.Msg, a); err != nil {
+		return "", err
+	}
+	return a.TypeUrl, nil
+}
+
+// Entry is a single recorded RPC.
+type Entry struct {
+	// Seq is the order in which the RPC was started, starting from 0.
+	Seq int64 `json:"seq"`
+
+	// Method is the full gRPC method name, e.g. "/google.bytestream.ByteStream/Read".
+	Method string `json:"method"`
+
+	// Start is the time at which the RPC was started.
+	Start time.Time `json:"start"`
+
+	// Duration is how long the RPC took, until its final status was known.
+	Duration time.Duration `json:"duration"`
+
+	// Requests are the messages sent by the client, in order.
+	Requests []*Message `json:"requests"`
+
+	// Responses are the messages received by the client, in order.
+	Responses []*Message `json:"responses"`
+
+	// Status is the protojson encoding of the final google.rpc.Status of the RPC. It is omitted if
+	// the RPC succeeded.
+	Status json.RawMessage `json:"status,omitempty"`
+}
+
+// Err returns the final error of the recorded RPC, or nil if it succeeded.
+func (e *Entry) Err() error {
+	if len(e.Status) == 0 || string(e.Status) == "null" {
+		return nil
+	}
+	st := &spb.Status{}
+	if err := protojson.Unmarshal(e.Status, st); err != nil {
+		return fmt.Errorf("invalid recorded status %s: %v", e.Status, err)
+	}
+	return status.ErrorProto(st)
+}
+
+// Recorder records RPCs to a file. It is safe for concurrent use.
+type Recorder struct {
+	mode PayloadMode
+	seq  int64
+
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+// New returns a Recorder that writes to the given file, truncating it if it exists.
+func New(path string, mode PayloadMode) (*Recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{mode: mode, w: f, closer: f}, nil
+}
+
+// NewFromWriter returns a Recorder that writes to w.
+func NewFromWriter(w io.Writer, mode PayloadMode) *Recorder {
+	return &Recorder{mode: mode, w: w}
+}
+
+// Close closes the underlying file, if the Recorder owns one. RPCs that complete afterwards are not
+// recorded.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	w := r.w
+	r.w = nil
+	if r.closer == nil || w == nil {
+		return nil
+	}
+	return r.closer.Close()
+}
+
+// DialOptions returns the dial options that install the Recorder on a connection.
+func (r *Recorder) DialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(r.UnaryClientInterceptor),
+		grpc.WithChainStreamInterceptor(r.StreamClientInterceptor),
+	}
+}
+
+// UnaryClientInterceptor records a unary RPC.
+func (r *Recorder) UnaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	c := r.newCall(method)
+	c.addRequest(req)
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	if err == nil {
+		c.addResponse(reply)
+	}
+	c.finish(err)
+	return err
+}
+
+// StreamClientInterceptor records a streaming RPC. The RPC is recorded once the client has
+// observed its final status, or once its context is done.
+func (r *Recorder) StreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	c := r.newCall(method)
+	cs, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		c.finish(err)
+		return nil, err
+	}
+	rs := &recordingStream{ClientStream: cs, call: c, serverStreams: desc.ServerStreams}
+	if done := ctx.Done(); done != nil {
+		go func() {
+			select {
+			case <-done:
+				c.finish(ctx.Err())
+			case <-c.done:
+			}
+		}()
+	}
+	return rs, nil
+}
+
+type recordingStream struct {
+	grpc.ClientStream
+	call          *call
+	serverStreams bool
+}
+
+func (s *recordingStream) SendMsg(m interface{}) error {
+	s.call.addRequest(m)
+	return s.ClientStream.SendMsg(m)
+}
+
+func (s *recordingStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	switch {
+	case err == io.EOF:
+		s.call.finish(nil)
+	case err != nil:
+		s.call.finish(err)
+	default:
+		s.call.addResponse(m)
+		// A non server-streaming RPC is complete after its only response is received.
+		if !s.serverStreams {
+			s.call.finish(nil)
+		}
+	}
+	return err
+}
+
+// call accumulates the messages of a single RPC until its completion.
+type call struct {
+	r     *Recorder
+	entry *Entry
+	mu    sync.Mutex
+	once  sync.Once
+	done  chan struct{}
+}
+
+func (r *Recorder) newCall(method string) *call {
+	return &call{
+		r: r,
+		entry: &Entry{
+			Seq:    atomic.AddInt64(&r.seq, 1) - 1,
+			Method: method,
+			Start:  time.Now(),
+		},
+		done: make(chan struct{}),
+	}
+}
+
+func (c *call) addRequest(m interface{}) {
+	msg := c.r.encode(m)
+	c.mu.Lock()
+	c.entry.Requests = append(c.entry.Requests, msg)
+	c.mu.Unlock()
+}
+
+func (c *call) addResponse(m interface{}) {
+	msg := c.r.encode(m)
+	c.mu.Lock()
+	c.entry.Responses = append(c.entry.Responses, msg)
+	c.mu.Unlock()
+}
+
+func (c *call) finish(err error) {
+	c.once.Do(func() {
+		defer close(c.done)
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.entry.Duration = time.Since(c.entry.Start)
+		if err != nil {
+			st, ok := status.FromError(err)
+			if !ok {
+				st = status.FromContextError(err)
+			}
+			b, mErr := protojson.Marshal(st.Proto())
+			if mErr != nil {
+				log.Errorf("recorder: failed to encode status %v: %v", st, mErr)
+			}
+			c.entry.Status = b
+		}
+		c.r.write(c.entry)
+	})
+}
+
+func (r *Recorder) write(e *Entry) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		log.Errorf("recorder: failed to encode %s call: %v", e.Method, err)
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.w == nil {
+		return
+	}
+	if _, err := r.w.Write(append(b, '\n')); err != nil {
+		log.Errorf("recorder: failed to write %s call: %v", e.Method, err)
+	}
+}
+
+// encode converts a message to its recorded form, stripping ByteStream payloads if requested.
+func (r *Recorder) encode(m interface{}) *Message {
+	pm, ok := m.(proto.Message)
+	if !ok {
+		log.Errorf("recorder: cannot record non-proto message of type %T", m)
+		return &Message{}
+	}
+	msg := &Message{}
+	if r.mode == HashPayloads {
+		switch v := pm.(type) {
+		case *bspb.ReadResponse:
+			msg.DataDigest = digest.NewFromBlob(v.Data).String()
+			c := proto.Clone(v).(*bspb.ReadResponse)
+			c.Data = nil
+			pm = c
+		case *bspb.WriteRequest:
+			msg.DataDigest = digest.NewFromBlob(v.Data).String()
+			c := proto.Clone(v).(*bspb.WriteRequest)
+			c.Data = nil
+			pm = c
+		}
+	}
+	a, err := anypb.New(pm)
+	if err != nil {
+		log.Errorf("recorder: failed to wrap %T: %v", pm, err)
+		return msg
+	}
+	msg.Msg, err = protojson.Marshal(a)
+	if err != nil {
+		log.Errorf("recorder: failed to encode %T: %v", pm, err)
+	}
+	return msg
+}
+
+// ReadFile reads all the entries of the recording at path.
+func ReadFile(path string) ([]*Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+// Read reads all the entries of a recording, ordered by their Seq.
+func Read(rd io.Reader) ([]*Entry, error) {
+	var entries []*Entry
+	sc := bufio.NewScanner(rd)
+	// ByteStream chunks can be large, so allow long lines.
+	sc.Buffer(make([]byte, 0, 64*1024), 1<<30)
+	for line := 1; sc.Scan(); line++ {
+		if len(strings.TrimSpace(sc.Text())) == 0 {
+			continue
+		}
+		e := &Entry{}
+		if err := json.Unmarshal(sc.Bytes(), e); err != nil {
+			return nil, fmt.Errorf("invalid recording entry on line %d: %v", line, err)
+		}
+		entries = append(entries, e)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	// Entries are written in completion order.
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Seq < entries[j].Seq })
+	return entries, nil
+}
@@ -0,0 +1,131 @@
+package recorder_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/client"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/fakes"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/recorder"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+)
+
+// exercise performs a fixed set of operations against a client and returns what it read.
+func exercise(ctx context.Context, t *testing.T, c *client.Client) (blob []byte, missing []digest.Digest, acErr codes.Code) {
+	t.Helper()
+	written := []byte("hello recording")
+	if _, err := c.WriteBlob(ctx, written); err != nil {
+		t.Fatalf("WriteBlob failed: %v", err)
+	}
+	blob, _, err := c.ReadBlob(ctx, digest.NewFromBlob(written))
+	if err != nil {
+		t.Fatalf("ReadBlob failed: %v", err)
+	}
+	missing, err = c.MissingBlobs(ctx, []digest.Digest{digest.NewFromBlob(written), digest.NewFromBlob([]byte("absent"))})
+	if err != nil {
+		t.Fatalf("MissingBlobs failed: %v", err)
+	}
+	_, err = c.GetActionResult(ctx, &repb.GetActionResultRequest{
+		InstanceName: c.InstanceName,
+		ActionDigest: digest.NewFromBlob([]byte("action")).ToProto(),
+	})
+	return blob, missing, status.Code(err)
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	ctx := context.Background()
+	for _, mode := range []recorder.PayloadMode{recorder.StorePayloads, recorder.HashPayloads} {
+		t.Run(mode.String(), func(t *testing.T) {
+			s, err := fakes.NewServer(t)
+			if err != nil {
+				t.Fatalf("Error starting fake server: %v", err)
+			}
+			defer s.Stop()
+			buf := &bytes.Buffer{}
+			rec := recorder.NewFromWriter(buf, mode)
+			c, err := client.NewClient(ctx, "instance", client.DialParams{
+				Service:    s.Addr(),
+				NoSecurity: true,
+				DialOpts:   rec.DialOptions(),
+			})
+			if err != nil {
+				t.Fatalf("Error connecting to server: %v", err)
+			}
+			wantBlob, wantMissing, wantCode := exercise(ctx, t, c)
+			c.Close()
+
+			entries, err := recorder.Read(buf)
+			if err != nil {
+				t.Fatalf("recorder.Read() failed: %v", err)
+			}
+			methods := make(map[string]int)
+			for i, e := range entries {
+				if e.Seq != int64(i) {
+					t.Errorf("entries[%d].Seq = %d, want %d", i, e.Seq, i)
+				}
+				methods[e.Method]++
+			}
+			wantMethods := map[string]int{
+				"/build.bazel.remote.execution.v2.Capabilities/GetCapabilities":               1,
+				"/build.bazel.remote.execution.v2.ContentAddressableStorage/FindMissingBlobs": 1,
+				"/build.bazel.remote.execution.v2.ActionCache/GetActionResult":                1,
+				"/google.bytestream.ByteStream/Write":                                         1,
+				"/google.bytestream.ByteStream/Read":                                          1,
+			}
+			if diff := cmp.Diff(wantMethods, methods); diff != "" {
+				t.Errorf("recorded methods diff (-want +got):\n%s", diff)
+			}
+
+			r, err := fakes.NewReplayServerFromEntries(entries)
+			if err != nil {
+				t.Fatalf("NewReplayServerFromEntries() failed: %v", err)
+			}
+			defer r.Stop()
+			rc, err := r.NewTestClient(ctx, "instance")
+			if err != nil {
+				t.Fatalf("Error connecting to replay server: %v", err)
+			}
+			defer rc.Close()
+			written := []byte("hello recording")
+			if _, err := rc.WriteBlob(ctx, written); err != nil {
+				t.Fatalf("replayed WriteBlob failed: %v", err)
+			}
+			gotBlob, _, err := rc.ReadBlob(ctx, digest.NewFromBlob(written))
+			if mode == recorder.HashPayloads {
+				if status.Code(err) != codes.FailedPrecondition {
+					t.Errorf("replayed ReadBlob of hashed payload returned %v, want FailedPrecondition", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("replayed ReadBlob failed: %v", err)
+			}
+			if !bytes.Equal(gotBlob, wantBlob) {
+				t.Errorf("replayed ReadBlob = %q, want %q", gotBlob, wantBlob)
+			}
+			gotMissing, err := rc.MissingBlobs(ctx, []digest.Digest{digest.NewFromBlob(written), digest.NewFromBlob([]byte("absent"))})
+			if err != nil {
+				t.Fatalf("replayed MissingBlobs failed: %v", err)
+			}
+			if diff := cmp.Diff(wantMissing, gotMissing); diff != "" {
+				t.Errorf("replayed MissingBlobs diff (-want +got):\n%s", diff)
+			}
+			_, err = rc.GetActionResult(ctx, &repb.GetActionResultRequest{
+				InstanceName: "instance",
+				ActionDigest: digest.NewFromBlob([]byte("action")).ToProto(),
+			})
+			if status.Code(err) != wantCode {
+				t.Errorf("replayed GetActionResult returned %v, want code %v", err, wantCode)
+			}
+			if n := r.Unreplayed(); n != 0 {
+				t.Errorf("Unreplayed() = %d, want 0", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package retry
+
+import (
+	"sync"
+)
+
+// budgetWindow is the number of calls whose earned tokens a Budget can hold on top of its reserve.
+const budgetWindow = 1000
+
+// Budget is a token bucket that limits retries to a fraction of the calls made, so that a
+// struggling backend is not overwhelmed by retries when many calls fail at once. Every call deposits
+// ratio tokens, and every retry withdraws one token. A retry is only allowed when a whole token is
+// available. The bucket starts with the reserve, so that an idle process can retry a few calls right
+// away, and holds at most the reserve plus the tokens earned by the last budgetWindow calls.
+//
+// A Budget is safe for concurrent use, and is meant to be shared by all the calls to one backend.
+type Budget struct {
+	mu        sync.Mutex
+	ratio     float64
+	maxTokens float64
+	tokens    float64
+}
+
+// NewBudget returns a budget that allows retrying ratio of the calls, e.g. 0.1 for 10%, on top of
+// a reserve of retries.
+func NewBudget(ratio float64, reserve int) *Budget {
+	return &Budget{ratio: ratio, maxTokens: float64(reserve) + ratio*budgetWindow, tokens: float64(reserve)}
+}
+
+// deposit accounts for a new call.
+func (b *Budget) deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens += b.ratio
+	if b.tokens > b.maxTokens {
+		b.tokens = b.maxTokens
+	}
+}
+
+// withdraw returns whether a retry is allowed, and accounts for it if so.
+func (b *Budget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+var (
+	budgetMu      sync.Mutex
+	defaultBudget *Budget
+)
+
+// SetDefaultBudget sets the process-wide budget used by policies that do not have their own. A nil
+// budget, the default, does not limit retries.
+func SetDefaultBudget(b *Budget) {
+	budgetMu.Lock()
+	defaultBudget = b
+	budgetMu.Unlock()
+}
+
+// DefaultBudget returns the process-wide budget set by SetDefaultBudget.
+func DefaultBudget() *Budget {
+	budgetMu.Lock()
+	defer budgetMu.Unlock()
+	return defaultBudget
+}
@@ -15,6 +15,7 @@ import (
 
 	log "github.com/golang/glog"
 	"github.com/pkg/errors"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
@@ -28,6 +29,7 @@ const (
 type BackoffPolicy struct {
 	baseDelay, maxDelay time.Duration
 	maxAttempts         Attempts // 0 means unlimited
+	budget              *Budget  // nil means the process-wide default budget
 }
 
 // ExponentialBackoff returns an exponential backoff implementation.
@@ -39,12 +41,19 @@ type BackoffPolicy struct {
 // Note that delays are randomized, so the exact values are not guaranteed. attempts=0 means
 // unlimited attempts. See UnlimitedAttempts.
 func ExponentialBackoff(baseDelay, maxDelay time.Duration, attempts Attempts) BackoffPolicy {
-	return BackoffPolicy{baseDelay, maxDelay, attempts}
+	return BackoffPolicy{baseDelay: baseDelay, maxDelay: maxDelay, maxAttempts: attempts}
 }
 
 // Immediately returns a retrier that retries right away.
 func Immediately(attempts Attempts) BackoffPolicy {
-	return BackoffPolicy{0, 0, attempts}
+	return BackoffPolicy{maxAttempts: attempts}
+}
+
+// WithBudget returns a copy of the policy that limits its retries by the given budget instead of
+// the process-wide one. See SetDefaultBudget.
+func (bp BackoffPolicy) WithBudget(b *Budget) BackoffPolicy {
+	bp.budget = b
+	return bp
 }
 
 // Attempts is the number of times to attempt something before giving up. A value of 0 represents
@@ -84,13 +93,23 @@ func TransientOnly(err error) bool {
 }
 
 // WithPolicy retries f until either it succeeds, or shouldRetry returns false, or the number of
-// retries is capped by the backoff policy. Returns the error returned by the final attempt. It
-// annotates the error message in case the retry budget is exhausted.
+// retries is capped by the backoff policy or its retry budget. Returns the error returned by the
+// final attempt. It annotates the error message in case the retry budget is exhausted.
+//
+// If the error carries a google.rpc.RetryInfo detail, the delay suggested by the server is used
+// when it is longer than the backoff policy's, still capped by its maximum delay and randomized.
 func WithPolicy(ctx context.Context, shouldRetry ShouldRetry, bp BackoffPolicy, f func() error) error {
 	timeAfter, ok := ctx.Value(TimeAfterContextKey).(func(time.Duration) <-chan time.Time)
 	if !ok {
 		timeAfter = time.After
 	}
+	budget := bp.budget
+	if budget == nil {
+		budget = DefaultBudget()
+	}
+	if budget != nil {
+		budget.deposit()
+	}
 
 	for attempts := 0; ; attempts++ {
 		err := f()
@@ -104,25 +123,52 @@ func WithPolicy(ctx context.Context, shouldRetry ShouldRetry, bp BackoffPolicy,
 		}
 
 		if attempts+1 == int(bp.maxAttempts) {
-			// Annotates the error message to indicate the retry budget was exhausted.
-			//
-			// This is a little hacky, but generic status annotation preserving status code doesn't exist
-			// in gRPC's status library yet, and it's overkill to implement it here for just this.
-			if s, ok := status.FromError(err); ok {
-				spb := s.Proto()
-				spb.Message = fmt.Sprintf("retry budget exhausted (%d attempts): ", bp.maxAttempts) + spb.Message
-				return status.ErrorProto(spb)
-			}
-			return errors.Wrapf(err, "retry budget exhausted (%d attempts)", bp.maxAttempts)
+			return annotate(err, fmt.Sprintf("retry budget exhausted (%d attempts)", bp.maxAttempts))
+		}
+		if budget != nil && !budget.withdraw() {
+			return annotate(err, "retry budget exhausted (too many retries in the process)")
 		}
 
+		delay := backoff(bp.baseDelay, bp.maxDelay, attempts)
+		if sd, ok := serverDelay(err); ok && sd > delay {
+			delay = jitter(float64(sd), float64(bp.maxDelay))
+		}
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case <-timeAfter(backoff(bp.baseDelay, bp.maxDelay, attempts)):
+		case <-timeAfter(delay):
+
+		}
+	}
+}
+
+// annotate prefixes the error message with msg, preserving the status code of gRPC errors.
+func annotate(err error, msg string) error {
+	// This is a little hacky, but generic status annotation preserving status code doesn't exist
+	// in gRPC's status library yet, and it's overkill to implement it here for just this.
+	if s, ok := status.FromError(err); ok {
+		spb := s.Proto()
+		spb.Message = msg + ": " + spb.Message
+		return status.ErrorProto(spb)
+	}
+	return errors.Wrap(err, msg)
+}
 
+// serverDelay returns the retry delay requested by the server in a google.rpc.RetryInfo detail of
+// the error, if any.
+func serverDelay(err error) (time.Duration, bool) {
+	s, ok := status.FromError(err)
+	if !ok {
+		return 0, false
+	}
+	for _, d := range s.Details() {
+		if ri, ok := d.(*errdetails.RetryInfo); ok && ri.RetryDelay != nil {
+			if delay := ri.RetryDelay.AsDuration(); delay >= 0 {
+				return delay, true
+			}
 		}
 	}
+	return 0, false
 }
 
 type timeAfterContextKey struct{}
@@ -153,15 +199,18 @@ func backoff(baseDelay, maxDelay time.Duration, retries int) time.Duration {
 		backoff = backoff * backoffFactor
 		retries--
 	}
-	if backoff > max {
-		backoff = max
-	}
+	return jitter(backoff, max)
+}
 
-	// Randomize backoff delays so that if a cluster of requests start at the same time, they won't
-	// operate in lockstep. We just subtract up to 40% so that we obey maxDelay.
-	backoff -= backoff * backoffRange * randFloat64()
-	if backoff < 0 {
+// jitter caps the delay at maxDelay and randomizes it, so that if a cluster of requests start at the
+// same time, they won't operate in lockstep. We just subtract up to 40% so that we obey maxDelay.
+func jitter(delay, maxDelay float64) time.Duration {
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	delay -= delay * backoffRange * randFloat64()
+	if delay < 0 {
 		return 0
 	}
-	return time.Duration(backoff)
+	return time.Duration(delay)
 }
@@ -4,11 +4,16 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+
+	// Redundant imports are required for the google3 mirror. Aliases should not be changed.
+	dpb "google.golang.org/protobuf/types/known/durationpb"
 )
 
 func alwaysRetry(error) bool { return true }
@@ -104,3 +109,118 @@ func TestRetries(t *testing.T) {
 		}
 	}
 }
+
+// recordDelays returns a context whose timer fires immediately and records the requested delays.
+func recordDelays(ctx context.Context, delays *[]time.Duration) context.Context {
+	return context.WithValue(ctx, TimeAfterContextKey, func(d time.Duration) <-chan time.Time {
+		*delays = append(*delays, d)
+		c := make(chan time.Time)
+		close(c)
+		return c
+	})
+}
+
+func retryInfoErr(t *testing.T, delay time.Duration) error {
+	t.Helper()
+	st, err := status.New(codes.ResourceExhausted, "slow down").WithDetails(&errdetails.RetryInfo{RetryDelay: dpb.New(delay)})
+	if err != nil {
+		t.Fatalf("WithDetails() failed: %v", err)
+	}
+	return st.Err()
+}
+
+func TestRetryInfoDelay(t *testing.T) {
+	// The server delays are used when longer than the backoff, capped by the maximum delay and randomized.
+	errs := []error{retryInfoErr(t, 7*time.Second), status.Error(codes.Unavailable, "no details"), retryInfoErr(t, time.Hour), retryInfoErr(t, 0)}
+	var delays []time.Duration
+	err := WithPolicy(recordDelays(context.Background(), &delays), TransientOnly, ExponentialBackoff(time.Millisecond, 10*time.Second, 5), func() error {
+		if len(errs) == 0 {
+			return nil
+		}
+		err := errs[0]
+		errs = errs[1:]
+		return err
+	})
+	if err != nil {
+		t.Fatalf("WithPolicy() failed: %v", err)
+	}
+	within := func(d, max time.Duration) bool {
+		return d <= max && float64(d) >= float64(max)*(1-backoffRange)
+	}
+	if len(delays) != 4 || !within(delays[0], 7*time.Second) || delays[1] > 2*time.Millisecond || !within(delays[2], 10*time.Second) || delays[3] > 3*time.Millisecond {
+		t.Errorf("WithPolicy() delays = %v, want [4.2s-7s, <=2ms, 6s-10s, <=3ms]", delays)
+	}
+}
+
+func TestBudget(t *testing.T) {
+	ctx := context.Background()
+	var delays []time.Duration
+	ctx = recordDelays(ctx, &delays)
+	bp := ExponentialBackoff(time.Millisecond, time.Millisecond, UnlimitedAttempts).WithBudget(NewBudget(0.5, 2))
+
+	// The reserve allows two retries.
+	f := &failer{attempts: 10}
+	err := WithPolicy(ctx, alwaysRetry, bp, f.run)
+	if err == nil || !strings.Contains(err.Error(), "retry budget exhausted") {
+		t.Errorf("WithPolicy() = %v, want retry budget exhausted error", err)
+	}
+	if got := 10 - f.attempts; got != 3 {
+		t.Errorf("WithPolicy() made %d attempts, want 3", got)
+	}
+
+	// Every call deposits half a token, so two calls later one more retry is allowed.
+	if err := WithPolicy(ctx, alwaysRetry, bp, func() error { return nil }); err != nil {
+		t.Fatalf("WithPolicy() failed: %v", err)
+	}
+	f = &failer{attempts: 1}
+	if err := WithPolicy(ctx, alwaysRetry, bp, f.run); err != nil {
+		t.Errorf("WithPolicy() = %v, want success after one retry", err)
+	}
+	// The call deposits one more token, which only allows one of its two retries.
+	f = &failer{attempts: 2}
+	if err := WithPolicy(ctx, alwaysRetry, bp, f.run); err == nil {
+		t.Errorf("WithPolicy() succeeded, want retry budget exhausted error")
+	}
+}
+
+func TestBudgetWithoutReserve(t *testing.T) {
+	var delays []time.Duration
+	ctx := recordDelays(context.Background(), &delays)
+	bp := ExponentialBackoff(time.Millisecond, time.Millisecond, UnlimitedAttempts).WithBudget(NewBudget(0.1, 0))
+
+	f := &failer{attempts: 1}
+	if err := WithPolicy(ctx, alwaysRetry, bp, f.run); err == nil {
+		t.Errorf("WithPolicy() succeeded, want retry budget exhausted error")
+	}
+	// The calls earn a retry every ten calls, even without a reserve.
+	for i := 0; i < 9; i++ {
+		if err := WithPolicy(ctx, alwaysRetry, bp, func() error { return nil }); err != nil {
+			t.Fatalf("WithPolicy() failed: %v", err)
+		}
+	}
+	f = &failer{attempts: 1}
+	if err := WithPolicy(ctx, alwaysRetry, bp, f.run); err != nil {
+		t.Errorf("WithPolicy() = %v, want success after one retry", err)
+	}
+}
+
+func TestDefaultBudget(t *testing.T) {
+	SetDefaultBudget(NewBudget(0, 1))
+	defer SetDefaultBudget(nil)
+	var delays []time.Duration
+	ctx := recordDelays(context.Background(), &delays)
+	bp := ExponentialBackoff(time.Millisecond, time.Millisecond, UnlimitedAttempts)
+
+	f := &failer{attempts: 5}
+	if err := WithPolicy(ctx, alwaysRetry, bp, f.run); err == nil {
+		t.Errorf("WithPolicy() succeeded, want retry budget exhausted error")
+	}
+	if got := 5 - f.attempts; got != 2 {
+		t.Errorf("WithPolicy() made %d attempts, want 2", got)
+	}
+	// A policy with its own budget is not limited by the default one.
+	f = &failer{attempts: 5}
+	if err := WithPolicy(ctx, alwaysRetry, bp.WithBudget(NewBudget(0, 10)), f.run); err != nil {
+		t.Errorf("WithPolicy() = %v, want success", err)
+	}
+}
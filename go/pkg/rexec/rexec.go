@@ -3,8 +3,10 @@ package rexec
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io/fs"
+	"os"
 	"path/filepath"
 	"regexp"
 	"sort"
@@ -13,11 +15,14 @@ import (
 	"sync"
 	"time"
 
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/breaker"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/casng"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/command"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/filemetadata"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/io/glob"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/io/impath"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/io/vfs"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/io/walker"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/outerr"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/symlinkopts"
@@ -38,6 +43,9 @@ import (
 type Client struct {
 	FileMetadataCache filemetadata.Cache
 	GrpcClient        *rc.Client
+	// SkipUnavailableCache treats the action cache as a cache miss instead of failing the command
+	// while the action cache circuit breaker of GrpcClient is open. See rc.CircuitBreakers.
+	SkipUnavailableCache bool
 }
 
 // Context allows more granular control over various stages of command execution.
@@ -164,17 +172,129 @@ func (ec *Context) downloadOutputs(outDir string) (*rc.MovedBytesMetadata, *comm
 	if !ec.client.GrpcClient.LegacyExecRootRelativeOutputs {
 		outDir = filepath.Join(outDir, ec.cmd.WorkingDir)
 	}
-	stats, err := ec.client.GrpcClient.DownloadActionOutputs(ec.ctx, ec.resPb, outDir, ec.client.FileMetadataCache)
+	var stats *rc.MovedBytesMetadata
+	var err error
+	policy := ec.opt.DownloadPolicy
+	if (policy == command.UnspecifiedDownloadPolicy || policy == command.DownloadAllOutputs) && len(ec.cmd.OutputGlobs) == 0 {
+		stats, err = ec.client.GrpcClient.DownloadActionOutputsAtomic(ec.ctx, ec.resPb, outDir, ec.client.FileMetadataCache, ec.opt.PreserveUnchangedOutputMtime)
+	} else {
+		stats, err = ec.downloadSelectedOutputs(outDir)
+	}
 	if err != nil {
 		return &rc.MovedBytesMetadata{}, command.NewRemoteErrorResult(err)
 	}
 	return stats, command.NewResultFromExitCode((int)(ec.resPb.ExitCode))
 }
 
+// shouldDownloadOutputs returns whether the outputs should be downloaded, or recorded in the output manifest, after execution.
+func (ec *Context) shouldDownloadOutputs() bool {
+	return ec.opt.DownloadOutputs || ec.opt.DownloadPolicy != command.UnspecifiedDownloadPolicy
+}
+
+// downloadSelectedOutputs downloads the outputs selected by the output globs and the download policy.
+// Unlike DownloadActionOutputsAtomic, it only replaces the declared output directories, since the directories
+// captured for the output globs may hold other local files. If a download policy is specified, the
+// outputs that are not downloaded are recorded in the output manifest of the action.
+func (ec *Context) downloadSelectedOutputs(outDir string) (*rc.MovedBytesMetadata, error) {
+	globs, err := glob.CompileAll(ec.cmd.OutputGlobs)
+	if err != nil {
+		return nil, err
+	}
+	outs, err := ec.client.GrpcClient.FlattenActionOutputs(ec.ctx, ec.resPb)
+	if err != nil {
+		return nil, err
+	}
+	skipped := make(map[string]*rc.TreeOutput)
+	for path, out := range outs {
+		switch {
+		case !ec.isDeclaredOutput(path) && (out.IsEmptyDirectory || !glob.MatchAny(globs, filepath.ToSlash(path))):
+			// Not an output of the command, but a sibling of a glob match.
+			delete(outs, path)
+		case !ec.shouldDownload(path):
+			skipped[path] = out
+			delete(outs, path)
+		}
+	}
+	if ec.opt.DownloadPolicy != command.UnspecifiedDownloadPolicy {
+		if err := ec.writeOutputManifest(outDir, skipped); err != nil {
+			return nil, err
+		}
+	}
+	if ec.opt.DownloadPolicy == command.DownloadNoOutputs {
+		return &rc.MovedBytesMetadata{}, nil
+	}
+	// The declared output directories are replaced as a whole, and the other outputs one by one.
+	roots := append([]string(nil), ec.cmd.OutputDirs...)
+	for path := range outs {
+		if !ec.isUnderOutputDir(path) {
+			roots = append(roots, path)
+		}
+	}
+	return ec.client.GrpcClient.DownloadOutputsAtomic(ec.ctx, outs, roots, outDir, ec.client.FileMetadataCache, ec.opt.PreserveUnchangedOutputMtime)
+}
+
+// shouldDownload returns whether the download policy selects the output at path.
+func (ec *Context) shouldDownload(path string) bool {
+	switch ec.opt.DownloadPolicy {
+	case command.DownloadNoOutputs:
+		return false
+	case command.DownloadTopLevelOutputs:
+		return !ec.isUnderOutputDir(path)
+	case command.DownloadMatchingOutputs:
+		return ec.opt.DownloadMatcher != nil && ec.opt.DownloadMatcher(path)
+	default:
+		return true
+	}
+}
+
+// writeOutputManifest records the skipped outputs in the output manifest of the action, or removes a stale
+// manifest if there are none.
+func (ec *Context) writeOutputManifest(outDir string, skipped map[string]*rc.TreeOutput) error {
+	path := rc.OutputManifestPath(ec.cmd.ExecRoot, ec.Metadata.ActionDigest)
+	if len(skipped) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	if err := rc.WriteOutputManifest(path, rc.NewOutputManifest(ec.Metadata.ActionDigest, outDir, skipped)); err != nil {
+		return err
+	}
+	ec.Metadata.OutputManifest = path
+	return nil
+}
+
+// isDeclaredOutput returns true if path is one of the output files of the command, or is under one of its output directories.
+func (ec *Context) isDeclaredOutput(path string) bool {
+	path = filepath.Clean(path)
+	for _, f := range ec.cmd.OutputFiles {
+		if path == filepath.Clean(f) {
+			return true
+		}
+	}
+	return ec.isUnderOutputDir(path)
+}
+
+// isUnderOutputDir returns true if path is one of the output directories of the command, or is under one of them.
+func (ec *Context) isUnderOutputDir(path string) bool {
+	path = filepath.Clean(path)
+	for _, d := range ec.cmd.OutputDirs {
+		d = filepath.Clean(d)
+		if d == "." || path == d || strings.HasPrefix(path, d+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
 func (ec *Context) computeCmdDg() (*repb.Platform, error) {
 	cmdID, executionID := ec.cmd.Identifiers.ExecutionID, ec.cmd.Identifiers.CommandID
 	commandHasOutputPathsField := ec.client.GrpcClient.SupportsCommandOutputPaths()
 	cmdPb := ec.cmd.ToREProto(commandHasOutputPathsField)
+	if len(ec.opt.OutputNodeProperties) > 0 {
+		cmdPb.OutputNodeProperties = append([]string(nil), ec.opt.OutputNodeProperties...)
+		sort.Strings(cmdPb.OutputNodeProperties)
+	}
 	log.V(2).Infof("%s %s> Command: \n%s\n", cmdID, executionID, prototext.Format(cmdPb))
 	var err error
 	if ec.cmdUe, err = uploadinfo.EntryFromProto(cmdPb); err != nil {
@@ -258,15 +378,23 @@ func (ec *Context) ngUploadInputs() error {
 		return err
 	}
 	slo := symlinkOpts(ec.client.GrpcClient.TreeSymlinkOpts, ec.cmd.InputSpec.SymlinkBehavior)
-	filter, err := exclusionsFilter(ec.cmd.InputSpec.InputExclusions)
+	exclGlobs, err := glob.CompileAll(ec.cmd.InputSpec.ExcludeGlobs)
+	if err != nil {
+		return err
+	}
+	filter, err := exclusionsFilter(execRoot, ec.cmd.InputSpec.InputExclusions, exclGlobs)
+	if err != nil {
+		return err
+	}
+	inputs, err := rc.ExpandInputGlobs(execRoot.String(), ec.cmd.InputSpec, exclGlobs)
 	if err != nil {
 		return err
 	}
-	log.V(2).Infof("[casng] ng.req; exec_root=%s, working_dir=%s, remote_working_dir=%s, symlink_opts=%s, inputs=%d, virtual_inputs=%d, cmd_id=%s, exec_id=%s", execRoot, workingDir, remoteWorkingDir, slo, len(ec.cmd.InputSpec.Inputs), len(ec.cmd.InputSpec.VirtualInputs), cmdID, executionID)
-	log.V(4).Infof("[casng] ng.req; exec_root=%s, working_dir=%s, remote_working_dir=%s, symlink_opts=%s, inputs=%+v, virtual_inputs=%+v, cmd_id=%s, exec_id=%s", execRoot, workingDir, remoteWorkingDir, slo, ec.cmd.InputSpec.Inputs, ec.cmd.InputSpec.VirtualInputs, cmdID, executionID)
-	reqs := make([]casng.UploadRequest, 0, len(ec.cmd.InputSpec.Inputs)+len(ec.cmd.InputSpec.VirtualInputs))
+	log.V(2).Infof("[casng] ng.req; exec_root=%s, working_dir=%s, remote_working_dir=%s, symlink_opts=%s, inputs=%d, virtual_inputs=%d, cmd_id=%s, exec_id=%s", execRoot, workingDir, remoteWorkingDir, slo, len(inputs), len(ec.cmd.InputSpec.VirtualInputs), cmdID, executionID)
+	log.V(4).Infof("[casng] ng.req; exec_root=%s, working_dir=%s, remote_working_dir=%s, symlink_opts=%s, inputs=%+v, virtual_inputs=%+v, cmd_id=%s, exec_id=%s", execRoot, workingDir, remoteWorkingDir, slo, inputs, ec.cmd.InputSpec.VirtualInputs, cmdID, executionID)
+	reqs := make([]casng.UploadRequest, 0, len(inputs)+len(ec.cmd.InputSpec.VirtualInputs))
 	pathSeen := make(map[impath.Absolute]bool)
-	for _, p := range ec.cmd.InputSpec.Inputs {
+	for _, p := range inputs {
 		rel, err := impath.Rel(p)
 		if err != nil {
 			return err
@@ -284,6 +412,31 @@ func (ec *Context) ngUploadInputs() error {
 		}
 		reqs = append(reqs, casng.UploadRequest{Path: absPath, SymlinkOptions: slo, Exclude: filter})
 	}
+	// Remote inputs are mounted as is. Their conflicts with real inputs are reported by casng.UploadTree.
+	for _, p := range ec.cmd.InputSpec.RemoteInputs {
+		if p.Path == "" {
+			return fmt.Errorf("[casng] ng.req: empty remote path; cmd_id=%s, exec_id=%s", cmdID, executionID)
+		}
+		rel, err := impath.Rel(p.Path)
+		if err != nil {
+			return err
+		}
+		absPath := execRoot.Append(rel)
+		pathSeen[absPath] = true
+		// Mark ancestors as seen to ensure any potential virtual parent is excluded, like empty directories.
+		parent := absPath.Dir()
+		for !pathSeen[parent] && parent.String() != execRoot.String() {
+			pathSeen[parent] = true
+			parent = parent.Dir()
+		}
+		r := casng.UploadRequest{Path: absPath, Digest: p.Digest, Remote: true, Exclude: filter}
+		if p.IsDirectory {
+			r.BytesFileMode |= fs.ModeDir
+		} else if p.IsExecutable {
+			r.BytesFileMode |= 0100
+		}
+		reqs = append(reqs, r)
+	}
 	// Append virtual inputs after real inputs in order to ignore any redundant virtual inputs.
 	// Sorting by path length descending is necessary to skip redundant ancestors. Otherwise, the conslidation in casng.UploadTree will skip descendants.
 	sort.Slice(ec.cmd.InputSpec.VirtualInputs, func(i, j int) bool {
@@ -429,7 +582,8 @@ func cmdDirs(cmd *command.Command) (execRoot impath.Absolute, workingDir impath.
 	return
 }
 
-func exclusionsFilter(es []*command.InputExclusion) (walker.Filter, error) {
+// exclusionsFilter returns a filter that matches the paths matching any of the regex exclusions, or any of the glob patterns relative to execRoot.
+func exclusionsFilter(execRoot impath.Absolute, es []*command.InputExclusion, exclGlobs []*glob.Pattern) (walker.Filter, error) {
 	filter := walker.Filter{}
 	var pathRegexes []*regexp.Regexp
 	var fileRegexes []*regexp.Regexp
@@ -478,6 +632,17 @@ func exclusionsFilter(es []*command.InputExclusion) (walker.Filter, error) {
 		}
 		return false
 	}
+	if len(exclGlobs) > 0 {
+		globFilter := walker.NewGlobFilter(execRoot, nil, exclGlobs)
+		regexPath, regexFile := filter.Path, filter.File
+		filter.Path = func(path string) bool {
+			return regexPath(path) || globFilter.MatchPath(path)
+		}
+		filter.File = func(path string, mode fs.FileMode) bool {
+			return regexFile(path, mode) || globFilter.MatchFile(path, mode)
+		}
+		id += globFilter.String()
+	}
 	filter.ID = func() string {
 		return id
 	}
@@ -493,11 +658,11 @@ func (ec *Context) GetCachedResult() {
 		ec.Result = command.NewLocalErrorResult(err)
 		return
 	}
-	if ec.opt.AcceptCached && !ec.opt.DoNotCache {
+	if ec.opt.AcceptCached && !ec.opt.DoNotCache && !ec.cacheUnavailable() {
 		ec.Metadata.EventTimes[command.EventCheckActionCache] = &command.TimeInterval{From: time.Now()}
 		resPb, err := ec.client.GrpcClient.CheckActionCache(ec.ctx, ec.Metadata.ActionDigest.ToProto())
 		ec.Metadata.EventTimes[command.EventCheckActionCache].To = time.Now()
-		if err != nil {
+		if err != nil && !(ec.client.SkipUnavailableCache && errors.Is(err, breaker.ErrOpen)) {
 			ec.Result = command.NewRemoteErrorResult(err)
 			return
 		}
@@ -511,7 +676,7 @@ func (ec *Context) GetCachedResult() {
 		if ec.opt.DownloadOutErr {
 			ec.Result = ec.downloadOutErr()
 		}
-		if ec.Result.Err == nil && ec.opt.DownloadOutputs {
+		if ec.Result.Err == nil && ec.shouldDownloadOutputs() {
 			stats, res := ec.downloadOutputs(ec.cmd.ExecRoot)
 			ec.Metadata.LogicalBytesDownloaded += stats.LogicalMoved
 			ec.Metadata.RealBytesDownloaded += stats.RealMoved
@@ -525,6 +690,16 @@ func (ec *Context) GetCachedResult() {
 	ec.Result = nil
 }
 
+// cacheUnavailable returns whether the action cache lookup should be skipped because the action
+// cache is known to be unavailable.
+func (ec *Context) cacheUnavailable() bool {
+	if !ec.client.SkipUnavailableCache || ec.client.GrpcClient.BreakerState(rc.ActionCacheFamily) != breaker.Open {
+		return false
+	}
+	log.V(1).Infof("%s %s> Action cache is unavailable, skipping cache lookup.", ec.cmd.Identifiers.ExecutionID, ec.cmd.Identifiers.CommandID)
+	return true
+}
+
 // UpdateCachedResult tries to write local results of the execution to the remote cache.
 // TODO(olaola): optional arguments to override values of local outputs, and also stdout/err.
 func (ec *Context) UpdateCachedResult() {
@@ -540,12 +715,18 @@ func (ec *Context) UpdateCachedResult() {
 	}
 	ec.Metadata.EventTimes[command.EventUpdateCachedResult] = &command.TimeInterval{From: time.Now()}
 	defer func() { ec.Metadata.EventTimes[command.EventUpdateCachedResult].To = time.Now() }()
-	outPaths := append(ec.cmd.OutputFiles, ec.cmd.OutputDirs...)
+	outPaths := append(append([]string(nil), ec.cmd.OutputFiles...), ec.cmd.OutputDirs...)
 	wd := ""
 	if !ec.client.GrpcClient.LegacyExecRootRelativeOutputs {
 		wd = ec.cmd.WorkingDir
 	}
-	blobs, resPb, err := ec.client.GrpcClient.ComputeOutputsToUpload(ec.cmd.ExecRoot, wd, outPaths, ec.client.FileMetadataCache, ec.cmd.InputSpec.SymlinkBehavior, ec.cmd.InputSpec.InputNodeProperties)
+	globPaths, err := ec.expandOutputGlobs(wd)
+	if err != nil {
+		ec.Result = command.NewLocalErrorResult(err)
+		return
+	}
+	outPaths = append(outPaths, globPaths...)
+	blobs, resPb, err := ec.client.GrpcClient.ComputeOutputsToUploadWithNodeProperties(ec.cmd.ExecRoot, wd, outPaths, ec.client.FileMetadataCache, ec.cmd.InputSpec.SymlinkBehavior, ec.cmd.InputSpec.InputNodeProperties, ec.opt.OutputNodeProperties)
 	if err != nil {
 		ec.Result = command.NewLocalErrorResult(err)
 		return
@@ -580,6 +761,33 @@ func (ec *Context) UpdateCachedResult() {
 	}
 }
 
+// expandOutputGlobs returns the local outputs, relative to the working directory wd, that match the output globs
+// and are not already declared as output files or under output directories. Globs matching nothing are ignored.
+func (ec *Context) expandOutputGlobs(wd string) ([]string, error) {
+	if len(ec.cmd.OutputGlobs) == 0 {
+		return nil, nil
+	}
+	globs, err := glob.CompileAll(ec.cmd.OutputGlobs)
+	if err != nil {
+		return nil, err
+	}
+	root, err := impath.Abs(ec.cmd.ExecRoot, wd)
+	if err != nil {
+		return nil, err
+	}
+	matches, err := glob.Expand(vfs.OS, root, globs, nil)
+	if err != nil && !errors.Is(err, glob.ErrNoMatch) {
+		return nil, fmt.Errorf("failed to expand output globs: %w", err)
+	}
+	var paths []string
+	for _, m := range matches {
+		if p := filepath.FromSlash(m); !ec.isDeclaredOutput(p) {
+			paths = append(paths, p)
+		}
+	}
+	return paths, nil
+}
+
 // ExecuteRemotely tries to execute the command remotely and download the results. It uploads any
 // missing inputs first.
 func (ec *Context) ExecuteRemotely() {
@@ -701,7 +909,7 @@ func (ec *Context) ExecuteRemotely() {
 				}
 			}
 		}
-		if ec.Result.Err == nil && ec.opt.DownloadOutputs {
+		if ec.Result.Err == nil && ec.shouldDownloadOutputs() {
 			log.V(1).Infof("%s %s> Downloading outputs...", cmdID, executionID)
 			stats, res := ec.downloadOutputs(ec.cmd.ExecRoot)
 			ec.Metadata.LogicalBytesDownloaded += stats.LogicalMoved
@@ -851,10 +1059,22 @@ func formatInputSpec(spec *command.InputSpec, indent string) string {
 	for _, v := range spec.VirtualInputs {
 		sb.WriteString(fmt.Sprintf("%[1]s%[1]s%s, bytes=%d, dir=%t, exe=%t\n", indent, v.Path, len(v.Contents), v.IsEmptyDirectory, v.IsExecutable))
 	}
+	sb.WriteString(indent + "remote_inputs:\n")
+	for _, r := range spec.RemoteInputs {
+		sb.WriteString(fmt.Sprintf("%[1]s%[1]s%s, digest=%s, dir=%t, exe=%t\n", indent, r.Path, r.Digest, r.IsDirectory, r.IsExecutable))
+	}
 	sb.WriteString(indent + "exclusions:\n")
 	for _, e := range spec.InputExclusions {
 		sb.WriteString(fmt.Sprintf("%[1]s%[1]s%s\n", indent, e))
 	}
+	sb.WriteString(indent + "input_globs:\n")
+	for _, g := range spec.InputGlobs {
+		sb.WriteString(fmt.Sprintf("%[1]s%[1]s%s\n", indent, g))
+	}
+	sb.WriteString(indent + "exclude_globs:\n")
+	for _, g := range spec.ExcludeGlobs {
+		sb.WriteString(fmt.Sprintf("%[1]s%[1]s%s\n", indent, g))
+	}
 	sb.WriteString(fmt.Sprintf("%ssymlink_behaviour: %s", indent, spec.SymlinkBehavior))
 	return sb.String()
 }
@@ -5,13 +5,18 @@ package rexec_test
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/breaker"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/command"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/fakes"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/filemetadata"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/outerr"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
@@ -19,6 +24,7 @@ import (
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
 
+	rc "github.com/bazelbuild/remote-apis-sdks/go/pkg/client"
 	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
 )
 
@@ -257,6 +263,91 @@ func TestExecDoNotCache_NotAcceptCached(t *testing.T) {
 	}
 }
 
+func TestExecSkipUnavailableCache(t *testing.T) {
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	rc.CircuitBreakers{MinCalls: 2, OpenDuration: time.Hour}.Apply(e.Client.GrpcClient)
+	e.Client.SkipUnavailableCache = true
+	cmd := &command.Command{Args: []string{"tool"}, ExecRoot: e.ExecRoot}
+	opt := &command.ExecutionOptions{AcceptCached: true, DownloadOutputs: true, DownloadOutErr: true}
+	wantRes := &command.Result{Status: command.SuccessResultStatus}
+	_, acDg, _, _ := e.Set(cmd, opt, wantRes, fakes.StdOut("stdout"))
+	e.Server.ActionCache.SetError(status.Error(codes.Unavailable, "cache is down"))
+
+	// The execution service still has its own cache, which serves the second run.
+	for i, want := range []command.ResultStatus{command.SuccessResultStatus, command.CacheHitResultStatus} {
+		oe := outerr.NewRecordingOutErr()
+		res, _ := e.Client.Run(context.Background(), cmd, opt, oe)
+		if diff := cmp.Diff(&command.Result{Status: want}, res); diff != "" {
+			t.Errorf("Run() %d gave result diff (-want +got):\n%s", i, diff)
+		}
+		if got := string(oe.Stdout()); got != "stdout" {
+			t.Errorf("Run() %d gave stdout %q, want %q", i, got, "stdout")
+		}
+	}
+	// The first run opened the breaker, so the second one did not look up the cache.
+	if n := e.Server.ActionCache.Reads(acDg); n != 2 {
+		t.Errorf("server received %d GetActionResult calls, want 2", n)
+	}
+	if got := e.Client.GrpcClient.BreakerState(rc.ActionCacheFamily); got != breaker.Open {
+		t.Errorf("BreakerState(ActionCache) = %v, want %v", got, breaker.Open)
+	}
+}
+
+func TestExecManyConcurrentActions(t *testing.T) {
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	const n = 20
+	for i := 0; i < n; i++ {
+		e.Server.Exec.AddResult(fakes.MatchArgs("tool", fmt.Sprint(i)), &fakes.ExecResult{
+			ActionResult:   &repb.ActionResult{ExitCode: int32(i), StdoutRaw: []byte(fmt.Sprintf("out%d", i))},
+			QueuedDelay:    10 * time.Millisecond,
+			ExecutingDelay: 50 * time.Millisecond,
+		})
+	}
+	opt := &command.ExecutionOptions{AcceptCached: true, DownloadOutputs: true, DownloadOutErr: true}
+
+	var wg sync.WaitGroup
+	results := make([]*command.Result, n)
+	stdouts := make([]string, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			cmd := &command.Command{Args: []string{"tool", fmt.Sprint(i)}, ExecRoot: e.ExecRoot}
+			oe := outerr.NewRecordingOutErr()
+			results[i], _ = e.Client.Run(context.Background(), cmd, opt, oe)
+			stdouts[i] = string(oe.Stdout())
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < n; i++ {
+		want := &command.Result{Status: command.NonZeroExitResultStatus, ExitCode: i}
+		if i == 0 {
+			want = &command.Result{Status: command.SuccessResultStatus}
+		}
+		if diff := cmp.Diff(want, results[i]); diff != "" {
+			t.Errorf("Run(%d) gave result diff (-want +got):\n%s", i, diff)
+		}
+		if stdouts[i] != fmt.Sprintf("out%d", i) {
+			t.Errorf("Run(%d) gave stdout %q, want %q", i, stdouts[i], fmt.Sprintf("out%d", i))
+		}
+	}
+	calls := e.Server.Exec.Calls()
+	if len(calls) != n {
+		t.Errorf("Execute was called %d times, want %d", len(calls), n)
+	}
+	for i := 1; i < len(calls); i++ {
+		if calls[i].Start.Before(calls[i-1].Start) {
+			t.Errorf("Calls() not in start order: call %d started at %v, before call %d at %v", i, calls[i].Start, i-1, calls[i-1].Start)
+		}
+	}
+	if got := e.Server.Exec.MaxInFlight(); got < 2 {
+		t.Errorf("MaxInFlight() = %d, want concurrent executions", got)
+	}
+}
+
 func TestExecRemoteFailureDownloadsPartialResults(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -829,3 +920,315 @@ func TestDownloadResults(t *testing.T) {
 		t.Errorf("DownloadOutputs() stderr = %v, want 'stderr'", string(oe.Stderr()))
 	}
 }
+
+func TestUpdateRemoteCacheOutputGlobs(t *testing.T) {
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	cmd := &command.Command{
+		Args:        []string{"tool"},
+		ExecRoot:    e.ExecRoot,
+		InputSpec:   &command.InputSpec{},
+		OutputFiles: []string{"out/a.o"},
+		OutputGlobs: []string{"out/**/*.o", "missing/*.o"},
+	}
+	opt := command.DefaultExecutionOptions()
+	opt.OutputNodeProperties = []string{command.NodePropertyMtime}
+	ec, err := e.Client.NewContext(context.Background(), cmd, opt, outerr.NewRecordingOutErr())
+	if err != nil {
+		t.Fatalf("failed creating execution context: %v", err)
+	}
+	// Simulating local execution.
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, path := range []string{"out/a.o", "out/sub/b.o", "out/c.txt"} {
+		path = filepath.Join(e.ExecRoot, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatalf("failed to create output file parents %s: %v", path, err)
+		}
+		if err := os.WriteFile(path, []byte(path), 0666); err != nil {
+			t.Fatalf("failed to write output file %s: %v", path, err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatalf("failed to set mtime of %s: %v", path, err)
+		}
+	}
+	ec.UpdateCachedResult()
+	if diff := cmp.Diff(&command.Result{Status: command.SuccessResultStatus}, ec.Result); diff != "" {
+		t.Fatalf("UpdateCachedResult() gave result diff (-want +got):\n%s", diff)
+	}
+	cmdPb := &repb.Command{}
+	blob, _ := e.Server.CAS.Get(ec.Metadata.CommandDigest)
+	if err := proto.Unmarshal(blob, cmdPb); err != nil {
+		t.Fatalf("failed to unmarshal command: %v", err)
+	}
+	if diff := cmp.Diff([]string{command.NodePropertyMtime}, cmdPb.OutputNodeProperties); diff != "" {
+		t.Errorf("UpdateCachedResult() gave diff on output node properties (-want +got):\n%s", diff)
+	}
+	ar := e.Server.ActionCache.Get(ec.Metadata.ActionDigest)
+	var got []string
+	for _, f := range ar.GetOutputFiles() {
+		got = append(got, f.Path)
+		if !f.GetNodeProperties().GetMtime().AsTime().Equal(mtime) {
+			t.Errorf("output %s has mtime %v, want %v", f.Path, f.GetNodeProperties().GetMtime(), mtime)
+		}
+	}
+	if diff := cmp.Diff([]string{"out/a.o", "out/sub/b.o"}, got); diff != "" {
+		t.Errorf("UpdateCachedResult() gave diff on output files (-want +got):\n%s", diff)
+	}
+}
+
+func TestDownloadOutputGlobs(t *testing.T) {
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	cmd := &command.Command{
+		Args:        []string{"tool"},
+		ExecRoot:    e.ExecRoot,
+		OutputGlobs: []string{"gen/*.o"},
+	}
+	opt := &command.ExecutionOptions{AcceptCached: true, DownloadOutputs: true}
+	for _, path := range []string{"gen/a.o", "gen/b.txt"} {
+		path = filepath.Join(e.ExecRoot, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatalf("failed to create output file parents %s: %v", path, err)
+		}
+		if err := os.WriteFile(path, []byte(path), 0666); err != nil {
+			t.Fatalf("failed to write output file %s: %v", path, err)
+		}
+	}
+	wantRes := &command.Result{Status: command.CacheHitResultStatus}
+	e.Set(cmd, opt, wantRes, &fakes.OutputDir{Path: "gen"}, fakes.ExecutionCacheHit(true))
+	// Replace the local outputs by a file that is not an output.
+	if err := os.RemoveAll(filepath.Join(e.ExecRoot, "gen")); err != nil {
+		t.Fatalf("failed to remove outputs: %v", err)
+	}
+	keepPath := filepath.Join(e.ExecRoot, "gen/keep.txt")
+	if err := os.MkdirAll(filepath.Dir(keepPath), 0777); err != nil {
+		t.Fatalf("failed to create %s: %v", filepath.Dir(keepPath), err)
+	}
+	if err := os.WriteFile(keepPath, []byte("keep"), 0666); err != nil {
+		t.Fatalf("failed to write %s: %v", keepPath, err)
+	}
+
+	res, _ := e.Client.Run(context.Background(), cmd, opt, outerr.NewRecordingOutErr())
+	if diff := cmp.Diff(wantRes, res); diff != "" {
+		t.Fatalf("Run() gave result diff (-want +got):\n%s", diff)
+	}
+	for path, want := range map[string]bool{"gen/a.o": true, "gen/b.txt": false, "gen/keep.txt": true} {
+		_, err := os.Stat(filepath.Join(e.ExecRoot, path))
+		if got := err == nil; got != want {
+			t.Errorf("%s exists: got %t, want %t (err: %v)", path, got, want, err)
+		}
+	}
+}
+
+func TestDownloadPolicy(t *testing.T) {
+	tests := []struct {
+		name           string
+		policy         command.DownloadPolicy
+		matcher        func(string) bool
+		wantDownloaded []string
+		wantManifest   []string
+	}{
+		{
+			name:         "none",
+			policy:       command.DownloadNoOutputs,
+			wantManifest: []string{"a/b/out", "gen/a.o", "gen/b.txt"},
+		},
+		{
+			name:           "all",
+			policy:         command.DownloadAllOutputs,
+			wantDownloaded: []string{"a/b/out", "gen/a.o", "gen/b.txt"},
+		},
+		{
+			name:           "top level",
+			policy:         command.DownloadTopLevelOutputs,
+			wantDownloaded: []string{"a/b/out"},
+			wantManifest:   []string{"gen/a.o", "gen/b.txt"},
+		},
+		{
+			name:           "matching",
+			policy:         command.DownloadMatchingOutputs,
+			matcher:        func(path string) bool { return filepath.Ext(path) == ".txt" },
+			wantDownloaded: []string{"gen/b.txt"},
+			wantManifest:   []string{"a/b/out", "gen/a.o"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			e, cleanup := fakes.NewTestEnv(t)
+			defer cleanup()
+			cmd := &command.Command{
+				Args:        []string{"tool"},
+				ExecRoot:    e.ExecRoot,
+				OutputFiles: []string{"a/b/out"},
+				OutputDirs:  []string{"gen"},
+			}
+			opt := &command.ExecutionOptions{AcceptCached: true, DownloadPolicy: tc.policy, DownloadMatcher: tc.matcher}
+			for _, path := range []string{"gen/a.o", "gen/b.txt"} {
+				path = filepath.Join(e.ExecRoot, path)
+				if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+					t.Fatalf("failed to create output file parents %s: %v", path, err)
+				}
+				if err := os.WriteFile(path, []byte(path), 0666); err != nil {
+					t.Fatalf("failed to write output file %s: %v", path, err)
+				}
+			}
+			wantRes := &command.Result{Status: command.CacheHitResultStatus}
+			_, acDg, _, _ := e.Set(cmd, opt, wantRes, &fakes.OutputFile{Path: "a/b/out", Contents: "output"}, &fakes.OutputDir{Path: "gen"}, fakes.ExecutionCacheHit(true))
+			if err := os.RemoveAll(filepath.Join(e.ExecRoot, "gen")); err != nil {
+				t.Fatalf("failed to remove outputs: %v", err)
+			}
+
+			res, meta := e.Client.Run(context.Background(), cmd, opt, outerr.NewRecordingOutErr())
+			if diff := cmp.Diff(wantRes, res); diff != "" {
+				t.Fatalf("Run() gave result diff (-want +got):\n%s", diff)
+			}
+			var gotDownloaded []string
+			for _, path := range []string{"a/b/out", "gen/a.o", "gen/b.txt"} {
+				if _, err := os.Stat(filepath.Join(e.ExecRoot, path)); err == nil {
+					gotDownloaded = append(gotDownloaded, path)
+				}
+			}
+			if diff := cmp.Diff(tc.wantDownloaded, gotDownloaded); diff != "" {
+				t.Errorf("Run() gave diff on downloaded outputs (-want +got):\n%s", diff)
+			}
+			manifestPath := rc.OutputManifestPath(e.ExecRoot, acDg)
+			if len(tc.wantManifest) == 0 {
+				if meta.OutputManifest != "" {
+					t.Errorf("Run() gave output manifest %q, want none", meta.OutputManifest)
+				}
+				return
+			}
+			if meta.OutputManifest != manifestPath {
+				t.Errorf("Run() gave output manifest %q, want %q", meta.OutputManifest, manifestPath)
+			}
+			m, err := rc.ReadOutputManifest(manifestPath)
+			if err != nil {
+				t.Fatalf("ReadOutputManifest(%q) failed: %v", manifestPath, err)
+			}
+			var gotManifest []string
+			for _, o := range m.Outputs {
+				gotManifest = append(gotManifest, o.Path)
+			}
+			if diff := cmp.Diff(tc.wantManifest, gotManifest); diff != "" {
+				t.Errorf("Run() gave diff on manifest outputs (-want +got):\n%s", diff)
+			}
+			// The manifest outputs can be fetched on demand.
+			if _, err := e.Client.GrpcClient.DownloadManifestOutputs(context.Background(), m, nil, e.Client.FileMetadataCache); err != nil {
+				t.Fatalf("DownloadManifestOutputs() failed: %v", err)
+			}
+			for _, path := range tc.wantManifest {
+				if _, err := os.Stat(filepath.Join(e.ExecRoot, path)); err != nil {
+					t.Errorf("manifest output %s was not downloaded: %v", path, err)
+				}
+			}
+		})
+	}
+}
+
+func TestPreserveUnchangedOutputMtime(t *testing.T) {
+	for _, preserve := range []bool{false, true} {
+		t.Run(fmt.Sprintf("Preserve:%t", preserve), func(t *testing.T) {
+			e, cleanup := fakes.NewTestEnv(t)
+			defer cleanup()
+			cmd := &command.Command{
+				Args:        []string{"tool"},
+				ExecRoot:    e.ExecRoot,
+				OutputFiles: []string{"unchanged", "changed"},
+			}
+			opt := &command.ExecutionOptions{AcceptCached: true, DownloadOutputs: true, PreserveUnchangedOutputMtime: preserve}
+			wantRes := &command.Result{Status: command.CacheHitResultStatus}
+			e.Set(cmd, opt, wantRes, &fakes.OutputFile{Path: "unchanged", Contents: "same"},
+				&fakes.OutputFile{Path: "changed", Contents: "new"}, fakes.ExecutionCacheHit(true))
+			mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+			for path, contents := range map[string]string{"unchanged": "same", "changed": "old"} {
+				path = filepath.Join(e.ExecRoot, path)
+				if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+					t.Fatalf("failed to write output file %s: %v", path, err)
+				}
+				if err := os.Chtimes(path, mtime, mtime); err != nil {
+					t.Fatalf("failed to set the mtime of %s: %v", path, err)
+				}
+			}
+
+			res, _ := e.Client.Run(context.Background(), cmd, opt, outerr.NewRecordingOutErr())
+			if diff := cmp.Diff(wantRes, res); diff != "" {
+				t.Fatalf("Run() gave result diff (-want +got):\n%s", diff)
+			}
+			for path, wantPreserved := range map[string]bool{"unchanged": preserve, "changed": false} {
+				info, err := os.Stat(filepath.Join(e.ExecRoot, path))
+				if err != nil {
+					t.Fatalf("failed to stat output %s: %v", path, err)
+				}
+				if got := info.ModTime().Equal(mtime); got != wantPreserved {
+					t.Errorf("output %s has mtime %v, want mtime %v preserved: %t", path, info.ModTime(), mtime, wantPreserved)
+				}
+			}
+			if contents, err := os.ReadFile(filepath.Join(e.ExecRoot, "changed")); err != nil || string(contents) != "new" {
+				t.Errorf("output changed = %q, %v, want %q", contents, err, "new")
+			}
+		})
+	}
+}
+
+func TestPreserveUnchangedOutputMtimeStreamed(t *testing.T) {
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	rc.StreamingTreeDownloads(true).Apply(e.Client.GrpcClient)
+	e.Client.FileMetadataCache = filemetadata.NewSingleFlightCache()
+	cmd := &command.Command{
+		Args:       []string{"tool"},
+		ExecRoot:   e.ExecRoot,
+		OutputDirs: []string{"gen"},
+	}
+	opt := &command.ExecutionOptions{AcceptCached: true, DownloadOutputs: true, PreserveUnchangedOutputMtime: true}
+	write := func(path, contents string) {
+		path = filepath.Join(e.ExecRoot, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatalf("failed to create output file parents %s: %v", path, err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatalf("failed to write output file %s: %v", path, err)
+		}
+	}
+	write("gen/unchanged", "same")
+	write("gen/changed", "new")
+	wantRes := &command.Result{Status: command.CacheHitResultStatus}
+	e.Set(cmd, opt, wantRes, &fakes.OutputDir{Path: "gen"}, fakes.ExecutionCacheHit(true))
+	write("gen/changed", "old")
+	write("gen/stale", "stale")
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for _, path := range []string{"gen/unchanged", "gen/changed"} {
+		path = filepath.Join(e.ExecRoot, path)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatalf("failed to set the mtime of %s: %v", path, err)
+		}
+		// Cache the metadata of the existing outputs.
+		if md := e.Client.FileMetadataCache.Get(path); md.Err != nil {
+			t.Fatalf("failed to get the metadata of %s: %v", path, md.Err)
+		}
+	}
+
+	res, _ := e.Client.Run(context.Background(), cmd, opt, outerr.NewRecordingOutErr())
+	if diff := cmp.Diff(wantRes, res); diff != "" {
+		t.Fatalf("Run() gave result diff (-want +got):\n%s", diff)
+	}
+	for path, wantPreserved := range map[string]bool{"gen/unchanged": true, "gen/changed": false} {
+		info, err := os.Stat(filepath.Join(e.ExecRoot, path))
+		if err != nil {
+			t.Fatalf("failed to stat output %s: %v", path, err)
+		}
+		if got := info.ModTime().Equal(mtime); got != wantPreserved {
+			t.Errorf("output %s has mtime %v, want mtime %v preserved: %t", path, info.ModTime(), mtime, wantPreserved)
+		}
+	}
+	changed := filepath.Join(e.ExecRoot, "gen/changed")
+	if contents, err := os.ReadFile(changed); err != nil || string(contents) != "new" {
+		t.Errorf("output gen/changed = %q, %v, want %q", contents, err, "new")
+	}
+	if md := e.Client.FileMetadataCache.Get(changed); md.Digest != digest.NewFromBlob([]byte("new")) {
+		t.Errorf("FileMetadataCache.Get(%s) gave digest %v, want the digest of the new contents", changed, md.Digest)
+	}
+	if _, err := os.Stat(filepath.Join(e.ExecRoot, "gen/stale")); !os.IsNotExist(err) {
+		t.Errorf("stale file gen/stale was not removed: %v", err)
+	}
+}
@@ -0,0 +1,247 @@
+package tool
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	"golang.org/x/sync/errgroup"
+
+	rc "github.com/bazelbuild/remote-apis-sdks/go/pkg/client"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	bspb "google.golang.org/genproto/googleapis/bytestream"
+)
+
+// copyConcurrency is the number of batches or streamed blobs copied concurrently.
+const copyConcurrency = 16
+
+// Copy copies the action with the given digest from the CAS and action cache of the tool client to those
+// of dst: the action and its command, the input root tree, and the cached action result with its output
+// files, output directory trees and their files, stdout and stderr. Only the blobs that dst is missing are copied. Small
+// blobs are copied with batch calls, and large ones are streamed from one ByteStream to the other without
+// being held in memory. A summary is written to w.
+func (c *Client) Copy(ctx context.Context, dst *rc.Client, actionDigest string, w io.Writer) error {
+	acDg, err := digest.NewFromString(actionDigest)
+	if err != nil {
+		return err
+	}
+	src := c.GrpcClient
+	blobs := map[digest.Digest]bool{acDg: true}
+	ac := &repb.Action{}
+	if _, err := src.ReadProto(ctx, acDg, ac); err != nil {
+		return fmt.Errorf("failed to read action %v: %w", acDg, err)
+	}
+	blobs[digest.NewFromProtoUnvalidated(ac.CommandDigest)] = true
+	rootDg := digest.NewFromProtoUnvalidated(ac.InputRootDigest)
+	blobs[rootDg] = true
+	dirs, err := src.GetDirectoryTree(ctx, rootDg.ToProto())
+	if err != nil {
+		return fmt.Errorf("failed to read input root %v: %w", rootDg, err)
+	}
+	for _, dir := range dirs {
+		addDirectoryBlobs(blobs, dir)
+	}
+	res, err := src.CheckActionCache(ctx, acDg.ToProto())
+	if err != nil {
+		return err
+	}
+	if res != nil {
+		if err := c.addActionResultBlobs(ctx, blobs, res); err != nil {
+			return err
+		}
+	}
+
+	var dgs []digest.Digest
+	for dg := range blobs {
+		if dg.Size > 0 {
+			dgs = append(dgs, dg)
+		}
+	}
+	missing, err := dst.MissingBlobs(ctx, dgs)
+	if err != nil {
+		return err
+	}
+	copied, err := copyBlobs(ctx, src, dst, missing)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Copied %d of %d blobs (%d bytes)\n", len(missing), len(dgs), copied)
+	if res == nil {
+		fmt.Fprintln(w, "No action result in cache.")
+		return nil
+	}
+	// The result is only uploaded once all its blobs are in the CAS, so that it is never served incomplete.
+	_, err = dst.UpdateActionResult(ctx, &repb.UpdateActionResultRequest{
+		InstanceName: dst.InstanceName,
+		ActionDigest: acDg.ToProto(),
+		ActionResult: res,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update the action result: %w", err)
+	}
+	fmt.Fprintln(w, "Copied action result.")
+	return nil
+}
+
+func addDirectoryBlobs(blobs map[digest.Digest]bool, dir *repb.Directory) {
+	for _, f := range dir.Files {
+		blobs[digest.NewFromProtoUnvalidated(f.Digest)] = true
+	}
+	for _, sub := range dir.Directories {
+		blobs[digest.NewFromProtoUnvalidated(sub.Digest)] = true
+	}
+}
+
+func (c *Client) addActionResultBlobs(ctx context.Context, blobs map[digest.Digest]bool, res *repb.ActionResult) error {
+	for _, f := range res.OutputFiles {
+		blobs[digest.NewFromProtoUnvalidated(f.Digest)] = true
+	}
+	if res.StdoutDigest != nil {
+		blobs[digest.NewFromProtoUnvalidated(res.StdoutDigest)] = true
+	}
+	if res.StderrDigest != nil {
+		blobs[digest.NewFromProtoUnvalidated(res.StderrDigest)] = true
+	}
+	// The directories of an output tree need not be in the CAS on their own, so only its files are copied.
+	for _, dir := range res.OutputDirectories {
+		treeDg := digest.NewFromProtoUnvalidated(dir.TreeDigest)
+		blobs[treeDg] = true
+		t := &repb.Tree{}
+		if _, err := c.GrpcClient.ReadProto(ctx, treeDg, t); err != nil {
+			return fmt.Errorf("failed to read the tree of output directory %q: %w", dir.Path, err)
+		}
+		outs, err := c.GrpcClient.FlattenTree(t, dir.Path)
+		if err != nil {
+			return err
+		}
+		for _, out := range outs {
+			if !out.IsEmptyDirectory && out.SymlinkTarget == "" {
+				blobs[out.Digest] = true
+			}
+		}
+	}
+	return nil
+}
+
+// copyBlobs copies the given blobs from src to dst and returns the number of bytes copied. The blobs
+// that fit in batch calls of both clients are grouped into batches, and the others are streamed.
+func copyBlobs(ctx context.Context, src, dst *rc.Client, dgs []digest.Digest) (int64, error) {
+	// Leave room for the overhead of the requests, see the batching of the client.
+	limit := int64(src.MaxBatchSize) / 2
+	if l := int64(dst.MaxBatchSize) / 2; l < limit {
+		limit = l
+	}
+	sort.Slice(dgs, func(i, j int) bool { return dgs[i].Size < dgs[j].Size })
+	var batches [][]digest.Digest
+	var streamed []digest.Digest
+	var batch []digest.Digest
+	var batchSize, total int64
+	for _, dg := range dgs {
+		total += dg.Size
+		if dg.Size > limit {
+			streamed = append(streamed, dg)
+			continue
+		}
+		if batchSize+dg.Size > limit {
+			batches = append(batches, batch)
+			batch, batchSize = nil, 0
+		}
+		batch = append(batch, dg)
+		batchSize += dg.Size
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+
+	eg, eCtx := errgroup.WithContext(ctx)
+	eg.SetLimit(copyConcurrency)
+	for _, batch := range batches {
+		batch := batch
+		eg.Go(func() error {
+			blobs, err := src.BatchDownloadBlobs(eCtx, batch)
+			if err != nil {
+				return err
+			}
+			return dst.BatchWriteBlobs(eCtx, blobs)
+		})
+	}
+	for _, dg := range streamed {
+		dg := dg
+		eg.Go(func() error {
+			if err := copyBlobStreamed(eCtx, src, dst, dg); err != nil {
+				return fmt.Errorf("failed to copy blob %v: %w", dg, err)
+			}
+			return nil
+		})
+	}
+	return total, eg.Wait()
+}
+
+// copyBlobStreamed pipes a blob from a ByteStream read of src to a ByteStream write of dst. A failed
+// write is retried from the beginning of the blob, while reads are resumed by the read of src.
+func copyBlobStreamed(ctx context.Context, src, dst *rc.Client, dg digest.Digest) error {
+	readName, err := src.ResourceName("blobs", dg.Hash, strconv.FormatInt(dg.Size, 10))
+	if err != nil {
+		return err
+	}
+	writeName := dst.ResourceNameWrite(dg.Hash, dg.Size)
+	return dst.Retrier.Do(ctx, func() error {
+		rCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		pr, pw := io.Pipe()
+		readDone := make(chan struct{})
+		go func() {
+			_, err := src.ReadResourceTo(rCtx, readName, pw)
+			pw.CloseWithError(err)
+			close(readDone)
+		}()
+		// A failed read fails the write with the same error through the pipe.
+		err := writeStream(ctx, dst, writeName, dg.Size, pr)
+		// Stop the read, which is left unfinished if the write failed or if the server already had the blob.
+		pr.Close()
+		cancel()
+		<-readDone
+		return err
+	})
+}
+
+// writeStream writes size bytes from r to the resource with the given name, in chunks of the maximum
+// chunk size of the client.
+func writeStream(ctx context.Context, c *rc.Client, name string, size int64, r io.Reader) error {
+	stream, err := c.Write(ctx)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, int(c.ChunkMaxSize))
+	for off := int64(0); off < size; {
+		n := int64(len(buf))
+		if size-off < n {
+			n = size - off
+		}
+		if _, err := io.ReadFull(r, buf[:n]); err != nil {
+			return err
+		}
+		req := &bspb.WriteRequest{ResourceName: name, WriteOffset: off, Data: buf[:n], FinishWrite: off+n == size}
+		err := stream.Send(req)
+		if err == io.EOF {
+			// The server already has the blob.
+			break
+		}
+		if err != nil {
+			return err
+		}
+		off += n
+	}
+	res, err := stream.CloseAndRecv()
+	if err != nil {
+		return err
+	}
+	if res.CommittedSize != size {
+		return fmt.Errorf("wrote %d bytes, want %d", res.CommittedSize, size)
+	}
+	return nil
+}
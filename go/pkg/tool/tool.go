@@ -7,6 +7,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"sort"
@@ -22,6 +23,7 @@ import (
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/cas"
 	rc "github.com/bazelbuild/remote-apis-sdks/go/pkg/client"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/command"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/conformance"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/filemetadata"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/outerr"
@@ -82,6 +84,19 @@ func (c *Client) CheckDeterminism(ctx context.Context, actionDigest, actionRoot
 	return nil
 }
 
+// CheckConformance runs the REAPI conformance checks against the server and writes a report to w.
+// It returns an error if any of the checks failed.
+func (c *Client) CheckConformance(ctx context.Context, opts *conformance.Options, w io.Writer) error {
+	results, err := conformance.Run(ctx, c.GrpcClient, opts)
+	if err != nil {
+		return err
+	}
+	if failed := conformance.Report(w, results); failed > 0 {
+		return fmt.Errorf("%d of %d conformance checks failed", failed, len(results))
+	}
+	return nil
+}
+
 func (c *Client) prepCommand(ctx context.Context, client *rexec.Client, actionDigest, actionRoot string) (*command.Command, error) {
 	acDg, err := digest.NewFromString(actionDigest)
 	if err != nil {
@@ -325,6 +340,22 @@ func (c *Client) DownloadDirectory(ctx context.Context, rootDigest, path string)
 	return err
 }
 
+// DownloadManifestOutputs downloads the outputs recorded in the output manifest at manifestPath that are at or
+// under any of the given paths, or all of them if no paths are given. The outputs are downloaded into outDir if it
+// is set, and into the directory recorded in the manifest otherwise.
+func (c *Client) DownloadManifestOutputs(ctx context.Context, manifestPath string, paths []string, outDir string) error {
+	m, err := rc.ReadOutputManifest(manifestPath)
+	if err != nil {
+		return err
+	}
+	if outDir != "" {
+		m.OutDir = outDir
+	}
+	log.Infof("Downloading outputs of action %v to %v.", m.ActionDigest, m.OutDir)
+	_, err = c.GrpcClient.DownloadManifestOutputs(ctx, m, paths, filemetadata.NewNoopCache())
+	return err
+}
+
 func (c *Client) writeProto(m proto.Message, baseName string) error {
 	f, err := os.Create(baseName)
 	if err != nil {
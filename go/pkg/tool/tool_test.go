@@ -6,14 +6,17 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 	"testing"
 
+	rc "github.com/bazelbuild/remote-apis-sdks/go/pkg/client"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/command"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/fakes"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/outerr"
 	"github.com/google/go-cmp/cmp"
 	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
 
 	cpb "github.com/bazelbuild/remote-apis-sdks/go/api/command"
 	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
@@ -430,3 +433,223 @@ func TestTool_UploadBlob(t *testing.T) {
 		t.Fatalf("Expected 1 write for blob '%v', got %v", dg.String(), cas.BlobWrites(dg))
 	}
 }
+
+func TestTool_DownloadManifestOutputs(t *testing.T) {
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	fooDg := e.Server.CAS.Put([]byte("foo"))
+	barDg := e.Server.CAS.Put([]byte("bar"))
+	manifestPath := filepath.Join(t.TempDir(), "manifest.json")
+	m := &rc.OutputManifest{
+		OutDir: filepath.Join(t.TempDir(), "unused"),
+		Outputs: []*rc.ManifestOutput{
+			{Path: "a/foo", Digest: fooDg},
+			{Path: "b/bar", Digest: barDg},
+		},
+	}
+	if err := rc.WriteOutputManifest(manifestPath, m); err != nil {
+		t.Fatalf("WriteOutputManifest(%v) failed: %v", manifestPath, err)
+	}
+
+	toolClient := &Client{GrpcClient: e.Client.GrpcClient}
+	outDir := t.TempDir()
+	if err := toolClient.DownloadManifestOutputs(context.Background(), manifestPath, []string{"b"}, outDir); err != nil {
+		t.Fatalf("DownloadManifestOutputs(%v) failed: %v", manifestPath, err)
+	}
+	c, err := os.ReadFile(filepath.Join(outDir, "b/bar"))
+	if err != nil {
+		t.Fatalf("Unable to read downloaded output file: %v", err)
+	}
+	if got := string(c); got != "bar" {
+		t.Errorf("Incorrect content in downloaded file, want %v, got %v", "bar", got)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "a/foo")); !os.IsNotExist(err) {
+		t.Errorf("a/foo was downloaded, want it skipped: %v", err)
+	}
+}
+
+func TestTool_Verify(t *testing.T) {
+	tests := []struct {
+		name        string
+		deleted     []string
+		corrupted   []string
+		download    bool
+		wantErr     bool
+		wantSummary string
+		wantLines   []string
+	}{
+		{
+			name:        "valid",
+			wantSummary: "9 valid, 0 missing, 0 corrupt blobs",
+		},
+		{
+			name:        "missing",
+			deleted:     []string{"output", "stderr"},
+			wantErr:     true,
+			wantSummary: "7 valid, 2 missing, 0 corrupt blobs",
+			wantLines:   []string{"MISSING " + digest.NewFromBlob([]byte("output")).String() + ": output a/b/out", "MISSING " + digest.NewFromBlob([]byte("stderr")).String() + ": stderr"},
+		},
+		{
+			name:        "corrupt unchecked",
+			corrupted:   []string{"input"},
+			wantSummary: "9 valid, 0 missing, 0 corrupt blobs",
+		},
+		{
+			name:        "corrupt downloaded",
+			corrupted:   []string{"input"},
+			download:    true,
+			wantErr:     true,
+			wantSummary: "8 valid, 0 missing, 1 corrupt blobs",
+			wantLines:   []string{"CORRUPT " + digest.NewFromBlob([]byte("input")).String() + ": input root/a/b/input.txt: has digest"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			e, cleanup := fakes.NewTestEnv(t)
+			defer cleanup()
+			cmd := &command.Command{
+				Args:        []string{"tool"},
+				ExecRoot:    e.ExecRoot,
+				InputSpec:   &command.InputSpec{Inputs: []string{"a/b/input.txt"}},
+				OutputFiles: []string{"a/b/out"},
+			}
+			opt := command.DefaultExecutionOptions()
+			_, acDg, _, _ := e.Set(cmd, opt, &command.Result{Status: command.CacheHitResultStatus}, &fakes.OutputFile{Path: "a/b/out", Contents: "output"},
+				fakes.StdOut("stdout"), fakes.StdErr("stderr"), &fakes.InputFile{Path: "a/b/input.txt", Contents: "input"})
+			for _, blob := range tc.deleted {
+				e.Server.CAS.Delete(digest.NewFromBlob([]byte(blob)))
+			}
+			for _, blob := range tc.corrupted {
+				e.Server.CAS.Corrupt(digest.NewFromBlob([]byte(blob)), 100)
+			}
+
+			toolClient := &Client{GrpcClient: e.Client.GrpcClient}
+			var out strings.Builder
+			err := toolClient.Verify(context.Background(), acDg.String(), false, tc.download, &out)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Errorf("Verify(%v) gave error %v, want error: %t", acDg, err, tc.wantErr)
+			}
+			lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+			if lines[0] != tc.wantSummary {
+				t.Errorf("Verify(%v) gave summary %q, want %q", acDg, lines[0], tc.wantSummary)
+			}
+			for _, want := range tc.wantLines {
+				found := false
+				for _, l := range lines[1:] {
+					found = found || strings.HasPrefix(l, want)
+				}
+				if !found {
+					t.Errorf("Verify(%v) report does not contain %q:\n%s", acDg, want, out.String())
+				}
+			}
+		})
+	}
+}
+
+func TestTool_VerifyDirectory(t *testing.T) {
+	e, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	fooDg := e.Server.CAS.Put([]byte("foo"))
+	sub := &repb.Directory{Files: []*repb.FileNode{{Name: "foo", Digest: fooDg.ToProto()}}}
+	subBlob, err := proto.Marshal(sub)
+	if err != nil {
+		t.Fatalf("proto.Marshal failed: %v", err)
+	}
+	subDg := e.Server.CAS.Put(subBlob)
+	root := &repb.Directory{Directories: []*repb.DirectoryNode{{Name: "a", Digest: subDg.ToProto()}, {Name: "b", Digest: subDg.ToProto()}}}
+	rootBlob, err := proto.Marshal(root)
+	if err != nil {
+		t.Fatalf("proto.Marshal failed: %v", err)
+	}
+	rootDg := e.Server.CAS.Put(rootBlob)
+	e.Server.CAS.Corrupt(subDg, 100)
+
+	toolClient := &Client{GrpcClient: e.Client.GrpcClient}
+	var out strings.Builder
+	if err := toolClient.Verify(context.Background(), rootDg.String(), true, false, &out); err == nil {
+		t.Errorf("Verify(%v) succeeded with a corrupt directory, want error", rootDg)
+	}
+	want := "1 valid, 0 missing, 1 corrupt blobs\nCORRUPT " + subDg.String() + ": root directory/a: "
+	if got := out.String(); !strings.HasPrefix(got, want) {
+		t.Errorf("Verify(%v) gave report %q, want prefix %q", rootDg, got, want)
+	}
+}
+
+func TestTool_Copy(t *testing.T) {
+	src, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	dst, dstCleanup := fakes.NewTestEnv(t)
+	defer dstCleanup()
+	cmd := &command.Command{
+		Args:        []string{"tool"},
+		ExecRoot:    src.ExecRoot,
+		InputSpec:   &command.InputSpec{Inputs: []string{"a/b/input.txt"}},
+		OutputFiles: []string{"a/b/out"},
+	}
+	opt := command.DefaultExecutionOptions()
+	large := strings.Repeat("large output", 100)
+	_, acDg, _, _ := src.Set(cmd, opt, &command.Result{Status: command.CacheHitResultStatus}, &fakes.OutputFile{Path: "a/b/out", Contents: large},
+		fakes.StdOut("stdout"), fakes.StdErr("stderr"), &fakes.InputFile{Path: "a/b/input.txt", Contents: "input"})
+	// The large output is streamed in several chunks, and the other blobs are copied in batches.
+	rc.MaxBatchSize(500).Apply(src.Client.GrpcClient)
+	rc.ChunkMaxSize(100).Apply(dst.Client.GrpcClient)
+	inputDg := dst.Server.CAS.Put([]byte("input"))
+
+	toolClient := &Client{GrpcClient: src.Client.GrpcClient}
+	var out strings.Builder
+	if err := toolClient.Copy(context.Background(), dst.Client.GrpcClient, acDg.String(), &out); err != nil {
+		t.Fatalf("Copy(%v) failed: %v", acDg, err)
+	}
+	if got, want := out.String(), "Copied 8 of 9 blobs"; !strings.HasPrefix(got, want) || !strings.HasSuffix(got, "Copied action result.\n") {
+		t.Errorf("Copy(%v) gave summary %q, want %q and a copied action result", acDg, got, want)
+	}
+	if n := dst.Server.CAS.WriteReqs(); n != 1 {
+		t.Errorf("Copy(%v) made %d ByteStream writes, want 1 for the large output", acDg, n)
+	}
+	if n := dst.Server.CAS.BlobWrites(inputDg); n != 0 {
+		t.Errorf("Copy(%v) wrote the existing blob %v %d times, want 0", acDg, inputDg, n)
+	}
+
+	dstClient := &Client{GrpcClient: dst.Client.GrpcClient}
+	out.Reset()
+	if err := dstClient.Verify(context.Background(), acDg.String(), false, true, &out); err != nil {
+		t.Errorf("Verify(%v) of the copy failed: %v\n%s", acDg, err, out.String())
+	}
+}
+
+func TestTool_CopyOutputDirectory(t *testing.T) {
+	src, cleanup := fakes.NewTestEnv(t)
+	defer cleanup()
+	dst, dstCleanup := fakes.NewTestEnv(t)
+	defer dstCleanup()
+	for path, contents := range map[string]string{"gen/a": "a", "gen/sub/b": "b"} {
+		path = filepath.Join(src.ExecRoot, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatalf("failed to create %s: %v", filepath.Dir(path), err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0666); err != nil {
+			t.Fatalf("failed to write %s: %v", path, err)
+		}
+	}
+	cmd := &command.Command{Args: []string{"tool"}, ExecRoot: src.ExecRoot, OutputDirs: []string{"gen"}}
+	opt := command.DefaultExecutionOptions()
+	// Only the Tree of the output directory is in the source CAS, not its Directory messages.
+	_, acDg, _, _ := src.Set(cmd, opt, &command.Result{Status: command.CacheHitResultStatus}, &fakes.OutputDir{Path: "gen"})
+
+	toolClient := &Client{GrpcClient: src.Client.GrpcClient}
+	var out strings.Builder
+	if err := toolClient.Copy(context.Background(), dst.Client.GrpcClient, acDg.String(), &out); err != nil {
+		t.Fatalf("Copy(%v) failed: %v", acDg, err)
+	}
+	for _, contents := range []string{"a", "b"} {
+		if _, ok := dst.Server.CAS.Get(digest.NewFromBlob([]byte(contents))); !ok {
+			t.Errorf("Copy(%v) did not copy the output file with contents %q", acDg, contents)
+		}
+	}
+
+	dstClient := &Client{GrpcClient: dst.Client.GrpcClient}
+	out.Reset()
+	if err := dstClient.Verify(context.Background(), acDg.String(), false, true, &out); err != nil {
+		t.Errorf("Verify(%v) of the copy failed: %v\n%s", acDg, err, out.String())
+	}
+}
@@ -0,0 +1,301 @@
+package tool
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	rc "github.com/bazelbuild/remote-apis-sdks/go/pkg/client"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/digest"
+
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+)
+
+// verifyConcurrency is the number of blobs downloaded concurrently to be re-hashed.
+const verifyConcurrency = 16
+
+// VerifyReport is the result of verifying the blobs of an action or of a directory tree in the CAS.
+type VerifyReport struct {
+	// Valid is the number of blobs that are present, and match their digests if they were downloaded.
+	Valid int
+	// Missing maps the blobs that are not in the CAS to the description of one of their uses.
+	Missing map[digest.Digest]string
+	// Corrupt maps the blobs that do not match their digests, or cannot be parsed, to the reason.
+	Corrupt map[digest.Digest]string
+	// NoActionResult is set if the action was verified but has no cached result.
+	NoActionResult bool
+}
+
+// Write writes a human readable summary of the report to w, listing the missing and corrupt blobs.
+func (r *VerifyReport) Write(w io.Writer) {
+	fmt.Fprintf(w, "%d valid, %d missing, %d corrupt blobs\n", r.Valid, len(r.Missing), len(r.Corrupt))
+	if r.NoActionResult {
+		fmt.Fprintln(w, "No action result in cache.")
+	}
+	writeSorted := func(kind string, blobs map[digest.Digest]string) {
+		var lines []string
+		for dg, desc := range blobs {
+			lines = append(lines, fmt.Sprintf("%s %v: %s", kind, dg, desc))
+		}
+		sort.Strings(lines)
+		for _, l := range lines {
+			fmt.Fprintln(w, l)
+		}
+	}
+	writeSorted("MISSING", r.Missing)
+	writeSorted("CORRUPT", r.Corrupt)
+}
+
+// Verify checks that all the blobs of the action with the given digest, or of the directory tree with
+// the given digest if isDir is set, are in the CAS: the action and its command, the input root tree,
+// and the output files, output directory trees, stdout and stderr of its cached action result.
+// If download is set, every blob is also downloaded and checked against its digest. A report is written
+// to w, and an error is returned if any blob is missing or corrupt.
+func (c *Client) Verify(ctx context.Context, dgStr string, isDir, download bool, w io.Writer) error {
+	dg, err := digest.NewFromString(dgStr)
+	if err != nil {
+		return err
+	}
+	v := &verifier{
+		c:        c.GrpcClient,
+		blobs:    make(map[digest.Digest]string),
+		verified: make(map[digest.Digest]bool),
+		dirs:     make(map[digest.Digest]bool),
+		report: &VerifyReport{
+			Missing: make(map[digest.Digest]string),
+			Corrupt: make(map[digest.Digest]string),
+		},
+	}
+	if isDir {
+		err = v.walkDirectory(ctx, dg, "root directory")
+	} else {
+		err = v.walkAction(ctx, dg)
+	}
+	if err != nil {
+		return err
+	}
+	if err := v.check(ctx, download); err != nil {
+		return err
+	}
+	v.report.Write(w)
+	if n := len(v.report.Missing) + len(v.report.Corrupt); n > 0 {
+		return fmt.Errorf("%d of %d blobs are missing or corrupt", n, len(v.blobs))
+	}
+	return nil
+}
+
+// verifier walks the blobs of an action or directory tree.
+type verifier struct {
+	c *rc.Client
+	// blobs maps all the blobs found to the description of their first use.
+	blobs map[digest.Digest]string
+	// verified are the blobs that were downloaded and hashed during the walk.
+	verified map[digest.Digest]bool
+	// dirs are the directories that were walked.
+	dirs   map[digest.Digest]bool
+	report *VerifyReport
+}
+
+// add records a blob to be checked.
+func (v *verifier) add(dg digest.Digest, desc string) {
+	if _, ok := v.blobs[dg]; !ok {
+		v.blobs[dg] = desc
+	}
+}
+
+// readProto downloads a blob, checks it against its digest and parses it into msg. It returns false if
+// the blob is missing or corrupt, in which case it is recorded in the report.
+func (v *verifier) readProto(ctx context.Context, dg digest.Digest, desc string, msg proto.Message) (bool, error) {
+	v.add(dg, desc)
+	blob, _, err := v.c.ReadBlob(ctx, dg)
+	switch {
+	case status.Code(err) == codes.NotFound:
+		v.report.Missing[dg] = desc
+		return false, nil
+	case err != nil:
+		// Errors other than those of the RPCs, e.g. digest mismatches or partial reads, are due to the blob.
+		if _, isStatus := status.FromError(err); isStatus || ctx.Err() != nil {
+			return false, err
+		}
+		v.report.Corrupt[dg] = fmt.Sprintf("%s: %v", desc, err)
+		return false, nil
+	}
+	v.verified[dg] = true
+	if got := digest.NewFromBlob(blob); got != dg {
+		v.report.Corrupt[dg] = fmt.Sprintf("%s: has digest %v", desc, got)
+		return false, nil
+	}
+	if err := proto.Unmarshal(blob, msg); err != nil {
+		v.report.Corrupt[dg] = fmt.Sprintf("%s: %v", desc, err)
+		return false, nil
+	}
+	return true, nil
+}
+
+func (v *verifier) walkAction(ctx context.Context, acDg digest.Digest) error {
+	ac := &repb.Action{}
+	ok, err := v.readProto(ctx, acDg, "action", ac)
+	if err != nil || !ok {
+		return err
+	}
+	cmdDg, err := digest.NewFromProto(ac.GetCommandDigest())
+	if err != nil {
+		return err
+	}
+	if _, err := v.readProto(ctx, cmdDg, "command", &repb.Command{}); err != nil {
+		return err
+	}
+	rootDg, err := digest.NewFromProto(ac.GetInputRootDigest())
+	if err != nil {
+		return err
+	}
+	if err := v.walkDirectory(ctx, rootDg, "input root"); err != nil {
+		return err
+	}
+	res, err := v.c.CheckActionCache(ctx, acDg.ToProto())
+	if err != nil {
+		return err
+	}
+	if res == nil {
+		v.report.NoActionResult = true
+		return nil
+	}
+	return v.walkActionResult(ctx, res)
+}
+
+func (v *verifier) walkActionResult(ctx context.Context, res *repb.ActionResult) error {
+	for _, f := range res.OutputFiles {
+		v.add(digest.NewFromProtoUnvalidated(f.Digest), "output "+f.Path)
+	}
+	if res.StdoutDigest != nil {
+		v.add(digest.NewFromProtoUnvalidated(res.StdoutDigest), "stdout")
+	}
+	if res.StderrDigest != nil {
+		v.add(digest.NewFromProtoUnvalidated(res.StderrDigest), "stderr")
+	}
+	for _, dir := range res.OutputDirectories {
+		t := &repb.Tree{}
+		ok, err := v.readProto(ctx, digest.NewFromProtoUnvalidated(dir.TreeDigest), "tree of output directory "+dir.Path, t)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		outs, err := v.c.FlattenTree(t, dir.Path)
+		if err != nil {
+			return err
+		}
+		for _, out := range outs {
+			if !out.IsEmptyDirectory && out.SymlinkTarget == "" {
+				v.add(out.Digest, "output "+out.Path)
+			}
+		}
+	}
+	return nil
+}
+
+// walkDirectory records the directory with the given digest and all its contents. The directories
+// are downloaded and verified, since their contents are needed to walk the tree.
+func (v *verifier) walkDirectory(ctx context.Context, dg digest.Digest, desc string) error {
+	if v.dirs[dg] {
+		return nil
+	}
+	v.dirs[dg] = true
+	dir := &repb.Directory{}
+	ok, err := v.readProto(ctx, dg, desc, dir)
+	if err != nil || !ok {
+		return err
+	}
+	for _, f := range dir.Files {
+		v.add(digest.NewFromProtoUnvalidated(f.Digest), path.Join(desc, f.Name))
+	}
+	for _, sub := range dir.Directories {
+		if err := v.walkDirectory(ctx, digest.NewFromProtoUnvalidated(sub.Digest), path.Join(desc, sub.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// check finds the missing blobs among those that were not downloaded during the walk, and downloads
+// and hashes the others if download is set.
+func (v *verifier) check(ctx context.Context, download bool) error {
+	var dgs []digest.Digest
+	for dg := range v.blobs {
+		if _, ok := v.report.Missing[dg]; ok {
+			continue
+		}
+		if _, ok := v.report.Corrupt[dg]; ok {
+			continue
+		}
+		if v.verified[dg] {
+			v.report.Valid++
+			continue
+		}
+		dgs = append(dgs, dg)
+	}
+	missing, err := v.c.MissingBlobs(ctx, dgs)
+	if err != nil {
+		return err
+	}
+	isMissing := make(map[digest.Digest]bool)
+	for _, dg := range missing {
+		isMissing[dg] = true
+		v.report.Missing[dg] = v.blobs[dg]
+	}
+	var mu sync.Mutex
+	eg, eCtx := errgroup.WithContext(ctx)
+	eg.SetLimit(verifyConcurrency)
+	for _, dg := range dgs {
+		dg := dg
+		if isMissing[dg] {
+			continue
+		}
+		if !download || dg.Size == 0 {
+			v.report.Valid++
+			continue
+		}
+		eg.Go(func() error {
+			got, err := v.hashBlob(eCtx, dg)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case status.Code(err) == codes.NotFound:
+				v.report.Missing[dg] = v.blobs[dg]
+			case err != nil:
+				return err
+			case got != dg:
+				v.report.Corrupt[dg] = fmt.Sprintf("%s: has digest %v", v.blobs[dg], got)
+			default:
+				v.report.Valid++
+			}
+			return nil
+		})
+	}
+	return eg.Wait()
+}
+
+// hashBlob returns the digest of the contents of a blob, without holding it in memory.
+func (v *verifier) hashBlob(ctx context.Context, dg digest.Digest) (digest.Digest, error) {
+	name, err := v.c.ResourceName("blobs", dg.Hash, strconv.FormatInt(dg.Size, 10))
+	if err != nil {
+		return digest.Digest{}, err
+	}
+	h := digest.HashFn.New()
+	n, err := v.c.ReadResourceTo(ctx, name, h)
+	if err != nil {
+		return digest.Digest{}, err
+	}
+	return digest.Digest{Hash: hex.EncodeToString(h.Sum(nil)), Size: n}, nil
+}